@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"orchiddb/parser"
+	"orchiddb/response"
+)
+
+// -----------------------------------------------------------------------------
+// Users other than admins only have the rights they were granted on tables. A
+// grant gives a right on the tables whose name matches a pattern, e.g. logs_*,
+// with the syntax of path.Match. Rights are ordered, each including the ones
+// before it:
+//
+//	read   GET, GETV, HISTORY, SCAN and WATCH
+//	write  PUT, DEL, INCR, DECR, APPEND, GETSET, CAS, PUTNX and PUTXX
+//	admin  MAKE and DROP
+//
+// A user's right on a table is the highest of its grants matching the table.
+// Admins have every right on every table, and are the only ones to STOP the
+// server, SYNC replicas or change the cluster.
+// -----------------------------------------------------------------------------
+
+// Right is what a user may do with a table.
+type Right uint8
+
+const (
+	RightNone Right = iota
+	RightRead
+	RightWrite
+	RightAdmin
+)
+
+func (r Right) String() string {
+	switch r {
+	case RightRead:
+		return "read"
+	case RightWrite:
+		return "write"
+	case RightAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// ParseRight returns the right called s, read, write or admin.
+func ParseRight(s string) (Right, error) {
+	switch strings.ToLower(s) {
+	case "read":
+		return RightRead, nil
+	case "write":
+		return RightWrite, nil
+	case "admin":
+		return RightAdmin, nil
+	default:
+		return RightNone, response.Errorf(response.CodeInvalid, "unknown right %s, use read, write or admin", s)
+	}
+}
+
+// Grant is a right on the tables matching Pattern.
+type Grant struct {
+	Pattern string
+	Right   Right
+}
+
+// String returns the grant as it is written in the credentials file, e.g.
+// read:logs_*.
+func (g Grant) String() string { return g.Right.String() + ":" + g.Pattern }
+
+// parseGrant parses a grant written by Grant.String.
+func parseGrant(s string) (Grant, error) {
+	name, pattern, _ := strings.Cut(s, ":")
+	right, err := ParseRight(name)
+	if err != nil {
+		return Grant{}, err
+	}
+	if err := validPattern(pattern); err != nil {
+		return Grant{}, err
+	}
+	return Grant{Pattern: pattern, Right: right}, nil
+}
+
+func validPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" || strings.ContainsFunc(pattern, isSpace) {
+		return response.Errorf(response.CodeInvalid, "invalid table pattern %q", pattern)
+	}
+	return nil
+}
+
+func isSpace(r rune) bool { return r == ' ' || r == '\t' || r == '\n' || r == '\r' }
+
+// rightOn returns the user's right on table.
+func (u *User) rightOn(table string) Right {
+	if u.Admin {
+		return RightAdmin
+	}
+	right := RightNone
+	for _, g := range u.Grants {
+		if ok, _ := path.Match(g.Pattern, table); ok && g.Right > right {
+			right = g.Right
+		}
+	}
+	return right
+}
+
+// -------Checks----------------------------------------------------------------
+
+// Check returns a DENIED error unless the user called name has the right need,
+// or a higher one, on table. Everything is allowed while authentication is
+// disabled.
+func Check(name, table string, need Right) error {
+	if !Enabled() {
+		return nil
+	}
+	table = parser.NormalizeTableKey(table)
+	if u := Lookup(name); u != nil && u.rightOn(table) >= need {
+		return nil
+	}
+	return response.Errorf(response.CodeDenied, "%s has no %s right on %s", name, need, table)
+}
+
+// CheckAdmin returns a DENIED error unless the user called name is an admin.
+// what names the command for the error, e.g. STOP.
+func CheckAdmin(name, what string) error {
+	if IsAdmin(name) {
+		return nil
+	}
+	return response.Errorf(response.CodeDenied, "only admins can run %s", what)
+}
+
+// -------Managing Grants-------------------------------------------------------
+
+// AddGrant gives the user name the right on the tables matching pattern,
+// replacing the user's grant on that pattern if it has one, and saves the
+// credentials file.
+func AddGrant(name, pattern string, right Right) error {
+	if err := validPattern(pattern); err != nil {
+		return err
+	}
+
+	return updateUser(name, func(u *User) error {
+		g := Grant{Pattern: pattern, Right: right}
+		if i := slices.IndexFunc(u.Grants, func(g Grant) bool { return g.Pattern == pattern }); i >= 0 {
+			u.Grants[i] = g
+		} else {
+			u.Grants = append(u.Grants, g)
+		}
+		return nil
+	})
+}
+
+// RemoveGrant removes the user name's grant on pattern and saves the
+// credentials file.
+func RemoveGrant(name, pattern string) error {
+	return updateUser(name, func(u *User) error {
+		n := len(u.Grants)
+		u.Grants = slices.DeleteFunc(u.Grants, func(g Grant) bool { return g.Pattern == pattern })
+		if len(u.Grants) == n {
+			return response.Errorf(response.CodeInvalid, "%s has no grant on %s", name, pattern)
+		}
+		return nil
+	})
+}
+
+// updateUser changes a copy of the user name with update and saves it.
+func updateUser(name string, update func(u *User) error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := requireLoadedLocked(); err != nil {
+		return err
+	}
+	old, found := store.users[name]
+	if !found {
+		return response.Errorf(response.CodeInvalid, "no user %s", name)
+	}
+
+	u := *old
+	u.Grants = slices.Clone(old.Grants)
+	if err := update(&u); err != nil {
+		return err
+	}
+
+	users := cloneUsersLocked()
+	users[name] = &u
+	return saveLocked(users)
+}
@@ -0,0 +1,409 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"orchiddb/response"
+)
+
+// -----------------------------------------------------------------------------
+// The users allowed to connect are kept in a credentials file, one per line:
+//
+//	name pbkdf2-sha256$iterations$salt$hash [admin] [right:pattern...]
+//
+// where salt and hash are base64 encoded and the password hash is PBKDF2 with
+// SHA-256 over a random salt of the user's own. The grants that follow give
+// the user rights on tables, see acl.go. Admins manage the users. The
+// file is rewritten as a whole on every change, and created with a single
+// admin user and a random password if it does not exist.
+//
+// Authentication is enabled once a file is loaded. Until then every
+// connection has every right.
+// -----------------------------------------------------------------------------
+
+const (
+	hashScheme     = "pbkdf2-sha256"
+	hashIterations = 100_000
+	saltSize       = 16
+	hashSize       = 32
+
+	// MaxNameLen is the longest user name, in bytes.
+	MaxNameLen = 64
+
+	// firstUser is the admin the credentials file is created with.
+	firstUser = "admin"
+)
+
+var (
+	ErrCredentials = response.Errorf(response.CodeNoAuth, "invalid user or password")
+	ErrNotAdmin    = response.Errorf(response.CodeDenied, "only admins can manage users")
+)
+
+// User is a user of the credentials file.
+type User struct {
+	Name   string
+	Admin  bool
+	Grants []Grant // The user's rights on tables, in the order granted.
+	hash   string  // The encoded password hash.
+}
+
+var store struct {
+	mu    sync.RWMutex
+	path  string // Empty until a file is loaded.
+	users map[string]*User
+}
+
+// Enabled returns whether connections must authenticate.
+func Enabled() bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.path != ""
+}
+
+// Load reads the credentials file at path and enables authentication. A
+// missing file is created with an admin user, whose password is printed.
+func Load(path string) error {
+	users, err := readFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		users, err = createFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	store.path, store.users = path, users
+	store.mu.Unlock()
+	return nil
+}
+
+// createFile creates the credentials file at path with the first admin.
+func createFile(path string) (map[string]*User, error) {
+	password := rand.Text()
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string]*User{firstUser: {Name: firstUser, Admin: true, hash: hash}}
+	if err := writeFile(path, users); err != nil {
+		return nil, err
+	}
+	fmt.Printf("created %s with the user %s, password %s\n", path, firstUser, password)
+	return users, nil
+}
+
+// Authenticate returns the user called name if password is its password, or
+// else ErrCredentials.
+func Authenticate(name, password string) (*User, error) {
+	store.mu.RLock()
+	u, found := store.users[name]
+	store.mu.RUnlock()
+
+	if !found {
+		// Take as long as for a user, so names cannot be told apart by time.
+		checkPassword(dummyHash(), password)
+		return nil, ErrCredentials
+	}
+
+	// HTTP clients authenticate every request, so the last password that
+	// matched is remembered, as a fast hash, until the user's hash changes.
+	seen := sha256.Sum256([]byte(password))
+	if v, ok := verified.Load(name); ok && v.(verifiedPassword) == (verifiedPassword{u.hash, seen}) {
+		return u, nil
+	}
+	if !checkPassword(u.hash, password) {
+		return nil, ErrCredentials
+	}
+	verified.Store(name, verifiedPassword{u.hash, seen})
+	return u, nil
+}
+
+// verified maps user names to the last password that matched their hash.
+var verified sync.Map
+
+type verifiedPassword struct {
+	hash     string   // The user's encoded hash at the time.
+	password [32]byte // The SHA-256 of the password.
+}
+
+// dummyHash returns the hash checked against the passwords given for unknown
+// users.
+var dummyHash = sync.OnceValue(func() string {
+	return hashPasswordWith(make([]byte, saltSize), "")
+})
+
+// Lookup returns the user called name, nil if there is none, e.g. since it
+// was removed.
+func Lookup(name string) *User {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.users[name]
+}
+
+// IsAdmin returns whether the user called name is an admin. Everyone is while
+// authentication is disabled.
+func IsAdmin(name string) bool {
+	if !Enabled() {
+		return true
+	}
+	u := Lookup(name)
+	return u != nil && u.Admin
+}
+
+// -------Managing Users--------------------------------------------------------
+
+// AddUser adds the user name, or replaces its password and role if it exists,
+// keeping its grants, and saves the credentials file.
+func AddUser(name, password string, admin bool) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	if password == "" {
+		return response.Errorf(response.CodeInvalid, "the password cannot be empty")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := requireLoadedLocked(); err != nil {
+		return err
+	}
+	if old, found := store.users[name]; found && old.Admin && !admin && adminsLocked() == 1 {
+		return response.Errorf(response.CodeInvalid, "%s is the last admin", name)
+	}
+
+	u := &User{Name: name, Admin: admin, hash: hash}
+	if old, found := store.users[name]; found {
+		u.Grants = old.Grants
+	}
+	users := cloneUsersLocked()
+	users[name] = u
+	return saveLocked(users)
+}
+
+// RemoveUser removes the user name and saves the credentials file. The last
+// admin cannot be removed.
+func RemoveUser(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := requireLoadedLocked(); err != nil {
+		return err
+	}
+	u, found := store.users[name]
+	if !found {
+		return response.Errorf(response.CodeInvalid, "no user %s", name)
+	}
+	if u.Admin && adminsLocked() == 1 {
+		return response.Errorf(response.CodeInvalid, "%s is the last admin", name)
+	}
+
+	users := cloneUsersLocked()
+	delete(users, name)
+	return saveLocked(users)
+}
+
+// Users returns the users ordered by name.
+func Users() []User {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	users := make([]User, 0, len(store.users))
+	for _, u := range store.users {
+		users = append(users, User{Name: u.Name, Admin: u.Admin, Grants: slices.Clone(u.Grants)})
+	}
+	slices.SortFunc(users, func(a, b User) int { return strings.Compare(a.Name, b.Name) })
+	return users
+}
+
+func requireLoadedLocked() error {
+	if store.path == "" {
+		return response.Errorf(response.CodeUnsupported, "authentication is not enabled")
+	}
+	return nil
+}
+
+func adminsLocked() int {
+	n := 0
+	for _, u := range store.users {
+		if u.Admin {
+			n++
+		}
+	}
+	return n
+}
+
+// cloneUsersLocked returns a copy of the users to change, so the store only
+// changes once the file is saved.
+func cloneUsersLocked() map[string]*User {
+	users := make(map[string]*User, len(store.users)+1)
+	for name, u := range store.users {
+		users[name] = u
+	}
+	return users
+}
+
+// saveLocked writes users to the credentials file and makes them the store's.
+func saveLocked(users map[string]*User) error {
+	if err := writeFile(store.path, users); err != nil {
+		return err
+	}
+	store.users = users
+	return nil
+}
+
+func validName(name string) error {
+	if name == "" || len(name) > MaxNameLen || strings.ContainsFunc(name, notNameRune) {
+		return response.Errorf(
+			response.CodeInvalid, "invalid user name %q, use up to %d letters, digits, '-', '_' or '.'", name, MaxNameLen,
+		)
+	}
+	return nil
+}
+
+func notNameRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		r == '-' || r == '_' || r == '.')
+}
+
+// -------Password Hashes-------------------------------------------------------
+
+// hashPassword returns the encoded hash of password with a new random salt.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hashPasswordWith(salt, password), nil
+}
+
+func hashPasswordWith(salt []byte, password string) string {
+	key, _ := pbkdf2.Key(sha256.New, password, salt, hashIterations, hashSize)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, hashIterations, enc.EncodeToString(salt), enc.EncodeToString(key))
+}
+
+// checkPassword returns whether password matches the encoded hash.
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// -------File------------------------------------------------------------------
+
+func readFile(path string) (map[string]*User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := map[string]*User{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected a name, a password hash, an optional admin and grants", path, n)
+		}
+		if err := validName(fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+
+		u := &User{Name: fields[0], hash: fields[1]}
+		for _, f := range fields[2:] {
+			if f == "admin" {
+				u.Admin = true
+				continue
+			}
+			g, err := parseGrant(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+			u.Grants = append(u.Grants, g)
+		}
+		users[u.Name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// writeFile replaces the file at path with users, readable by its owner only.
+func writeFile(path string, users map[string]*User) error {
+	var b strings.Builder
+	b.WriteString("# Orchid users: name password-hash [admin] [right:pattern...]\n")
+	for _, name := range slices.Sorted(maps.Keys(users)) {
+		u := users[name]
+		b.WriteString(u.Name + " " + u.hash)
+		if u.Admin {
+			b.WriteString(" admin")
+		}
+		for _, g := range u.Grants {
+			b.WriteString(" " + g.String())
+		}
+		b.WriteByte('\n')
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot save users: %w", err)
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot save users: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot save users: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot save users: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("cannot save users: %w", err)
+	}
+	return nil
+}
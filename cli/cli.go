@@ -0,0 +1,337 @@
+package cli
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+
+	"orchiddb/client"
+	"orchiddb/parser"
+)
+
+// -----------------------------------------------------------------------------
+// The cli is an interactive client for a server. On a terminal it offers line
+// editing, a history kept across sessions and tab completion of keywords and
+// table names. Queries can also be piped in, one per line.
+//
+// Every response is printed in a readable form, followed by the time the
+// query took.
+// -----------------------------------------------------------------------------
+
+const (
+	prompt      = "orchid> "
+	batchPrompt = " batch> "
+
+	historyFile = ".orchid_history"
+	historySize = 100
+)
+
+type config struct {
+	addr    string
+	port    int
+	timeout time.Duration
+
+	tls     bool   // Connect over TLS, implied by the other TLS options.
+	tlsCA   string // PEM file of the CAs the server's certificate is checked against.
+	tlsCert string // PEM client certificate file, for servers that require one.
+	tlsKey  string
+
+	user     string
+	password string // Taken from ORCHID_PASSWORD or asked for if empty.
+}
+
+// repl is a session with a server.
+type repl struct {
+	c     *client.Client
+	out   io.Writer
+	term  *term.Terminal // Nil if stdin is not a terminal.
+	comp  *completer
+	batch []string // Queries of the open BATCH, nil if there is none.
+
+	history *os.File // Lines read are appended, nil if it cannot be opened.
+}
+
+// Run parses the cli options from argv and runs queries against the server
+// until the input ends. Returns the process exit code.
+func Run(argv []string) int {
+	cfg, err := parseArgs(argv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	tlsCfg, err := cfg.tlsConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if err := cfg.askPassword(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	addr := fmt.Sprintf("%s:%d", cfg.addr, cfg.port)
+	c, err := client.Dial(addr, client.Options{
+		PoolSize: 1,
+		Timeout:  cfg.timeout,
+		TLS:      tlsCfg,
+		User:     cfg.user,
+		Password: cfg.password,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer c.Close()
+
+	r := &repl{c: c, out: os.Stdout}
+	r.comp = newCompleter(c)
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return r.runPiped(os.Stdin)
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer term.Restore(fd, state)
+
+	r.term = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, prompt)
+	r.term.AutoCompleteCallback = r.complete
+	r.out = r.term
+	r.loadHistory()
+	if r.history != nil {
+		defer r.history.Close()
+	}
+
+	fmt.Fprintf(r.out, "connected to %s, type help for help\n", addr)
+	for {
+		line, err := r.term.ReadLine()
+		if err != nil && !errors.Is(err, term.ErrPasteIndicator) {
+			return 0
+		}
+		r.saveHistory(line)
+		if !r.handle(line) {
+			return 0
+		}
+		if r.batch != nil {
+			r.term.SetPrompt(batchPrompt)
+		} else {
+			r.term.SetPrompt(prompt)
+		}
+	}
+}
+
+func parseArgs(argv []string) (*config, error) {
+	cfg := &config{}
+
+	fs := flag.NewFlagSet("cli", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+
+	fs.StringVar(&cfg.addr, "addr", "127.0.0.1", "Address of the server.")
+	fs.IntVar(&cfg.port, "port", 6000, "Port of the server.")
+	fs.DurationVar(&cfg.timeout, "timeout", 5*time.Second, "Time a query has to respond.")
+	fs.BoolVar(&cfg.tls, "tls", false, "Connect over TLS.")
+	fs.StringVar(&cfg.tlsCA, "tls-ca", "", "PEM file of the CAs to verify the server with. Implies -tls.")
+	fs.StringVar(&cfg.tlsCert, "tls-cert", "", "PEM client certificate file. Implies -tls.")
+	fs.StringVar(&cfg.tlsKey, "tls-key", "", "PEM private key file of -tls-cert.")
+	fs.StringVar(&cfg.user, "user", "", "User to authenticate as.")
+	fs.StringVar(&cfg.password, "password", "", "Password of -user, ORCHID_PASSWORD or asked for if empty.")
+
+	if err := fs.Parse(argv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// askPassword sets the password of the user, if there is one and no password
+// was given, from ORCHID_PASSWORD or else by asking on the terminal.
+func (cfg *config) askPassword() error {
+	if cfg.user == "" || cfg.password != "" {
+		return nil
+	}
+	if cfg.password = os.Getenv("ORCHID_PASSWORD"); cfg.password != "" {
+		return nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("-user needs -password or ORCHID_PASSWORD when the input is piped")
+	}
+	fmt.Fprintf(os.Stderr, "password for %s: ", cfg.user)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	cfg.password = string(password)
+	return nil
+}
+
+// tlsConfig returns the TLS configuration of the connection, nil for plain
+// TCP.
+func (cfg *config) tlsConfig() (*tls.Config, error) {
+	if !cfg.tls && cfg.tlsCA == "" && cfg.tlsCert == "" {
+		return nil, nil
+	}
+
+	return client.TLSConfig(cfg.tlsCA, cfg.tlsCert, cfg.tlsKey)
+}
+
+// runPiped runs every line of in as a query.
+func (r *repl) runPiped(in io.Reader) int {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if !r.handle(scanner.Text()) {
+			return 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// -------Queries---------------------------------------------------------------
+
+// handle runs a line of input. Returns false once the session should end.
+func (r *repl) handle(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true
+	}
+
+	switch strings.ToLower(line) {
+	case "exit", "quit":
+		return false
+	case "help":
+		r.printHelp()
+		return true
+	}
+
+	cmd := parser.NewParser(parser.NewLexer(line)).ParseCommand()
+	var node parser.Node
+	if cmd != nil {
+		node = cmd.Command
+	}
+
+	if r.batch != nil {
+		if _, ok := node.(*parser.EndCommand); !ok {
+			r.batch = append(r.batch, line)
+			return true
+		}
+		queries := r.batch
+		r.batch = nil
+		r.timed(func() string {
+			if err := r.c.ExecBatch(queries); err != nil {
+				return formatError(err)
+			}
+			return fmt.Sprintf("OK, %d queries committed", len(queries))
+		})
+		return true
+	}
+
+	switch node.(type) {
+	case *parser.BatchCommand:
+		r.batch = []string{}
+		fmt.Fprintln(r.out, "batch opened, END commits it")
+		return true
+	case *parser.WatchCommand, *parser.SyncCommand:
+		fmt.Fprintf(r.out, "(error) %s streams and is not supported by the cli\n", node.TokenLiteral())
+		return true
+	}
+
+	stopped := false
+	r.timed(func() string {
+		lines, err := r.c.Exec(line)
+		if errors.Is(err, client.ErrNil) {
+			return "(nil)"
+		}
+		if err != nil {
+			return formatError(err)
+		}
+		switch node.(type) {
+		case *parser.MakeCommand, *parser.DropCommand:
+			r.comp.refreshTables()
+		case *parser.StopCommand:
+			stopped = true
+		}
+		return format(node, lines)
+	})
+	return !stopped
+}
+
+// timed runs query and prints its output followed by the time it took.
+func (r *repl) timed(query func() string) {
+	start := time.Now()
+	out := query()
+	elapsed := time.Since(start)
+
+	fmt.Fprintln(r.out, out)
+	fmt.Fprintf(r.out, "(%s)\n", elapsed.Round(time.Microsecond))
+}
+
+func (r *repl) printHelp() {
+	fmt.Fprint(r.out, `Queries are sent as typed, e.g. GET(table, key). Quote arguments with
+spaces or symbols, e.g. PUT(table, key, "a, b"). BATCH opens a batch that END
+commits. TAB completes keywords and table names.
+
+Keywords: `+strings.Join(parser.Keywords(), " ")+`
+exit or quit ends the session.
+`)
+}
+
+// -------History---------------------------------------------------------------
+
+// loadHistory loads the lines of previous sessions into the terminal's
+// history and opens the history file for appending.
+func (r *repl) loadHistory() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+	path := filepath.Join(home, historyFile)
+
+	if data, err := os.ReadFile(path); err == nil {
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		for _, line := range lines[max(0, len(lines)-historySize):] {
+			if line != "" {
+				r.term.History.Add(line)
+			}
+		}
+	}
+
+	r.history, _ = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+}
+
+func (r *repl) saveHistory(line string) {
+	if r.history == nil || strings.TrimSpace(line) == "" {
+		return
+	}
+
+	// Passwords are not written to disk.
+	if cmd := parser.NewParser(parser.NewLexer(strings.TrimSpace(line))).ParseCommand(); cmd != nil {
+		switch cmd.Command.(type) {
+		case *parser.AuthCommand, *parser.AddUserCommand:
+			return
+		}
+	}
+	fmt.Fprintln(r.history, line)
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"orchiddb/client"
+	"orchiddb/parser"
+)
+
+// completer completes keywords and table names.
+type completer struct {
+	c        *client.Client
+	keywords []string
+
+	mu     sync.Mutex
+	tables []string
+}
+
+func newCompleter(c *client.Client) *completer {
+	comp := &completer{c: c, keywords: parser.Keywords()}
+	comp.refreshTables()
+	return comp
+}
+
+// refreshTables loads the table names from the server. The names loaded last
+// are kept if the server cannot list them, e.g. through the router.
+func (comp *completer) refreshTables() {
+	tables, err := comp.c.Tables()
+	if err != nil {
+		return
+	}
+
+	comp.mu.Lock()
+	comp.tables = tables
+	comp.mu.Unlock()
+}
+
+// candidates returns the completions of the word being typed at the end of
+// head, which is a keyword before the opening parenthesis and a table name as
+// the first argument. Keywords complete with their parenthesis.
+func (comp *completer) candidates(head string) (word string, matches []string) {
+	word = head[strings.LastIndexAny(head, "(, ")+1:]
+
+	open := strings.LastIndex(head, "(")
+	switch {
+	case open < 0:
+		for _, kw := range comp.keywords {
+			if strings.HasPrefix(kw, strings.ToUpper(word)) {
+				matches = append(matches, kw+"(")
+			}
+		}
+	case !strings.Contains(head[open:], ","):
+		comp.mu.Lock()
+		for _, tbl := range comp.tables {
+			if strings.HasPrefix(tbl, word) {
+				matches = append(matches, tbl)
+			}
+		}
+		comp.mu.Unlock()
+	}
+	return word, matches
+}
+
+// complete is the terminal's AutoCompleteCallback. TAB replaces the word
+// before the cursor with the longest prefix of its completions, and lists
+// them if there are several to choose from.
+func (r *repl) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	head := line[:pos]
+	word, matches := r.comp.candidates(head)
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completion := matches[0]
+	for _, m := range matches[1:] {
+		completion = commonPrefix(completion, m)
+	}
+	if len(matches) > 1 && len(completion) <= len(word) {
+		slices.Sort(matches)
+		fmt.Fprintln(r.term, strings.Join(matches, "  "))
+	}
+
+	start := len(head) - len(word)
+	return head[:start] + completion + line[pos:], start + len(completion), true
+}
+
+func commonPrefix(a, b string) string {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return a[:n]
+}
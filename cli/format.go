@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"orchiddb/client"
+	"orchiddb/parser"
+)
+
+// format returns the response lines of cmd in a readable form.
+func format(cmd parser.Node, lines []string) string {
+	switch cmd.(type) {
+	case *parser.GetCommand, *parser.GetVersionCommand, *parser.GetSetCommand,
+		*parser.AppendCommand:
+		return strconv.Quote(lines[0])
+	case *parser.IncrCommand:
+		return "(integer) " + lines[0]
+	case *parser.CasCommand, *parser.CondPutCommand:
+		if lines[0] == "1" {
+			return "(written)"
+		}
+		return "(not written)"
+	case *parser.ScanCommand:
+		return formatRows(lines, 2, "item")
+	case *parser.HistoryCommand:
+		return formatRows(lines, 3, "version")
+	case *parser.TablesCommand:
+		return formatRows(lines, 1, "table")
+	case *parser.ClusterCommand, *parser.UsersCommand:
+		return formatRows(lines, 0, "")
+	case *parser.StopCommand:
+		return "server is stopping"
+	default:
+		return "OK"
+	}
+}
+
+// formatRows returns the lines as numbered rows of cols columns, separated by
+// spaces, followed by a count of the rows. Quoted columns, such as keys and
+// values, stay quoted. cols 0 aligns the lines without numbers or a count.
+func formatRows(lines []string, cols int, noun string) string {
+	if len(lines) == 0 {
+		return fmt.Sprintf("(no %ss)", noun)
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for i, line := range lines {
+		if cols == 0 {
+			fmt.Fprintln(w, strings.Replace(line, " ", "\t", 1))
+			continue
+		}
+		fmt.Fprintf(w, "%d)\t%s\n", i+1, strings.Join(splitColumns(line, cols), "\t"))
+	}
+	w.Flush()
+
+	if cols == 0 {
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+	if len(lines) != 1 {
+		noun += "s"
+	}
+	return fmt.Sprintf("%s(%d %s)", b.String(), len(lines), noun)
+}
+
+// splitColumns splits line into at most cols columns at its spaces, keeping
+// quoted columns whole.
+func splitColumns(line string, cols int) []string {
+	var fields []string
+	for line != "" && len(fields) < cols-1 {
+		field, rest, _ := strings.Cut(line, " ")
+		if quoted, err := strconv.QuotedPrefix(line); err == nil && line[0] == '"' {
+			field, rest = quoted, strings.TrimPrefix(line[len(quoted):], " ")
+		}
+		fields = append(fields, field)
+		line = rest
+	}
+	return append(fields, line)
+}
+
+// formatError returns err as the cli prints it.
+func formatError(err error) string {
+	var srvErr *client.ServerError
+	if errors.As(err, &srvErr) {
+		return fmt.Sprintf("(error) %s %s", srvErr.Code, srvErr.Msg)
+	}
+	return "(error) " + strings.TrimPrefix(err.Error(), "orchid: ")
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Package client talks to an Orchid server over its query protocol.
+//
+// A Client keeps a pool of connections, so it can be shared by any number of
+// goroutines. Every request runs on a connection of its own, under a deadline,
+// and a connection that fails is closed instead of going back to the pool. A
+// request that fails on a pooled connection, which the server may have closed
+// since, is retried once on a new connection if it could not be written, or if
+// it only reads. Other requests may have been applied, so they are not sent
+// twice.
+// -----------------------------------------------------------------------------
+
+var (
+	ErrNil    = errors.New("orchid: nil")
+	ErrClosed = errors.New("orchid: client is closed")
+)
+
+// errNotSent wraps the error of a request that could not be written.
+var errNotSent = errors.New("request not sent")
+
+// ServerError is an ERR response of the server.
+type ServerError struct {
+	Code string // The error code, e.g. NOTABLE, see package response.
+	Msg  string
+}
+
+func (e *ServerError) Error() string { return "orchid: " + e.Msg }
+
+// Options configure a Client. Zero values use the defaults.
+type Options struct {
+	PoolSize    int           // Connections open at once, 8 by default.
+	DialTimeout time.Duration // Time to connect, 5s by default.
+	Timeout     time.Duration // Time for a request and its response, 5s by default.
+	TLS         *tls.Config   // Connects over TLS if set.
+	User        string        // Authenticates every connection as User if set.
+	Password    string
+}
+
+// Client is a pool of connections to a server.
+type Client struct {
+	addr string
+	opts Options
+
+	slots chan struct{} // Holds a token for every connection in use.
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// conn is a connection of the pool.
+type conn struct {
+	net.Conn
+	r      *bufio.Reader
+	reused bool // Whether the conn served a request before.
+}
+
+// New returns a client for the server at addr, e.g. "127.0.0.1:6000".
+// Connections are made as requests need them.
+func New(addr string, opts Options) *Client {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 8
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+
+	return &Client{
+		addr:  addr,
+		opts:  opts,
+		slots: make(chan struct{}, opts.PoolSize),
+	}
+}
+
+// Dial returns a client for the server at addr after checking that the server
+// can be reached.
+func Dial(addr string, opts Options) (*Client, error) {
+	c := New(addr, opts)
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	c.put(cn)
+	return c, nil
+}
+
+// TLSConfig returns a TLS configuration that verifies the server with the CAs
+// in the PEM file caFile, or the system's if it is empty, and presents the
+// client certificate in certFile and keyFile if they are set.
+func TLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Close closes the idle connections. Requests in flight finish and close
+// their connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+
+	var errs []error
+	for _, cn := range c.idle {
+		errs = append(errs, cn.Close())
+	}
+	c.idle = nil
+	return errors.Join(errs...)
+}
+
+// -------Pool------------------------------------------------------------------
+
+// do sends req and reads its response with read. A request that fails on a
+// reused connection before a response arrived is sent again on a new one if it
+// was not written, or if readOnly is set.
+func (c *Client) do(req string, readOnly bool, read func(r *bufio.Reader) error) error {
+	for attempt := 0; ; attempt++ {
+		cn, err := c.get()
+		if err != nil {
+			return err
+		}
+
+		err = cn.roundTrip(req, read, c.opts.Timeout)
+		var srvErr *ServerError
+		if err == nil || errors.As(err, &srvErr) {
+			c.put(cn)
+			return err
+		}
+
+		c.discard(cn)
+		if errors.Is(err, errClosedByServer) {
+			return nil
+		}
+		var netErr net.Error
+		timeout := errors.As(err, &netErr) && netErr.Timeout()
+		if cn.reused && attempt == 0 && !timeout && (readOnly || errors.Is(err, errNotSent)) {
+			continue
+		}
+		if timeout {
+			return fmt.Errorf("orchid: no response within %s: %w", c.opts.Timeout, err)
+		}
+		return fmt.Errorf("orchid: %w", err)
+	}
+}
+
+// get takes an idle connection or dials a new one, waiting while PoolSize
+// connections are in use.
+func (c *Client) get() (*conn, error) {
+	c.slots <- struct{}{}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.slots
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	var nc net.Conn
+	var err error
+	if c.opts.TLS != nil {
+		dialer := &net.Dialer{Timeout: c.opts.DialTimeout}
+		nc, err = tls.DialWithDialer(dialer, "tcp", c.addr, c.opts.TLS)
+	} else {
+		nc, err = net.DialTimeout("tcp", c.addr, c.opts.DialTimeout)
+	}
+	if err != nil {
+		<-c.slots
+		return nil, fmt.Errorf("orchid: %w", err)
+	}
+
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.opts.User != "" {
+		if err := c.authenticate(cn); err != nil {
+			nc.Close()
+			<-c.slots
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// authenticate sends the AUTH of a new connection.
+func (c *Client) authenticate(cn *conn) error {
+	req, err := command("AUTH", c.opts.User, c.opts.Password)
+	if err != nil {
+		return err
+	}
+
+	err = cn.roundTrip(req, readStatus, c.opts.Timeout)
+	var srvErr *ServerError
+	if err != nil && !errors.As(err, &srvErr) {
+		return fmt.Errorf("orchid: %w", err)
+	}
+	return err
+}
+
+// put returns cn to the pool.
+func (c *Client) put(cn *conn) {
+	cn.reused = true
+
+	c.mu.Lock()
+	if c.closed {
+		cn.Close()
+	} else {
+		c.idle = append(c.idle, cn)
+	}
+	c.mu.Unlock()
+	<-c.slots
+}
+
+// discard closes cn, which is in an unknown state.
+func (c *Client) discard(cn *conn) {
+	cn.Close()
+	<-c.slots
+}
+
+// roundTrip writes req and reads the response with read, within timeout.
+func (cn *conn) roundTrip(req string, read func(r *bufio.Reader) error, timeout time.Duration) error {
+	if err := cn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if _, err := cn.Write([]byte(req)); err != nil {
+		return fmt.Errorf("%w: %w", errNotSent, err)
+	}
+	return read(cn.r)
+}
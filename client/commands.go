@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"orchiddb/parser"
+	"orchiddb/storage"
+)
+
+// -----------------------------------------------------------------------------
+// Every command of the query protocol responds with OK, a VALUE, NIL or an ERR
+// line, and lists with lines up to an END line, so a response is read up to
+// its end without waiting for anything else. Writes respond once the server
+// applied them, and a command on a missing table fails with ERR NOTABLE.
+// -----------------------------------------------------------------------------
+
+// ErrUnencodable is returned for arguments the query protocol cannot carry.
+var ErrUnencodable = errors.New("orchid: arguments cannot contain newlines or NUL bytes")
+
+// Item is a key and its value, as returned by Scan.
+type Item struct {
+	Key   string
+	Value string
+}
+
+// Get returns the value of key in table, or ErrNil if there is none.
+func (c *Client) Get(table, key string) (string, error) {
+	req, err := command("GET", table, key)
+	if err != nil {
+		return "", err
+	}
+
+	var value string
+	var found bool
+	err = c.do(req, true, func(r *bufio.Reader) error {
+		value, found, err = readValue(r)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", ErrNil
+	}
+	return value, nil
+}
+
+// Put sets key to value in table. Returns once the server applied the write.
+func (c *Client) Put(table, key, value string) error {
+	req, err := command("PUT", table, key, value)
+	if err != nil {
+		return err
+	}
+	return c.do(req, false, readStatus)
+}
+
+// Del removes key from table. Returns once the server applied the delete.
+func (c *Client) Del(table, key string) error {
+	req, err := command("DEL", table, key)
+	if err != nil {
+		return err
+	}
+	return c.do(req, false, readStatus)
+}
+
+// Make makes table as a B-tree, unless it exists.
+func (c *Client) Make(table string) error {
+	return c.MakeKind(table, storage.KindBTree, 0)
+}
+
+// MakeKind makes table of the given kind, one of the storage Kind constants,
+// that keeps versions per key if versions is not 0. Does nothing if table
+// exists.
+func (c *Client) MakeKind(table, kind string, versions int) error {
+	args := []string{table, kind}
+	if versions != 0 {
+		args = append(args, strconv.Itoa(versions))
+	}
+	req, err := command("MAKE", args...)
+	if err != nil {
+		return err
+	}
+	return c.do(req, false, readStatus)
+}
+
+// Drop drops table and removes its files. Returns a ServerError with the code
+// NOTABLE if it does not exist.
+func (c *Client) Drop(table string) error {
+	req, err := command("DROP", table)
+	if err != nil {
+		return err
+	}
+	return c.do(req, false, readStatus)
+}
+
+// Scan returns the items of table with keys from start up to, but excluding,
+// end, in key order. An empty end scans to the end of the table.
+func (c *Client) Scan(table, start, end string) ([]Item, error) {
+	req, err := command("SCAN", table, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	err = c.do(req, true, func(r *bufio.Reader) error {
+		lines, err := readList(r)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			fields, err := Fields(line)
+			if err != nil || len(fields) != 2 {
+				return fmt.Errorf("unexpected response: scan line %q", line)
+			}
+			items = append(items, Item{Key: fields[0], Value: fields[1]})
+		}
+		return nil
+	})
+	return items, err
+}
+
+// Fields splits a line of a list response at its spaces and unquotes the
+// quoted fields, e.g. the key and value of a SCAN line.
+func Fields(line string) ([]string, error) {
+	var fields []string
+	for line != "" {
+		field, rest, _ := strings.Cut(line, " ")
+		if strings.HasPrefix(line, `"`) {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, err
+			}
+			if field, err = strconv.Unquote(quoted); err != nil {
+				return nil, err
+			}
+			rest = strings.TrimPrefix(line[len(quoted):], " ")
+		}
+		fields = append(fields, field)
+		line = rest
+	}
+	return fields, nil
+}
+
+// -------Protocol--------------------------------------------------------------
+
+// command returns the query line of the command name with args quoted.
+func command(name string, args ...string) (string, error) {
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('(')
+	for i, arg := range args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		q, err := Quote(arg)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(q)
+	}
+	b.WriteString(")\n")
+	return b.String(), nil
+}
+
+// Quote returns s as a double quoted string of the query language, which can
+// hold any character but newlines and NUL bytes.
+func Quote(s string) (string, error) {
+	if strings.ContainsAny(s, "\n\x00") {
+		return "", ErrUnencodable
+	}
+	return parser.Quote(s), nil
+}
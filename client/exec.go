@@ -0,0 +1,148 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"orchiddb/parser"
+	"orchiddb/response"
+)
+
+// errClosedByServer is returned by readers of commands after which the server
+// closes the connection.
+var errClosedByServer = errors.New("connection closed by the server")
+
+// Exec sends a query line as is and returns the lines of its response, without
+// the END line of lists. The response of commands with a value, e.g. GET or
+// INCR, is a single line, or ErrNil if they have none. Commands that respond
+// OK return no lines.
+//
+// BATCH, WATCH and SYNC cannot be sent with Exec. Use ExecBatch for batches.
+// An AUTH sent with Exec only authenticates the connection it ran on, set
+// Options.User to authenticate every connection.
+func (c *Client) Exec(query string) ([]string, error) {
+	if strings.ContainsAny(query, "\n\x00") {
+		return nil, ErrUnencodable
+	}
+	cmd := parser.NewParser(parser.NewLexer(query)).ParseCommand()
+	if cmd == nil || cmd.Command == nil {
+		return nil, &ServerError{Code: string(response.CodeParse), Msg: "invalid query"}
+	}
+
+	req := query + "\n"
+	var lines []string
+	var read func(r *bufio.Reader) error
+	found := true // Whether a command with a value had one.
+
+	switch t := cmd.Command.(type) {
+	case *parser.GetCommand, *parser.GetVersionCommand, *parser.IncrCommand,
+		*parser.AppendCommand, *parser.GetSetCommand, *parser.CasCommand,
+		*parser.CondPutCommand:
+		read = func(r *bufio.Reader) error {
+			value, ok, err := readValue(r)
+			lines, found = []string{value}, ok
+			return err
+		}
+	case *parser.ScanCommand, *parser.HistoryCommand, *parser.TablesCommand,
+		*parser.ClusterCommand, *parser.UsersCommand:
+		read = func(r *bufio.Reader) error {
+			var err error
+			lines, err = readList(r)
+			return err
+		}
+	case *parser.PutCommand, *parser.DelCommand, *parser.MakeCommand,
+		*parser.DropCommand, *parser.JoinCommand, *parser.LeaveCommand,
+		*parser.AuthCommand, *parser.AddUserCommand, *parser.DelUserCommand,
+		*parser.GrantCommand, *parser.RevokeCommand:
+		read = readStatus
+	case *parser.StopCommand:
+		read = func(r *bufio.Reader) error {
+			if err := readStatus(r); err != nil {
+				return err
+			}
+			if _, err := r.ReadString('\n'); err != io.EOF {
+				return fmt.Errorf("unexpected response to STOP: %v", err)
+			}
+			return errClosedByServer
+		}
+	default:
+		return nil, fmt.Errorf("orchid: %s cannot be sent with Exec", t.TokenLiteral())
+	}
+
+	readOnly := false
+	switch cmd.Command.(type) {
+	case *parser.GetCommand, *parser.GetVersionCommand, *parser.ScanCommand,
+		*parser.HistoryCommand, *parser.TablesCommand, *parser.ClusterCommand,
+		*parser.UsersCommand:
+		readOnly = true
+	}
+
+	if err := c.do(req, readOnly, read); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNil
+	}
+	return lines, nil
+}
+
+// ExecBatch sends the PUT and DEL query lines as a BATCH, which commits them
+// all-or-nothing.
+func (c *Client) ExecBatch(queries []string) error {
+	var b strings.Builder
+	b.WriteString("BATCH\n")
+	for _, q := range queries {
+		if strings.ContainsAny(q, "\n\x00") {
+			return ErrUnencodable
+		}
+		b.WriteString(q)
+		b.WriteByte('\n')
+	}
+	b.WriteString("END\n")
+
+	return c.do(b.String(), false, readStatus)
+}
+
+// Tables returns the names of the server's tables in order.
+func (c *Client) Tables() ([]string, error) {
+	return c.Exec("TABLES()")
+}
+
+// readValue reads a VALUE or NIL response. found is false for NIL.
+func readValue(r *bufio.Reader) (value string, found bool, err error) {
+	reply, err := response.Read(r)
+	if err != nil {
+		return "", false, serverError(err)
+	}
+	return string(reply.Value), !reply.Nil, nil
+}
+
+// readStatus reads an OK response.
+func readStatus(r *bufio.Reader) error {
+	reply, err := response.Read(r)
+	if err != nil {
+		return serverError(err)
+	}
+	if reply.Nil || reply.Value != nil {
+		return errors.New("unexpected response: expected OK")
+	}
+	return nil
+}
+
+// readList reads lines up to an END line, or a single ERR line.
+func readList(r *bufio.Reader) ([]string, error) {
+	lines, err := response.ReadList(r)
+	return lines, serverError(err)
+}
+
+// serverError returns err as a ServerError if it is an ERR response.
+func serverError(err error) error {
+	var e *response.Error
+	if errors.As(err, &e) {
+		return &ServerError{Code: string(e.Code), Msg: e.Err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,272 @@
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"orchiddb/filestamp"
+	"orchiddb/parser"
+	"orchiddb/paths"
+	"orchiddb/response"
+	"orchiddb/storage"
+	"orchiddb/vfs"
+)
+
+// -----------------------------------------------------------------------------
+// Multi-table batches.
+//
+// A batch is split into one part per table and every part is sent to its
+// table's worker, which stages the operations and writes them to a batch log.
+// Once every part is prepared, the batch's commit record is written and the
+// workers commit. A worker does not take any other command between preparing
+// and committing its part, so no other commit can include the staged changes.
+// A part that fails to commit after the commit record is written is retried by
+// its worker before it takes another command, and its batch log and the commit
+// record are only removed once it is committed.
+// See storage/batch.go for how the logs and commit record are recovered.
+// -----------------------------------------------------------------------------
+
+// batchMutex serializes batches, so two batches never wait on each other's
+// workers.
+var batchMutex sync.Mutex
+
+// batchRetryInterval is how long a worker waits before committing its part of
+// a committed batch again.
+const batchRetryInterval = time.Second
+
+// batchPart is the share of a batch for a single table.
+// It implements parser.Node so it can be sent through a worker's channel.
+type batchPart struct {
+	id     string
+	table  string
+	worker *TableWorker
+	ops    []storage.BatchOp
+
+	prepared chan error // The worker's result of staging and logging ops.
+	commit   chan bool  // The coordinator's decision, commit or roll back.
+	done     chan error // The worker's result of the decision.
+	applied  chan error // The worker's result of retrying a failed commit.
+}
+
+func (bp *batchPart) TokenLiteral() string { return parser.BATCH }
+func (bp *batchPart) GetTable() string     { return bp.table }
+
+func (bp *batchPart) String() string {
+	return fmt.Sprintf("batch %s: %d ops on %s", bp.id, len(bp.ops), bp.table)
+}
+
+// ExecuteBatch commits the PUT and DEL commands in cmds to their tables
+// all-or-nothing. Returns an error if the batch was not committed.
+func ExecuteBatch(cmds []*parser.Command) error {
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	id := filestamp.FileStamp()
+
+	var parts []*batchPart
+	byTable := map[string]*batchPart{}
+	for _, cmd := range cmds {
+		tbl := parser.NormalizeTableKey(cmd.Command.GetTable())
+
+		part, exists := byTable[tbl]
+		if !exists {
+			part = &batchPart{
+				id:       id,
+				table:    tbl,
+				prepared: make(chan error, 1),
+				commit:   make(chan bool, 1),
+				done:     make(chan error, 1),
+				applied:  make(chan error, 1),
+			}
+			byTable[tbl] = part
+			parts = append(parts, part)
+		}
+
+		switch t := cmd.Command.(type) {
+		case *parser.PutCommand:
+			part.ops = append(part.ops, storage.BatchOp{
+				Key: []byte(t.Key), Value: []byte(t.Value),
+			})
+		case *parser.DelCommand:
+			part.ops = append(part.ops, storage.BatchOp{
+				Key: []byte(t.Key), Del: true,
+			})
+		default:
+			return response.Errorf(
+				response.CodeInvalid, "batch aborted: %s cannot be batched", cmd.Command.TokenLiteral(),
+			)
+		}
+	}
+
+	// ---- prepare
+	catalogMutex.Lock()
+	for i, part := range parts {
+		part.worker = workerOf(part.table)
+		if part.worker == nil {
+			for _, taken := range parts[:i] {
+				taken.worker.release()
+			}
+			catalogMutex.Unlock()
+			return fmt.Errorf("batch aborted: %w: %s", ErrNoTable, part.table)
+		}
+	}
+	catalogMutex.Unlock()
+
+	for _, part := range parts {
+		// A part whose table is dropped meanwhile is never executed, so it
+		// answers for its worker.
+		if !part.worker.send(&parser.Command{Command: part}) {
+			part.prepared <- fmt.Errorf("%w: %s", ErrNoTable, part.table)
+			part.done <- nil
+		}
+	}
+
+	var errs []error
+	for _, part := range parts {
+		errs = append(errs, <-part.prepared)
+	}
+	err := errors.Join(errs...)
+
+	// ---- commit point
+	commitPath := paths.BatchCommitPath(id)
+	if err == nil {
+		err = storage.WriteBatchCommit(vfs.Default, commitPath)
+	}
+
+	// ---- commit or roll back
+	for _, part := range parts {
+		part.commit <- err == nil
+	}
+	errs = errs[:0]
+	var retrying []*batchPart
+	for _, part := range parts {
+		partErr := <-part.done
+		if partErr != nil && err == nil {
+			retrying = append(retrying, part)
+		}
+		errs = append(errs, partErr)
+	}
+	if err != nil {
+		vfs.Default.Remove(commitPath)
+		return fmt.Errorf("batch aborted: %w", err)
+	}
+
+	// A part that failed to commit is retried by its worker, or replayed from
+	// its log on startup if the worker stops first, which needs the commit
+	// record to be kept until then.
+	if len(retrying) > 0 {
+		go removeBatchCommit(commitPath, retrying)
+		return fmt.Errorf("batch %s is committed but not yet applied: %w", id, errors.Join(errs...))
+	}
+	return vfs.Default.Remove(commitPath)
+}
+
+// removeBatchCommit removes the commit record at commitPath once the workers
+// retrying the parts have committed them.
+func removeBatchCommit(commitPath string, parts []*batchPart) {
+	for _, part := range parts {
+		if err := <-part.applied; err != nil {
+			fmt.Printf("Error applying batch %s to %s, it is recovered on startup: %v\n", part.id, part.table, err)
+			return
+		}
+	}
+	if err := vfs.Default.Remove(commitPath); err != nil {
+		fmt.Printf("Error removing batch commit %s: %v\n", commitPath, err)
+	}
+}
+
+// batch prepares the worker's part of a batch, then waits for the
+// coordinator's decision and commits or rolls back the table.
+// In-memory tables have nothing to recover and write no batch log.
+func (tw *TableWorker) batch(part *batchPart) error {
+	logPath := paths.BatchLogPath(tw.name, part.id)
+	durable := !tw.isMemory()
+
+	// The sequence is reserved before the log is written, as the log may be
+	// replayed on startup.
+	err := storage.ApplyBatch(tw.tbl, part.ops)
+	if err == nil {
+		err = tw.feed.reserve(len(part.ops))
+	}
+	if err == nil && durable {
+		err = storage.WriteBatchLog(vfs.Default, logPath, part.ops)
+	}
+	if err != nil {
+		err = fmt.Errorf("table %s: %w", tw.name, err)
+	}
+	part.prepared <- err
+
+	if !<-part.commit {
+		err := tw.tbl.Rollback()
+		if durable {
+			vfs.Default.Remove(logPath)
+		}
+		part.done <- err
+		return err
+	}
+
+	err = tw.commit(part.ops...)
+	committed := err == nil
+	if committed && durable {
+		err = removeBatchLog(logPath)
+	}
+	part.done <- err
+	if err == nil {
+		return nil
+	}
+
+	// The batch is committed, so the part must be too before the worker takes
+	// another command. Otherwise its next commit would include the staged
+	// changes, and replaying the log on startup would undo the commits after
+	// it.
+	for err != nil {
+		fmt.Printf("Error committing batch %s to %s, retrying: %v\n", part.id, tw.name, err)
+		select {
+		case <-time.After(batchRetryInterval):
+		case <-tw.stop:
+			// The log and the commit record are kept for startup to replay,
+			// which must happen before any other commit to the table.
+			tw.fenced = fmt.Errorf("table %s stopped before applying batch %s: %w", tw.name, part.id, err)
+			part.applied <- err
+			return err
+		}
+
+		if !committed {
+			err = tw.tbl.Rollback()
+			if err == nil {
+				err = storage.ApplyBatch(tw.tbl, part.ops)
+			}
+			if err == nil {
+				err = tw.commit(part.ops...)
+			}
+			committed = err == nil
+		}
+		if committed && durable {
+			err = removeBatchLog(logPath)
+		}
+	}
+	part.applied <- nil
+	return nil
+}
+
+// removeBatchLog removes the batch log at logPath, if it still exists.
+func removeBatchLog(logPath string) error {
+	err := vfs.Default.Remove(logPath)
+	if errors.Is(err, vfs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// isMemory returns whether the worker's table only lives in memory.
+func (tw *TableWorker) isMemory() bool {
+	return inMemory(tw.tbl)
+}
+
+// inMemory returns whether tbl only lives in memory.
+func inMemory(tbl storage.Engine) bool {
+	m, ok := tbl.(interface{ IsMemory() bool })
+	return ok && m.IsMemory()
+}
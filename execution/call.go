@@ -0,0 +1,155 @@
+package execution
+
+import (
+	"bytes"
+	"fmt"
+
+	"orchiddb/parser"
+	"orchiddb/response"
+	"orchiddb/storage"
+)
+
+// -----------------------------------------------------------------------------
+// Calls run typed operations on a table's worker, in order with the table's
+// other commands, and return their results instead of writing a response to a
+// connection. They serve the protocols that are not query lines, such as the
+// binary protocol.
+// -----------------------------------------------------------------------------
+
+// ErrNoTable is returned by calls to a table that is not loaded.
+var ErrNoTable = response.Errorf(response.CodeNoTable, "no worker loaded for table")
+
+// call is an operation run on a worker's goroutine.
+// It implements parser.Node so it can be sent through a worker's channel.
+type call struct {
+	table string
+	fn    func(tw *TableWorker) error
+	done  chan error
+}
+
+func (c *call) TokenLiteral() string { return "CALL" }
+func (c *call) GetTable() string     { return c.table }
+func (c *call) String() string       { return "call on " + c.table }
+
+// callWorker runs fn on the worker of table and waits for its result.
+func callWorker(table string, fn func(tw *TableWorker) error) error {
+	table = parser.NormalizeTableKey(table)
+
+	catalogMutex.Lock()
+	worker := workerOf(table)
+	catalogMutex.Unlock()
+
+	c := &call{table: table, fn: fn, done: make(chan error, 1)}
+	if worker == nil || !worker.send(&parser.Command{Command: c}) {
+		return fmt.Errorf("%w: %s", ErrNoTable, table)
+	}
+	return <-c.done
+}
+
+// Get returns the value of key in table. found is false if there is none.
+func Get(table string, key []byte) (value []byte, found bool, err error) {
+	err = callWorker(table, func(tw *TableWorker) error {
+		item, err := tw.tbl.Get(key)
+		if err != nil || item == nil {
+			return err
+		}
+		value, found = bytes.Clone(item.Value), true
+		return nil
+	})
+	return value, found, err
+}
+
+// Put sets key to value in table and commits it.
+func Put(table string, key, value []byte) error {
+	return callWorker(table, func(tw *TableWorker) error {
+		return tw.apply(storage.BatchOp{Key: key, Value: value})
+	})
+}
+
+// Del removes key from table and commits it. found is false if there was no
+// key to remove.
+func Del(table string, key []byte) (found bool, err error) {
+	err = callWorker(table, func(tw *TableWorker) error {
+		item, err := tw.tbl.Get(key)
+		if err != nil {
+			return err
+		}
+		found = item != nil
+		return tw.apply(storage.BatchOp{Key: key, Del: true})
+	})
+	return found, err
+}
+
+// Incr adds delta to the integer value of key in table, counting from 0 for
+// an absent key, commits it and returns the result.
+func Incr(table string, key []byte, delta int64) (n int64, err error) {
+	err = callWorker(table, func(tw *TableWorker) error {
+		n, err = tw.add(key, delta, "INCR")
+		return err
+	})
+	return n, err
+}
+
+// Scan returns the items of table with keys from start up to, but excluding,
+// end, in key order. A nil end scans to the end of the table.
+func Scan(table string, start, end []byte) ([]*storage.Item, error) {
+	var items []*storage.Item
+	err := callWorker(table, func(tw *TableWorker) error {
+		cursor, err := tw.tbl.Cursor()
+		if err != nil {
+			return err
+		}
+
+		item, err := cursor.Seek(start)
+		for ; item != nil && err == nil; item, err = cursor.Next() {
+			if end != nil && bytes.Compare(item.Key, end) >= 0 {
+				break
+			}
+			items = append(items, storage.NewItem(bytes.Clone(item.Key), bytes.Clone(item.Value)))
+		}
+		return err
+	})
+	return items, err
+}
+
+// Keys returns up to n keys of table in key order, starting after the key
+// after, or from the first key if after is nil.
+func Keys(table string, after []byte, n int) ([][]byte, error) {
+	var keys [][]byte
+	err := callWorker(table, func(tw *TableWorker) error {
+		cursor, err := tw.tbl.Cursor()
+		if err != nil {
+			return err
+		}
+
+		item, err := cursor.Seek(after)
+		if item != nil && err == nil && after != nil && bytes.Equal(item.Key, after) {
+			item, err = cursor.Next()
+		}
+		for ; item != nil && err == nil && len(keys) < n; item, err = cursor.Next() {
+			keys = append(keys, bytes.Clone(item.Key))
+		}
+		return err
+	})
+	return keys, err
+}
+
+// Await waits until the worker of table executed the commands sent to it
+// before, e.g. a command just committed in cluster mode.
+func Await(table string) error {
+	return callWorker(table, func(*TableWorker) error { return nil })
+}
+
+// apply stages and commits op, rolling it back if it fails.
+func (tw *TableWorker) apply(op storage.BatchOp) error {
+	err := storage.ApplyBatch(tw.tbl, []storage.BatchOp{op})
+	if err == nil {
+		err = tw.commit(op)
+	}
+	if err != nil {
+		if rbErr := tw.tbl.Rollback(); rbErr != nil {
+			fmt.Println("[ERROR]", rbErr)
+		}
+	}
+	return err
+}
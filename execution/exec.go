@@ -1,14 +1,19 @@
 package execution
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
 	"orchiddb/globals"
 	"orchiddb/parser"
 	"orchiddb/paths"
+	"orchiddb/response"
 	"orchiddb/storage"
-	"os"
-	"path/filepath"
-	"strings"
+	"orchiddb/vfs"
 )
 
 // -------Worker Handling-------------------------------------------------------
@@ -17,6 +22,9 @@ import (
 var LoadedWorkers map[string]*TableWorker = map[string]*TableWorker{}
 
 func CloseAllTables() {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
 	for name, w := range LoadedWorkers {
 		w.Stop()
 		if err := w.Close(); err != nil {
@@ -44,54 +52,159 @@ func ExecuteCommand(cmd *parser.Command) {
 		dropTable(t)
 	default:
 		tbl := parser.NormalizeTableKey(cmd.Command.GetTable())
-		worker, found := LoadedWorkers[tbl]
-		if !found {
-			fmt.Println("no worker loaded for table:", tbl, "(did you MAKE(table)?)")
-			return
+
+		catalogMutex.Lock()
+		worker := workerOf(tbl)
+		catalogMutex.Unlock()
+
+		if worker == nil || !worker.send(cmd) {
+			err := fmt.Errorf("%w: %s (did you MAKE(table)?)", ErrNoTable, tbl)
+			if err := respondError(parser.ConnOf(cmd.Command), err); err != nil {
+				fmt.Println(err)
+			}
 		}
-		worker.in <- cmd
 	}
 }
 
 // -------Commands--------------------------------------------------------------
 
-// makeTable creates cmd.Table if it does not already exist.
+// makeTable creates cmd.Table if it does not already exist, and responds once
+// it exists.
 // Will spawn and register a worker for the table.
 func makeTable(cmd *parser.MakeCommand) {
-	tblName := cmd.Table
-	if !strings.HasSuffix(tblName, globals.TBL_SUFFIX) {
-		tblName = fmt.Sprintf("%s%s", cmd.Table, globals.TBL_SUFFIX)
+	versions := 0
+	var err error
+	if cmd.Versions != "" {
+		versions, err = strconv.Atoi(cmd.Versions)
+		if err != nil {
+			err = response.Errorf(response.CodeInvalid, "could not make table %s: invalid versions %s", cmd.Table, cmd.Versions)
+		}
 	}
+	if err == nil {
+		err = MakeTable(cmd.Table, cmd.Kind, versions)
+	}
+	respond(cmd.Conn, err)
+}
 
-	tablePath := filepath.Join(paths.DatabasePath, tblName)
-	tbl, err := storage.GetTable(tablePath)
+// MakeTable creates the table name of the given kind, keeping versions per key
+// if versions is not 0, and starts its worker. Does nothing if the table
+// already exists. Names that are not plain file names are refused.
+func MakeTable(name, kind string, versions int) error {
+	if err := paths.CheckTableName(name); err != nil {
+		return response.Errorf(response.CodeInvalid, "could not make table: %w", err)
+	}
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	if _, exists := LoadedWorkers[name]; exists {
+		return nil
+	}
+
+	tbl, err := openEngine(name, kind, versions)
 	if err != nil {
-		msg := fmt.Sprintf("could not make table %s: %s", cmd.Table, err)
-		fmt.Println(msg)
-		return
+		return fmt.Errorf("could not make table %s: %w", name, err)
 	}
 
-	worker := NewWorker(tbl)
+	worker := NewWorker(name, tbl)
 	worker.Start()
+	publishCatalog(catalogEvent{table: name})
+	return nil
 }
 
-// dropTable stops and unloads the cmd.Table's worker and removes the .db file
-// from the disk.
+// openEngine opens the table name of the given kind, or creates it if it does
+// not exist, keeping versions per key if versions is not 0.
+func openEngine(name, kind string, versions int) (storage.Engine, error) {
+	var tbl storage.Engine
+	var err error
+
+	switch kind {
+	case "", storage.KindBTree:
+		tbl, err = storage.GetTable(tablePath(name, globals.TBL_SUFFIX))
+	case storage.KindMemory:
+		tbl, err = storage.GetMemoryTable(name)
+	case storage.KindLSM:
+		tbl, err = storage.GetLSM(vfs.Default, tablePath(name, globals.LSM_SUFFIX))
+	case storage.KindHash:
+		tbl, err = storage.GetHashTable(vfs.Default, tablePath(name, globals.TBL_SUFFIX))
+	default:
+		err = response.Errorf(response.CodeInvalid, "unknown table kind %s", kind)
+	}
+	if err == nil && versions != 0 {
+		tbl, err = keepVersions(tbl, versions)
+	}
+	return tbl, err
+}
+
+// keepVersions wraps tbl to keep the given number of versions per key.
+// The table is closed if it cannot keep versions.
+func keepVersions(tbl storage.Engine, versions int) (storage.Engine, error) {
+	v, err := storage.NewVersioned(tbl, versions)
+	if err == nil {
+		return v, nil
+	}
+	if closeErr := tbl.Close(); closeErr != nil {
+		fmt.Println("[ERROR]", closeErr)
+	}
+	return nil, &response.Error{
+		Code: response.CodeInvalid,
+		Err:  fmt.Errorf("invalid versions %d: %w", versions, err),
+	}
+}
+
+// tablePath returns the path of the table's file with the given suffix in the
+// database path.
+func tablePath(table, suffix string) string {
+	tblName := table
+	if !strings.HasSuffix(tblName, suffix) {
+		tblName = fmt.Sprintf("%s%s", table, suffix)
+	}
+
+	return filepath.Join(paths.DatabasePath, tblName)
+}
+
+// dropTable stops and unloads the cmd.Table's worker, removes the table's
+// files from the disk and responds once they are removed.
 func dropTable(cmd *parser.DropCommand) {
-	worker, exists := LoadedWorkers[cmd.Table]
-	if !exists {
-		return
+	respond(cmd.Conn, DropTable(cmd.Table))
+}
+
+// respond writes OK, or err if it is not nil, to conn.
+func respond(conn net.Conn, err error) {
+	if err == nil {
+		err = response.WriteOK(conn)
+	} else {
+		err = respondError(conn, err)
+	}
+	if err != nil {
+		fmt.Println("Error writing to client:", err)
 	}
-	worker.Stop()
+}
+
+// DropTable stops and unloads the table's worker and removes the table's
+// files from the disk.
+// In-memory tables have no files, unloading them discards their contents.
+func DropTable(name string) error {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
 
-	delete(LoadedWorkers, cmd.Table)
-	p, found := paths.GetTablePath(cmd.Table)
-	if !found {
-		return
+	worker, exists := LoadedWorkers[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrNoTable, name)
 	}
+	return worker.drop()
+}
+
+// drop stops and unloads the worker and removes its table's files.
+// Must be called with catalogMutex held.
+func (tw *TableWorker) drop() error {
+	tw.Stop()
+	tw.feed.close(errTableDropped)
 
-	if err := os.Remove(p); err != nil {
-		msg := fmt.Sprintf("could not remove %s: %s", cmd.GetTable(), err)
-		fmt.Println(msg)
+	delete(LoadedWorkers, tw.name)
+	publishCatalog(catalogEvent{table: tw.name, dropped: true})
+	if err := errors.Join(tw.tbl.Drop(), tw.feed.closeFile(true)); err != nil {
+		return fmt.Errorf("could not remove %s: %w", tw.name, err)
 	}
+	return nil
 }
@@ -0,0 +1,598 @@
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"orchiddb/parser"
+	"orchiddb/paths"
+	"orchiddb/response"
+	"orchiddb/storage"
+	"orchiddb/vfs"
+)
+
+// -----------------------------------------------------------------------------
+// Replication from a primary.
+//
+// A replica sends SYNC() and the primary answers with a snapshot of every
+// table followed by every committed change, as lines of:
+//
+//	MAKE table kind versions items
+//	PUT table "key" "value"
+//	DEL table "key"
+//	DROP table
+//
+// Keys and values are quoted with strconv.Quote. The items PUT lines after a
+// MAKE are the table's snapshot, and a SYNCED line follows the initial
+// snapshot. The snapshot of a table is taken on its worker together with
+// subscribing to its change feed, so the changes continue exactly where the
+// snapshot ends. If the stream ends with an ERR line the replica should
+// sync again from scratch.
+// -----------------------------------------------------------------------------
+
+// catalogMutex serializes making and dropping tables with syncs registering
+// for those events.
+var catalogMutex sync.Mutex
+
+// syncers are the channels of the running syncs, which receive the names of
+// the tables made and dropped.
+var syncers = map[chan catalogEvent]struct{}{}
+
+// catalogEvent is a table being made or dropped.
+type catalogEvent struct {
+	table   string
+	dropped bool
+}
+
+// publishCatalog tells the running syncs about a table made or dropped.
+// Must be called with catalogMutex held. A sync that cannot keep up is ended.
+func publishCatalog(ev catalogEvent) {
+	for ch := range syncers {
+		select {
+		case ch <- ev:
+		default:
+			delete(syncers, ch)
+			close(ch)
+		}
+	}
+}
+
+// snapshotRequest asks a worker for all items of its table, along with a
+// watcher subscribed at the same point of the table's change feed if subscribe
+// is set. It implements parser.Node so it can be sent through a worker's
+// channel.
+type snapshotRequest struct {
+	table     string
+	subscribe bool
+	reply     chan snapshot
+}
+
+type snapshot struct {
+	items []*storage.Item
+	w     *watcher
+	err   error
+}
+
+func (sr *snapshotRequest) TokenLiteral() string { return parser.SYNC }
+func (sr *snapshotRequest) GetTable() string     { return sr.table }
+func (sr *snapshotRequest) String() string       { return "snapshot of " + sr.table }
+
+// requestSnapshot queues a snapshot request behind the commands of the worker
+// taken with workerOf and waits for it. Returns false if the worker is stopped
+// before the request is queued.
+func (tw *TableWorker) requestSnapshot(subscribe bool) (snapshot, bool) {
+	req := &snapshotRequest{table: tw.name, subscribe: subscribe, reply: make(chan snapshot, 1)}
+	if !tw.send(&parser.Command{Command: req}) {
+		return snapshot{}, false
+	}
+	return <-req.reply, true
+}
+
+// describe returns the kind of the worker's table and the number of versions
+// it keeps, 0 for none.
+func (tw *TableWorker) describe() (string, int) {
+	versions := 0
+	if v, ok := tw.tbl.(*storage.Versioned); ok {
+		versions = v.Versions()
+	}
+	return storage.KindOf(tw.tbl), versions
+}
+
+// snapshot answers req on the worker's goroutine, so no commit can happen
+// between collecting the items and subscribing.
+func (tw *TableWorker) snapshot(req *snapshotRequest) error {
+	var snap snapshot
+	snap.err = storage.ForEach(tw.tbl, func(item *storage.Item) error {
+		snap.items = append(snap.items, item)
+		return nil
+	})
+	if snap.err == nil && req.subscribe {
+		snap.w, _, _, snap.err = tw.feed.subscribe(nil, false, 0)
+	}
+
+	req.reply <- snap
+	return snap.err
+}
+
+// syncEvent is a change forwarded from a table's watcher, or the error that
+// ended the watcher.
+type syncEvent struct {
+	table string
+	w     *watcher
+	c     change
+	err   error
+}
+
+// Sync streams a snapshot of every table followed by all committed changes to
+// the replica on conn, until it disconnects or the stream ends.
+func Sync(conn net.Conn) error {
+	catalog := make(chan catalogEvent, 128)
+
+	catalogMutex.Lock()
+	syncers[catalog] = struct{}{}
+	names := slices.Sorted(maps.Keys(LoadedWorkers))
+	catalogMutex.Unlock()
+
+	defer func() {
+		catalogMutex.Lock()
+		if _, ok := syncers[catalog]; ok {
+			delete(syncers, catalog)
+			close(catalog)
+		}
+		catalogMutex.Unlock()
+	}()
+
+	s := &syncStream{
+		conn:     conn,
+		events:   make(chan syncEvent, watcherBuffer),
+		watchers: map[string]*watcher{},
+		stop:     make(chan struct{}),
+	}
+	defer s.close()
+
+	for _, name := range names {
+		if err := s.addTable(name); err != nil {
+			return respondError(conn, err)
+		}
+	}
+	if _, err := io.WriteString(conn, "SYNCED\n"); err != nil {
+		return err
+	}
+
+	// The replica sends nothing more, reading only notices it leaving.
+	left := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(left)
+	}()
+
+	for {
+		select {
+		case ev, ok := <-catalog:
+			if !ok {
+				return respondError(conn, errors.New("sync fell behind"))
+			}
+			var err error
+			if ev.dropped {
+				err = s.dropTable(ev.table)
+			} else {
+				err = s.addTable(ev.table)
+			}
+			if err != nil {
+				return respondError(conn, err)
+			}
+		case ev := <-s.events:
+			if s.watchers[ev.table] != ev.w {
+				continue // From a watcher of a table since dropped.
+			}
+			if ev.err != nil {
+				return respondError(conn, ev.err)
+			}
+			if err := writeSyncChange(conn, ev.table, ev.c); err != nil {
+				return err
+			}
+		case <-left:
+			return nil
+		}
+	}
+}
+
+// syncStream is the state of a single Sync.
+type syncStream struct {
+	conn     net.Conn
+	events   chan syncEvent
+	watchers map[string]*watcher // The current watcher of every table.
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// addTable writes the table and its snapshot, then forwards its changes.
+// Tables dropped before the snapshot is taken are skipped.
+func (s *syncStream) addTable(name string) error {
+	catalogMutex.Lock()
+	worker := workerOf(name)
+	catalogMutex.Unlock()
+
+	if worker == nil {
+		return nil
+	}
+	snap, found := worker.requestSnapshot(true)
+	if !found {
+		return nil
+	}
+	if snap.err != nil {
+		return fmt.Errorf("snapshot of %s: %w", name, snap.err)
+	}
+	s.watchers[name] = snap.w
+
+	kind, versions := worker.describe()
+	if _, err := fmt.Fprintf(s.conn, "MAKE %s %s %d %d\n", name, kind, versions, len(snap.items)); err != nil {
+		return err
+	}
+	for _, item := range snap.items {
+		op := storage.BatchOp{Key: item.Key, Value: item.Value}
+		if err := writeSyncChange(s.conn, name, change{op: op}); err != nil {
+			return err
+		}
+	}
+
+	s.wg.Add(1)
+	go s.forward(name, snap.w, worker.feed)
+	return nil
+}
+
+// dropTable writes the drop of a table and stops forwarding its changes.
+func (s *syncStream) dropTable(name string) error {
+	delete(s.watchers, name)
+	_, err := fmt.Fprintf(s.conn, "DROP %s\n", name)
+	return err
+}
+
+// forward sends the changes of a table's watcher to the stream's events.
+// A watcher ended by its table being dropped ends quietly.
+func (s *syncStream) forward(table string, w *watcher, feed *changeFeed) {
+	defer s.wg.Done()
+	defer feed.unsubscribe(w)
+
+	for {
+		select {
+		case c, ok := <-w.ch:
+			ev := syncEvent{table: table, w: w, c: c}
+			if !ok {
+				if errors.Is(w.err, errTableDropped) {
+					return
+				}
+				ev.err = w.err
+			}
+			select {
+			case s.events <- ev:
+			case <-s.stop:
+				return
+			}
+			if !ok {
+				return
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *syncStream) close() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func writeSyncChange(conn net.Conn, table string, c change) error {
+	var err error
+	if c.op.Del {
+		_, err = fmt.Fprintf(conn, "DEL %s %s\n", table, strconv.Quote(string(c.op.Key)))
+	} else {
+		_, err = fmt.Fprintf(
+			conn, "PUT %s %s %s\n",
+			table, strconv.Quote(string(c.op.Key)), strconv.Quote(string(c.op.Value)),
+		)
+	}
+	return err
+}
+
+// -------Replica---------------------------------------------------------------
+
+// replicatedOps are changes received from the primary for a single table.
+// It implements parser.Node so it can be sent through a worker's channel.
+type replicatedOps struct {
+	table string
+	ops   []storage.BatchOp
+	done  chan error
+}
+
+func (ro *replicatedOps) TokenLiteral() string { return parser.SYNC }
+func (ro *replicatedOps) GetTable() string     { return ro.table }
+func (ro *replicatedOps) String() string       { return "replicated changes to " + ro.table }
+
+// Replicate applies changes received from the primary to table in a single
+// commit and waits for it. The changes are published to the table's watchers
+// like any other, so a replica can itself be synced from.
+func Replicate(table string, ops ...storage.BatchOp) error {
+	catalogMutex.Lock()
+	worker := workerOf(table)
+	catalogMutex.Unlock()
+
+	ro := &replicatedOps{table: table, ops: ops, done: make(chan error, 1)}
+	if worker == nil || !worker.send(&parser.Command{Command: ro}) {
+		return fmt.Errorf("no worker loaded for table: %s", table)
+	}
+	return <-ro.done
+}
+
+func (tw *TableWorker) replicate(ro *replicatedOps) error {
+	err := storage.ApplyBatch(tw.tbl, ro.ops)
+	if err == nil {
+		err = tw.commit(ro.ops...)
+	} else {
+		tw.tbl.Rollback()
+	}
+	ro.done <- err
+	return err
+}
+
+// -------Loading Snapshots-----------------------------------------------------
+
+// stagingSuffix makes the name of the table a snapshot is loaded into. Table
+// names cannot contain "..", so it never names a table of the primary.
+const stagingSuffix = "..sync"
+
+// loadBatch is how many items of a snapshot are committed at once.
+const loadBatch = 1024
+
+// TableLoader loads the snapshot of a table received from the primary into a
+// staging table, which replaces the table once complete. Until then the table,
+// if there is one, serves reads as it was.
+type TableLoader struct {
+	name     string
+	kind     string
+	versions int
+
+	staging string
+	tbl     storage.Engine    // The staging table.
+	ops     []storage.BatchOp // Items not yet committed.
+}
+
+// LoadTable starts loading the table name of the given kind, keeping versions
+// per key if versions is not 0. A staging table left by an earlier load is
+// dropped first.
+func LoadTable(name, kind string, versions int) (*TableLoader, error) {
+	if err := paths.CheckTableName(name); err != nil {
+		return nil, response.Errorf(response.CodeInvalid, "could not load table: %w", err)
+	}
+
+	l := &TableLoader{name: name, kind: kind, versions: versions, staging: name + stagingSuffix}
+	if err := DropTable(l.staging); err != nil && !errors.Is(err, ErrNoTable) {
+		return nil, err
+	}
+	if err := l.removeStaging(); err != nil {
+		return nil, err
+	}
+
+	tbl, err := openEngine(l.staging, kind, versions)
+	if err != nil {
+		return nil, fmt.Errorf("could not load table %s: %w", name, err)
+	}
+	l.tbl = tbl
+	return l, nil
+}
+
+// Name returns the name of the table being loaded.
+func (l *TableLoader) Name() string {
+	return l.name
+}
+
+// Put adds an item of the snapshot. Items are committed loadBatch at a time.
+func (l *TableLoader) Put(key, value []byte) error {
+	l.ops = append(l.ops, storage.BatchOp{Key: key, Value: value})
+	if len(l.ops) < loadBatch {
+		return nil
+	}
+	return l.commit()
+}
+
+func (l *TableLoader) commit() error {
+	err := storage.ApplyBatch(l.tbl, l.ops)
+	if err == nil {
+		err = l.tbl.Commit()
+	}
+	if err != nil {
+		return fmt.Errorf("could not load table %s: %w", l.name, err)
+	}
+	l.ops = l.ops[:0]
+	return nil
+}
+
+// Finish commits the rest of the snapshot and replaces the table with the
+// staging table. The table is only unavailable while their files are swapped.
+// The loader is aborted if it fails.
+func (l *TableLoader) Finish() error {
+	if err := l.commit(); err != nil {
+		l.Abort()
+		return err
+	}
+
+	// Tables in memory have no files to move, the staging table itself takes
+	// the table's place.
+	tbl := l.tbl
+	memory := inMemory(tbl)
+	if !memory {
+		l.tbl = nil
+		if err := tbl.Close(); err != nil {
+			l.Abort()
+			return fmt.Errorf("could not load table %s: %w", l.name, err)
+		}
+	}
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	if worker := LoadedWorkers[l.name]; worker != nil {
+		if err := worker.drop(); err != nil {
+			l.Abort()
+			return err
+		}
+	}
+	if !memory {
+		err := l.moveStaging()
+		if err == nil {
+			tbl, err = openEngine(l.name, l.kind, l.versions)
+		}
+		if err != nil {
+			l.Abort()
+			return fmt.Errorf("could not load table %s: %w", l.name, err)
+		}
+	}
+
+	worker := NewWorker(l.name, tbl)
+	worker.Start()
+	publishCatalog(catalogEvent{table: l.name})
+	return nil
+}
+
+// Abort drops the staging table.
+func (l *TableLoader) Abort() {
+	if l.tbl != nil {
+		if err := l.tbl.Drop(); err != nil {
+			fmt.Printf("Error dropping staging table of %s: %v\n", l.name, err)
+		}
+		l.tbl = nil
+	}
+	if err := l.removeStaging(); err != nil {
+		fmt.Printf("Error dropping staging table of %s: %v\n", l.name, err)
+	}
+}
+
+// stagingFiles returns the paths of the staging table's files, each mapped to
+// the path it has as a file of the table. A staging file is named like the
+// table's, with the staging name in place of the table's.
+func (l *TableLoader) stagingFiles() (map[string]string, error) {
+	files, err := paths.GetDirContents(paths.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+
+	moves := map[string]string{}
+	for _, file := range files {
+		rest, ok := strings.CutPrefix(filepath.Base(file), l.staging)
+		if ok && rest != "" && strings.ContainsRune(".-_", rune(rest[0])) {
+			moves[file] = filepath.Join(paths.DatabasePath, l.name+rest)
+		}
+	}
+	return moves, nil
+}
+
+// moveStaging renames the staging table's files to the table's.
+func (l *TableLoader) moveStaging() error {
+	moves, err := l.stagingFiles()
+	if err != nil {
+		return err
+	}
+	for from, to := range moves {
+		if err := vfs.Default.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStaging removes the staging table's files.
+func (l *TableLoader) removeStaging() error {
+	moves, err := l.stagingFiles()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for file := range moves {
+		errs = append(errs, vfs.Default.Remove(file))
+	}
+	return errors.Join(errs...)
+}
+
+// TableNames returns the names of the loaded tables.
+func TableNames() []string {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	return slices.Sorted(maps.Keys(LoadedWorkers))
+}
+
+// -------Snapshots-------------------------------------------------------------
+
+// TableSnapshot is the definition and contents of a table at a point in time.
+type TableSnapshot struct {
+	Name     string
+	Kind     string
+	Versions int
+	Items    []storage.BatchOp
+}
+
+// Snapshot returns a snapshot of every table. Each table's snapshot is taken
+// once the commands queued for it are executed, so if nothing else writes
+// meanwhile, the snapshots include every command executed before the call.
+func Snapshot() ([]TableSnapshot, error) {
+	catalogMutex.Lock()
+	var workers []*TableWorker
+	for _, name := range slices.Sorted(maps.Keys(LoadedWorkers)) {
+		workers = append(workers, workerOf(name))
+	}
+	catalogMutex.Unlock()
+
+	var tables []TableSnapshot
+	for i, worker := range workers {
+		name := worker.name
+		snap, found := worker.requestSnapshot(false)
+		if !found {
+			continue // Dropped meanwhile.
+		}
+		if snap.err != nil {
+			for _, left := range workers[i+1:] {
+				left.release()
+			}
+			return nil, fmt.Errorf("snapshot of %s: %w", name, snap.err)
+		}
+
+		ts := TableSnapshot{Name: name, Items: make([]storage.BatchOp, 0, len(snap.items))}
+		ts.Kind, ts.Versions = worker.describe()
+		for _, item := range snap.items {
+			ts.Items = append(ts.Items, storage.BatchOp{Key: item.Key, Value: item.Value})
+		}
+		tables = append(tables, ts)
+	}
+	return tables, nil
+}
+
+// Restore replaces every table with the tables of a snapshot.
+func Restore(tables []TableSnapshot) error {
+	for _, name := range TableNames() {
+		if err := DropTable(name); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+	}
+
+	for _, ts := range tables {
+		if err := MakeTable(ts.Name, ts.Kind, ts.Versions); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+		if len(ts.Items) == 0 {
+			continue
+		}
+		if err := Replicate(ts.Name, ts.Items...); err != nil {
+			return fmt.Errorf("restore %s: %w", ts.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package execution
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"orchiddb/globals"
+	"orchiddb/parser"
+	"orchiddb/paths"
+	"orchiddb/response"
+	"orchiddb/storage"
+	"orchiddb/vfs"
+)
+
+// -----------------------------------------------------------------------------
+// Change data capture.
+//
+// Every worker publishes the changes of each successful commit to its table's
+// change feed, numbered by a sequence in commit order. The feed keeps the
+// latest globals.WatchBuffer changes in memory so watchers can resume after
+// reconnecting, and fans new changes out to the current watchers.
+//
+// The sequence of a table with files is reserved seqBlock at a time in a .seq
+// file, before the commits that use it, and saved exactly when the table is
+// closed. After a restart the feed continues from the saved sequence, so
+// watchers that read every change can resume. After a crash it continues from
+// the end of the reserved block, past any sequence a watcher read, and
+// resuming from before it fails instead of skipping changes.
+// -----------------------------------------------------------------------------
+
+// watcherBuffer is how many changes a watcher can fall behind before it is
+// dropped. A dropped watcher can resume from the last sequence it read.
+const watcherBuffer = 1024
+
+// seqBlock is how many sequences a single write of the .seq file reserves.
+const seqBlock = 1024
+
+// change is a single committed put or delete.
+type change struct {
+	seq uint64
+	op  storage.BatchOp
+}
+
+// changeFeed is the sequence of committed changes to a table.
+type changeFeed struct {
+	mu sync.Mutex
+
+	seq      uint64   // The sequence number of the latest change.
+	changes  []change // The latest changes, oldest first.
+	watchers map[*watcher]struct{}
+
+	seqFile  vfs.File // Holds the latest reserved sequence, nil if not saved.
+	seqPath  string
+	reserved uint64 // The sequence in seqFile, the feed does not go past it.
+}
+
+// watcher receives the changes to keys starting with prefix.
+type watcher struct {
+	prefix []byte
+	ch     chan change
+	err    error // Why ch was closed, set before closing.
+}
+
+// newChangeFeed starts a feed at a sequence derived from the clock, so
+// sequences are not reused by a table made again after being dropped, or
+// after a restart of a table whose sequence is not saved, and resuming from an
+// old sequence fails instead of silently skipping changes.
+func newChangeFeed() *changeFeed {
+	return &changeFeed{
+		seq:      uint64(time.Now().UnixNano()),
+		watchers: map[*watcher]struct{}{},
+	}
+}
+
+// openChangeFeed starts a feed at the sequence saved in the file at path, or
+// like newChangeFeed if there is none yet, and saves the sequence to the file
+// from then on.
+func openChangeFeed(fsys vfs.FS, path string) (*changeFeed, error) {
+	file, err := fsys.Open(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	f := newChangeFeed()
+	size, err := file.Size()
+	if err == nil && size >= 8 {
+		var buf [8]byte
+		if _, err = file.ReadAt(buf[:], 0); err == nil {
+			f.seq = binary.LittleEndian.Uint64(buf[:])
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f.seqFile, f.seqPath, f.reserved = file, path, f.seq
+	return f, nil
+}
+
+// openFeed returns the change feed of the worker's table. In-memory tables do
+// not outlive the server and save no sequence.
+func (tw *TableWorker) openFeed() *changeFeed {
+	if tw.isMemory() {
+		return newChangeFeed()
+	}
+
+	f, err := openChangeFeed(vfs.Default, paths.SeqPath(tw.name))
+	if err != nil {
+		fmt.Printf("Error opening the sequence of %s, watchers cannot resume from before now: %v\n", tw.name, err)
+		return newChangeFeed()
+	}
+	return f
+}
+
+// reserve makes sure the sequence the feed reaches once n more changes are
+// published is saved, and must be called before they are committed. A restart
+// then never starts the feed below a sequence a watcher read. Only the commits
+// that go past the reserved block save a new one.
+func (f *changeFeed) reserve(n int) error {
+	if f.seqFile == nil || n == 0 {
+		return nil
+	}
+
+	f.mu.Lock()
+	seq := f.seq + uint64(n)
+	f.mu.Unlock()
+
+	if seq <= f.reserved {
+		return nil
+	}
+	return f.save(seq + seqBlock)
+}
+
+// save writes seq to the sequence file and syncs it.
+func (f *changeFeed) save(seq uint64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], seq)
+	if _, err := f.seqFile.WriteAt(buf[:], 0); err != nil {
+		return err
+	}
+	if err := f.seqFile.Sync(); err != nil {
+		return err
+	}
+	f.reserved = seq
+	return nil
+}
+
+// closeFile closes the file the sequence is saved to, removing it if remove is
+// set, otherwise saving the feed's exact sequence first.
+func (f *changeFeed) closeFile(remove bool) error {
+	if f.seqFile == nil {
+		return nil
+	}
+
+	var err error
+	if remove {
+		err = errors.Join(f.seqFile.Close(), vfs.Default.Remove(f.seqPath))
+	} else {
+		f.mu.Lock()
+		seq := f.seq
+		f.mu.Unlock()
+		err = errors.Join(f.save(seq), f.seqFile.Close())
+	}
+	f.seqFile = nil
+	return err
+}
+
+// publish numbers ops and hands them to the watchers.
+// A watcher that cannot keep up is dropped instead of blocking the worker.
+func (f *changeFeed) publish(ops ...storage.BatchOp) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, op := range ops {
+		f.seq++
+		c := change{seq: f.seq, op: op}
+
+		f.changes = append(f.changes, c)
+		if over := len(f.changes) - globals.WatchBuffer; over > 0 {
+			f.changes = append(f.changes[:0], f.changes[over:]...)
+		}
+
+		for w := range f.watchers {
+			if !bytes.HasPrefix(op.Key, w.prefix) {
+				continue
+			}
+			select {
+			case w.ch <- c:
+			default:
+				f.dropLocked(w, fmt.Errorf("watcher fell behind at %d", c.seq-1))
+			}
+		}
+	}
+}
+
+// subscribe registers a watcher of keys starting with prefix.
+// If resume is set, the changes after seq still in the feed are returned to be
+// sent before the watcher's. Returns the feed's latest sequence number.
+func (f *changeFeed) subscribe(
+	prefix []byte, resume bool, seq uint64,
+) (*watcher, []change, uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var backlog []change
+	if resume {
+		oldest := f.seq + 1
+		if len(f.changes) > 0 {
+			oldest = f.changes[0].seq
+		}
+		if seq > f.seq {
+			return nil, nil, 0, fmt.Errorf("sequence %d is ahead of the latest %d", seq, f.seq)
+		}
+		if seq+1 < oldest {
+			return nil, nil, 0, fmt.Errorf("sequence %d is no longer available", seq)
+		}
+		for _, c := range f.changes {
+			if c.seq > seq && bytes.HasPrefix(c.op.Key, prefix) {
+				backlog = append(backlog, c)
+			}
+		}
+	}
+
+	w := &watcher{prefix: prefix, ch: make(chan change, watcherBuffer)}
+	f.watchers[w] = struct{}{}
+	return w, backlog, f.seq, nil
+}
+
+func (f *changeFeed) unsubscribe(w *watcher) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.watchers, w)
+}
+
+// close ends every watcher with err.
+func (f *changeFeed) close(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for w := range f.watchers {
+		f.dropLocked(w, err)
+	}
+}
+
+func (f *changeFeed) dropLocked(w *watcher, err error) {
+	w.err = err
+	close(w.ch)
+	delete(f.watchers, w)
+}
+
+// -------Streaming-------------------------------------------------------------
+
+// Watch streams the committed changes of cmd.Table to conn until the requester
+// disconnects or the watch ends.
+//
+// The stream starts with a "WATCH seq" line holding the latest sequence number,
+// followed by a "seq PUT key value" or "seq DEL key" line for every change.
+// Keys and values are quoted with strconv.Quote, like those of a sync.
+// If the watch ends, a final ERR line says why.
+func Watch(conn net.Conn, cmd *parser.WatchCommand) error {
+	tbl := parser.NormalizeTableKey(cmd.Table)
+
+	var seq uint64
+	resume := cmd.Seq != ""
+	if resume {
+		var err error
+		seq, err = strconv.ParseUint(cmd.Seq, 10, 64)
+		if err != nil {
+			return respondError(conn, response.Errorf(response.CodeInvalid, "sequence %s is not a number", cmd.Seq))
+		}
+	}
+
+	// Holding catalogMutex keeps the table from being dropped before the
+	// watcher is subscribed, so a drop always ends it.
+	catalogMutex.Lock()
+	worker, found := LoadedWorkers[tbl]
+	var (
+		w       *watcher
+		backlog []change
+		head    uint64
+		err     error
+	)
+	if found {
+		w, backlog, head, err = worker.feed.subscribe([]byte(cmd.Prefix), resume, seq)
+	}
+	catalogMutex.Unlock()
+
+	if !found {
+		return respondError(conn, fmt.Errorf("%w: %s", ErrNoTable, tbl))
+	}
+	if err != nil {
+		return respondError(conn, err)
+	}
+	defer worker.feed.unsubscribe(w)
+
+	if _, err := fmt.Fprintf(conn, "WATCH %d\n", head); err != nil {
+		return err
+	}
+	for _, c := range backlog {
+		if err := writeChange(conn, c); err != nil {
+			return err
+		}
+	}
+
+	// The requester sends nothing more, reading only notices it leaving.
+	left := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(left)
+	}()
+
+	for {
+		select {
+		case c, ok := <-w.ch:
+			if !ok {
+				return respondError(conn, w.err)
+			}
+			if err := writeChange(conn, c); err != nil {
+				return err
+			}
+		case <-left:
+			return nil
+		}
+	}
+}
+
+func writeChange(conn net.Conn, c change) error {
+	var err error
+	if c.op.Del {
+		_, err = fmt.Fprintf(conn, "%d DEL %s\n", c.seq, strconv.Quote(string(c.op.Key)))
+	} else {
+		_, err = fmt.Fprintf(
+			conn, "%d PUT %s %s\n",
+			c.seq, strconv.Quote(string(c.op.Key)), strconv.Quote(string(c.op.Value)),
+		)
+	}
+	return err
+}
+
+// errTableDropped ends the watchers of a dropped table.
+var errTableDropped = errors.New("table dropped")
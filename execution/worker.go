@@ -1,9 +1,17 @@
 package execution
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 
 	"orchiddb/parser"
+	"orchiddb/response"
 	"orchiddb/storage"
 )
 
@@ -15,18 +23,28 @@ import (
 type TableWorker struct {
 	in chan *parser.Command
 
-	tbl    *storage.Table
-	IsIdle bool // Is the loop paused?
+	name   string
+	tbl    storage.Engine
+	feed   *changeFeed   // Changes published after every commit.
+	done   chan struct{} // Closed once the loop has returned.
+	stop   chan struct{} // Closed when the worker is stopped.
+	fenced error         // Why the commands left are refused, if set.
+	IsIdle bool          // Is the loop paused?
+
+	senders sync.WaitGroup // Sends taken with workerOf and not yet done.
 }
 
-func NewWorker(tbl *storage.Table) *TableWorker {
+func NewWorker(name string, tbl storage.Engine) *TableWorker {
 	worker := &TableWorker{
+		name:   name,
 		tbl:    tbl,
 		in:     make(chan *parser.Command, 128),
+		stop:   make(chan struct{}),
 		IsIdle: true,
 	}
+	worker.feed = worker.openFeed()
 
-	LoadedWorkers[tbl.Name] = worker
+	LoadedWorkers[name] = worker
 	PrintWorkers()
 
 	return worker
@@ -39,22 +57,75 @@ func (tw *TableWorker) Start() {
 	}
 
 	tw.IsIdle = false
+	tw.done = make(chan struct{})
 	go tw.loop()
 }
 
-// Stop closes the in channel and then the worker is idled.
-func (tw *TableWorker) Stop() { close(tw.in); tw.IsIdle = true }
+// Stop closes the in channel and waits for the commands already queued to be
+// executed, then the worker is idled. Sends waiting for room in the channel
+// give up.
+// A worker retrying a batch gives up and refuses the commands left, so the
+// batch is recovered on startup.
+// Must be called with catalogMutex held.
+func (tw *TableWorker) Stop() {
+	close(tw.stop)
+	tw.senders.Wait()
+	close(tw.in)
+	if !tw.IsIdle {
+		<-tw.done
+	}
+	tw.IsIdle = true
+}
+
+// workerOf returns the loaded worker of table, or nil if there is none. The
+// worker is taken for a single send, which Stop waits for, so the caller must
+// call send or release once it has let go of catalogMutex.
+// Must be called with catalogMutex held.
+func workerOf(table string) *TableWorker {
+	worker := LoadedWorkers[table]
+	if worker != nil {
+		worker.senders.Add(1)
+	}
+	return worker
+}
+
+// send queues cmd on the worker taken with workerOf, waiting while the queue
+// is full. Returns false if the worker is stopped first, cmd is then never
+// executed.
+func (tw *TableWorker) send(cmd *parser.Command) bool {
+	defer tw.release()
+
+	select {
+	case tw.in <- cmd:
+		return true
+	case <-tw.stop:
+		return false
+	}
+}
+
+// release gives up the worker taken with workerOf without sending.
+func (tw *TableWorker) release() {
+	tw.senders.Done()
+}
 
 // Close closes the worker's table, returns any error.
-func (tw *TableWorker) Close() error { return tw.tbl.Close() }
+func (tw *TableWorker) Close() error {
+	return errors.Join(tw.tbl.Close(), tw.feed.closeFile(false))
+}
 
 // The primary logic for handling parsed commands and turning them into queried
 // database data.
 func (tw *TableWorker) loop() {
+	defer close(tw.done)
+
 	for cmd := range tw.in {
 		if cmd == nil {
 			continue
 		}
+		if tw.fenced != nil {
+			tw.refuse(cmd, tw.fenced)
+			continue
+		}
 
 		err := tw.executeCommand(cmd)
 		if err != nil {
@@ -71,42 +142,372 @@ func (tw *TableWorker) executeCommand(cmd *parser.Command) error {
 		return tw.put(t)
 	case *parser.DelCommand:
 		return tw.del(t)
+	case *parser.ScanCommand:
+		return tw.scan(t)
+	case *parser.GetVersionCommand:
+		return tw.getVersion(t)
+	case *parser.HistoryCommand:
+		return tw.history(t)
+	case *parser.IncrCommand:
+		return tw.incr(t)
+	case *parser.AppendCommand:
+		return tw.append(t)
+	case *parser.GetSetCommand:
+		return tw.getSet(t)
+	case *parser.CasCommand:
+		return tw.cas(t)
+	case *parser.CondPutCommand:
+		return tw.condPut(t)
+	case *batchPart:
+		return tw.batch(t)
+	case *snapshotRequest:
+		return tw.snapshot(t)
+	case *replicatedOps:
+		return tw.replicate(t)
+	case *call:
+		// The caller gets the error, there is nothing to print.
+		t.done <- t.fn(tw)
+		return nil
 	default:
 		return fmt.Errorf("unknown command: %s", cmd.Command.String())
 	}
 }
 
+// refuse answers cmd with err without executing it.
+func (tw *TableWorker) refuse(cmd *parser.Command, err error) {
+	switch t := cmd.Command.(type) {
+	case *batchPart:
+		t.prepared <- err
+		<-t.commit
+		t.done <- nil
+	case *snapshotRequest:
+		t.reply <- snapshot{err: err}
+	case *replicatedOps:
+		t.done <- err
+	case *call:
+		t.done <- err
+	default:
+		if err := respondError(parser.ConnOf(t), err); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// get responds with the value of cmd.Key, or NIL if it is absent.
 func (tw *TableWorker) get(cmd *parser.GetCommand) error {
-	var item *storage.Item
-	var err error
-	var resp []byte
+	item, err := tw.tbl.Get([]byte(cmd.Key))
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+	if item == nil {
+		return response.WriteNil(cmd.Conn)
+	}
+	return response.WriteValue(cmd.Conn, item.Value)
+}
+
+// put puts cmd.Value for cmd.Key and responds once it is committed.
+func (tw *TableWorker) put(cmd *parser.PutCommand) error {
+	err := tw.apply(storage.BatchOp{Key: []byte(cmd.Key), Value: []byte(cmd.Value)})
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+	return response.WriteOK(cmd.Conn)
+}
 
-	item, err = tw.tbl.Get([]byte(cmd.Key))
+// del removes cmd.Key and responds once it is committed.
+func (tw *TableWorker) del(cmd *parser.DelCommand) error {
+	err := tw.apply(storage.BatchOp{Key: []byte(cmd.Key), Del: true})
 	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+	return response.WriteOK(cmd.Conn)
+}
+
+// commit commits the table and publishes the committed ops to its watchers.
+func (tw *TableWorker) commit(ops ...storage.BatchOp) error {
+	if err := tw.feed.reserve(len(ops)); err != nil {
+		return fmt.Errorf("could not save the sequence of %s: %w", tw.name, err)
+	}
+	if err := tw.tbl.Commit(); err != nil {
 		return err
 	}
-	if item == nil {
-		resp = []byte("nil")
-	} else {
-		resp = item.Value
+	tw.feed.publish(ops...)
+	return nil
+}
+
+// scan writes every item with a key in [cmd.Start, cmd.End) as a "key value"
+// line, with both quoted by strconv.Quote, followed by an END line.
+// An empty cmd.End scans to the end of the table.
+// Tables that cannot be scanned, such as hash tables, respond with an ERR line.
+func (tw *TableWorker) scan(cmd *parser.ScanCommand) error {
+	cursor, err := tw.tbl.Cursor()
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+
+	var resp []byte
+	item, err := cursor.Seek([]byte(cmd.Start))
+	for ; item != nil && err == nil; item, err = cursor.Next() {
+		if cmd.End != "" && bytes.Compare(item.Key, []byte(cmd.End)) >= 0 {
+			break
+		}
+		resp = strconv.AppendQuote(resp, string(item.Key))
+		resp = append(resp, ' ')
+		resp = strconv.AppendQuote(resp, string(item.Value))
+		resp = append(resp, '\n')
+	}
+	if err != nil {
+		return respondError(cmd.Conn, err)
 	}
+	resp = append(resp, "END\n"...)
 
 	_, err = cmd.Conn.Write(resp)
 	return err
 }
 
-func (tw *TableWorker) put(cmd *parser.PutCommand) error {
-	err := tw.tbl.Put([]byte(cmd.Key), []byte(cmd.Value))
+// -------Versions--------------------------------------------------------------
+
+// versioned returns the worker's table if it keeps versions.
+func (tw *TableWorker) versioned() (*storage.Versioned, error) {
+	v, ok := tw.tbl.(*storage.Versioned)
+	if !ok {
+		return nil, response.Errorf(response.CodeUnsupported, "table %s does not keep versions", tw.name)
+	}
+	return v, nil
+}
+
+// getVersion writes the value cmd.Key had at commit cmd.Version, or nil if the
+// version is not kept or was a delete.
+func (tw *TableWorker) getVersion(cmd *parser.GetVersionCommand) error {
+	v, err := tw.versioned()
 	if err != nil {
-		return err
+		return respondError(cmd.Conn, err)
 	}
-	return tw.tbl.Txn.Commit()
+	seq, err := strconv.ParseUint(cmd.Version, 10, 64)
+	if err != nil {
+		return respondError(cmd.Conn, response.Errorf(response.CodeInvalid, "version %s is not a sequence number", cmd.Version))
+	}
+
+	ver, err := v.GetVersion([]byte(cmd.Key), seq)
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+
+	if ver == nil || ver.Deleted {
+		return response.WriteNil(cmd.Conn)
+	}
+	return response.WriteValue(cmd.Conn, ver.Value)
 }
 
-func (tw *TableWorker) del(cmd *parser.DelCommand) error {
-	err := tw.tbl.Del([]byte(cmd.Key))
+// history writes the kept versions of cmd.Key, newest first, as
+// "seq timestamp value" lines, with the value quoted by strconv.Quote, or
+// "seq timestamp DEL" for deletes, followed by an END line.
+func (tw *TableWorker) history(cmd *parser.HistoryCommand) error {
+	v, err := tw.versioned()
 	if err != nil {
-		return err
+		return respondError(cmd.Conn, err)
+	}
+
+	versions, err := v.History([]byte(cmd.Key))
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+
+	var resp []byte
+	for _, ver := range versions {
+		resp = fmt.Appendf(resp, "%d %s ", ver.Seq, ver.Time.Format(time.RFC3339Nano))
+		if ver.Deleted {
+			resp = append(resp, "DEL\n"...)
+		} else {
+			resp = strconv.AppendQuote(resp, string(ver.Value))
+			resp = append(resp, '\n')
+		}
+	}
+	resp = append(resp, "END\n"...)
+
+	_, err = cmd.Conn.Write(resp)
+	return err
+}
+
+// -------Read-Modify-Write-----------------------------------------------------
+
+// modify replaces the value of key with the one returned by fn, which is given
+// the current item, or nil if the key is absent. A write that fails to commit
+// is rolled back.
+// The read, the write and its commit all happen on the worker's goroutine, so
+// no other command on the table can interleave with them.
+// Returns the replaced item and the new value.
+func (tw *TableWorker) modify(
+	key []byte, fn func(old *storage.Item) ([]byte, error),
+) (*storage.Item, []byte, error) {
+	old, err := tw.tbl.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value, err := fn(old)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tw.apply(storage.BatchOp{Key: key, Value: value}); err != nil {
+		return nil, nil, err
+	}
+
+	return old, value, nil
+}
+
+// incr adds cmd.Delta, or subtracts it for DECR, to the integer value of
+// cmd.Key and responds with the result. Absent keys count from 0.
+func (tw *TableWorker) incr(cmd *parser.IncrCommand) error {
+	delta := int64(1)
+	if cmd.Delta != "" {
+		d, err := strconv.ParseInt(cmd.Delta, 10, 64)
+		if err != nil {
+			return respondError(cmd.Conn, response.Errorf(response.CodeInvalid, "delta %s is not an integer", cmd.Delta))
+		}
+		delta = d
+	}
+	if cmd.Token.Type == parser.DECR {
+		if delta == math.MinInt64 {
+			return respondError(cmd.Conn, response.Errorf(response.CodeInvalid, "delta %s is out of range", cmd.Delta))
+		}
+		delta = -delta
+	}
+
+	n, err := tw.add([]byte(cmd.Key), delta, cmd.Token.Literal)
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+
+	return response.WriteValue(cmd.Conn, strconv.AppendInt(nil, n, 10))
+}
+
+// add adds delta to the integer value of key, counting from 0 for an absent
+// key, commits it and returns the result. op names the command in errors.
+func (tw *TableWorker) add(key []byte, delta int64, op string) (int64, error) {
+	var n int64
+	_, _, err := tw.modify(key, func(old *storage.Item) ([]byte, error) {
+		if old != nil {
+			var err error
+			n, err = strconv.ParseInt(string(old.Value), 10, 64)
+			if err != nil {
+				return nil, response.Errorf(response.CodeInvalid, "value of %s is not an integer", key)
+			}
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return nil, response.Errorf(response.CodeInvalid, "%s %s would overflow", op, key)
+		}
+		n += delta
+		return strconv.AppendInt(nil, n, 10), nil
+	})
+	return n, err
+}
+
+// append appends cmd.Suffix to the value of cmd.Key and responds with the new
+// value. Absent keys are created with the suffix as their value.
+func (tw *TableWorker) append(cmd *parser.AppendCommand) error {
+	_, value, err := tw.modify([]byte(cmd.Key), func(old *storage.Item) ([]byte, error) {
+		var value []byte
+		if old != nil {
+			value = append(value, old.Value...)
+		}
+		return append(value, cmd.Suffix...), nil
+	})
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+
+	return response.WriteValue(cmd.Conn, value)
+}
+
+// getSet puts cmd.Value for cmd.Key and responds with the value it replaced,
+// or nil.
+func (tw *TableWorker) getSet(cmd *parser.GetSetCommand) error {
+	old, _, err := tw.modify([]byte(cmd.Key), func(*storage.Item) ([]byte, error) {
+		return []byte(cmd.Value), nil
+	})
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+
+	if old == nil {
+		return response.WriteNil(cmd.Conn)
+	}
+	return response.WriteValue(cmd.Conn, old.Value)
+}
+
+// -------Conditional Writes----------------------------------------------------
+
+// putIf puts value for key and commits it only if cond holds for the current
+// item, or nil if the key is absent. Like modify, the check and the write
+// cannot be interleaved by other commands on the table, and a write that fails
+// to commit is rolled back.
+// Returns whether the write happened.
+func (tw *TableWorker) putIf(
+	key, value []byte, cond func(cur *storage.Item) bool,
+) (bool, error) {
+	cur, err := tw.tbl.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if !cond(cur) {
+		return false, nil
+	}
+
+	if err := tw.apply(storage.BatchOp{Key: key, Value: value}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// cas puts cmd.New for cmd.Key only if its value is cmd.Expected, absent keys
+// never match. Responds with whether the write happened.
+func (tw *TableWorker) cas(cmd *parser.CasCommand) error {
+	written, err := tw.putIf(
+		[]byte(cmd.Key), []byte(cmd.New),
+		func(cur *storage.Item) bool {
+			return cur != nil && string(cur.Value) == cmd.Expected
+		},
+	)
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+	return respondBool(cmd.Conn, written)
+}
+
+// condPut puts cmd.Value for cmd.Key only if the key is absent for PUTNX or
+// present for PUTXX. Responds with whether the write happened.
+func (tw *TableWorker) condPut(cmd *parser.CondPutCommand) error {
+	wantPresent := cmd.Token.Type == parser.PUTXX
+	written, err := tw.putIf(
+		[]byte(cmd.Key), []byte(cmd.Value),
+		func(cur *storage.Item) bool { return (cur != nil) == wantPresent },
+	)
+	if err != nil {
+		return respondError(cmd.Conn, err)
+	}
+	return respondBool(cmd.Conn, written)
+}
+
+// -------Responses-------------------------------------------------------------
+
+// respondBool writes the value 1 for true and 0 for false to the requester.
+func respondBool(conn net.Conn, b bool) error {
+	resp := "0"
+	if b {
+		resp = "1"
+	}
+	return response.WriteValue(conn, []byte(resp))
+}
+
+// respondError writes err to the requester as an ERR line.
+func respondError(conn net.Conn, err error) error {
+	switch {
+	case errors.Is(err, storage.ErrUnsupported):
+		err = &response.Error{Code: response.CodeUnsupported, Err: err}
+	case errors.Is(err, storage.ErrReservedKey):
+		err = &response.Error{Code: response.CodeInvalid, Err: err}
 	}
-	return tw.tbl.Txn.Commit()
+	return response.WriteError(conn, err)
 }
@@ -8,8 +8,10 @@ package globals
 // -------Storage---------------------------------------------------------------
 
 const (
-	NodeHeaderSize = 3
-	PageNumSize    = 8 // The size of a page's number in bytes
+	NodeHeaderSize   = 3
+	PageNumSize      = 8   // The size of a page's number in bytes
+	ItemOverheadSize = 4   // An item's offset (2), key length (1) and value length (1)
+	MaxItemFieldSize = 255 // The largest key or value a node can hold in bytes
 
 	// -------Page Marker-------------------------------------------------------
 
@@ -70,6 +72,20 @@ const (
 const (
 	TBL_SUFFIX = ".db"
 	WAL_SUFFIX = ".wal"
+
+	LSM_SUFFIX     = ".lsm"    // LSM table manifest
+	LSM_LOG_SUFFIX = ".lsmlog" // LSM table memtable log
+	SEGMENT_SUFFIX = ".sst"    // LSM table sorted segment
+	TMP_SUFFIX     = ".tmp"
+
+	BATCH_SUFFIX  = ".batch"  // A table's operations in a multi-table batch
+	COMMIT_SUFFIX = ".commit" // A multi-table batch's commit record
+
+	SEQ_SUFFIX = ".seq" // A table's latest change sequence number
+
+	RAFT_STATE_SUFFIX    = ".raftstate" // A cluster node's current term and vote
+	RAFT_LOG_SUFFIX      = ".raftlog"   // A cluster node's Raft log
+	RAFT_SNAPSHOT_SUFFIX = ".raftsnap"  // A cluster node's latest snapshot
 )
 
 // -------Terminal--------------------------------------------------------------
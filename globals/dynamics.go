@@ -2,6 +2,7 @@ package globals
 
 import (
 	"os"
+	"time"
 )
 
 // -----------------------------------------------------------------------------
@@ -18,6 +19,106 @@ var Address = "127.0.0.1"
 // Port denotes which port the server uses when listening.
 var Port = 6000
 
+// RespPort denotes which port the server's RESP listener uses, 0 if it has
+// none.
+var RespPort = 0
+
+// RespTables denotes the prefix of the tables Redis databases map to over RESP,
+// database n being the table RespTables followed by n.
+var RespTables = "db"
+
+// HTTPPort denotes which port the server's HTTP gateway uses, 0 if it has
+// none.
+var HTTPPort = 0
+
+// ReplicaOf denotes the host:port of the primary the server replicates, if any.
+// A replica only serves reads.
+var ReplicaOf = ""
+
+// ReplicaAuth denotes the user:password a replica authenticates to its primary
+// with, if the primary requires it.
+var ReplicaAuth = ""
+
+// ReplicaTLS denotes whether a replica connects to its primary over TLS. The
+// other replica TLS options imply it.
+var ReplicaTLS = false
+
+// ReplicaTLSCA denotes a PEM file of the certificate authorities the primary's
+// certificate is checked against. The system's are used if it is empty.
+var ReplicaTLSCA = ""
+
+// ReplicaTLSCert denotes the PEM client certificate file a replica presents to
+// its primary, for primaries that require one.
+var ReplicaTLSCert = ""
+
+// ReplicaTLSKey denotes the PEM private key file of ReplicaTLSCert.
+var ReplicaTLSKey = ""
+
+// UsersFile denotes the credentials file of the users allowed to connect.
+// Connections must authenticate if it is set.
+var UsersFile = ""
+
+// -------Connection Limits-----------------------------------------------------
+
+// MaxConns denotes how many client connections the listeners keep open at
+// once, together. Unlimited if 0.
+var MaxConns = 1024
+
+// IdleTimeout denotes how long a connection may wait between requests before
+// it is closed. Unlimited if 0.
+var IdleTimeout = 5 * time.Minute
+
+// ReadTimeout denotes how long a client has to send the rest of a request once
+// it started it. Unlimited if 0.
+var ReadTimeout = 30 * time.Second
+
+// WriteTimeout denotes how long a client has to take a response before its
+// connection is closed. Unlimited if 0.
+var WriteTimeout = 30 * time.Second
+
+// MaxRequestSize denotes the largest request, in bytes, e.g. a query line.
+var MaxRequestSize = 16 << 20
+
+// RateLimit denotes how many requests per second a client address may make,
+// in bursts of up to RateLimit. Unlimited if 0.
+var RateLimit = 0
+
+// -------TLS Options-----------------------------------------------------------
+
+// TLSCert denotes the PEM certificate file the server's listeners present. The
+// listeners accept TLS connections only if it is set.
+var TLSCert = ""
+
+// TLSKey denotes the PEM private key file of TLSCert.
+var TLSKey = ""
+
+// TLSClientCA denotes a PEM file of the certificate authorities client
+// certificates must be signed by. Clients need no certificate if it is empty.
+var TLSClientCA = ""
+
+// -------Cluster Options-------------------------------------------------------
+
+// NodeID denotes the server's id in a Raft cluster. The server runs in cluster
+// mode if it is set.
+var NodeID = ""
+
+// RaftAddr denotes which host:port the server uses for Raft traffic. Defaults
+// to the node's address in Cluster.
+var RaftAddr = ""
+
+// RaftTLSCA denotes a PEM file of the certificate authorities the nodes'
+// certificates must be signed by. Raft traffic uses mutual TLS with TLSCert
+// and TLSKey if it is set, and plain TCP otherwise.
+var RaftTLSCA = ""
+
+// Cluster denotes the initial members of a Raft cluster as id=host:port pairs
+// separated by commas. Left empty for nodes joining an existing cluster.
+var Cluster = ""
+
+// SnapshotEvery denotes how many applied Raft log entries trigger a snapshot
+// of the tables, which compacts the log.
+var SnapshotEvery = 1024
+
 // -------Database Page Options-------------------------------------------------
 
 // PageSize denotes the size of a page in bytes.
@@ -30,3 +131,15 @@ var MinFillPercent float32 = 0.5
 // MaxFillPercent denotes the maximum percentage a page can be filled before it
 // is split.
 var MaxFillPercent float32 = 0.95
+
+// -------LSM Table Options-----------------------------------------------------
+
+// MemtableSize denotes the size in bytes an LSM table's memtable can grow to
+// before it is flushed to a segment file.
+var MemtableSize = 4 << 20
+
+// -------Watch Options---------------------------------------------------------
+
+// WatchBuffer denotes how many of its latest changes a table keeps in memory
+// for watchers resuming from a sequence number.
+var WatchBuffer = 4096
@@ -6,10 +6,17 @@ import (
 	"os/signal"
 	"syscall"
 
+	"orchiddb/auth"
+	"orchiddb/cli"
 	"orchiddb/execution"
+	"orchiddb/globals"
+	"orchiddb/raft"
+	"orchiddb/replication"
+	"orchiddb/router"
 	"orchiddb/server"
 	"orchiddb/system"
 	"orchiddb/system/startup"
+	"orchiddb/torture"
 )
 
 var majorVersion = 0 // Proud version
@@ -17,11 +24,53 @@ var minorVersion = 2 // Real  version
 var patchVersion = 2 // Sucky version
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "torture" {
+		os.Exit(torture.Run(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "router" {
+		os.Exit(router.Run(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cli" {
+		os.Exit(cli.Run(os.Args[2:]))
+	}
+
 	system.PrintStartupText(majorVersion, minorVersion, patchVersion)
 
 	startup.Startup(os.Args[1:])
 
+	if err := server.ConfigureTLS(); err != nil {
+		fmt.Println("TLS error:", err)
+		os.Exit(2)
+	}
+	if globals.UsersFile != "" {
+		if err := auth.Load(globals.UsersFile); err != nil {
+			fmt.Println("users error:", err)
+			os.Exit(2)
+		}
+	}
+
+	if globals.NodeID != "" {
+		if err := raft.Start(server.RaftTLS()); err != nil {
+			fmt.Println("cluster start error:", err)
+			os.Exit(2)
+		}
+	}
+	if globals.ReplicaOf != "" {
+		tlsCfg, err := replication.TLSConfig()
+		if err != nil {
+			fmt.Println("replica TLS error:", err)
+			os.Exit(2)
+		}
+		go replication.Follow(globals.ReplicaOf, tlsCfg)
+	}
+
 	go startServer()
+	if globals.RespPort != 0 {
+		go startRespServer()
+	}
+	if globals.HTTPPort != 0 {
+		go startHTTPServer()
+	}
 	awaitSigterm()
 }
 
@@ -36,6 +85,24 @@ func startServer() {
 	}
 }
 
+func startRespServer() {
+	s, err := server.NewRespServer()
+	if err != nil {
+		fmt.Println("RESP server start error:", err)
+		os.Exit(2)
+	}
+	if err := s.Run(); err != nil {
+		fmt.Println("RESP server runtime error:", err)
+	}
+}
+
+func startHTTPServer() {
+	if err := server.ListenHTTP(); err != nil {
+		fmt.Println("HTTP server error:", err)
+		os.Exit(2)
+	}
+}
+
 func awaitSigterm() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
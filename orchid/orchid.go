@@ -0,0 +1,303 @@
+package orchid
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"orchiddb/globals"
+	"orchiddb/paths"
+	"orchiddb/storage"
+	"orchiddb/system/startup"
+	"orchiddb/vfs"
+)
+
+// -----------------------------------------------------------------------------
+// Package orchid embeds an Orchid database in a Go program, without the TCP
+// server. The tables are the same files the server reads and writes, opened
+// with the same storage engines, so a directory can be served later or
+// embedded again.
+//
+// The storage layer keeps the database path and page settings in process-wide
+// globals, so only one DB can be open in a process at a time, and a directory
+// must not be used by a server and an embedded DB at the same time. Closing
+// the DB restores the globals it changed.
+// -----------------------------------------------------------------------------
+
+var (
+	ErrNotFound    = errors.New("key not found")
+	ErrNoTable     = errors.New("no such table")
+	ErrTableExists = errors.New("table already exists")
+	ErrClosed      = errors.New("database or table is closed")
+	ErrAlreadyOpen = errors.New("a database is already open in this process")
+	ErrTableName   = paths.ErrTableName
+)
+
+// Options tune an embedded database. Zero values keep the server's defaults.
+type Options struct {
+	PageSize     int // Bytes per page of new B-tree and hash tables.
+	MemtableSize int // Bytes an LSM memtable holds before it is flushed.
+}
+
+// TableOptions are the options of a table made with MakeTable.
+type TableOptions struct {
+	Kind     string // One of the storage Kind constants, a B-tree if empty.
+	Versions int    // Versions kept per key, none if 0.
+}
+
+// DB is an open database directory.
+type DB struct {
+	mu     sync.Mutex
+	tables map[string]*Table
+	closed bool
+
+	updateMu sync.Mutex // Serializes Update calls.
+
+	saved savedGlobals // The globals before Open, restored by Close.
+}
+
+// savedGlobals are the process-wide settings Open changes.
+type savedGlobals struct {
+	dbPath       string
+	pageSize     int
+	memtableSize int
+}
+
+func saveGlobals() savedGlobals {
+	return savedGlobals{
+		dbPath:       paths.DatabasePath,
+		pageSize:     globals.PageSize,
+		memtableSize: globals.MemtableSize,
+	}
+}
+
+func (g savedGlobals) restore() {
+	paths.DatabasePath = g.dbPath
+	globals.PageSize = g.pageSize
+	globals.MemtableSize = g.memtableSize
+}
+
+var (
+	openMu sync.Mutex
+	opened *DB
+)
+
+// Open opens the database in dir, creating the directory if needed.
+// Like a server start, the tables are recovered from their WAL files and
+// interrupted batches are finished or discarded before they are loaded. A
+// batch that cannot be finished fails the Open, as writing over its tables
+// would be undone once it is.
+func Open(dir string, opts Options) (*DB, error) {
+	openMu.Lock()
+	defer openMu.Unlock()
+
+	if opened != nil {
+		return nil, ErrAlreadyOpen
+	}
+	if err := startup.CreateDatabaseDirectory(dir); err != nil {
+		return nil, fmt.Errorf("create %s: %w", dir, err)
+	}
+
+	db := &DB{tables: map[string]*Table{}, saved: saveGlobals()}
+	paths.DatabasePath = dir
+	if opts.PageSize > 0 {
+		globals.PageSize = opts.PageSize
+	}
+	if opts.MemtableSize > 0 {
+		globals.MemtableSize = opts.MemtableSize
+	}
+	if err := startup.Recover(nil); err != nil {
+		db.saved.restore()
+		return nil, fmt.Errorf("recover %s: %w", dir, err)
+	}
+
+	var errs []error
+	load := func(p string, open func(string) (storage.Engine, error)) {
+		name, err := paths.GetStem(p)
+		if err != nil {
+			return
+		}
+		tbl, err := open(p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("load table %s: %w", p, err))
+			return
+		}
+		db.tables[name] = &Table{name: name, tbl: tbl}
+	}
+	for _, p := range paths.GetTablePaths() {
+		load(p, func(p string) (storage.Engine, error) {
+			return startup.OpenEngine(storage.OpenTableFile(vfs.Default, p))
+		})
+	}
+	for _, p := range paths.GetLSMPaths() {
+		load(p, func(p string) (storage.Engine, error) {
+			return startup.OpenEngine(storage.GetLSM(vfs.Default, p))
+		})
+	}
+	if err := errors.Join(errs...); err != nil {
+		err = errors.Join(err, db.closeTables())
+		db.saved.restore()
+		return nil, err
+	}
+
+	opened = db
+	return db, nil
+}
+
+// Close closes every table. The DB cannot be used afterwards, but the
+// directory can be opened again.
+func (db *DB) Close() error {
+	openMu.Lock()
+	defer openMu.Unlock()
+
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	db.closed = true
+	db.mu.Unlock()
+
+	err := db.closeTables()
+	if opened == db {
+		opened = nil
+		db.saved.restore()
+	}
+	return err
+}
+
+func (db *DB) closeTables() error {
+	var errs []error
+	for _, t := range db.tables {
+		t.mu.Lock()
+		if !t.closed {
+			t.closed = true
+			errs = append(errs, t.tbl.Close())
+		}
+		t.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// -------Tables----------------------------------------------------------------
+
+// Table returns the table name, making it as a B-tree if it does not exist.
+func (db *DB) Table(name string) (*Table, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if t, ok := db.tables[name]; ok {
+		return t, nil
+	}
+	return db.makeLocked(name, TableOptions{})
+}
+
+// MakeTable makes the table name with opts. Returns ErrTableExists if there
+// already is a table with that name.
+func (db *DB) MakeTable(name string, opts TableOptions) (*Table, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if _, ok := db.tables[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableExists, name)
+	}
+	return db.makeLocked(name, opts)
+}
+
+func (db *DB) makeLocked(name string, opts TableOptions) (*Table, error) {
+	if err := paths.CheckTableName(name); err != nil {
+		return nil, err
+	}
+
+	var tbl storage.Engine
+	var err error
+	switch opts.Kind {
+	case "", storage.KindBTree:
+		tbl, err = storage.GetTable(tablePath(name, globals.TBL_SUFFIX))
+	case storage.KindMemory:
+		tbl, err = storage.GetMemoryTable(name)
+	case storage.KindLSM:
+		tbl, err = storage.GetLSM(vfs.Default, tablePath(name, globals.LSM_SUFFIX))
+	case storage.KindHash:
+		tbl, err = storage.GetHashTable(vfs.Default, tablePath(name, globals.TBL_SUFFIX))
+	default:
+		err = fmt.Errorf("unknown table kind %s", opts.Kind)
+	}
+	if err == nil && opts.Versions > 0 {
+		var v *storage.Versioned
+		if v, err = storage.NewVersioned(tbl, opts.Versions); err == nil {
+			tbl = v
+		} else {
+			err = errors.Join(err, tbl.Close())
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not make table %s: %w", name, err)
+	}
+
+	t := &Table{name: name, tbl: tbl}
+	db.tables[name] = t
+	return t, nil
+}
+
+// DropTable closes the table name and removes its files.
+func (db *DB) DropTable(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	t, ok := db.tables[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoTable, name)
+	}
+	delete(db.tables, name)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	return t.tbl.Drop()
+}
+
+// Tables returns the names of the tables in order.
+func (db *DB) Tables() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	names := make([]string, 0, len(db.tables))
+	for name := range db.tables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// lookup returns the open table name.
+func (db *DB) lookup(name string) (*Table, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	t, ok := db.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoTable, name)
+	}
+	return t, nil
+}
+
+// tablePath returns the path of the table's file with the given suffix in the
+// database path.
+func tablePath(table, suffix string) string {
+	return filepath.Join(paths.DatabasePath, table+suffix)
+}
@@ -0,0 +1,163 @@
+package orchid
+
+import (
+	"bytes"
+	"sync"
+
+	"orchiddb/storage"
+)
+
+// Table is a table of an open DB. It is safe for concurrent use, operations
+// on a table run one at a time.
+type Table struct {
+	name string
+
+	mu     sync.Mutex
+	tbl    storage.Engine
+	closed bool
+	failed error // Why the table refuses operations until the DB is opened again.
+}
+
+// Name returns the table's name.
+func (t *Table) Name() string { return t.name }
+
+// Kind returns the table's kind, one of the storage Kind constants.
+func (t *Table) Kind() string { return storage.KindOf(t.tbl) }
+
+// Get returns the value of key, or ErrNotFound if there is none.
+func (t *Table) Get(key []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.usableLocked(); err != nil {
+		return nil, err
+	}
+	item, err := t.tbl.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(item.Value), nil
+}
+
+// Put sets key to value and commits it.
+func (t *Table) Put(key, value []byte) error {
+	return t.apply(storage.BatchOp{Key: key, Value: value})
+}
+
+// Delete removes key and commits it. Deleting a missing key is not an error.
+func (t *Table) Delete(key []byte) error {
+	return t.apply(storage.BatchOp{Key: key, Del: true})
+}
+
+// apply stages and commits ops, rolling them back if any fails.
+func (t *Table) apply(ops ...storage.BatchOp) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.usableLocked(); err != nil {
+		return err
+	}
+	return t.commitLocked(ops)
+}
+
+func (t *Table) commitLocked(ops []storage.BatchOp) error {
+	err := storage.ApplyBatch(t.tbl, ops)
+	if err == nil {
+		err = t.tbl.Commit()
+	}
+	if err != nil {
+		if rbErr := t.tbl.Rollback(); rbErr != nil {
+			return rbErr
+		}
+	}
+	return err
+}
+
+// usableLocked returns ErrClosed if the table is closed, or why it failed.
+func (t *Table) usableLocked() error {
+	if t.closed {
+		return ErrClosed
+	}
+	return t.failed
+}
+
+// isMemory returns whether the table only lives in memory.
+func (t *Table) isMemory() bool {
+	m, ok := t.tbl.(interface{ IsMemory() bool })
+	return ok && m.IsMemory()
+}
+
+// -------Cursors---------------------------------------------------------------
+
+// Cursor returns a cursor over the table's items in key order. Hash tables
+// cannot be iterated in order and their cursors return storage.ErrUnsupported.
+func (t *Table) Cursor() *Cursor {
+	return &Cursor{t: t}
+}
+
+// Cursor iterates the items of a table in key order.
+//
+// A cursor does not hold the table between calls, so the table can be written
+// while iterating. Every call continues after the last key returned and sees
+// the changes committed since. Every method returns a nil key once the end of
+// the table is reached.
+type Cursor struct {
+	t    *Table
+	last []byte // The last key returned, nil before the first call.
+	end  bool   // Set once the end of the table was reached.
+}
+
+// First moves to the first item.
+func (c *Cursor) First() (key, value []byte, err error) {
+	return c.seek(nil, false)
+}
+
+// Seek moves to the first item with a key greater than or equal to key.
+func (c *Cursor) Seek(key []byte) ([]byte, []byte, error) {
+	return c.seek(key, false)
+}
+
+// Next moves to the item after the one last returned.
+func (c *Cursor) Next() ([]byte, []byte, error) {
+	if c.end {
+		return nil, nil, nil
+	}
+	if c.last == nil {
+		return c.First()
+	}
+	return c.seek(c.last, true)
+}
+
+// seek returns the first item at or, if after is set, past key.
+func (c *Cursor) seek(key []byte, after bool) ([]byte, []byte, error) {
+	c.t.mu.Lock()
+	defer c.t.mu.Unlock()
+
+	if err := c.t.usableLocked(); err != nil {
+		return nil, nil, err
+	}
+	cursor, err := c.t.tbl.Cursor()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var item *storage.Item
+	if key == nil {
+		item, err = cursor.First()
+	} else {
+		item, err = cursor.Seek(key)
+	}
+	if after && err == nil && item != nil && bytes.Equal(item.Key, key) {
+		item, err = cursor.Next()
+	}
+	if err != nil || item == nil {
+		c.last, c.end = nil, err == nil
+		return nil, nil, err
+	}
+
+	c.last, c.end = bytes.Clone(item.Key), false
+	return bytes.Clone(item.Key), bytes.Clone(item.Value), nil
+}
@@ -0,0 +1,216 @@
+package orchid
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"orchiddb/filestamp"
+	"orchiddb/paths"
+	"orchiddb/storage"
+	"orchiddb/vfs"
+)
+
+// -----------------------------------------------------------------------------
+// Transactions stage their writes in memory and commit them all-or-nothing
+// when the update function returns, with the same batch logs and commit
+// record as the server's BATCH, see storage/batch.go. A database that stops
+// in the middle of a commit is recovered on the next Open, and so is a table
+// that fails to commit its part, which refuses operations until then.
+// -----------------------------------------------------------------------------
+
+var errTxDone = errors.New("transaction has already ended")
+
+// Tx is a read-write transaction over any number of tables.
+// It is only valid inside the function passed to Update.
+type Tx struct {
+	db     *DB
+	writes map[string]*txWrites
+	done   bool
+}
+
+// txWrites are the writes of a transaction to a single table.
+type txWrites struct {
+	t      *Table
+	ops    []storage.BatchOp
+	latest map[string]storage.BatchOp // The last op per key.
+}
+
+// Update runs fn in a transaction and commits its writes if fn returns nil.
+// If fn returns an error, or the commit fails, none of the writes are made.
+//
+// Updates run one at a time. Reads in a transaction see its own writes and
+// the values committed by others, including writes made outside of Update.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.updateMu.Lock()
+	defer db.updateMu.Unlock()
+
+	tx := &Tx{db: db, writes: map[string]*txWrites{}}
+	err := fn(tx)
+	tx.done = true
+	if err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// Get returns the value of key in table, or ErrNotFound if there is none.
+func (tx *Tx) Get(table string, key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, errTxDone
+	}
+	if w, ok := tx.writes[table]; ok {
+		if op, ok := w.latest[string(key)]; ok {
+			if op.Del {
+				return nil, ErrNotFound
+			}
+			return bytes.Clone(op.Value), nil
+		}
+		return w.t.Get(key)
+	}
+
+	t, err := tx.db.lookup(table)
+	if err != nil {
+		return nil, err
+	}
+	return t.Get(key)
+}
+
+// Put sets key to value in table when the transaction commits.
+func (tx *Tx) Put(table string, key, value []byte) error {
+	return tx.stage(table, storage.BatchOp{
+		Key: bytes.Clone(key), Value: bytes.Clone(value),
+	})
+}
+
+// Delete removes key from table when the transaction commits.
+func (tx *Tx) Delete(table string, key []byte) error {
+	return tx.stage(table, storage.BatchOp{Key: bytes.Clone(key), Del: true})
+}
+
+func (tx *Tx) stage(table string, op storage.BatchOp) error {
+	if tx.done {
+		return errTxDone
+	}
+
+	w, ok := tx.writes[table]
+	if !ok {
+		t, err := tx.db.lookup(table)
+		if err != nil {
+			return err
+		}
+		w = &txWrites{t: t, latest: map[string]storage.BatchOp{}}
+		tx.writes[table] = w
+	}
+	w.ops = append(w.ops, op)
+	w.latest[string(op.Key)] = op
+	return nil
+}
+
+// commit commits the staged writes of every table.
+// The tables are locked in name order, so commits never wait on each other.
+func (tx *Tx) commit() error {
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	var parts []*txWrites
+	for _, name := range slices.Sorted(maps.Keys(tx.writes)) {
+		w := tx.writes[name]
+		w.t.mu.Lock()
+		defer w.t.mu.Unlock()
+
+		if err := w.t.usableLocked(); err != nil {
+			return fmt.Errorf("transaction aborted: table %s: %w", name, err)
+		}
+		parts = append(parts, w)
+	}
+
+	if len(parts) == 1 {
+		return parts[0].t.commitLocked(parts[0].ops)
+	}
+
+	// ---- prepare
+	id := filestamp.FileStamp()
+	var err error
+	for _, w := range parts {
+		err = storage.ApplyBatch(w.t.tbl, w.ops)
+		if err == nil && !w.t.isMemory() {
+			err = storage.WriteBatchLog(vfs.Default, paths.BatchLogPath(w.t.name, id), w.ops)
+		}
+		if err != nil {
+			err = fmt.Errorf("table %s: %w", w.t.name, err)
+			break
+		}
+	}
+
+	// ---- commit point
+	commitPath := paths.BatchCommitPath(id)
+	if err == nil {
+		err = storage.WriteBatchCommit(vfs.Default, commitPath)
+	}
+
+	// ---- roll back
+	if err != nil {
+		for _, w := range parts {
+			err = errors.Join(err, w.t.tbl.Rollback())
+			if !w.t.isMemory() {
+				vfs.Default.Remove(paths.BatchLogPath(w.t.name, id))
+			}
+		}
+		vfs.Default.Remove(commitPath)
+		return fmt.Errorf("transaction aborted: %w", err)
+	}
+
+	// ---- commit
+	// A table that failed to commit is replayed from its log on the next Open,
+	// which needs the commit record to be kept.
+	var errs []error
+	for _, w := range parts {
+		errs = append(errs, w.t.commitPartLocked(w.ops, paths.BatchLogPath(w.t.name, id)))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("transaction %s is committed but not yet applied: %w", id, err)
+	}
+	return vfs.Default.Remove(commitPath)
+}
+
+// commitPartLocked commits the table's staged part of a committed
+// transaction, then removes its batch log at logPath once the part is
+// durable. A part that fails is tried once more, then the table is rolled
+// back and refuses operations, so no other commit includes the part and the
+// log is not replayed over newer writes on the next Open.
+func (t *Table) commitPartLocked(ops []storage.BatchOp, logPath string) error {
+	var err error
+	committed := false
+	for attempt := 0; attempt < 2; attempt++ {
+		if !committed {
+			// A failed commit may leave the staged ops partway applied.
+			if attempt > 0 {
+				if err = t.tbl.Rollback(); err == nil {
+					err = storage.ApplyBatch(t.tbl, ops)
+				}
+			}
+			if err == nil {
+				err = t.tbl.Commit()
+			}
+			committed = err == nil
+		}
+		if committed && !t.isMemory() {
+			if err = vfs.Default.Remove(logPath); errors.Is(err, vfs.ErrNotExist) {
+				err = nil
+			}
+		}
+		if err == nil {
+			return nil
+		}
+	}
+
+	if !committed {
+		err = errors.Join(err, t.tbl.Rollback())
+	}
+	t.failed = fmt.Errorf("table %s has a transaction not yet applied, open the database again: %w", t.name, err)
+	return err
+}
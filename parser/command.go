@@ -22,6 +22,57 @@ type Command struct {
 	Command Node
 }
 
+// BindConn sets the connection node responds to, for the commands that
+// respond to their requester.
+func BindConn(node Node, conn net.Conn) {
+	if c := connOf(node); c != nil {
+		*c = conn
+	}
+}
+
+// ConnOf returns the connection node responds to, nil if it has none.
+func ConnOf(node Node) net.Conn {
+	if c := connOf(node); c != nil {
+		return *c
+	}
+	return nil
+}
+
+// connOf returns the connection field of the commands that respond to their
+// requester.
+func connOf(node Node) *net.Conn {
+	switch t := node.(type) {
+	case *MakeCommand:
+		return &t.Conn
+	case *DropCommand:
+		return &t.Conn
+	case *GetCommand:
+		return &t.Conn
+	case *PutCommand:
+		return &t.Conn
+	case *DelCommand:
+		return &t.Conn
+	case *ScanCommand:
+		return &t.Conn
+	case *GetVersionCommand:
+		return &t.Conn
+	case *HistoryCommand:
+		return &t.Conn
+	case *IncrCommand:
+		return &t.Conn
+	case *AppendCommand:
+		return &t.Conn
+	case *GetSetCommand:
+		return &t.Conn
+	case *CasCommand:
+		return &t.Conn
+	case *CondPutCommand:
+		return &t.Conn
+	default:
+		return nil
+	}
+}
+
 type Identifier struct {
 	Token Token // the token.IDENT token
 	Value string
@@ -41,20 +92,217 @@ func (sc *StopCommand) TokenLiteral() string { return sc.Token.Literal }
 func (sc *StopCommand) String() string       { return "STOP" }
 func (sc *StopCommand) GetTable() string     { return "" }
 
+// -------SYNC Command----------------------------------------------------------
+
+// SyncCommand represents a replica's intent to receive a snapshot of every
+// table followed by every committed change.
+// The server hands the replica's connection to the stream.
+type SyncCommand struct {
+	Token Token // the 'SYNC' keyword token
+}
+
+func (sc *SyncCommand) TokenLiteral() string { return sc.Token.Literal }
+func (sc *SyncCommand) String() string       { return "SYNC" }
+func (sc *SyncCommand) GetTable() string     { return "" }
+
+// -------Cluster Commands------------------------------------------------------
+
+// JoinCommand represents user intent to add the node cmd.ID, listening for
+// Raft traffic on cmd.Addr, to the cluster.
+type JoinCommand struct {
+	// JOIN(id, addr)
+	Token Token  // the 'JOIN' keyword token
+	ID    string // the first argument identifier
+	Addr  string // The second argument identifier
+}
+
+func (jc *JoinCommand) TokenLiteral() string { return jc.Token.Literal }
+func (jc *JoinCommand) GetTable() string     { return "" }
+
+func (jc *JoinCommand) String() string {
+	return fmt.Sprintf("cmd: %s( id: %s, addr: %s )", jc.Token.Literal, jc.ID, jc.Addr)
+}
+
+// LeaveCommand represents user intent to remove the node cmd.ID from the
+// cluster.
+type LeaveCommand struct {
+	// LEAVE(id)
+	Token Token  // the 'LEAVE' keyword token
+	ID    string // the first argument identifier
+}
+
+func (lc *LeaveCommand) TokenLiteral() string { return lc.Token.Literal }
+func (lc *LeaveCommand) GetTable() string     { return "" }
+
+func (lc *LeaveCommand) String() string {
+	return fmt.Sprintf("cmd: %s( id: %s )", lc.Token.Literal, lc.ID)
+}
+
+// ClusterCommand represents user intent to list the node's view of the
+// cluster.
+type ClusterCommand struct {
+	Token Token // the 'CLUSTER' keyword token
+}
+
+func (cc *ClusterCommand) TokenLiteral() string { return cc.Token.Literal }
+func (cc *ClusterCommand) String() string       { return "CLUSTER" }
+func (cc *ClusterCommand) GetTable() string     { return "" }
+
+// -------User Commands---------------------------------------------------------
+
+// AuthCommand represents a connection's intent to act as cmd.User.
+type AuthCommand struct {
+	// AUTH(user, password)
+	Token    Token  // the 'AUTH' keyword token
+	User     string // the first argument identifier
+	Password string // The second argument identifier
+}
+
+func (ac *AuthCommand) TokenLiteral() string { return ac.Token.Literal }
+func (ac *AuthCommand) GetTable() string     { return "" }
+
+// String leaves the password out, so it is not logged.
+func (ac *AuthCommand) String() string {
+	return fmt.Sprintf("cmd: %s( user: %s )", ac.Token.Literal, ac.User)
+}
+
+// AddUserCommand represents user intent to add cmd.User, or to change its
+// password and role. cmd.Role is "admin" for admins and empty otherwise.
+type AddUserCommand struct {
+	// ADDUSER(user, password) or ADDUSER(user, password, admin)
+	Token    Token  // the 'ADDUSER' keyword token
+	User     string // the first argument identifier
+	Password string // The second argument identifier
+	Role     string // The optional third argument identifier
+}
+
+func (ac *AddUserCommand) TokenLiteral() string { return ac.Token.Literal }
+func (ac *AddUserCommand) GetTable() string     { return "" }
+
+// String leaves the password out, so it is not logged.
+func (ac *AddUserCommand) String() string {
+	return fmt.Sprintf("cmd: %s( user: %s, role: %s )", ac.Token.Literal, ac.User, ac.Role)
+}
+
+// DelUserCommand represents user intent to remove cmd.User.
+type DelUserCommand struct {
+	// DELUSER(user)
+	Token Token  // the 'DELUSER' keyword token
+	User  string // the first argument identifier
+}
+
+func (dc *DelUserCommand) TokenLiteral() string { return dc.Token.Literal }
+func (dc *DelUserCommand) GetTable() string     { return "" }
+
+func (dc *DelUserCommand) String() string {
+	return fmt.Sprintf("cmd: %s( user: %s )", dc.Token.Literal, dc.User)
+}
+
+// UsersCommand represents user intent to list the users.
+type UsersCommand struct {
+	Token Token // the 'USERS' keyword token
+}
+
+func (uc *UsersCommand) TokenLiteral() string { return uc.Token.Literal }
+func (uc *UsersCommand) String() string       { return "USERS" }
+func (uc *UsersCommand) GetTable() string     { return "" }
+
+// GrantCommand represents user intent to give cmd.User the right cmd.Right on
+// the tables matching cmd.Pattern.
+type GrantCommand struct {
+	// GRANT(user, pattern, right)
+	Token   Token  // the 'GRANT' keyword token
+	User    string // the first argument identifier
+	Pattern string // The second argument identifier
+	Right   string // The third argument identifier
+}
+
+func (gc *GrantCommand) TokenLiteral() string { return gc.Token.Literal }
+func (gc *GrantCommand) GetTable() string     { return "" }
+
+func (gc *GrantCommand) String() string {
+	return fmt.Sprintf("cmd: %s( user: %s, pattern: %s, right: %s )", gc.Token.Literal, gc.User, gc.Pattern, gc.Right)
+}
+
+// RevokeCommand represents user intent to remove cmd.User's grant on
+// cmd.Pattern.
+type RevokeCommand struct {
+	// REVOKE(user, pattern)
+	Token   Token  // the 'REVOKE' keyword token
+	User    string // the first argument identifier
+	Pattern string // The second argument identifier
+}
+
+func (rc *RevokeCommand) TokenLiteral() string { return rc.Token.Literal }
+func (rc *RevokeCommand) GetTable() string     { return "" }
+
+func (rc *RevokeCommand) String() string {
+	return fmt.Sprintf("cmd: %s( user: %s, pattern: %s )", rc.Token.Literal, rc.User, rc.Pattern)
+}
+
+// -------TABLES Command--------------------------------------------------------
+
+// TablesCommand represents user intent to list the loaded tables.
+type TablesCommand struct {
+	Token Token // the 'TABLES' keyword token
+}
+
+func (tc *TablesCommand) TokenLiteral() string { return tc.Token.Literal }
+func (tc *TablesCommand) String() string       { return "TABLES" }
+func (tc *TablesCommand) GetTable() string     { return "" }
+
+// -------BATCH/END Command-----------------------------------------------------
+
+// BatchCommand represents user intent to start a batch. The PUT and DEL
+// commands that follow, up to an EndCommand, are committed all-or-nothing.
+type BatchCommand struct {
+	// BATCH or BATCH()
+	Token Token // the 'BATCH' keyword token
+}
+
+func (bc *BatchCommand) TokenLiteral() string { return bc.Token.Literal }
+func (bc *BatchCommand) String() string       { return "BATCH" }
+func (bc *BatchCommand) GetTable() string     { return "" }
+
+// EndCommand represents user intent to commit the open batch.
+type EndCommand struct {
+	// END or END()
+	Token Token // the 'END' keyword token
+}
+
+func (ec *EndCommand) TokenLiteral() string { return ec.Token.Literal }
+func (ec *EndCommand) String() string       { return "END" }
+func (ec *EndCommand) GetTable() string     { return "" }
+
 // -------MAKE Command----------------------------------------------------------
 
 // MakeCommand represents user intent to create a new table.
 type MakeCommand struct {
-	// MAKE(table)
-	Token Token // the 'MAKE' keyword token
-	Table string
+	// MAKE(table), MAKE(table, kind) or MAKE(table, kind, versions)
+	Conn net.Conn // Used to respond to requester
+
+	Token    Token  // the 'MAKE' keyword token
+	Table    string // the first argument identifier
+	Kind     string // the optional second argument identifier, e.g. memory
+	Versions string // the optional third argument identifier, versions kept per key
 }
 
 func (mc *MakeCommand) TokenLiteral() string { return mc.Token.Literal }
 func (mc *MakeCommand) GetTable() string     { return mc.Table }
 
 func (mc *MakeCommand) String() string {
-	return fmt.Sprintf("cmd: %s( table: %s )", mc.Token.Literal, mc.Table)
+	if mc.Kind == "" {
+		return fmt.Sprintf("cmd: %s( table: %s )", mc.Token.Literal, mc.Table)
+	}
+	if mc.Versions == "" {
+		return fmt.Sprintf(
+			"cmd: %s( table: %s, kind: %s )", mc.Token.Literal, mc.Table, mc.Kind,
+		)
+	}
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, kind: %s, versions: %s )",
+		mc.Token.Literal, mc.Table, mc.Kind, mc.Versions,
+	)
 }
 
 // -------DROP Command----------------------------------------------------------
@@ -62,6 +310,8 @@ func (mc *MakeCommand) String() string {
 // DropCommand represents user intent to drop, or remove, an existing table.
 type DropCommand struct {
 	// DROP(table)
+	Conn net.Conn // Used to respond to requester
+
 	Token Token  // the 'DROP' keyword token
 	Table string // the first argument identifier
 }
@@ -94,12 +344,83 @@ func (gc *GetCommand) String() string {
 	)
 }
 
+// -------WATCH Command---------------------------------------------------------
+
+// WatchCommand represents user intent to stream the committed changes to the
+// keys of cmd.Table starting with cmd.Prefix, resuming after cmd.Seq if set.
+// The server hands the requester's connection to the stream.
+type WatchCommand struct {
+	// WATCH(table), WATCH(table, prefix) or WATCH(table, prefix, seq)
+	Token  Token  // the 'WATCH' keyword token
+	Table  string // the first argument identifier
+	Prefix string // The optional second argument identifier
+	Seq    string // The optional third argument identifier
+}
+
+func (wc *WatchCommand) TokenLiteral() string { return wc.Token.Literal }
+func (wc *WatchCommand) GetTable() string     { return wc.Table }
+
+func (wc *WatchCommand) String() string {
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, prefix: %s, seq: %s )",
+		wc.Token.Literal, wc.Table, wc.Prefix, wc.Seq,
+	)
+}
+
+// -------GETV Command----------------------------------------------------------
+
+// GetVersionCommand represents user intent to get the value cmd.Key had in
+// cmd.Table at the commit numbered cmd.Version.
+type GetVersionCommand struct {
+	// GETV(table, key, version)
+	Conn net.Conn // Used to respond to requester
+
+	Token   Token  // the 'GETV' keyword token
+	Table   string // the first argument identifier
+	Key     string // The second argument identifier
+	Version string // The third argument identifier
+}
+
+func (gc *GetVersionCommand) TokenLiteral() string { return gc.Token.Literal }
+func (gc *GetVersionCommand) GetTable() string     { return gc.Table }
+
+func (gc *GetVersionCommand) String() string {
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, key: %s, version: %s )",
+		gc.Token.Literal, gc.Table, gc.Key, gc.Version,
+	)
+}
+
+// -------HISTORY Command-------------------------------------------------------
+
+// HistoryCommand represents user intent to list the kept versions of cmd.Key
+// in cmd.Table.
+type HistoryCommand struct {
+	// HISTORY(table, key)
+	Conn net.Conn // Used to respond to requester
+
+	Token Token  // the 'HISTORY' keyword token
+	Table string // the first argument identifier
+	Key   string // The second argument identifier
+}
+
+func (hc *HistoryCommand) TokenLiteral() string { return hc.Token.Literal }
+func (hc *HistoryCommand) GetTable() string     { return hc.Table }
+
+func (hc *HistoryCommand) String() string {
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, key: %s )", hc.Token.Literal, hc.Table, hc.Key,
+	)
+}
+
 // -------PUT Command-----------------------------------------------------------
 
 // PutCommand represents user intent to put the value of cmd.Value for cmd.Key
 // into cmd.Table.
 type PutCommand struct {
 	// PUT(table, key, value)
+	Conn net.Conn // Used to respond to requester
+
 	Token Token  // the 'PUT' keyword token
 	Table string // The first argument identifier
 	Key   string // The second argument identifier
@@ -121,6 +442,8 @@ func (pc *PutCommand) String() string {
 // DelCommand represents user intent to delete the cmd.Key from cmd.Table.
 type DelCommand struct {
 	// DEL(table, key)
+	Conn net.Conn // Used to respond to requester
+
 	Token Token  // the 'DEL' keyword token
 	Table string // The first argument identifier
 	Key   string // The second argument identifier
@@ -134,3 +457,148 @@ func (dc *DelCommand) String() string {
 		"cmd: %s( table: %s, key: %s )", dc.Token.Literal, dc.Table, dc.Key,
 	)
 }
+
+// -------SCAN Command----------------------------------------------------------
+
+// ScanCommand represents user intent to list the items of cmd.Table in key
+// order, from cmd.Start up to but excluding cmd.End.
+type ScanCommand struct {
+	// SCAN(table), SCAN(table, start) or SCAN(table, start, end)
+	Conn net.Conn // Used to respond to requester
+
+	Token Token  // the 'SCAN' keyword token
+	Table string // The first argument identifier
+	Start string // The optional second argument identifier
+	End   string // The optional third argument identifier
+}
+
+func (sc *ScanCommand) TokenLiteral() string { return sc.Token.Literal }
+func (sc *ScanCommand) GetTable() string     { return sc.Table }
+
+func (sc *ScanCommand) String() string {
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, start: %s, end: %s )",
+		sc.Token.Literal, sc.Table, sc.Start, sc.End,
+	)
+}
+
+// -------INCR/DECR Command-----------------------------------------------------
+
+// IncrCommand represents user intent to add cmd.Delta to, for INCR, or
+// subtract it from, for DECR, the integer value of cmd.Key in cmd.Table.
+type IncrCommand struct {
+	// INCR(table, key), INCR(table, key, delta) or the same for DECR
+	Conn net.Conn // Used to respond to requester
+
+	Token Token  // the 'INCR' or 'DECR' keyword token
+	Table string // The first argument identifier
+	Key   string // The second argument identifier
+	Delta string // The optional third argument identifier, defaults to 1
+}
+
+func (ic *IncrCommand) TokenLiteral() string { return ic.Token.Literal }
+func (ic *IncrCommand) GetTable() string     { return ic.Table }
+
+func (ic *IncrCommand) String() string {
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, key: %s, delta: %s )",
+		ic.Token.Literal, ic.Table, ic.Key, ic.Delta,
+	)
+}
+
+// -------APPEND Command--------------------------------------------------------
+
+// AppendCommand represents user intent to append cmd.Suffix to the value of
+// cmd.Key in cmd.Table.
+type AppendCommand struct {
+	// APPEND(table, key, suffix)
+	Conn net.Conn // Used to respond to requester
+
+	Token  Token  // the 'APPEND' keyword token
+	Table  string // The first argument identifier
+	Key    string // The second argument identifier
+	Suffix string // The third argument identifier
+}
+
+func (ac *AppendCommand) TokenLiteral() string { return ac.Token.Literal }
+func (ac *AppendCommand) GetTable() string     { return ac.Table }
+
+func (ac *AppendCommand) String() string {
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, key: %s, suffix: %s )",
+		ac.Token.Literal, ac.Table, ac.Key, ac.Suffix,
+	)
+}
+
+// -------GETSET Command--------------------------------------------------------
+
+// GetSetCommand represents user intent to put cmd.Value for cmd.Key into
+// cmd.Table and get the value it replaced.
+type GetSetCommand struct {
+	// GETSET(table, key, value)
+	Conn net.Conn // Used to respond to requester
+
+	Token Token  // the 'GETSET' keyword token
+	Table string // The first argument identifier
+	Key   string // The second argument identifier
+	Value string // The third argument identifier
+}
+
+func (gc *GetSetCommand) TokenLiteral() string { return gc.Token.Literal }
+func (gc *GetSetCommand) GetTable() string     { return gc.Table }
+
+func (gc *GetSetCommand) String() string {
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, key: %s, value: %s )",
+		gc.Token.Literal, gc.Table, gc.Key, gc.Value,
+	)
+}
+
+// -------CAS Command-----------------------------------------------------------
+
+// CasCommand represents user intent to put cmd.New for cmd.Key into cmd.Table
+// only if its current value is cmd.Expected.
+type CasCommand struct {
+	// CAS(table, key, expected, new)
+	Conn net.Conn // Used to respond to requester
+
+	Token    Token  // the 'CAS' keyword token
+	Table    string // The first argument identifier
+	Key      string // The second argument identifier
+	Expected string // The third argument identifier
+	New      string // The fourth argument identifier
+}
+
+func (cc *CasCommand) TokenLiteral() string { return cc.Token.Literal }
+func (cc *CasCommand) GetTable() string     { return cc.Table }
+
+func (cc *CasCommand) String() string {
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, key: %s, expected: %s, new: %s )",
+		cc.Token.Literal, cc.Table, cc.Key, cc.Expected, cc.New,
+	)
+}
+
+// -------PUTNX/PUTXX Command---------------------------------------------------
+
+// CondPutCommand represents user intent to put cmd.Value for cmd.Key into
+// cmd.Table only if the key is absent, for PUTNX, or present, for PUTXX.
+type CondPutCommand struct {
+	// PUTNX(table, key, value) or PUTXX(table, key, value)
+	Conn net.Conn // Used to respond to requester
+
+	Token Token  // the 'PUTNX' or 'PUTXX' keyword token
+	Table string // The first argument identifier
+	Key   string // The second argument identifier
+	Value string // The third argument identifier
+}
+
+func (cp *CondPutCommand) TokenLiteral() string { return cp.Token.Literal }
+func (cp *CondPutCommand) GetTable() string     { return cp.Table }
+
+func (cp *CondPutCommand) String() string {
+	return fmt.Sprintf(
+		"cmd: %s( table: %s, key: %s, value: %s )",
+		cp.Token.Literal, cp.Table, cp.Key, cp.Value,
+	)
+}
@@ -40,6 +40,15 @@ func (l *Lexer) NextToken() Token {
 		tok = newToken(LPAREN, l.ch)
 	case ')':
 		tok = newToken(RPAREN, l.ch)
+	case '"':
+		literal, ok := l.readString()
+		if !ok {
+			tok.Literal = literal
+			tok.Type = ILLEGAL
+			return tok
+		}
+		tok.Literal = literal
+		tok.Type = STRING
 	case 0:
 		tok.Literal = ""
 		tok.Type = EOF
@@ -79,7 +88,46 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
+// Reads a double quoted string, starting at its opening quote, and returns its
+// contents with the quotes removed and \" and \\ escapes resolved.
+// Leaves the lexer on the closing quote. Returns false if the string is not
+// closed.
+func (l *Lexer) readString() (string, bool) {
+	var b []byte
+	for {
+		l.readChar()
+		switch l.ch {
+		case '"':
+			return string(b), true
+		case 0:
+			return string(b), false
+		case '\\':
+			l.readChar()
+			if l.ch == 0 {
+				return string(b), false
+			}
+		}
+		b = append(b, l.ch)
+	}
+}
+
+// Quote returns s as a double quoted string that the lexer reads back as s,
+// with quotes and backslashes escaped. NUL bytes cannot be quoted, they end
+// the input.
+func Quote(s string) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, s[i])
+	}
+	return string(append(b, '"'))
+}
+
 // Is the current byte a character?
+// '-' is included so negative numbers, e.g. an INCR delta, lex as identifiers.
 func isLetterOrDigit(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || '0' <= ch && ch <= '9'
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || '0' <= ch && ch <= '9' || ch == '-'
 }
@@ -33,9 +33,33 @@ func NewParser(l *Lexer) *Parser {
 	p.registerParseFn(STOP, p.paseStopCommand)
 	p.registerParseFn(MAKE, p.parseMakeCommand)
 	p.registerParseFn(DROP, p.parseDropCommand)
+	p.registerParseFn(TABLES, p.parseTablesCommand)
 	p.registerParseFn(GET, p.parseGetCommand)
 	p.registerParseFn(PUT, p.parsePutCommand)
 	p.registerParseFn(DEL, p.parseDelCommand)
+	p.registerParseFn(SCAN, p.parseScanCommand)
+	p.registerParseFn(INCR, p.parseIncrCommand)
+	p.registerParseFn(DECR, p.parseIncrCommand)
+	p.registerParseFn(APPEND, p.parseAppendCommand)
+	p.registerParseFn(GETSET, p.parseGetSetCommand)
+	p.registerParseFn(CAS, p.parseCasCommand)
+	p.registerParseFn(BATCH, p.parseBatchCommand)
+	p.registerParseFn(WATCH, p.parseWatchCommand)
+	p.registerParseFn(SYNC, p.parseSyncCommand)
+	p.registerParseFn(JOIN, p.parseJoinCommand)
+	p.registerParseFn(LEAVE, p.parseLeaveCommand)
+	p.registerParseFn(CLUSTER, p.parseClusterCommand)
+	p.registerParseFn(AUTH, p.parseAuthCommand)
+	p.registerParseFn(ADDUSER, p.parseAddUserCommand)
+	p.registerParseFn(DELUSER, p.parseDelUserCommand)
+	p.registerParseFn(USERS, p.parseUsersCommand)
+	p.registerParseFn(GRANT, p.parseGrantCommand)
+	p.registerParseFn(REVOKE, p.parseRevokeCommand)
+	p.registerParseFn(GETV, p.parseGetVersionCommand)
+	p.registerParseFn(HISTORY, p.parseHistoryCommand)
+	p.registerParseFn(END, p.parseEndCommand)
+	p.registerParseFn(PUTNX, p.parseCondPutCommand)
+	p.registerParseFn(PUTXX, p.parseCondPutCommand)
 
 	// Read two tokens, so curToken and peekToken are both set.
 	p.nextToken()
@@ -154,17 +178,18 @@ func (p *Parser) parseMakeCommand() Node {
 		return nil
 	}
 
-	if p.missingNextArg("Table", MAKE) {
-		// move passed RPAREN
+	// Optional table kind and versions, e.g. MAKE(table, memory, 5)
+	args := p.parseArguments(MAKE, 1, "Table", "Kind", "Versions")
+	if args == nil {
 		return nil
 	}
 
-	p.nextToken() // Move passed LPAREN to TABLE
-
-	cmd.Table = NormalizeTableKey(p.curToken.Literal)
-
-	if !p.expectPeek(RPAREN) {
-		return nil
+	cmd.Table = NormalizeTableKey(args[0].String())
+	if len(args) > 1 {
+		cmd.Kind = args[1].String()
+	}
+	if len(args) > 2 {
+		cmd.Versions = args[2].String()
 	}
 
 	return cmd
@@ -350,6 +375,429 @@ func (p *Parser) parseDelParameters() []*Identifier {
 	return identifiers
 }
 
+func (p *Parser) parseScanCommand() Node {
+	cmd := &ScanCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(SCAN, 1, "Table", "Start", "End")
+	if args == nil {
+		return nil
+	}
+
+	cmd.Table = NormalizeTableKey(args[0].String())
+	if len(args) > 1 {
+		cmd.Start = args[1].String()
+	}
+	if len(args) > 2 {
+		cmd.End = args[2].String()
+	}
+
+	return cmd
+}
+
+// parseIncrCommand parses both INCR and DECR, the token tells them apart.
+func (p *Parser) parseIncrCommand() Node {
+	cmd := &IncrCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(cmd.Token.Literal, 2, "Table", "Key", "Delta")
+	if args == nil {
+		return nil
+	}
+
+	cmd.Table = NormalizeTableKey(args[0].String())
+	cmd.Key = args[1].String()
+	if len(args) > 2 {
+		cmd.Delta = args[2].String()
+	}
+
+	return cmd
+}
+
+func (p *Parser) parseAppendCommand() Node {
+	cmd := &AppendCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(APPEND, 3, "Table", "Key", "Suffix")
+	if args == nil {
+		return nil
+	}
+
+	cmd.Table = NormalizeTableKey(args[0].String())
+	cmd.Key = args[1].String()
+	cmd.Suffix = args[2].String()
+
+	return cmd
+}
+
+func (p *Parser) parseGetSetCommand() Node {
+	cmd := &GetSetCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(GETSET, 3, "Table", "Key", "Value")
+	if args == nil {
+		return nil
+	}
+
+	cmd.Table = NormalizeTableKey(args[0].String())
+	cmd.Key = args[1].String()
+	cmd.Value = args[2].String()
+
+	return cmd
+}
+
+func (p *Parser) parseCasCommand() Node {
+	cmd := &CasCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(CAS, 4, "Table", "Key", "Expected", "New")
+	if args == nil {
+		return nil
+	}
+
+	cmd.Table = NormalizeTableKey(args[0].String())
+	cmd.Key = args[1].String()
+	cmd.Expected = args[2].String()
+	cmd.New = args[3].String()
+
+	return cmd
+}
+
+// parseCondPutCommand parses both PUTNX and PUTXX, the token tells them apart.
+func (p *Parser) parseCondPutCommand() Node {
+	cmd := &CondPutCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(cmd.Token.Literal, 3, "Table", "Key", "Value")
+	if args == nil {
+		return nil
+	}
+
+	cmd.Table = NormalizeTableKey(args[0].String())
+	cmd.Key = args[1].String()
+	cmd.Value = args[2].String()
+
+	return cmd
+}
+
+func (p *Parser) parseWatchCommand() Node {
+	cmd := &WatchCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(WATCH, 1, "Table", "Prefix", "Seq")
+	if args == nil {
+		return nil
+	}
+
+	cmd.Table = NormalizeTableKey(args[0].String())
+	if len(args) > 1 {
+		cmd.Prefix = args[1].String()
+	}
+	if len(args) > 2 {
+		cmd.Seq = args[2].String()
+	}
+
+	return cmd
+}
+
+func (p *Parser) parseSyncCommand() Node {
+	cmd := &SyncCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+
+	return cmd
+}
+
+func (p *Parser) parseJoinCommand() Node {
+	cmd := &JoinCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(JOIN, 2, "ID", "Addr")
+	if args == nil {
+		return nil
+	}
+
+	cmd.ID = args[0].String()
+	cmd.Addr = args[1].String()
+
+	return cmd
+}
+
+func (p *Parser) parseLeaveCommand() Node {
+	cmd := &LeaveCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(LEAVE, 1, "ID")
+	if args == nil {
+		return nil
+	}
+
+	cmd.ID = args[0].String()
+
+	return cmd
+}
+
+func (p *Parser) parseAuthCommand() Node {
+	cmd := &AuthCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(AUTH, 2, "User", "Password")
+	if args == nil {
+		return nil
+	}
+
+	cmd.User = args[0].String()
+	cmd.Password = args[1].String()
+
+	return cmd
+}
+
+func (p *Parser) parseAddUserCommand() Node {
+	cmd := &AddUserCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(ADDUSER, 2, "User", "Password", "Role")
+	if args == nil {
+		return nil
+	}
+
+	cmd.User = args[0].String()
+	cmd.Password = args[1].String()
+	if len(args) > 2 {
+		cmd.Role = args[2].String()
+	}
+
+	return cmd
+}
+
+func (p *Parser) parseDelUserCommand() Node {
+	cmd := &DelUserCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(DELUSER, 1, "User")
+	if args == nil {
+		return nil
+	}
+
+	cmd.User = args[0].String()
+
+	return cmd
+}
+
+func (p *Parser) parseUsersCommand() Node {
+	cmd := &UsersCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+
+	return cmd
+}
+
+func (p *Parser) parseGrantCommand() Node {
+	cmd := &GrantCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(GRANT, 3, "User", "Pattern", "Right")
+	if args == nil {
+		return nil
+	}
+
+	cmd.User = args[0].String()
+	cmd.Pattern = args[1].String()
+	cmd.Right = args[2].String()
+
+	return cmd
+}
+
+func (p *Parser) parseRevokeCommand() Node {
+	cmd := &RevokeCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(REVOKE, 2, "User", "Pattern")
+	if args == nil {
+		return nil
+	}
+
+	cmd.User = args[0].String()
+	cmd.Pattern = args[1].String()
+
+	return cmd
+}
+
+func (p *Parser) parseTablesCommand() Node {
+	cmd := &TablesCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+
+	return cmd
+}
+
+func (p *Parser) parseClusterCommand() Node {
+	cmd := &ClusterCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+
+	return cmd
+}
+
+func (p *Parser) parseGetVersionCommand() Node {
+	cmd := &GetVersionCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(GETV, 3, "Table", "Key", "Version")
+	if args == nil {
+		return nil
+	}
+
+	cmd.Table = NormalizeTableKey(args[0].String())
+	cmd.Key = args[1].String()
+	cmd.Version = args[2].String()
+
+	return cmd
+}
+
+func (p *Parser) parseHistoryCommand() Node {
+	cmd := &HistoryCommand{Token: p.curToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	args := p.parseArguments(HISTORY, 2, "Table", "Key")
+	if args == nil {
+		return nil
+	}
+
+	cmd.Table = NormalizeTableKey(args[0].String())
+	cmd.Key = args[1].String()
+
+	return cmd
+}
+
+func (p *Parser) parseBatchCommand() Node {
+	cmd := &BatchCommand{Token: p.curToken}
+	if !p.parseOptionalParens() {
+		return nil
+	}
+	return cmd
+}
+
+func (p *Parser) parseEndCommand() Node {
+	cmd := &EndCommand{Token: p.curToken}
+	if !p.parseOptionalParens() {
+		return nil
+	}
+	return cmd
+}
+
+// parseOptionalParens parses the empty parentheses of commands that take no
+// arguments and may be written without them, e.g. END or END().
+func (p *Parser) parseOptionalParens() bool {
+	if !p.peekTokenIs(LPAREN) {
+		return true
+	}
+	p.nextToken()
+	return p.expectPeek(RPAREN)
+}
+
+// parseArguments parses the comma separated arguments of cmd up to and
+// including the closing RPAREN. names holds the name of every argument the
+// command accepts, the first minArgs of them are required.
+// Returns nil if an argument is missing or too many are given.
+func (p *Parser) parseArguments(cmd string, minArgs int, names ...string) []*Identifier {
+	var identifiers []*Identifier
+
+	for i, name := range names {
+		if i >= minArgs && p.peekTokenIs(RPAREN) {
+			break
+		}
+		if i > 0 && !p.expectPeek(COMMA) {
+			return nil
+		}
+		if p.missingNextArg(name, cmd) {
+			return nil
+		}
+		if p.peekTokenIs(EOF) || p.peekTokenIs(ILLEGAL) {
+			p.peekError(IDENT)
+			return nil
+		}
+		p.nextToken() // Move to the argument
+
+		arg := &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		identifiers = append(identifiers, arg)
+	}
+
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+
+	return identifiers
+}
+
 // -------Helpers---------------------------------------------------------------
 
 // NormalizeTableKey ensures the table has no suffix.
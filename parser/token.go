@@ -1,5 +1,10 @@
 package parser
 
+import (
+	"maps"
+	"slices"
+)
+
 type TokenType string
 
 type Token struct {
@@ -11,7 +16,8 @@ const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
 
-	IDENT = "IDENT"
+	IDENT  = "IDENT"
+	STRING = "STRING" // A double quoted identifier, e.g. "a b"
 
 	// Delimiters
 
@@ -21,12 +27,43 @@ const (
 
 	// Keywords
 
-	GET = "GET"
-	PUT = "PUT"
-	DEL = "DEL"
+	GET  = "GET"
+	PUT  = "PUT"
+	DEL  = "DEL"
+	SCAN = "SCAN"
+
+	INCR   = "INCR"
+	DECR   = "DECR"
+	APPEND = "APPEND"
+	GETSET = "GETSET"
+
+	WATCH = "WATCH"
+	SYNC  = "SYNC"
+
+	JOIN    = "JOIN"
+	LEAVE   = "LEAVE"
+	CLUSTER = "CLUSTER"
+
+	AUTH    = "AUTH"
+	ADDUSER = "ADDUSER"
+	DELUSER = "DELUSER"
+	USERS   = "USERS"
+	GRANT   = "GRANT"
+	REVOKE  = "REVOKE"
 
-	DROP = "DROP"
-	MAKE = "MAKE"
+	GETV    = "GETV"
+	HISTORY = "HISTORY"
+
+	CAS   = "CAS"
+	PUTNX = "PUTNX"
+	PUTXX = "PUTXX"
+
+	DROP   = "DROP"
+	MAKE   = "MAKE"
+	TABLES = "TABLES"
+
+	BATCH = "BATCH"
+	END   = "END"
 
 	STOP = "STOP"
 )
@@ -36,12 +73,51 @@ var keywords = map[string]TokenType{
 	"PUT": PUT, // PUT(table, key, value)
 	"DEL": DEL, // DEL(table, key)
 
-	"MAKE": MAKE, // MAKE(table)
+	"SCAN": SCAN, // SCAN(table), SCAN(table, start) or SCAN(table, start, end)
+
+	"INCR":   INCR,   // INCR(table, key) or INCR(table, key, delta)
+	"DECR":   DECR,   // DECR(table, key) or DECR(table, key, delta)
+	"APPEND": APPEND, // APPEND(table, key, suffix)
+	"GETSET": GETSET, // GETSET(table, key, value)
+
+	"WATCH": WATCH, // WATCH(table), WATCH(table, prefix) or WATCH(table, prefix, seq)
+
+	"SYNC": SYNC, // SYNC(), streams every table to a replica
+
+	"JOIN":    JOIN,    // JOIN(id, "host:port"), adds a node to the cluster
+	"LEAVE":   LEAVE,   // LEAVE(id), removes a node from the cluster
+	"CLUSTER": CLUSTER, // CLUSTER(), lists the node's view of the cluster
+
+	"AUTH":    AUTH,    // AUTH(user, password), authenticates the connection
+	"ADDUSER": ADDUSER, // ADDUSER(user, password) or ADDUSER(user, password, admin)
+	"DELUSER": DELUSER, // DELUSER(user), removes a user
+	"USERS":   USERS,   // USERS(), lists the users
+	"GRANT":   GRANT,   // GRANT(user, pattern, right), right is read, write or admin
+	"REVOKE":  REVOKE,  // REVOKE(user, pattern)
+
+	"GETV":    GETV,    // GETV(table, key, version)
+	"HISTORY": HISTORY, // HISTORY(table, key)
+
+	"CAS":   CAS,   // CAS(table, key, expected, new)
+	"PUTNX": PUTNX, // PUTNX(table, key, value)
+	"PUTXX": PUTXX, // PUTXX(table, key, value)
+
+	"MAKE": MAKE, // MAKE(table), MAKE(table, kind) or MAKE(table, kind, versions)
 	"DROP": DROP, // DROP(table)
 
+	"TABLES": TABLES, // TABLES(), lists the tables
+
+	"BATCH": BATCH, // BATCH or BATCH(), followed by PUT and DEL lines
+	"END":   END,   // END or END(), commits the open batch
+
 	"STOP": STOP, // STOP()
 }
 
+// Keywords returns the command keywords in order.
+func Keywords() []string {
+	return slices.Sorted(maps.Keys(keywords))
+}
+
 func LookupIdentifier(ident string) TokenType {
 	if tok, exists := keywords[ident]; exists {
 		return tok
@@ -1,11 +1,14 @@
 package paths
 
 import (
+	"errors"
 	"fmt"
-	"orchiddb/globals"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"orchiddb/globals"
+	"orchiddb/vfs"
 )
 
 // -----------------------------------------------------------------------------
@@ -33,11 +36,22 @@ var ExeDir = GetExecDirectory()
 // This can be set through CLI but will default to the .exe's directory.
 var DatabasePath = ExeDir
 
+// ErrTableName is returned for a table name that is not a plain file name.
+var ErrTableName = errors.New("invalid table name")
+
+// CheckTableName returns ErrTableName if name is empty or could name a file
+// outside the database path.
+func CheckTableName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\\x00") || strings.Contains(name, "..") {
+		return fmt.Errorf("%w %q", ErrTableName, name)
+	}
+	return nil
+}
+
 // GetTablePath returns the path to the .db file for tbl.
 func GetTablePath(tbl string) (string, bool) {
 	p := filepath.Join(DatabasePath, tbl)
-	_, err := os.Stat(p)
-	if err != nil {
+	if !vfs.Default.Exists(p) {
 		return "", false
 	}
 
@@ -51,13 +65,22 @@ func GetTablePath(tbl string) (string, bool) {
 // are removed after successful transaction commits.
 // If an invalid WAL file was generated, the system will delete them later.
 func GetTableWAL(tbl string) (string, bool) {
-	files, err := GetDirContents(DatabasePath)
+	return FindTableWAL(vfs.Default, DatabasePath, tbl)
+}
+
+// FindTableWAL returns the first WAL file for the table name tbl in dir of
+// fsys and a bool signifying if it was found.
+func FindTableWAL(fsys vfs.FS, dir, tbl string) (string, bool) {
+	files, err := fsys.List(dir)
 	if err != nil {
 		return "", false
 	}
 
 	for _, f := range files {
-		if strings.Contains(f, tbl) && strings.HasSuffix(f, globals.WAL_SUFFIX) {
+		if !strings.HasSuffix(f, globals.WAL_SUFFIX) {
+			continue
+		}
+		if walTableName(f) == tbl {
 			return f, true
 		}
 	}
@@ -65,41 +88,94 @@ func GetTableWAL(tbl string) (string, bool) {
 	return "", false
 }
 
+// walTableName returns the name of the table a WAL file belongs to.
+// WAL files are named "<table>_<stamp>.wal" and stamps contain no underscores.
+func walTableName(walPath string) string {
+	stem := strings.TrimSuffix(filepath.Base(walPath), globals.WAL_SUFFIX)
+	i := strings.LastIndex(stem, "_")
+	if i == -1 {
+		return ""
+	}
+	return stem[:i]
+}
+
 // GetTablePaths returns a list of absolute paths to the table .db files.
 func GetTablePaths() []string {
+	return getPathsWithSuffix(globals.TBL_SUFFIX)
+}
+
+// GetLSMPaths returns a list of absolute paths to the LSM table manifests.
+func GetLSMPaths() []string {
+	return getPathsWithSuffix(globals.LSM_SUFFIX)
+}
+
+// GetBatchLogPaths returns a list of absolute paths to the batch logs.
+func GetBatchLogPaths() []string {
+	return getPathsWithSuffix(globals.BATCH_SUFFIX)
+}
+
+// GetBatchCommitPaths returns a list of absolute paths to the batch commit
+// records.
+func GetBatchCommitPaths() []string {
+	return getPathsWithSuffix(globals.COMMIT_SUFFIX)
+}
+
+// BatchLogPath returns the path of table's log for the batch id.
+// Batch logs are named "<table>_<id>.batch" and ids contain no underscores.
+func BatchLogPath(table, id string) string {
+	return filepath.Join(DatabasePath, table+"_"+id+globals.BATCH_SUFFIX)
+}
+
+// BatchCommitPath returns the path of the commit record for the batch id.
+func BatchCommitPath(id string) string {
+	return filepath.Join(DatabasePath, "batch_"+id+globals.COMMIT_SUFFIX)
+}
+
+// ParseBatchLogPath returns the table and batch id of a batch log path.
+func ParseBatchLogPath(logPath string) (table, id string, ok bool) {
+	stem := strings.TrimSuffix(filepath.Base(logPath), globals.BATCH_SUFFIX)
+	i := strings.LastIndex(stem, "_")
+	if i == -1 {
+		return "", "", false
+	}
+	return stem[:i], stem[i+1:], true
+}
+
+// SeqPath returns the path of the file holding table's latest change sequence
+// number.
+func SeqPath(table string) string {
+	return filepath.Join(DatabasePath, table+globals.SEQ_SUFFIX)
+}
+
+// RaftPath returns the path of the cluster file with the given suffix in the
+// database path.
+func RaftPath(suffix string) string {
+	return filepath.Join(DatabasePath, "cluster"+suffix)
+}
+
+// getPathsWithSuffix returns the files in the database path ending in suffix.
+func getPathsWithSuffix(suffix string) []string {
 	items, err := GetDirContents(DatabasePath)
 	if err != nil {
 		return nil
 	}
 
-	var tables []string
+	var files []string
 
 	for _, item := range items {
-		if strings.HasSuffix(item, globals.TBL_SUFFIX) {
-			tables = append(tables, item)
+		if strings.HasSuffix(item, suffix) {
+			files = append(files, item)
 		}
 	}
 
-	return tables
+	return files
 }
 
 // -------Generic Utils---------------------------------------------------------
 
-// GetDirContents returns all items in path or encountered error.
+// GetDirContents returns all files in path or encountered error.
 func GetDirContents(path string) ([]string, error) {
-	var contents []string
-
-	items, err := os.ReadDir(path)
-	if err != nil {
-		return contents, err
-	}
-
-	for _, item := range items {
-		entry := fmt.Sprintf("%s/%s", path, item.Name())
-		contents = append(contents, entry)
-	}
-
-	return contents, nil
+	return vfs.Default.List(path)
 }
 
 type PathLike interface {
@@ -0,0 +1,210 @@
+package raft
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+
+	"orchiddb/execution"
+	"orchiddb/globals"
+	"orchiddb/vfs"
+)
+
+// node is the server's node when running in cluster mode.
+var node *Node
+
+// Enabled returns whether the server runs in cluster mode, in which every
+// write goes through the Raft log.
+func Enabled() bool {
+	return node != nil
+}
+
+// Start loads the node's Raft state and starts taking part in the cluster.
+//
+// The tables are rebuilt from the latest snapshot and the log entries after it
+// are applied again as the leader reports them committed, so the tables always
+// reflect the log. On its first start, a node in -cluster writes the initial
+// configuration to its log, while a node that is not waits to be added with
+// JOIN on the leader.
+// Raft traffic uses TLS if listenTLS is set, in which case dialTLS returns the
+// configuration of connections to the other nodes.
+func Start(listenTLS *tls.Config, dialTLS func() *tls.Config) error {
+	if globals.ReplicaOf != "" {
+		return errors.New("a cluster node cannot be a replica")
+	}
+
+	members, err := parseMembers(globals.Cluster)
+	if err != nil {
+		return err
+	}
+	addr := globals.RaftAddr
+	if addr == "" {
+		addr = members[globals.NodeID]
+	}
+	if addr == "" {
+		return errors.New("-raft-addr is required for nodes that are not in -cluster")
+	}
+
+	st, err := openStore(vfs.Default)
+	if err != nil {
+		return err
+	}
+	hs, err := st.loadState()
+	if err != nil {
+		return fmt.Errorf("load raft state: %w", err)
+	}
+	snap, err := st.loadSnapshot()
+	if err != nil {
+		return fmt.Errorf("load raft snapshot: %w", err)
+	}
+	entries, err := st.loadLog()
+	if err != nil {
+		return fmt.Errorf("load raft log: %w", err)
+	}
+
+	first := st.isEmpty()
+	if first && len(execution.TableNames()) > 0 {
+		return errors.New("the database path holds tables but no cluster state, start new nodes with an empty -path")
+	}
+
+	n := &Node{
+		id:         globals.NodeID,
+		addr:       addr,
+		clientAddr: fmt.Sprintf("%s:%d", globals.Address, globals.Port),
+		store:      st,
+		term:       hs.Term,
+		votedFor:   hs.VotedFor,
+		pending:    map[uint64]*proposal{},
+		wake:       make(chan struct{}, 1),
+		applyCh:    make(chan struct{}, 1),
+		clients:    map[string]*rpcClient{},
+		dialTLS:    dialTLS,
+	}
+
+	var data []byte
+	if snap != nil {
+		n.snapIndex, n.snapTerm, n.snapConfig = snap.Index, snap.Term, snap.Config
+		data = snap.Data
+	}
+	if err := n.fsm.restore(data); err != nil {
+		return fmt.Errorf("restore raft snapshot: %w", err)
+	}
+
+	// Entries the snapshot includes are left over if the node stopped between
+	// saving the snapshot and compacting the log.
+	for len(entries) > 0 && entries[0].Index <= n.snapIndex {
+		entries = entries[1:]
+	}
+	if len(entries) > 0 && entries[0].Index != n.snapIndex+1 {
+		return fmt.Errorf("raft log starts at %d after a snapshot at %d", entries[0].Index, n.snapIndex)
+	}
+	n.log = entries
+	n.reloadConfigLocked()
+	n.commitIndex, n.lastApplied = n.snapIndex, n.snapIndex
+
+	if first && len(members) > 0 {
+		if _, ok := members[n.id]; !ok {
+			return fmt.Errorf("node %s is not one of the -cluster members", n.id)
+		}
+		if _, err := n.appendLocked(Entry{Kind: entryConfig, Config: members}); err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot create raft listener for %s: %w", addr, err)
+	}
+	if listenTLS != nil {
+		l = tls.NewListener(l, listenTLS)
+	}
+
+	n.resetElectionLocked()
+	go n.serve(l)
+	go n.run()
+	go n.applier()
+
+	node = n
+	fmt.Println("raft: node", n.id, "listening on", addr)
+	return nil
+}
+
+// parseMembers parses id=host:port pairs separated by commas.
+func parseMembers(s string) (map[string]string, error) {
+	members := map[string]string{}
+	if s == "" {
+		return members, nil
+	}
+
+	for pair := range strings.SplitSeq(s, ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("invalid cluster member %q, expected id=host:port", pair)
+		}
+		members[id] = addr
+	}
+	return members, nil
+}
+
+// -------Clients---------------------------------------------------------------
+
+// Propose commits a write query through the Raft log and waits for it to be
+// applied, which responds to conn. Returns an error redirecting to the leader
+// if the node is not the leader.
+func Propose(query string, conn net.Conn) error {
+	return node.propose(Entry{Kind: entryCommand, Lines: []string{query}}, conn)
+}
+
+// ProposeBatch commits the PUT and DEL queries of a batch through the Raft log
+// and waits for the batch to be applied, which responds to conn.
+func ProposeBatch(queries []string, conn net.Conn) error {
+	return node.propose(Entry{Kind: entryBatch, Lines: queries}, conn)
+}
+
+// AddMember adds the node id listening for Raft traffic on addr to the cluster.
+func AddMember(id, addr string) error {
+	return node.changeConfig(func(config map[string]string) error {
+		if _, ok := config[id]; ok {
+			return fmt.Errorf("%s is already a member", id)
+		}
+		config[id] = addr
+		return nil
+	})
+}
+
+// RemoveMember removes the node id from the cluster.
+func RemoveMember(id string) error {
+	return node.changeConfig(func(config map[string]string) error {
+		if _, ok := config[id]; !ok {
+			return fmt.Errorf("%s is not a member", id)
+		}
+		if len(config) == 1 {
+			return errors.New("cannot remove the last member")
+		}
+		delete(config, id)
+		return nil
+	})
+}
+
+// WriteStatus writes the node's view of the cluster to w as "key value" lines
+// followed by an END line.
+func WriteStatus(w io.Writer) error {
+	n := node
+	n.mu.Lock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "node %s\nrole %s\nterm %d\n", n.id, n.role, n.term)
+	fmt.Fprintf(&b, "leader %s\ncommit %d\napplied %d\n", n.leaderID, n.commitIndex, n.lastApplied)
+	for _, id := range slices.Sorted(maps.Keys(n.config)) {
+		fmt.Fprintf(&b, "member %s %s\n", id, n.config[id])
+	}
+	n.mu.Unlock()
+
+	b.WriteString("END\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
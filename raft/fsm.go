@@ -0,0 +1,106 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"orchiddb/execution"
+	"orchiddb/globals"
+	"orchiddb/parser"
+	"orchiddb/response"
+)
+
+// fsm applies committed entries to the node's tables, and snapshots and
+// restores them.
+type fsm struct{}
+
+// apply executes the queries of e. The response goes to conn, or nowhere if it
+// is nil, which is the case for every node but the leader the client wrote to.
+func (fsm) apply(e Entry, conn net.Conn) {
+	if conn == nil {
+		conn = discardConn{}
+	}
+
+	switch e.Kind {
+	case entryCommand:
+		cmd := parse(e.Lines[0])
+		if cmd == nil {
+			return
+		}
+		parser.BindConn(cmd.Command, conn)
+		execution.ExecuteCommand(cmd)
+	case entryBatch:
+		var cmds []*parser.Command
+		for _, line := range e.Lines {
+			if cmd := parse(line); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		}
+
+		err := execution.ExecuteBatch(cmds)
+		if err == nil {
+			err = response.WriteOK(conn)
+		} else {
+			err = response.WriteError(conn, err)
+		}
+		if err != nil {
+			fmt.Printf("Error writing batch result to client: %v\n", err)
+		}
+	}
+}
+
+// parse parses a query of the log, which the leader already parsed once.
+func parse(line string) *parser.Command {
+	cmd := parser.NewParser(parser.NewLexer(line)).ParseCommand()
+	if cmd == nil || cmd.Command == nil {
+		fmt.Println("[ERROR] raft log holds an invalid query:", line)
+		return nil
+	}
+	return cmd
+}
+
+// snapshot returns the encoded snapshot of every table.
+func (fsm) snapshot() ([]byte, error) {
+	tables, err := execution.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(tables); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// restore replaces every table with the tables of an encoded snapshot, or
+// drops them all if data is nil.
+func (fsm) restore(data []byte) error {
+	var tables []execution.TableSnapshot
+	if data != nil {
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tables); err != nil {
+			return fmt.Errorf("decode snapshot: %w", err)
+		}
+	}
+	return execution.Restore(tables)
+}
+
+func snapshotEvery() int {
+	return max(1, globals.SnapshotEvery)
+}
+
+// discardConn is a net.Conn that discards responses.
+type discardConn struct{}
+
+func (discardConn) Read(b []byte) (int, error)         { return 0, io.EOF }
+func (discardConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (discardConn) Close() error                       { return nil }
+func (discardConn) LocalAddr() net.Addr                { return nil }
+func (discardConn) RemoteAddr() net.Addr               { return nil }
+func (discardConn) SetDeadline(t time.Time) error      { return nil }
+func (discardConn) SetReadDeadline(t time.Time) error  { return nil }
+func (discardConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,700 @@
+package raft
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"orchiddb/response"
+)
+
+// -----------------------------------------------------------------------------
+// Raft consensus for clustered mode.
+//
+// Every write is appended to the leader's log and replicated to the followers.
+// Once a majority of the cluster has it, the entry is committed and every node
+// applies it to its tables in log order, so all nodes hold the same tables.
+// Followers redirect writes to the leader. Membership changes add or remove a
+// single node at a time through a configuration entry, which takes effect as
+// soon as it is appended. Snapshots of the tables compact the log and bring
+// nodes that are too far behind up to date.
+// -----------------------------------------------------------------------------
+
+const (
+	heartbeatInterval  = 50 * time.Millisecond
+	minElectionTimeout = 300 * time.Millisecond
+	maxElectionTimeout = 600 * time.Millisecond
+
+	rpcTimeout      = 500 * time.Millisecond
+	snapshotTimeout = 30 * time.Second
+	proposeTimeout  = 5 * time.Second
+
+	maxEntriesPerAppend = 256
+)
+
+// ErrNotLeader is returned for writes proposed to a node that is not the
+// leader.
+var ErrNotLeader = response.Errorf(response.CodeNotLeader, "not the leader")
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+func (r role) String() string {
+	switch r {
+	case follower:
+		return "follower"
+	case candidate:
+		return "candidate"
+	default:
+		return "leader"
+	}
+}
+
+type entryKind uint8
+
+const (
+	entryNoop    entryKind = iota // Committed by a new leader to commit earlier terms.
+	entryCommand                  // A single write query.
+	entryBatch                    // The PUT and DEL queries of a batch.
+	entryConfig                   // The cluster's new members.
+)
+
+// Entry is a single entry of the Raft log.
+type Entry struct {
+	Index  uint64
+	Term   uint64
+	Kind   entryKind
+	Lines  []string          // The queries of command and batch entries.
+	Config map[string]string // The members of config entries, ids to addresses.
+}
+
+// proposal is a client waiting for its entry to be applied.
+type proposal struct {
+	term uint64
+	conn net.Conn // Where the applied entry responds, nil for none.
+	done chan error
+}
+
+// Node is a member of a Raft cluster.
+type Node struct {
+	mu      sync.Mutex
+	applyMu sync.Mutex // Held while applying entries or restoring a snapshot.
+
+	id         string
+	addr       string // Where the node listens for Raft traffic.
+	clientAddr string // Where the node listens for clients.
+	store      *store
+	fsm        fsm
+
+	role         role
+	term         uint64
+	votedFor     string
+	leaderID     string
+	leaderClient string // The leader's client address, for redirects.
+
+	log         []Entry // The entries after the snapshot.
+	snapIndex   uint64
+	snapTerm    uint64
+	snapConfig  map[string]string
+	config      map[string]string // The latest configuration in the log.
+	configIndex uint64            // The index of the latest configuration.
+
+	commitIndex uint64
+	lastApplied uint64
+
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	inflight   map[string]bool // Whether an RPC to the peer is outstanding.
+
+	lastContact      time.Time // When the leader was last heard from.
+	electionDeadline time.Time
+
+	pending map[uint64]*proposal
+
+	wake    chan struct{} // Wakes the main loop to replicate.
+	applyCh chan struct{} // Wakes the applier.
+
+	clients   map[string]*rpcClient
+	clientsMu sync.Mutex
+	dialTLS   func() *tls.Config // Nil if Raft traffic is plain TCP.
+}
+
+// -------Log-------------------------------------------------------------------
+
+func (n *Node) lastIndex() uint64 {
+	return n.snapIndex + uint64(len(n.log))
+}
+
+// termAt returns the term of the entry at index, 0 if it is compacted or
+// beyond the log.
+func (n *Node) termAt(index uint64) uint64 {
+	if index == n.snapIndex {
+		return n.snapTerm
+	}
+	if index < n.snapIndex || index > n.lastIndex() {
+		return 0
+	}
+	return n.log[index-n.snapIndex-1].Term
+}
+
+// entriesFrom returns up to max entries starting at index.
+func (n *Node) entriesFrom(index uint64, max int) []Entry {
+	if index <= n.snapIndex || index > n.lastIndex() {
+		return nil
+	}
+	entries := n.log[index-n.snapIndex-1:]
+	return append([]Entry(nil), entries[:min(len(entries), max)]...)
+}
+
+// appendLocked appends e to the log with the next index in the current term.
+func (n *Node) appendLocked(e Entry) (Entry, error) {
+	e.Index = n.lastIndex() + 1
+	e.Term = n.term
+	if err := n.store.appendLog(e); err != nil {
+		return e, err
+	}
+
+	n.log = append(n.log, e)
+	if e.Kind == entryConfig {
+		n.config, n.configIndex = e.Config, e.Index
+	}
+	return e, nil
+}
+
+// truncateLocked removes the entries from index on.
+func (n *Node) truncateLocked(index uint64) error {
+	n.log = n.log[:index-n.snapIndex-1]
+	for i, p := range n.pending {
+		if i >= index {
+			p.done <- errors.New("write was overwritten by a new leader")
+			delete(n.pending, i)
+		}
+	}
+	n.reloadConfigLocked()
+	return n.store.rewriteLog(n.log)
+}
+
+// reloadConfigLocked finds the latest configuration in the log.
+func (n *Node) reloadConfigLocked() {
+	n.config, n.configIndex = n.snapConfig, n.snapIndex
+	for i := len(n.log) - 1; i >= 0; i-- {
+		if n.log[i].Kind == entryConfig {
+			n.config, n.configIndex = n.log[i].Config, n.log[i].Index
+			return
+		}
+	}
+}
+
+// configAt returns the configuration in effect at index.
+func (n *Node) configAt(index uint64) map[string]string {
+	for i := int(index-n.snapIndex) - 1; i >= 0; i-- {
+		if n.log[i].Kind == entryConfig {
+			return n.log[i].Config
+		}
+	}
+	return n.snapConfig
+}
+
+func (n *Node) inConfigLocked() bool {
+	_, ok := n.config[n.id]
+	return ok
+}
+
+func (n *Node) quorum() int {
+	return len(n.config)/2 + 1
+}
+
+// -------Roles-----------------------------------------------------------------
+
+func (n *Node) persistLocked() error {
+	return n.store.saveState(hardState{Term: n.term, VotedFor: n.votedFor})
+}
+
+func (n *Node) resetElectionLocked() {
+	timeout := minElectionTimeout + rand.N(maxElectionTimeout-minElectionTimeout)
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// becomeFollowerLocked steps down to a follower of term.
+// Clients waiting on the node as a leader are told it lost leadership.
+func (n *Node) becomeFollowerLocked(term uint64) {
+	if n.role == leader {
+		for i, p := range n.pending {
+			p.done <- errors.New("leadership lost, the write may or may not be applied")
+			delete(n.pending, i)
+		}
+	}
+
+	n.role = follower
+	if n.leaderID == n.id {
+		n.leaderID = ""
+		n.leaderClient = ""
+	}
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leaderID = ""
+		n.leaderClient = ""
+		if err := n.persistLocked(); err != nil {
+			fmt.Println("[ERROR] raft state:", err)
+		}
+	}
+	n.resetElectionLocked()
+}
+
+func (n *Node) startElectionLocked() {
+	n.role = candidate
+	n.term++
+	n.votedFor = n.id
+	n.leaderID = ""
+	n.leaderClient = ""
+	if err := n.persistLocked(); err != nil {
+		fmt.Println("[ERROR] raft state:", err)
+		return
+	}
+	n.resetElectionLocked()
+	fmt.Println("raft: starting election for term", n.term)
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeaderLocked()
+		return
+	}
+
+	args := &VoteArgs{
+		Term:      n.term,
+		Candidate: n.id,
+		LastIndex: n.lastIndex(),
+		LastTerm:  n.termAt(n.lastIndex()),
+	}
+	for id, addr := range n.config {
+		if id == n.id {
+			continue
+		}
+		go func() {
+			reply := &VoteReply{}
+			if err := n.call(addr, "RequestVote", args, reply, rpcTimeout); err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			if reply.Term > n.term {
+				n.becomeFollowerLocked(reply.Term)
+				return
+			}
+			if n.role != candidate || n.term != args.Term || !reply.Granted {
+				return
+			}
+			if votes++; votes >= n.quorum() {
+				n.becomeLeaderLocked()
+			}
+		}()
+	}
+}
+
+func (n *Node) becomeLeaderLocked() {
+	fmt.Println("raft: elected leader for term", n.term)
+
+	n.role = leader
+	n.leaderID = n.id
+	n.leaderClient = n.clientAddr
+	n.nextIndex = map[string]uint64{}
+	n.matchIndex = map[string]uint64{}
+	n.inflight = map[string]bool{}
+
+	// Committing an entry of its own term commits the entries of earlier ones.
+	if _, err := n.appendLocked(Entry{Kind: entryNoop}); err != nil {
+		fmt.Println("[ERROR] raft log:", err)
+		n.becomeFollowerLocked(n.term)
+		return
+	}
+	n.advanceCommitLocked()
+	n.broadcastLocked()
+}
+
+// -------Main Loop-------------------------------------------------------------
+
+// run sends heartbeats as the leader and starts elections as a follower whose
+// leader went quiet. Nodes that are not members of the configuration never
+// start elections, they wait to be added by the leader.
+func (n *Node) run() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.wake:
+		}
+
+		n.mu.Lock()
+		if n.role == leader {
+			n.broadcastLocked()
+		} else if time.Now().After(n.electionDeadline) && n.inConfigLocked() {
+			n.startElectionLocked()
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *Node) wakeUp() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// broadcastLocked sends every member without an outstanding RPC the entries
+// it is missing, or a heartbeat if it has them all.
+func (n *Node) broadcastLocked() {
+	for id, addr := range n.config {
+		if id == n.id || n.inflight[id] {
+			continue
+		}
+		if _, ok := n.nextIndex[id]; !ok {
+			n.nextIndex[id] = n.lastIndex() + 1
+		}
+
+		n.inflight[id] = true
+		if n.nextIndex[id] <= n.snapIndex {
+			go n.sendSnapshot(id, addr)
+			continue
+		}
+
+		next := n.nextIndex[id]
+		args := &AppendArgs{
+			Term:         n.term,
+			Leader:       n.id,
+			LeaderClient: n.clientAddr,
+			PrevIndex:    next - 1,
+			PrevTerm:     n.termAt(next - 1),
+			Entries:      n.entriesFrom(next, maxEntriesPerAppend),
+			LeaderCommit: n.commitIndex,
+		}
+		go n.sendAppend(id, addr, args)
+	}
+}
+
+func (n *Node) sendAppend(id, addr string, args *AppendArgs) {
+	reply := &AppendReply{}
+	err := n.call(addr, "AppendEntries", args, reply, rpcTimeout)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.inflight[id] = false
+	if err != nil {
+		return
+	}
+	if reply.Term > n.term {
+		n.becomeFollowerLocked(reply.Term)
+		return
+	}
+	if n.role != leader || n.term != args.Term {
+		return
+	}
+
+	if reply.Success {
+		match := args.PrevIndex + uint64(len(args.Entries))
+		n.matchIndex[id] = max(n.matchIndex[id], match)
+		n.nextIndex[id] = n.matchIndex[id] + 1
+		n.advanceCommitLocked()
+	} else {
+		n.nextIndex[id] = max(1, min(n.nextIndex[id]-1, reply.LastIndex+1))
+	}
+
+	if n.nextIndex[id] <= n.lastIndex() {
+		n.wakeUp()
+	}
+}
+
+func (n *Node) sendSnapshot(id, addr string) {
+	n.mu.Lock()
+	term := n.term
+	n.mu.Unlock()
+
+	snap, err := n.store.loadSnapshot()
+	if err != nil || snap == nil {
+		fmt.Println("[ERROR] raft snapshot:", err)
+		n.mu.Lock()
+		n.inflight[id] = false
+		n.mu.Unlock()
+		return
+	}
+
+	args := &SnapshotArgs{
+		Term:         term,
+		Leader:       n.id,
+		LeaderClient: n.clientAddr,
+		Snapshot:     *snap,
+	}
+	reply := &SnapshotReply{}
+	err = n.call(addr, "InstallSnapshot", args, reply, snapshotTimeout)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.inflight[id] = false
+	if err != nil {
+		return
+	}
+	if reply.Term > n.term {
+		n.becomeFollowerLocked(reply.Term)
+		return
+	}
+	if n.role != leader || n.term != term {
+		return
+	}
+	n.matchIndex[id] = max(n.matchIndex[id], snap.Index)
+	n.nextIndex[id] = n.matchIndex[id] + 1
+	n.wakeUp()
+}
+
+// advanceCommitLocked commits the latest entry of the current term a majority
+// of the configuration has.
+func (n *Node) advanceCommitLocked() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.termAt(index) != n.term {
+			break
+		}
+
+		count := 0
+		for id := range n.config {
+			if id == n.id || n.matchIndex[id] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = index
+			n.signalApply()
+			break
+		}
+	}
+}
+
+// -------Applying--------------------------------------------------------------
+
+func (n *Node) signalApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// applier applies committed entries in log order and snapshots the tables
+// every globals.SnapshotEvery entries.
+func (n *Node) applier() {
+	for range n.applyCh {
+		n.applyMu.Lock()
+		n.applyCommitted()
+		n.applyMu.Unlock()
+	}
+}
+
+// applyCommitted applies the entries up to the commit index.
+// A leader that is no longer a member steps down once its removal is applied.
+// Must be called with applyMu held.
+func (n *Node) applyCommitted() {
+	for {
+		n.mu.Lock()
+		if n.lastApplied >= n.commitIndex {
+			n.mu.Unlock()
+			return
+		}
+		entries := n.entriesFrom(n.lastApplied+1, int(n.commitIndex-n.lastApplied))
+		proposals := map[uint64]*proposal{}
+		for _, e := range entries {
+			if p, ok := n.pending[e.Index]; ok && p.term == e.Term {
+				proposals[e.Index] = p
+				delete(n.pending, e.Index)
+			}
+		}
+		n.mu.Unlock()
+
+		for _, e := range entries {
+			p := proposals[e.Index]
+			var conn net.Conn
+			if p != nil {
+				conn = p.conn
+			}
+			n.fsm.apply(e, conn)
+			if p != nil {
+				p.done <- nil
+			}
+		}
+
+		n.mu.Lock()
+		n.lastApplied = entries[len(entries)-1].Index
+		if n.role == leader && !n.inConfigLocked() && n.lastApplied >= n.configIndex {
+			fmt.Println("raft: removed from the cluster, stepping down")
+			n.becomeFollowerLocked(n.term)
+		}
+		compact := n.lastApplied-n.snapIndex >= uint64(snapshotEvery())
+		n.mu.Unlock()
+
+		if compact {
+			if err := n.takeSnapshot(); err != nil {
+				fmt.Println("[ERROR] raft snapshot:", err)
+			}
+		}
+	}
+}
+
+// takeSnapshot snapshots the tables at the last applied entry and removes the
+// entries it includes from the log. Must be called with applyMu held.
+func (n *Node) takeSnapshot() error {
+	data, err := n.fsm.snapshot()
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	index := n.lastApplied
+	snap := &snapshotFile{
+		Index:  index,
+		Term:   n.termAt(index),
+		Config: n.configAt(index),
+		Data:   data,
+	}
+	if err := n.store.saveSnapshot(snap); err != nil {
+		return err
+	}
+
+	n.log = append([]Entry(nil), n.log[index-n.snapIndex:]...)
+	n.snapIndex, n.snapTerm, n.snapConfig = snap.Index, snap.Term, snap.Config
+	fmt.Println("raft: snapshot taken at", index)
+	return n.store.rewriteLog(n.log)
+}
+
+// installSnapshot replaces the tables and the log up to snap.Index with a
+// snapshot from the leader.
+func (n *Node) installSnapshot(snap *snapshotFile) error {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	stale := snap.Index <= n.lastApplied
+	n.mu.Unlock()
+	if stale {
+		return nil
+	}
+
+	if err := n.fsm.restore(snap.Data); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.store.saveSnapshot(snap); err != nil {
+		return err
+	}
+
+	// Entries after the snapshot are kept if the log agrees with it.
+	if snap.Index < n.lastIndex() && n.termAt(snap.Index) == snap.Term {
+		n.log = append([]Entry(nil), n.log[snap.Index-n.snapIndex:]...)
+	} else {
+		n.log = nil
+	}
+	n.snapIndex, n.snapTerm, n.snapConfig = snap.Index, snap.Term, snap.Config
+	n.reloadConfigLocked()
+	n.commitIndex = max(n.commitIndex, snap.Index)
+	n.lastApplied = snap.Index
+	fmt.Println("raft: installed snapshot at", snap.Index)
+	return n.store.rewriteLog(n.log)
+}
+
+// -------Clients---------------------------------------------------------------
+
+// propose appends e to the log as the leader and waits for it to be applied.
+func (n *Node) propose(e Entry, conn net.Conn) error {
+	n.mu.Lock()
+	p, index, err := n.proposeLocked(e, conn)
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return n.await(p, index)
+}
+
+// changeConfig proposes the configuration fn makes of a copy of the current
+// one and waits for it to be applied.
+func (n *Node) changeConfig(fn func(config map[string]string) error) error {
+	n.mu.Lock()
+	config := maps.Clone(n.config)
+	err := fn(config)
+	var p *proposal
+	var index uint64
+	if err == nil {
+		p, index, err = n.proposeLocked(Entry{Kind: entryConfig, Config: config}, nil)
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return n.await(p, index)
+}
+
+func (n *Node) proposeLocked(e Entry, conn net.Conn) (*proposal, uint64, error) {
+	if n.role != leader {
+		return nil, 0, n.notLeaderLocked()
+	}
+	if e.Kind == entryConfig && n.configIndex > n.commitIndex {
+		return nil, 0, errors.New("a membership change is already in progress")
+	}
+
+	e, err := n.appendLocked(e)
+	if err != nil {
+		return nil, 0, err
+	}
+	p := &proposal{term: e.Term, conn: conn, done: make(chan error, 1)}
+	n.pending[e.Index] = p
+	n.advanceCommitLocked() // A single node cluster commits right away.
+	n.wakeUp()
+	return p, e.Index, nil
+}
+
+// await waits for the proposal of the entry at index to be applied.
+// A proposal still pending when the wait times out is abandoned, so its entry
+// is applied without responding. One already taken to be applied, or ended,
+// has its result sent to done shortly and is waited for, as it may respond.
+func (n *Node) await(p *proposal, index uint64) error {
+	select {
+	case err := <-p.done:
+		return err
+	case <-time.After(proposeTimeout):
+		n.mu.Lock()
+		abandoned := n.pending[index] == p
+		if abandoned {
+			delete(n.pending, index)
+		}
+		n.mu.Unlock()
+
+		if !abandoned {
+			return <-p.done
+		}
+		return response.Errorf(response.CodeUnavailable, "write not committed in time, it may still be applied")
+	}
+}
+
+// notLeaderLocked returns the error redirecting a client to the leader.
+func (n *Node) notLeaderLocked() error {
+	if n.leaderClient == "" {
+		return fmt.Errorf("%w, no leader is elected", ErrNotLeader)
+	}
+	return fmt.Errorf("%w, leader is at %s", ErrNotLeader, n.leaderClient)
+}
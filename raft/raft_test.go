@@ -0,0 +1,297 @@
+package raft
+
+import (
+	"slices"
+	"testing"
+
+	"orchiddb/paths"
+	"orchiddb/vfs"
+)
+
+// members is the configuration of the test nodes.
+var members = map[string]string{"n1": "127.0.0.1:7001", "n2": "127.0.0.1:7002", "n3": "127.0.0.1:7003"}
+
+// newTestNode returns the node n1 of members in term, with a log of entries
+// of the given terms stored on a MemFS.
+func newTestNode(t *testing.T, term uint64, terms ...uint64) *Node {
+	t.Helper()
+
+	oldPath := paths.DatabasePath
+	paths.DatabasePath = "/db"
+	t.Cleanup(func() { paths.DatabasePath = oldPath })
+
+	st, err := openStore(vfs.NewMemFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &Node{
+		id:         "n1",
+		store:      st,
+		term:       term,
+		snapConfig: members,
+		pending:    map[uint64]*proposal{},
+		wake:       make(chan struct{}, 1),
+		applyCh:    make(chan struct{}, 1),
+		nextIndex:  map[string]uint64{},
+		matchIndex: map[string]uint64{},
+	}
+	n.reloadConfigLocked()
+
+	for i, term := range terms {
+		n.log = append(n.log, Entry{Index: uint64(i + 1), Term: term, Kind: entryNoop})
+	}
+	if err := st.rewriteLog(n.log); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// entries returns noop entries of the given terms, from index on.
+func entries(index uint64, terms ...uint64) []Entry {
+	var es []Entry
+	for i, term := range terms {
+		es = append(es, Entry{Index: index + uint64(i), Term: term, Kind: entryNoop})
+	}
+	return es
+}
+
+// logTerms returns the terms of the entries in n's log, and checks that the
+// stored log holds the same entries.
+func logTerms(t *testing.T, n *Node) []uint64 {
+	t.Helper()
+
+	var terms []uint64
+	for i, e := range n.log {
+		if e.Index != n.snapIndex+uint64(i)+1 {
+			t.Fatalf("entry %d of the log has index %d", i, e.Index)
+		}
+		terms = append(terms, e.Term)
+	}
+
+	stored, err := n.store.loadLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var storedTerms []uint64
+	for _, e := range stored {
+		storedTerms = append(storedTerms, e.Term)
+	}
+	if !slices.Equal(storedTerms, terms) {
+		t.Fatalf("stored log has terms %v, the log in memory %v", storedTerms, terms)
+	}
+	return terms
+}
+
+func appendEntries(t *testing.T, n *Node, args *AppendArgs) *AppendReply {
+	t.Helper()
+
+	args.Leader = "n2"
+	reply := &AppendReply{}
+	if err := (&RPC{n: n}).AppendEntries(args, reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// -------Log-------------------------------------------------------------------
+
+func TestAppendEntriesAppends(t *testing.T) {
+	n := newTestNode(t, 1, 1)
+
+	reply := appendEntries(t, n, &AppendArgs{Term: 1, PrevIndex: 1, PrevTerm: 1, Entries: entries(2, 1, 1)})
+	if !reply.Success || reply.LastIndex != 3 {
+		t.Fatalf("reply %+v, want success with last index 3", reply)
+	}
+	if got := logTerms(t, n); !slices.Equal(got, []uint64{1, 1, 1}) {
+		t.Errorf("log terms %v, want [1 1 1]", got)
+	}
+}
+
+func TestAppendEntriesTruncatesConflicts(t *testing.T) {
+	n := newTestNode(t, 1, 1, 1, 1)
+	overwritten := &proposal{term: 1, done: make(chan error, 1)}
+	n.pending[3] = overwritten
+
+	reply := appendEntries(t, n, &AppendArgs{Term: 2, PrevIndex: 1, PrevTerm: 1, Entries: entries(2, 2)})
+	if !reply.Success || reply.LastIndex != 2 {
+		t.Fatalf("reply %+v, want success with last index 2", reply)
+	}
+	if got := logTerms(t, n); !slices.Equal(got, []uint64{1, 2}) {
+		t.Errorf("log terms %v, want [1 2]", got)
+	}
+
+	select {
+	case err := <-overwritten.done:
+		if err == nil {
+			t.Error("the proposal of an overwritten entry was told it is applied")
+		}
+	default:
+		t.Error("the proposal of an overwritten entry was not told")
+	}
+	if len(n.pending) != 0 {
+		t.Errorf("%d proposals still pending", len(n.pending))
+	}
+}
+
+func TestAppendEntriesKeepsMatchingEntries(t *testing.T) {
+	n := newTestNode(t, 1, 1, 1, 1)
+
+	// A delayed append of entries the node has must not remove the later ones.
+	reply := appendEntries(t, n, &AppendArgs{Term: 1, PrevIndex: 0, PrevTerm: 0, Entries: entries(1, 1)})
+	if !reply.Success || reply.LastIndex != 3 {
+		t.Fatalf("reply %+v, want success with last index 3", reply)
+	}
+	if got := logTerms(t, n); !slices.Equal(got, []uint64{1, 1, 1}) {
+		t.Errorf("log terms %v, want [1 1 1]", got)
+	}
+}
+
+func TestAppendEntriesRejectsGaps(t *testing.T) {
+	tests := []struct {
+		name          string
+		prev, prevT   uint64
+		wantLastIndex uint64
+	}{
+		{"beyond the log", 5, 1, 2},
+		{"term mismatch", 2, 2, 1},
+	}
+	for _, tt := range tests {
+		n := newTestNode(t, 2, 1, 1)
+
+		reply := appendEntries(t, n, &AppendArgs{Term: 2, PrevIndex: tt.prev, PrevTerm: tt.prevT, Entries: entries(tt.prev+1, 2)})
+		if reply.Success || reply.LastIndex != tt.wantLastIndex {
+			t.Errorf("%s: reply %+v, want failure with last index %d", tt.name, reply, tt.wantLastIndex)
+		}
+		if got := logTerms(t, n); !slices.Equal(got, []uint64{1, 1}) {
+			t.Errorf("%s: log terms %v, want [1 1]", tt.name, got)
+		}
+	}
+}
+
+func TestAppendEntriesRejectsOldTerms(t *testing.T) {
+	n := newTestNode(t, 3, 1)
+
+	reply := appendEntries(t, n, &AppendArgs{Term: 2, PrevIndex: 1, PrevTerm: 1, Entries: entries(2, 2)})
+	if reply.Success || reply.Term != 3 {
+		t.Fatalf("reply %+v, want failure in term 3", reply)
+	}
+	if got := logTerms(t, n); !slices.Equal(got, []uint64{1}) {
+		t.Errorf("log terms %v, want [1]", got)
+	}
+}
+
+func TestAppendEntriesSkipsSnapshottedEntries(t *testing.T) {
+	n := newTestNode(t, 1)
+	n.snapIndex, n.snapTerm = 2, 1
+	n.commitIndex, n.lastApplied = 2, 2
+
+	reply := appendEntries(t, n, &AppendArgs{Term: 1, PrevIndex: 0, PrevTerm: 0, Entries: entries(1, 1, 1, 1, 1, 1)})
+	if !reply.Success || reply.LastIndex != 5 {
+		t.Fatalf("reply %+v, want success with last index 5", reply)
+	}
+	if got := logTerms(t, n); !slices.Equal(got, []uint64{1, 1, 1}) {
+		t.Errorf("log terms after the snapshot %v, want [1 1 1]", got)
+	}
+}
+
+// -------Commit----------------------------------------------------------------
+
+func TestAppendEntriesCommitsUpToTheNewEntries(t *testing.T) {
+	n := newTestNode(t, 1, 1, 1, 1)
+
+	// The node's entries after the leader's are not known to be the leader's.
+	appendEntries(t, n, &AppendArgs{Term: 1, PrevIndex: 1, PrevTerm: 1, Entries: entries(2, 1), LeaderCommit: 5})
+	if n.commitIndex != 2 {
+		t.Errorf("commit index %d, want 2", n.commitIndex)
+	}
+
+	appendEntries(t, n, &AppendArgs{Term: 1, PrevIndex: 3, PrevTerm: 1, LeaderCommit: 3})
+	if n.commitIndex != 3 {
+		t.Errorf("commit index %d, want 3", n.commitIndex)
+	}
+
+	// A leader's commit index never moves it back.
+	appendEntries(t, n, &AppendArgs{Term: 1, PrevIndex: 3, PrevTerm: 1, LeaderCommit: 1})
+	if n.commitIndex != 3 {
+		t.Errorf("commit index %d after an older commit index, want 3", n.commitIndex)
+	}
+}
+
+func TestAdvanceCommitNeedsAMajority(t *testing.T) {
+	n := newTestNode(t, 1, 1, 1)
+	n.role = leader
+
+	n.advanceCommitLocked()
+	if n.commitIndex != 0 {
+		t.Fatalf("commit index %d with no follower, want 0", n.commitIndex)
+	}
+
+	n.matchIndex["n3"] = 1
+	n.advanceCommitLocked()
+	if n.commitIndex != 1 {
+		t.Fatalf("commit index %d with n3 at 1, want 1", n.commitIndex)
+	}
+
+	n.matchIndex["n2"] = 2
+	n.advanceCommitLocked()
+	if n.commitIndex != 2 {
+		t.Fatalf("commit index %d with n2 at 2, want 2", n.commitIndex)
+	}
+}
+
+func TestAdvanceCommitOnlyCountsTheCurrentTerm(t *testing.T) {
+	// A leader of term 3 with entries of term 2 that a majority has.
+	n := newTestNode(t, 3, 1, 2)
+	n.role = leader
+	n.matchIndex["n2"], n.matchIndex["n3"] = 2, 2
+
+	n.advanceCommitLocked()
+	if n.commitIndex != 0 {
+		t.Fatalf("commit index %d from entries of an older term, want 0", n.commitIndex)
+	}
+
+	// Once an entry of its own term is on a majority, the ones before it
+	// commit with it.
+	if _, err := n.appendLocked(Entry{Kind: entryNoop}); err != nil {
+		t.Fatal(err)
+	}
+	n.matchIndex["n2"] = 3
+	n.advanceCommitLocked()
+	if n.commitIndex != 3 {
+		t.Fatalf("commit index %d, want 3", n.commitIndex)
+	}
+	if got := logTerms(t, n); !slices.Equal(got, []uint64{1, 2, 3}) {
+		t.Errorf("log terms %v, want [1 2 3]", got)
+	}
+}
+
+// -------Store-----------------------------------------------------------------
+
+func TestLoadLogDropsTornRecord(t *testing.T) {
+	n := newTestNode(t, 1, 1, 1)
+
+	// Half of a third record, as if the node lost power appending it.
+	buf, err := encodeRecords(entries(3, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.store.log.WriteAt(buf[:len(buf)/2], n.store.logSize); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := n.store.loadLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 {
+		t.Fatalf("loaded %d entries, want 2", len(loaded))
+	}
+
+	// The torn record is gone, so the next append follows the last entry.
+	if err := n.store.appendLog(entries(3, 1)...); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = n.store.loadLog(); err != nil || len(loaded) != 3 {
+		t.Fatalf("loaded %d entries after an append, want 3: %v", len(loaded), err)
+	}
+}
@@ -0,0 +1,289 @@
+package raft
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Nodes talk to each other with net/rpc over TCP on their Raft address, over
+// mutual TLS if the server was given -raft-tls-ca. Plain TCP is neither
+// encrypted nor authenticated, so the Raft address must then only be reachable
+// by the other nodes.
+// -----------------------------------------------------------------------------
+
+type VoteArgs struct {
+	Term      uint64
+	Candidate string
+	LastIndex uint64
+	LastTerm  uint64
+}
+
+type VoteReply struct {
+	Term    uint64
+	Granted bool
+}
+
+type AppendArgs struct {
+	Term         uint64
+	Leader       string
+	LeaderClient string
+	PrevIndex    uint64
+	PrevTerm     uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+type AppendReply struct {
+	Term      uint64
+	Success   bool
+	LastIndex uint64 // The follower's last index, a hint for the next attempt.
+}
+
+type SnapshotArgs struct {
+	Term         uint64
+	Leader       string
+	LeaderClient string
+	Snapshot     snapshotFile
+}
+
+type SnapshotReply struct {
+	Term uint64
+}
+
+// RPC is the net/rpc service of a node.
+type RPC struct {
+	n *Node
+}
+
+// RequestVote grants the vote of the node to a candidate whose log is at least
+// as up to date, once per term.
+//
+// A node that heard from its leader within the minimum election timeout
+// ignores candidates, so a node that was removed from the cluster, or is
+// partitioned, cannot disrupt it.
+func (r *RPC) RequestVote(args *VoteArgs, reply *VoteReply) error {
+	n := r.n
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	reply.Term = n.term
+	if args.Term < n.term {
+		return nil
+	}
+	if args.Term > n.term && n.hasLeaderLocked() {
+		return nil
+	}
+	if args.Term > n.term {
+		n.becomeFollowerLocked(args.Term)
+		reply.Term = n.term
+	}
+
+	lastTerm := n.termAt(n.lastIndex())
+	upToDate := args.LastTerm > lastTerm ||
+		(args.LastTerm == lastTerm && args.LastIndex >= n.lastIndex())
+	if !upToDate || (n.votedFor != "" && n.votedFor != args.Candidate) {
+		return nil
+	}
+
+	n.votedFor = args.Candidate
+	if err := n.persistLocked(); err != nil {
+		return err
+	}
+	n.resetElectionLocked()
+	reply.Granted = true
+	return nil
+}
+
+// hasLeaderLocked returns whether the node is the leader or recently heard
+// from one.
+func (n *Node) hasLeaderLocked() bool {
+	if n.role == leader {
+		return true
+	}
+	return n.leaderID != "" && time.Since(n.lastContact) < minElectionTimeout
+}
+
+// AppendEntries appends the leader's entries to the log, replacing the entries
+// that conflict with them, and commits up to the leader's commit index.
+func (r *RPC) AppendEntries(args *AppendArgs, reply *AppendReply) error {
+	n := r.n
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	reply.Term = n.term
+	if args.Term < n.term {
+		return nil
+	}
+	n.followLocked(args.Term, args.Leader, args.LeaderClient)
+	reply.Term = n.term
+
+	// Entries up to the snapshot are committed and already applied.
+	entries := args.Entries
+	prevIndex, prevTerm := args.PrevIndex, args.PrevTerm
+	if prevIndex < n.snapIndex {
+		skip := min(uint64(len(entries)), n.snapIndex-prevIndex)
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.snapIndex, n.snapTerm
+	}
+
+	if prevIndex > n.lastIndex() {
+		reply.LastIndex = n.lastIndex()
+		return nil
+	}
+	if n.termAt(prevIndex) != prevTerm {
+		reply.LastIndex = prevIndex - 1
+		return nil
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			if err := n.truncateLocked(e.Index); err != nil {
+				return err
+			}
+		}
+
+		if err := n.store.appendLog(entries[i:]...); err != nil {
+			return err
+		}
+		n.log = append(n.log, entries[i:]...)
+		n.reloadConfigLocked()
+		break
+	}
+
+	if args.LeaderCommit > n.commitIndex {
+		last := prevIndex + uint64(len(entries))
+		n.commitIndex = max(n.commitIndex, min(args.LeaderCommit, last))
+		n.signalApply()
+	}
+
+	reply.Success = true
+	reply.LastIndex = n.lastIndex()
+	return nil
+}
+
+// InstallSnapshot replaces the node's tables with the leader's snapshot.
+func (r *RPC) InstallSnapshot(args *SnapshotArgs, reply *SnapshotReply) error {
+	n := r.n
+	n.mu.Lock()
+	reply.Term = n.term
+	if args.Term < n.term {
+		n.mu.Unlock()
+		return nil
+	}
+	n.followLocked(args.Term, args.Leader, args.LeaderClient)
+	reply.Term = n.term
+	n.mu.Unlock()
+
+	return n.installSnapshot(&args.Snapshot)
+}
+
+// followLocked follows the leader of term.
+func (n *Node) followLocked(term uint64, leader, leaderClient string) {
+	if term > n.term || n.role != follower {
+		n.becomeFollowerLocked(term)
+	}
+	n.leaderID = leader
+	n.leaderClient = leaderClient
+	n.lastContact = time.Now()
+	n.resetElectionLocked()
+}
+
+// -------Transport-------------------------------------------------------------
+
+// serve accepts the RPCs of other nodes on l.
+func (n *Node) serve(l net.Listener) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Raft", &RPC{n: n}); err != nil {
+		panic(err)
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Println("raft accept error:", err)
+			continue
+		}
+		go srv.ServeConn(conn)
+	}
+}
+
+// rpcClient is a connection to another node.
+type rpcClient struct {
+	*rpc.Client
+}
+
+var errRPCTimeout = errors.New("rpc timed out")
+
+// call calls the method of the node at addr. Failed connections are closed
+// and dialed again by the next call.
+func (n *Node) call(addr, method string, args, reply any, timeout time.Duration) error {
+	c, err := n.client(addr)
+	if err != nil {
+		return err
+	}
+
+	call := c.Go("Raft."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			n.dropClient(addr, c)
+		}
+		return call.Error
+	case <-time.After(timeout):
+		n.dropClient(addr, c)
+		return errRPCTimeout
+	}
+}
+
+// client returns the connection to addr, dialing it if there is none.
+// Dialing does not hold clientsMu, so an unreachable node does not hold up
+// the calls to the others.
+func (n *Node) client(addr string) (*rpcClient, error) {
+	n.clientsMu.Lock()
+	c, ok := n.clients[addr]
+	n.clientsMu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	var conn net.Conn
+	var err error
+	if n.dialTLS != nil {
+		dialer := &net.Dialer{Timeout: rpcTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, n.dialTLS())
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, rpcTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n.clientsMu.Lock()
+	defer n.clientsMu.Unlock()
+
+	if existing, ok := n.clients[addr]; ok {
+		conn.Close()
+		return existing, nil
+	}
+	c = &rpcClient{rpc.NewClient(conn)}
+	n.clients[addr] = c
+	return c, nil
+}
+
+func (n *Node) dropClient(addr string, c *rpcClient) {
+	n.clientsMu.Lock()
+	defer n.clientsMu.Unlock()
+
+	if n.clients[addr] == c {
+		delete(n.clients, addr)
+		c.Close()
+	}
+}
@@ -0,0 +1,242 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"orchiddb/globals"
+	"orchiddb/paths"
+	"orchiddb/vfs"
+)
+
+// -----------------------------------------------------------------------------
+// A node's durable Raft state lives in three files in the database path:
+//
+//	cluster.raftstate   the current term and vote
+//	cluster.raftlog     the log entries after the snapshot
+//	cluster.raftsnap    the latest snapshot of the tables
+//
+// The log is a sequence of records, each a length (4), a CRC32 (4) and a gob
+// encoded entry. A torn record at the end is dropped on load. The state and
+// snapshot are replaced by writing a temporary file and renaming it.
+// -----------------------------------------------------------------------------
+
+const logRecordHeaderSize = 8
+
+// hardState is the state a node must not forget across restarts.
+type hardState struct {
+	Term     uint64
+	VotedFor string
+}
+
+// snapshotFile is a snapshot of the tables with the last entry it includes.
+type snapshotFile struct {
+	Index  uint64
+	Term   uint64
+	Config map[string]string
+	Data   []byte // gob encoded []execution.TableSnapshot
+}
+
+// store reads and writes a node's durable state.
+type store struct {
+	fsys vfs.FS
+
+	log     vfs.File
+	logSize int64
+}
+
+func openStore(fsys vfs.FS) (*store, error) {
+	f, err := fsys.Open(paths.RaftPath(globals.RAFT_LOG_SUFFIX), true)
+	if err != nil {
+		return nil, err
+	}
+	return &store{fsys: fsys, log: f}, nil
+}
+
+// isEmpty returns whether the node has no durable state yet.
+func (s *store) isEmpty() bool {
+	return !s.fsys.Exists(paths.RaftPath(globals.RAFT_STATE_SUFFIX)) &&
+		!s.fsys.Exists(paths.RaftPath(globals.RAFT_SNAPSHOT_SUFFIX)) &&
+		s.logSize == 0
+}
+
+func (s *store) loadState() (hardState, error) {
+	var hs hardState
+	err := s.readGob(paths.RaftPath(globals.RAFT_STATE_SUFFIX), &hs)
+	if errors.Is(err, vfs.ErrNotExist) {
+		return hs, nil
+	}
+	return hs, err
+}
+
+func (s *store) saveState(hs hardState) error {
+	return s.writeGob(paths.RaftPath(globals.RAFT_STATE_SUFFIX), hs)
+}
+
+// loadSnapshot returns the latest snapshot, or nil if there is none.
+func (s *store) loadSnapshot() (*snapshotFile, error) {
+	snap := &snapshotFile{}
+	err := s.readGob(paths.RaftPath(globals.RAFT_SNAPSHOT_SUFFIX), snap)
+	if errors.Is(err, vfs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func (s *store) saveSnapshot(snap *snapshotFile) error {
+	return s.writeGob(paths.RaftPath(globals.RAFT_SNAPSHOT_SUFFIX), snap)
+}
+
+// loadLog reads the entries of the log, dropping a torn record at its end.
+func (s *store) loadLog() ([]Entry, error) {
+	size, err := s.log.Size()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if _, err := s.log.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	var off int64
+	for off+logRecordHeaderSize <= size {
+		n := int64(binary.LittleEndian.Uint32(buf[off:]))
+		sum := binary.LittleEndian.Uint32(buf[off+4:])
+		body := buf[off+logRecordHeaderSize:]
+		if n > int64(len(body)) || crc32.ChecksumIEEE(body[:n]) != sum {
+			break
+		}
+
+		var e Entry
+		if err := gob.NewDecoder(bytes.NewReader(body[:n])).Decode(&e); err != nil {
+			return nil, fmt.Errorf("raft log entry at %d: %w", off, err)
+		}
+		entries = append(entries, e)
+		off += logRecordHeaderSize + n
+	}
+
+	s.logSize = off
+	if off < size {
+		fmt.Println("dropping torn raft log record at", off)
+		return entries, s.rewriteLog(entries)
+	}
+	return entries, nil
+}
+
+// appendLog appends and syncs entries to the log.
+func (s *store) appendLog(entries ...Entry) error {
+	buf, err := encodeRecords(entries)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.WriteAt(buf, s.logSize); err != nil {
+		return err
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	s.logSize += int64(len(buf))
+	return nil
+}
+
+// rewriteLog replaces the log with entries.
+func (s *store) rewriteLog(entries []Entry) error {
+	buf, err := encodeRecords(entries)
+	if err != nil {
+		return err
+	}
+
+	path := paths.RaftPath(globals.RAFT_LOG_SUFFIX)
+	if err := s.replace(path, buf); err != nil {
+		return err
+	}
+	if err := s.log.Close(); err != nil {
+		return err
+	}
+	if s.log, err = s.fsys.Open(path, false); err != nil {
+		return err
+	}
+	s.logSize = int64(len(buf))
+	return nil
+}
+
+func (s *store) close() error {
+	return s.log.Close()
+}
+
+func encodeRecords(entries []Entry) ([]byte, error) {
+	var buf []byte
+	for _, e := range entries {
+		var body bytes.Buffer
+		if err := gob.NewEncoder(&body).Encode(e); err != nil {
+			return nil, err
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(body.Len()))
+		buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(body.Bytes()))
+		buf = append(buf, body.Bytes()...)
+	}
+	return buf, nil
+}
+
+// -------Files-----------------------------------------------------------------
+
+func (s *store) readGob(path string, v any) error {
+	f, err := s.fsys.Open(path, false)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size, err := f.Size()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(buf)).Decode(v)
+}
+
+func (s *store) writeGob(path string, v any) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return s.replace(path, buf.Bytes())
+}
+
+// replace atomically replaces the file at path with contents.
+func (s *store) replace(path string, contents []byte) error {
+	tmp := path + globals.TMP_SUFFIX
+	if s.fsys.Exists(tmp) {
+		if err := s.fsys.Remove(tmp); err != nil {
+			return err
+		}
+	}
+
+	f, err := s.fsys.Open(tmp, true)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(contents, 0); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return s.fsys.Rename(tmp, path)
+}
@@ -0,0 +1,271 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"orchiddb/client"
+	"orchiddb/execution"
+	"orchiddb/globals"
+	"orchiddb/parser"
+	"orchiddb/response"
+	"orchiddb/storage"
+)
+
+// -----------------------------------------------------------------------------
+// Following a primary.
+//
+// A replica connects to its primary and sends SYNC(). The primary answers with
+// every table, each as a MAKE line followed by a PUT line for each of its
+// items, then a SYNCED line, then a line for every committed change. See
+// execution/sync.go for the format.
+//
+// The snapshot of a table is loaded into a staging table in batched commits,
+// which replaces the replica's table of the same name once every item is read,
+// so the table serves reads until then. Once SYNCED is read the replica's
+// tables the primary does not have are dropped. If the
+// connection is lost the replica reconnects and bootstraps again from a new
+// snapshot, so it never has to know where it left off.
+// -----------------------------------------------------------------------------
+
+// retryDelay is how long a replica waits before reconnecting to its primary.
+const retryDelay = time.Second
+
+// TLSConfig returns the TLS configuration of the connection to the primary
+// given by the replica options, nil for plain TCP.
+func TLSConfig() (*tls.Config, error) {
+	if !globals.ReplicaTLS && globals.ReplicaTLSCA == "" && globals.ReplicaTLSCert == "" {
+		return nil, nil
+	}
+	return client.TLSConfig(globals.ReplicaTLSCA, globals.ReplicaTLSCert, globals.ReplicaTLSKey)
+}
+
+// Follow replicates the primary at addr until the process exits, over TLS if
+// tlsCfg is set.
+func Follow(addr string, tlsCfg *tls.Config) {
+	for {
+		err := follow(addr, tlsCfg)
+		fmt.Println("replication from", addr, "stopped:", err)
+		time.Sleep(retryDelay)
+	}
+}
+
+// follow syncs from the primary at addr once, until the connection ends.
+func follow(addr string, tlsCfg *tls.Config) error {
+	var conn net.Conn
+	var err error
+	if tlsCfg != nil {
+		conn, err = tls.Dial("tcp", addr, tlsCfg)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	if globals.ReplicaAuth != "" {
+		if err := authenticate(conn, r); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(conn, "SYNC()\n"); err != nil {
+		return err
+	}
+	fmt.Println("replicating from", addr)
+
+	s := &stream{synced: map[string]bool{}}
+	defer s.abort()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "SYNCED" {
+			dropMissing(s.synced)
+			s.synced = nil
+			fmt.Println("replica is in sync with", addr)
+			continue
+		}
+
+		if err := s.apply(line); err != nil {
+			return err
+		}
+	}
+}
+
+// stream is the state of a sync from the primary.
+type stream struct {
+	synced map[string]bool        // Tables in the snapshot, until SYNCED.
+	load   *execution.TableLoader // The table whose snapshot is being read.
+	left   int                    // The items of the snapshot not yet read.
+}
+
+// abort drops the table being loaded, if any.
+func (s *stream) abort() {
+	if s.load != nil {
+		s.load.Abort()
+		s.load = nil
+	}
+}
+
+// authenticate authenticates to the primary as the user of
+// globals.ReplicaAuth.
+func authenticate(conn net.Conn, r *bufio.Reader) error {
+	user, password, ok := strings.Cut(globals.ReplicaAuth, ":")
+	if !ok {
+		return errors.New("-replica-auth must be user:password")
+	}
+
+	query := fmt.Sprintf("AUTH(%s, %s)\n", parser.Quote(user), parser.Quote(password))
+	if _, err := io.WriteString(conn, query); err != nil {
+		return err
+	}
+	if _, err := response.Read(r); err != nil {
+		return fmt.Errorf("primary: %w", err)
+	}
+	return nil
+}
+
+// apply applies a single line from the primary.
+func (s *stream) apply(line string) error {
+	if e, ok := response.ParseError(line); ok {
+		return fmt.Errorf("primary: %w", e)
+	}
+
+	verb, rest, _ := strings.Cut(line, " ")
+	table, args, _ := strings.Cut(rest, " ")
+	if table == "" {
+		return fmt.Errorf("malformed line from primary: %q", line)
+	}
+	if s.load != nil && (verb != parser.PUT || table != s.load.Name()) {
+		return fmt.Errorf("snapshot of %s ended %d items early: %q", s.load.Name(), s.left, line)
+	}
+
+	switch verb {
+	case parser.MAKE:
+		if err := s.makeTable(table, strings.Fields(args)); err != nil {
+			return fmt.Errorf("%w: %q", err, line)
+		}
+		if s.synced != nil {
+			s.synced[table] = true
+		}
+		return nil
+	case parser.DROP:
+		dropTable(table)
+		return nil
+	case parser.PUT:
+		key, value, err := unquoteArgs(args, 2)
+		if err != nil {
+			return fmt.Errorf("malformed line from primary: %q: %w", line, err)
+		}
+		if s.load != nil {
+			return s.loadItem([]byte(key), []byte(value))
+		}
+		op := storage.BatchOp{Key: []byte(key), Value: []byte(value)}
+		return execution.Replicate(table, op)
+	case parser.DEL:
+		key, _, err := unquoteArgs(args, 1)
+		if err != nil {
+			return fmt.Errorf("malformed line from primary: %q: %w", line, err)
+		}
+		op := storage.BatchOp{Key: []byte(key), Del: true}
+		return execution.Replicate(table, op)
+	default:
+		return fmt.Errorf("unknown line from primary: %q", line)
+	}
+}
+
+// makeTable replaces the replica's table with the one of a MAKE line, whose
+// fields are the table's kind, the number of versions it keeps, 0 for none,
+// and the number of items of its snapshot. Primaries that do not send the
+// number of items make an empty table, which the PUT lines that follow fill.
+func (s *stream) makeTable(table string, fields []string) error {
+	if len(fields) != 2 && len(fields) != 3 {
+		return errors.New("malformed line from primary")
+	}
+	versions, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid versions %s for table %s", fields[1], table)
+	}
+	if len(fields) == 2 {
+		dropTable(table)
+		return execution.MakeTable(table, fields[0], versions)
+	}
+
+	items, err := strconv.Atoi(fields[2])
+	if err != nil || items < 0 {
+		return fmt.Errorf("invalid number of items %s for table %s", fields[2], table)
+	}
+	load, err := execution.LoadTable(table, fields[0], versions)
+	if err != nil {
+		return err
+	}
+	if items == 0 {
+		return load.Finish()
+	}
+	s.load, s.left = load, items
+	return nil
+}
+
+// loadItem adds an item to the snapshot being loaded, and replaces the table
+// with it once it has every item.
+func (s *stream) loadItem(key, value []byte) error {
+	if err := s.load.Put(key, value); err != nil {
+		return err
+	}
+	s.left--
+	if s.left > 0 {
+		return nil
+	}
+
+	load := s.load
+	s.load = nil
+	return load.Finish()
+}
+
+func dropTable(table string) {
+	if err := execution.DropTable(table); err != nil && !errors.Is(err, execution.ErrNoTable) {
+		fmt.Println("replica:", err)
+	}
+}
+
+// dropMissing drops the replica's tables that are not in the primary's
+// snapshot.
+func dropMissing(snapshot map[string]bool) {
+	for _, table := range execution.TableNames() {
+		if !snapshot[table] {
+			dropTable(table)
+		}
+	}
+}
+
+// unquoteArgs reads n space separated quoted strings from args.
+func unquoteArgs(args string, n int) (string, string, error) {
+	var out [2]string
+	for i := range n {
+		quoted, err := strconv.QuotedPrefix(args)
+		if err != nil {
+			return "", "", err
+		}
+		if out[i], err = strconv.Unquote(quoted); err != nil {
+			return "", "", err
+		}
+		args = strings.TrimPrefix(args[len(quoted):], " ")
+	}
+	if args != "" {
+		return "", "", errors.New("unexpected trailing arguments")
+	}
+	return out[0], out[1], nil
+}
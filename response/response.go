@@ -0,0 +1,223 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Every command of the query protocol responds with one of:
+//
+//	OK                      the command succeeded, e.g. a committed PUT
+//	VALUE <len>\n<value>    the command's value of len bytes, then a newline
+//	NIL                     the command has no value, e.g. a GET of a missing key
+//	ERR <code> <message>    the command failed
+//
+// each ended by a newline. Lists, e.g. of SCAN, are lines followed by an END
+// line, or a single ERR line. The code of an error is a single upper case word
+// clients can act on, the message is for humans.
+//
+// A query line can start with a request ID, as in "#42 GET(t, k)", and the
+// first line of its response then starts with the same "#42 ". Responses are
+// written whole, so a client that pipelines queries with IDs can match every
+// response to its query, whatever order they complete in.
+// -----------------------------------------------------------------------------
+
+// Code classifies an error response.
+type Code string
+
+const (
+	CodeParse       Code = "PARSE"       // The query cannot be parsed.
+	CodeInvalid     Code = "INVALID"     // An argument or a stored value is invalid.
+	CodeNoTable     Code = "NOTABLE"     // The table does not exist.
+	CodeUnsupported Code = "UNSUPPORTED" // Not supported by the table kind or server mode.
+	CodeReadOnly    Code = "READONLY"    // A write to a replica.
+	CodeNotLeader   Code = "NOTLEADER"   // A write to a cluster node that is not the leader.
+	CodeNoAuth      Code = "NOAUTH"      // Not authenticated, or invalid credentials.
+	CodeDenied      Code = "DENIED"      // The user lacks the rights for the command.
+	CodeUnavailable Code = "UNAVAILABLE" // A server the command needs did not answer in time.
+	CodeLimit       Code = "LIMIT"       // A limit of the server, e.g. on the request size, was hit.
+	CodeFailed      Code = "FAILED"      // Any other failure.
+)
+
+// Error is an error with the code it responds with.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Errorf returns an error with code and a message formatted like fmt.Errorf.
+func Errorf(code Code, format string, args ...any) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// CodeOf returns the code of the first Error in err's chain, CodeFailed if
+// there is none.
+func CodeOf(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeFailed
+}
+
+// -------Writing---------------------------------------------------------------
+
+func WriteOK(w io.Writer) error {
+	_, err := io.WriteString(w, "OK\n")
+	return err
+}
+
+// WriteValue writes value in a single write.
+func WriteValue(w io.Writer, value []byte) error {
+	resp := fmt.Appendf(nil, "VALUE %d\n", len(value))
+	resp = append(resp, value...)
+	_, err := w.Write(append(resp, '\n'))
+	return err
+}
+
+func WriteNil(w io.Writer) error {
+	_, err := io.WriteString(w, "NIL\n")
+	return err
+}
+
+// WriteError writes err with its code. Line breaks in its message are replaced
+// by spaces.
+func WriteError(w io.Writer, err error) error {
+	_, werr := io.WriteString(w, ErrorLine(err)+"\n")
+	return werr
+}
+
+// ErrorLine returns the ERR line of err without its newline.
+func ErrorLine(err error) string {
+	msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+	return fmt.Sprintf("ERR %s %s", CodeOf(err), msg)
+}
+
+// -------Request IDs-----------------------------------------------------------
+
+// MaxIDLen is the longest request ID, in bytes.
+const MaxIDLen = 64
+
+// CutID cuts the request ID off a query line. id is empty if the line has
+// none. An ID is made of letters, digits, '-', '_' and '.'.
+func CutID(line string) (id, query string, err error) {
+	rest, ok := strings.CutPrefix(line, "#")
+	if !ok {
+		return "", line, nil
+	}
+	id, query, _ = strings.Cut(rest, " ")
+	if id == "" || len(id) > MaxIDLen || strings.ContainsFunc(id, notIDRune) {
+		return "", "", Errorf(CodeParse, "invalid request ID %q", id)
+	}
+	return id, query, nil
+}
+
+func notIDRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		r == '-' || r == '_' || r == '.')
+}
+
+// Tag returns b, a query line or a response, prefixed with the request ID id.
+// Returns b as is if id is empty.
+func Tag(id string, b []byte) []byte {
+	if id == "" {
+		return b
+	}
+	tagged := make([]byte, 0, len(id)+2+len(b))
+	tagged = append(tagged, '#')
+	tagged = append(tagged, id...)
+	tagged = append(tagged, ' ')
+	return append(tagged, b...)
+}
+
+// -------Reading---------------------------------------------------------------
+
+// Reply is a response read from a server, other than an error.
+type Reply struct {
+	Nil   bool   // NIL, the command has no value.
+	Value []byte // The value of a VALUE response, nil for OK and NIL.
+}
+
+// Read reads an OK, VALUE, NIL or ERR response. An ERR response is returned
+// as an *Error.
+func Read(r *bufio.Reader) (*Reply, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := ParseError(line); ok {
+		return nil, e
+	}
+
+	switch {
+	case line == "OK":
+		return &Reply{}, nil
+	case line == "NIL":
+		return &Reply{Nil: true}, nil
+	case strings.HasPrefix(line, "VALUE "):
+		n, err := strconv.Atoi(strings.TrimPrefix(line, "VALUE "))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("malformed response: %q", line)
+		}
+		value := make([]byte, n+1)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		if value[n] != '\n' {
+			return nil, errors.New("malformed response: value not followed by a newline")
+		}
+		return &Reply{Value: value[:n]}, nil
+	default:
+		return nil, fmt.Errorf("malformed response: %q", line)
+	}
+}
+
+// ReadList reads the lines of a list response up to its END line. An ERR line
+// is returned as an *Error.
+func ReadList(r *bufio.Reader) ([]string, error) {
+	lines := []string{}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return lines, nil
+		}
+		if e, ok := ParseError(line); ok {
+			return nil, e
+		}
+		lines = append(lines, line)
+	}
+}
+
+// ParseError returns the error of an ERR line, without its newline. ok is
+// false if line is not an ERR line.
+func ParseError(line string) (e *Error, ok bool) {
+	rest, ok := strings.CutPrefix(line, "ERR ")
+	if !ok {
+		return nil, false
+	}
+	code, msg, _ := strings.Cut(rest, " ")
+	return &Error{Code: Code(code), Err: errors.New(msg)}, true
+}
+
+// readLine reads a line without its newline.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"orchiddb/response"
+)
+
+// maxFrameLine is how much of a response line a framer keeps, enough for the
+// lines it needs to read: VALUE lengths, END and the start of ERR lines.
+const maxFrameLine = 64
+
+// framer follows the responses a shard sends on a connection, to know when
+// the responses to the commands forwarded on it were all relayed.
+// A response is a single OK, NIL or ERR line, a VALUE line followed by the
+// value, or for a list, the lines up to an END line unless it is an ERR line.
+type framer struct {
+	pending []bool        // Whether each response not yet relayed is a list.
+	drained chan struct{} // Closed once pending is empty, nil if it is.
+
+	line   []byte // The start of the line being read.
+	value  int    // Bytes left of a VALUE's value, with its line ending.
+	inList bool   // Set once the first line of a list was read.
+}
+
+// expect adds the response to a command about to be forwarded.
+func (f *framer) expect(list bool) {
+	if len(f.pending) == 0 {
+		f.drained = make(chan struct{})
+	}
+	f.pending = append(f.pending, list)
+}
+
+// consume follows data, the next bytes the shard sent.
+func (f *framer) consume(data []byte) {
+	for len(data) > 0 && len(f.pending) > 0 {
+		if f.value > 0 {
+			n := min(f.value, len(data))
+			f.value -= n
+			data = data[n:]
+			if f.value == 0 {
+				f.next()
+			}
+			continue
+		}
+
+		i := bytes.IndexByte(data, '\n')
+		chunk := data
+		if i >= 0 {
+			chunk = data[:i]
+		}
+		if room := maxFrameLine - len(f.line); room > 0 {
+			f.line = append(f.line, chunk[:min(room, len(chunk))]...)
+		}
+		if i < 0 {
+			return
+		}
+		data = data[i+1:]
+
+		line := strings.TrimSuffix(string(f.line), "\r")
+		f.line = f.line[:0]
+		f.endLine(line)
+	}
+}
+
+// endLine follows a complete line of a response.
+func (f *framer) endLine(line string) {
+	if !f.inList && strings.HasPrefix(line, "#") {
+		if _, rest, err := response.CutID(line); err == nil {
+			line = rest
+		}
+	}
+
+	switch {
+	case f.inList:
+		if line == "END" {
+			f.next()
+		}
+	case f.pending[0]:
+		if line == "END" || strings.HasPrefix(line, "ERR ") {
+			f.next()
+		} else {
+			f.inList = true
+		}
+	default:
+		if size, ok := strings.CutPrefix(line, "VALUE "); ok {
+			if n, err := strconv.Atoi(size); err == nil && n >= 0 {
+				f.value = n + 1
+				return
+			}
+		}
+		f.next()
+	}
+}
+
+// next moves on to the next pending response.
+func (f *framer) next() {
+	f.pending = f.pending[1:]
+	f.inList = false
+	if len(f.pending) == 0 {
+		close(f.drained)
+		f.drained = nil
+	}
+}
+
+// reset forgets the pending responses, once the connection is lost.
+func (f *framer) reset() {
+	f.pending = nil
+	f.line, f.value, f.inList = f.line[:0], 0, false
+	if f.drained != nil {
+		close(f.drained)
+		f.drained = nil
+	}
+}
@@ -0,0 +1,114 @@
+package router
+
+import (
+	"strings"
+	"testing"
+)
+
+// isClosed returns whether ch is closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// consumeBytes feeds data to f one byte at a time, the worst split of reads.
+func consumeBytes(f *framer, data string) {
+	for i := range len(data) {
+		f.consume([]byte{data[i]})
+	}
+}
+
+func TestFramerResponses(t *testing.T) {
+	long := strings.Repeat("x", 3*maxFrameLine)
+	tests := []struct {
+		name  string
+		lists []bool // Whether each expected response is a list.
+		data  string
+	}{
+		{"ok", []bool{false}, "OK\n"},
+		{"nil", []bool{false}, "NIL\r\n"},
+		{"error", []bool{false}, "ERR NOTABLE no table t\n"},
+		{"value", []bool{false}, "VALUE 3\nabc\n"},
+		{"empty value", []bool{false}, "VALUE 0\n\n"},
+		{"value of lines", []bool{false}, "VALUE 9\nOK\nEND\nx\n\n"},
+		{"tagged value", []bool{false}, "#7 VALUE 3\nabc\n"},
+		{"list", []bool{true}, "\"a\" \"1\"\n\"b\" \"2\"\nEND\n"},
+		{"empty list", []bool{true}, "END\n"},
+		{"list error", []bool{true}, "ERR NOTABLE no table t\n"},
+		{"tagged list", []bool{true}, "#x \"a\" \"1\"\nEND\n"},
+		{"list of long lines", []bool{true}, long + "\n" + long + "END\nEND\n"},
+		{"list line like an error", []bool{true}, "\"a\" \"1\"\nERR x\nEND\n"},
+		{"pipelined", []bool{false, true, false}, "OK\n\"a\" \"1\"\nEND\nVALUE 1\nz\n"},
+	}
+	for _, tt := range tests {
+		for _, split := range []bool{false, true} {
+			f := &framer{}
+			for _, list := range tt.lists {
+				f.expect(list)
+			}
+			drained := f.drained
+
+			// Everything but the last byte leaves a response pending.
+			body, last := tt.data[:len(tt.data)-1], tt.data[len(tt.data)-1:]
+			if split {
+				consumeBytes(f, body)
+			} else {
+				f.consume([]byte(body))
+			}
+			if isClosed(drained) || len(f.pending) == 0 {
+				t.Errorf("%s (split %v): drained before the last byte", tt.name, split)
+				continue
+			}
+
+			f.consume([]byte(last))
+			if !isClosed(drained) || len(f.pending) != 0 || f.drained != nil {
+				t.Errorf("%s (split %v): not drained, %d responses pending", tt.name, split, len(f.pending))
+			}
+		}
+	}
+}
+
+func TestFramerCountsResponses(t *testing.T) {
+	f := &framer{}
+	f.expect(false)
+	f.expect(false)
+	drained := f.drained
+
+	f.consume([]byte("OK\n"))
+	if isClosed(drained) || len(f.pending) != 1 {
+		t.Fatalf("drained after 1 of 2 responses, %d pending", len(f.pending))
+	}
+	f.consume([]byte("NIL\n"))
+	if !isClosed(drained) {
+		t.Fatal("not drained after 2 of 2 responses")
+	}
+
+	// Responses to a new command need a new channel.
+	f.expect(true)
+	if f.drained == nil || isClosed(f.drained) {
+		t.Fatal("expect after draining did not make a new channel")
+	}
+}
+
+func TestFramerReset(t *testing.T) {
+	f := &framer{}
+	f.expect(false)
+	drained := f.drained
+	f.consume([]byte("VALUE 10\nabc"))
+
+	f.reset()
+	if !isClosed(drained) || len(f.pending) != 0 || f.value != 0 || len(f.line) != 0 {
+		t.Fatal("reset kept state of the lost connection")
+	}
+
+	// The rest of the lost value does not count towards the next response.
+	f.expect(false)
+	f.consume([]byte("defghij\n"))
+	if len(f.pending) != 0 {
+		t.Fatalf("%d responses pending after a line", len(f.pending))
+	}
+}
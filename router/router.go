@@ -0,0 +1,765 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"orchiddb/client"
+	"orchiddb/globals"
+	"orchiddb/parser"
+	"orchiddb/response"
+)
+
+// -----------------------------------------------------------------------------
+// Router mode accepts the normal query protocol and forwards every command to
+// the backend Orchid server, or shard, that holds its key.
+//
+// Every client gets its own connection to each shard it uses, so the commands
+// of a client reach a shard in the order they were sent. Responses are relayed
+// back as they arrive. Commands without a key fan out: MAKE and DROP go to
+// every shard and respond once all shards did, a MAKE that only some shards
+// applied being dropped again on those, and SCAN goes to every shard
+// holding part of its range, with the results merged in key order. A fanned
+// out command is only sent to a shard once the responses to the commands
+// forwarded before it were relayed, so it reads its own response.
+//
+// Request IDs are forwarded with the commands, so shards tag their responses.
+// Responses of the router itself, e.g. of fanned out commands, are tagged by
+// the router.
+//
+// With the -shard-tls options the router connects to the shards over TLS. It
+// listens in plain TCP itself.
+//
+// The router does not check credentials itself. An AUTH goes to every shard,
+// and is sent again first on every connection to a shard made afterwards.
+// -----------------------------------------------------------------------------
+
+// fanOutTimeout is how long a fanned out SCAN waits for each shard.
+const fanOutTimeout = 10 * time.Second
+
+var errParse = response.Errorf(response.CodeParse, "invalid query")
+
+type config struct {
+	addr   string
+	port   int
+	shards string
+	ranges string
+
+	shardTLS     bool   // Connect to the shards over TLS, implied by the other TLS options.
+	shardTLSCA   string // PEM file of the CAs the shards' certificates are checked against.
+	shardTLSCert string // PEM client certificate file, for shards that require one.
+	shardTLSKey  string
+}
+
+// Run parses the router options from argv and routes client connections until
+// the process exits. Returns the process exit code.
+func Run(argv []string) int {
+	cfg, err := parseArgs(argv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	m, err := newShardMap(cfg.shards, cfg.ranges)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	tlsCfg, err := cfg.tlsConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "shard TLS error:", err)
+		return 2
+	}
+
+	addr := fmt.Sprintf("%s:%d", cfg.addr, cfg.port)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Println("router start error:", err)
+		return 2
+	}
+	fmt.Println("router: listening on", addr, "with", m)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Println("accept error:", err)
+			continue
+		}
+		go newSession(conn, m, tlsCfg).run()
+	}
+}
+
+func parseArgs(argv []string) (*config, error) {
+	cfg := &config{}
+
+	fs := flag.NewFlagSet("router", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+
+	fs.StringVar(&cfg.addr, "addr", "127.0.0.1", "Which address the router uses for listening.")
+	fs.IntVar(&cfg.port, "port", 6000, "Which port the router uses for listening.")
+	fs.StringVar(&cfg.shards, "shards", "", "Backend servers as host:port,host:port,...")
+	fs.StringVar(&cfg.ranges, "ranges", "", "Split keys between the shards' key ranges. Hashes keys if empty.")
+	fs.BoolVar(&cfg.shardTLS, "shard-tls", false, "Connect to the shards over TLS.")
+	fs.StringVar(&cfg.shardTLSCA, "shard-tls-ca", "", "PEM file of the CAs to verify the shards with. Implies -shard-tls.")
+	fs.StringVar(&cfg.shardTLSCert, "shard-tls-cert", "", "PEM client certificate file presented to the shards. Implies -shard-tls.")
+	fs.StringVar(&cfg.shardTLSKey, "shard-tls-key", "", "PEM private key file of -shard-tls-cert.")
+	fs.IntVar(&globals.MaxRequestSize, "max-request-size", globals.MaxRequestSize, "Largest query line in bytes.")
+
+	if err := fs.Parse(argv); err != nil {
+		return nil, err
+	}
+	if globals.MaxRequestSize <= 0 {
+		return nil, fmt.Errorf("-max-request-size must be positive, got %d", globals.MaxRequestSize)
+	}
+	return cfg, nil
+}
+
+// tlsConfig returns the TLS configuration of the connections to the shards,
+// nil for plain TCP.
+func (cfg *config) tlsConfig() (*tls.Config, error) {
+	if !cfg.shardTLS && cfg.shardTLSCA == "" && cfg.shardTLSCert == "" {
+		return nil, nil
+	}
+	return client.TLSConfig(cfg.shardTLSCA, cfg.shardTLSCert, cfg.shardTLSKey)
+}
+
+// -------Sessions--------------------------------------------------------------
+
+// session routes the commands of a single client.
+type session struct {
+	client  net.Conn
+	writeMu sync.Mutex // Serializes writes to the client from the shards.
+
+	m      *shardMap
+	shards []*shardConn
+	batch  *batch      // The open BATCH, if any.
+	auth   string      // The last AUTH every shard accepted, sent on new connections.
+	tls    *tls.Config // Connects to the shards over TLS if set.
+}
+
+// shardConn is a client's connection to a shard.
+type shardConn struct {
+	addr string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	frames  *framer    // The responses forwarded on conn not yet relayed.
+	collect *collector // Set while a fanned out command reads the responses.
+}
+
+// collector receives what a shard sends instead of the client.
+type collector struct {
+	data chan []byte   // Closed by the relay if the connection is lost.
+	done chan struct{} // Closed once the collector stops reading.
+}
+
+// batch collects the commands between BATCH and END. A batch is forwarded if
+// all its keys are on the same shard.
+type batch struct {
+	lines []string
+	shard int // -1 until the first command.
+	err   error
+}
+
+func newSession(conn net.Conn, m *shardMap, tlsCfg *tls.Config) *session {
+	s := &session{client: conn, m: m, tls: tlsCfg}
+	for _, addr := range m.addrs {
+		s.shards = append(s.shards, &shardConn{addr: addr})
+	}
+	return s
+}
+
+func (s *session) run() {
+	defer func() {
+		s.client.Close()
+		for _, sc := range s.shards {
+			sc.close()
+		}
+	}()
+
+	r := bufio.NewReader(s.client)
+	for {
+		line, err := readLine(r)
+		if err != nil && response.CodeOf(err) != response.CodeLimit {
+			return
+		}
+		id := ""
+		if err == nil {
+			id, line, err = response.CutID(line)
+		}
+		if err == nil {
+			err = s.route(id, line)
+		}
+		if err != nil {
+			if err := s.respondError(id, err); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLine reads a query line without its line ending. A line over
+// globals.MaxRequestSize is read to its end and dropped, and a CodeLimit error
+// returned, so the lines after it are still read.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	tooLarge := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			line = append(line, chunk...)
+			// The line ending does not count.
+			if len(line) > globals.MaxRequestSize+2 {
+				tooLarge, line = true, nil
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		// The last line may have no line ending.
+		if err == nil || (errors.Is(err, io.EOF) && len(line) > 0) {
+			break
+		}
+		return "", err
+	}
+
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	if tooLarge || len(line) > globals.MaxRequestSize {
+		return "", response.Errorf(response.CodeLimit, "request larger than %d bytes", globals.MaxRequestSize)
+	}
+	return string(line), nil
+}
+
+// route forwards a single query line, without its request ID id.
+func (s *session) route(id, line string) error {
+	cmd := parser.NewParser(parser.NewLexer(line)).ParseCommand()
+	if s.batch != nil {
+		return s.addToBatch(cmd, id, line)
+	}
+	if cmd == nil || cmd.Command == nil {
+		return errParse
+	}
+
+	if table, key, ok := keyOf(cmd.Command); ok {
+		return s.forward(s.m.shardOf(table, key), tag(id, line), isList(cmd.Command))
+	}
+
+	switch t := cmd.Command.(type) {
+	case *parser.MakeCommand:
+		if err := s.schema(line, t.Table, true); err != nil {
+			return err
+		}
+		return s.respond(id, []byte("OK\n"))
+	case *parser.DropCommand:
+		if err := s.schema(line, t.Table, false); err != nil {
+			return err
+		}
+		return s.respond(id, []byte("OK\n"))
+	case *parser.AuthCommand:
+		if _, err := s.fanOut(s.allShards(), line, false); err != nil {
+			return err
+		}
+		s.auth = line
+		return s.respond(id, []byte("OK\n"))
+	case *parser.ScanCommand:
+		return s.scan(t, id, line)
+	case *parser.TablesCommand:
+		// MAKE and DROP go to every shard, so every shard has the same tables.
+		return s.forward(0, tag(id, line), true)
+	case *parser.BatchCommand:
+		s.batch = &batch{shard: -1}
+		return nil
+	case *parser.EndCommand:
+		return response.Errorf(response.CodeInvalid, "END without BATCH")
+	default:
+		return response.Errorf(
+			response.CodeUnsupported, "%s is not supported through the router", cmd.Command.TokenLiteral(),
+		)
+	}
+}
+
+// keyOf returns the table and key of the commands that address a single key.
+func keyOf(node parser.Node) (string, string, bool) {
+	switch t := node.(type) {
+	case *parser.GetCommand:
+		return t.Table, t.Key, true
+	case *parser.PutCommand:
+		return t.Table, t.Key, true
+	case *parser.DelCommand:
+		return t.Table, t.Key, true
+	case *parser.IncrCommand:
+		return t.Table, t.Key, true
+	case *parser.AppendCommand:
+		return t.Table, t.Key, true
+	case *parser.GetSetCommand:
+		return t.Table, t.Key, true
+	case *parser.CasCommand:
+		return t.Table, t.Key, true
+	case *parser.CondPutCommand:
+		return t.Table, t.Key, true
+	case *parser.GetVersionCommand:
+		return t.Table, t.Key, true
+	case *parser.HistoryCommand:
+		return t.Table, t.Key, true
+	default:
+		return "", "", false
+	}
+}
+
+// isList returns whether the forwarded command responds with a list.
+func isList(node parser.Node) bool {
+	switch node.(type) {
+	case *parser.HistoryCommand, *parser.TablesCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// addToBatch adds a command to the open batch, and forwards the batch to its
+// shard once END is read. The request ID of END tags the batch's response.
+func (s *session) addToBatch(cmd *parser.Command, id, line string) error {
+	b := s.batch
+	if cmd == nil || cmd.Command == nil {
+		b.fail(errParse)
+		return nil
+	}
+
+	switch t := cmd.Command.(type) {
+	case *parser.EndCommand:
+		s.batch = nil
+		return s.sendBatch(id, b)
+	case *parser.PutCommand, *parser.DelCommand:
+		table, key, _ := keyOf(t)
+		shard := s.m.shardOf(table, key)
+		if b.shard >= 0 && b.shard != shard {
+			b.fail(response.Errorf(response.CodeInvalid, "keys span several shards"))
+		}
+		b.shard = shard
+		b.lines = append(b.lines, line)
+	default:
+		b.fail(response.Errorf(response.CodeInvalid, "%s cannot be batched", cmd.Command.TokenLiteral()))
+	}
+	return nil
+}
+
+func (b *batch) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func (s *session) sendBatch(id string, b *batch) error {
+	if b.err != nil {
+		return fmt.Errorf("batch aborted: %w", b.err)
+	}
+	if len(b.lines) == 0 {
+		return s.respond(id, []byte("OK\n"))
+	}
+
+	lines := append([]string{"BATCH"}, b.lines...)
+	lines = append(lines, tag(id, "END"))
+	return s.forward(b.shard, strings.Join(lines, "\n"), false)
+}
+
+// -------Shards----------------------------------------------------------------
+
+// forward sends line to the shard. Its response, a list if list is set, is
+// relayed to the client.
+func (s *session) forward(shard int, line string, list bool) error {
+	sc := s.shards[shard]
+	conn, err := s.dial(sc)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	if sc.conn == conn {
+		sc.frames.expect(list)
+	}
+	sc.mu.Unlock()
+
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		sc.close()
+		return response.Errorf(response.CodeUnavailable, "shard %s: %w", sc.addr, err)
+	}
+	return nil
+}
+
+// dial returns the connection to the shard, connecting if needed.
+func (s *session) dial(sc *shardConn) (net.Conn, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.conn != nil {
+		return sc.conn, nil
+	}
+	var conn net.Conn
+	var err error
+	if s.tls != nil {
+		conn, err = tls.Dial("tcp", sc.addr, s.tls)
+	} else {
+		conn, err = net.Dial("tcp", sc.addr)
+	}
+	if err != nil {
+		return nil, response.Errorf(response.CodeUnavailable, "shard %s is unavailable: %w", sc.addr, err)
+	}
+	if s.auth != "" {
+		if err := authenticate(conn, s.auth); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("shard %s: %w", sc.addr, err)
+		}
+	}
+	sc.conn = conn
+	sc.frames = &framer{}
+	go s.relay(sc, conn, sc.frames)
+	return conn, nil
+}
+
+// authenticate sends the AUTH line on a new connection to a shard and reads
+// its response, before anything is relayed.
+func authenticate(conn net.Conn, line string) error {
+	if err := conn.SetDeadline(time.Now().Add(fanOutTimeout)); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		return response.Errorf(response.CodeUnavailable, "%w", err)
+	}
+
+	// Read byte by byte, so nothing after the response is taken from the relay.
+	var resp []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return response.Errorf(response.CodeUnavailable, "%w", err)
+		}
+		if b[0] == '\n' {
+			break
+		}
+		resp = append(resp, b[0])
+	}
+	if e, ok := response.ParseError(string(resp)); ok {
+		return e
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// relay passes what the shard sends to the client, or to the fanned out
+// command reading it, and counts the forwarded responses passed with frames.
+func (s *session) relay(sc *shardConn, conn net.Conn, frames *framer) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			data := bytes.Clone(buf[:n])
+
+			sc.mu.Lock()
+			collect := sc.collect
+			if collect == nil {
+				frames.consume(data)
+			}
+			sc.mu.Unlock()
+
+			if collect != nil {
+				select {
+				case collect.data <- data:
+					continue
+				case <-collect.done:
+				}
+			}
+			if err := s.write(data); err != nil {
+				conn.Close()
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fmt.Printf("Error reading from shard %s: %v\n", sc.addr, err)
+			}
+			sc.mu.Lock()
+			frames.reset()
+			if sc.conn == conn {
+				sc.conn = nil
+			}
+			if sc.collect != nil {
+				close(sc.collect.data)
+				sc.collect = nil
+			}
+			sc.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (sc *shardConn) close() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.conn != nil {
+		sc.conn.Close()
+		sc.conn = nil
+	}
+}
+
+// -------Fan Out---------------------------------------------------------------
+
+// allShards returns the index of every shard.
+func (s *session) allShards() []int {
+	shards := make([]int, len(s.shards))
+	for i := range shards {
+		shards[i] = i
+	}
+	return shards
+}
+
+// scan sends the SCAN to every shard holding part of its range and writes the
+// merged results.
+func (s *session) scan(cmd *parser.ScanCommand, id, line string) error {
+	results, err := s.fanOut(s.m.shardsOf(cmd.Start, cmd.End), line, true)
+	if err != nil {
+		return err
+	}
+
+	var resp []byte
+	for _, l := range mergeLines(results) {
+		resp = append(resp, l...)
+		resp = append(resp, '\n')
+	}
+	resp = append(resp, "END\n"...)
+	return s.respond(id, resp)
+}
+
+// fanOut sends line to the shards at once and returns the lines of their list
+// responses, or nothing if they respond with a single line. A single error is
+// returned, for the first shard that failed.
+func (s *session) fanOut(shards []int, line string, list bool) ([][]string, error) {
+	results, errs := s.fanOutEach(shards, line, list)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// fanOutEach is fanOut returning the response and error of every shard.
+func (s *session) fanOutEach(shards []int, line string, list bool) ([][]string, []error) {
+	results := make([][]string, len(shards))
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = s.collect(s.shards[shard], line, list)
+		}()
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// schema sends the MAKE, if making is set, or DROP line of table to every shard.
+// If only some shards apply it, the error names them, and a MAKE is dropped
+// again on those so the shards keep the same tables. A DROP cannot be undone.
+func (s *session) schema(line, table string, making bool) error {
+	shards := s.allShards()
+	_, errs := s.fanOutEach(shards, line, false)
+
+	var applied []int
+	var failed []string
+	var first error
+	for i, err := range errs {
+		if err == nil {
+			applied = append(applied, shards[i])
+			continue
+		}
+		failed = append(failed, s.shards[shards[i]].addr)
+		if first == nil {
+			first = err
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	if len(applied) == 0 {
+		return first
+	}
+
+	code := response.CodeOf(first)
+	failedOn := strings.Join(failed, ",")
+	if !making {
+		return response.Errorf(code, "dropped only on %s, failed on %s: %v", s.addrs(applied), failedOn, first)
+	}
+
+	_, errs = s.fanOutEach(applied, "DROP("+parser.Quote(table)+")", false)
+	var kept []int
+	for i, err := range errs {
+		if err != nil {
+			kept = append(kept, applied[i])
+		}
+	}
+	if len(kept) > 0 {
+		return response.Errorf(code, "failed on %s: %v, and is left made on %s", failedOn, first, s.addrs(kept))
+	}
+	return response.Errorf(code, "failed on %s: %v, and was dropped again on %s", failedOn, first, s.addrs(applied))
+}
+
+// addrs returns the addresses of shards, comma separated.
+func (s *session) addrs(shards []int) string {
+	addrs := make([]string, len(shards))
+	for i, shard := range shards {
+		addrs[i] = s.shards[shard].addr
+	}
+	return strings.Join(addrs, ",")
+}
+
+// collect sends line to the shard and reads its response, the lines up to the
+// END line of a list or else a single line. An ERR line from the shard is
+// returned as an error with its code.
+func (s *session) collect(sc *shardConn, line string, list bool) ([]string, error) {
+	conn, err := s.dial(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for the responses forwarded before, which the relay passes to the
+	// client, so the collector only reads its own.
+	collect := &collector{data: make(chan []byte, 16), done: make(chan struct{})}
+	timeout := time.After(fanOutTimeout)
+	for {
+		sc.mu.Lock()
+		if sc.conn != conn {
+			sc.mu.Unlock()
+			return nil, response.Errorf(response.CodeUnavailable, "shard %s closed the connection", sc.addr)
+		}
+		drained := sc.frames.drained
+		if drained == nil {
+			sc.collect = collect
+			sc.mu.Unlock()
+			break
+		}
+		sc.mu.Unlock()
+
+		select {
+		case <-drained:
+		case <-timeout:
+			sc.close()
+			return nil, response.Errorf(response.CodeUnavailable, "shard %s timed out", sc.addr)
+		}
+	}
+	defer func() {
+		sc.mu.Lock()
+		if sc.collect == collect {
+			sc.collect = nil
+		}
+		sc.mu.Unlock()
+		close(collect.done)
+	}()
+
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		sc.close()
+		return nil, response.Errorf(response.CodeUnavailable, "shard %s: %w", sc.addr, err)
+	}
+
+	var buf []byte
+	var lines []string
+	for {
+		select {
+		case data, ok := <-collect.data:
+			if !ok {
+				return nil, response.Errorf(response.CodeUnavailable, "shard %s closed the connection", sc.addr)
+			}
+			buf = append(buf, data...)
+		case <-timeout:
+			sc.close()
+			return nil, response.Errorf(response.CodeUnavailable, "shard %s timed out", sc.addr)
+		}
+
+		for {
+			i := bytes.IndexByte(buf, '\n')
+			if i < 0 {
+				break
+			}
+			l := string(buf[:i])
+			buf = buf[i+1:]
+
+			if e, ok := response.ParseError(l); ok {
+				s.passOn(buf)
+				return nil, &response.Error{Code: e.Code, Err: fmt.Errorf("shard %s: %w", sc.addr, e.Err)}
+			}
+			if !list || l == "END" {
+				return lines, s.passOn(buf)
+			}
+			lines = append(lines, l)
+		}
+	}
+}
+
+// passOn relays what a shard sent after the response a collector read.
+func (s *session) passOn(rest []byte) error {
+	if len(rest) == 0 {
+		return nil
+	}
+	return s.write(rest)
+}
+
+// mergeLines merges the "key value" lines of several shards, each sorted by
+// key, into a single list sorted by key.
+func mergeLines(results [][]string) []string {
+	var merged []string
+	for _, lines := range results {
+		merged = append(merged, lines...)
+	}
+	slices.SortStableFunc(merged, func(a, b string) int {
+		return strings.Compare(lineKey(a), lineKey(b))
+	})
+	return merged
+}
+
+// lineKey returns the unquoted key of a SCAN line, as the quoted keys do not
+// sort like the keys.
+func lineKey(line string) string {
+	quoted, err := strconv.QuotedPrefix(line)
+	if err != nil {
+		return line
+	}
+	key, _ := strconv.Unquote(quoted)
+	return key
+}
+
+// -------Responses-------------------------------------------------------------
+
+func (s *session) write(b []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.client.Write(b)
+	return err
+}
+
+// respond writes resp, the router's own response to the query with the
+// request ID id.
+func (s *session) respond(id string, resp []byte) error {
+	return s.write(response.Tag(id, resp))
+}
+
+func (s *session) respondError(id string, err error) error {
+	return s.respond(id, []byte(response.ErrorLine(err)+"\n"))
+}
+
+// tag returns the query line with the request ID id.
+func tag(id, line string) string {
+	return string(response.Tag(id, []byte(line)))
+}
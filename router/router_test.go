@@ -0,0 +1,39 @@
+package router
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLineKey(t *testing.T) {
+	tests := []struct {
+		line, key string
+	}{
+		{`"a" "1"`, "a"},
+		{`"a b" "c d"`, "a b"},
+		{`"tab\there" "v"`, "tab\there"},
+		{`"say \"hi\"" "v"`, `say "hi"`},
+		{`"" "v"`, ""},
+		{"unquoted line", "unquoted line"},
+	}
+	for _, tt := range tests {
+		if got := lineKey(tt.line); got != tt.key {
+			t.Errorf("lineKey(%q) = %q, want %q", tt.line, got, tt.key)
+		}
+	}
+}
+
+func TestMergeLines(t *testing.T) {
+	// Quoted, "a b" would sort before "a\tb" and "a!" before "a", since \ and "
+	// sort after the space and !.
+	shards := [][]string{
+		{`"a" "1"`, `"a\tb" "2"`, `"b" "3"`},
+		{`"a b" "4"`, `"a!" "5"`},
+		nil,
+	}
+	want := []string{`"a" "1"`, `"a\tb" "2"`, `"a b" "4"`, `"a!" "5"`, `"b" "3"`}
+
+	if got := mergeLines(shards); !slices.Equal(got, want) {
+		t.Errorf("mergeLines = %q, want %q", got, want)
+	}
+}
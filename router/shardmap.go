@@ -0,0 +1,100 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+)
+
+// shardMap maps the keys of every table to one of the backend servers.
+//
+// With no split keys, a key is placed by the hash of its table and key, which
+// spreads every table over all shards. With split keys, shard i holds the keys
+// from splits[i-1] up to, but excluding, splits[i] in byte order, so a SCAN of
+// a key range only visits the shards that overlap it.
+type shardMap struct {
+	addrs  []string
+	splits []string // Ascending, one less than addrs when set.
+}
+
+func newShardMap(shards, ranges string) (*shardMap, error) {
+	if shards == "" {
+		return nil, errors.New("-shards is required")
+	}
+
+	m := &shardMap{addrs: strings.Split(shards, ",")}
+	if ranges == "" {
+		return m, nil
+	}
+
+	m.splits = strings.Split(ranges, ",")
+	if len(m.splits) != len(m.addrs)-1 {
+		return nil, fmt.Errorf(
+			"%d shards need %d split keys, got %d",
+			len(m.addrs), len(m.addrs)-1, len(m.splits),
+		)
+	}
+	for i := 1; i < len(m.splits); i++ {
+		if m.splits[i-1] >= m.splits[i] {
+			return nil, fmt.Errorf("split keys must be ascending, %s is not before %s", m.splits[i-1], m.splits[i])
+		}
+	}
+	return m, nil
+}
+
+// shardOf returns the shard holding key of table.
+func (m *shardMap) shardOf(table, key string) int {
+	if m.splits == nil {
+		h := fnv.New64a()
+		h.Write([]byte(table))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		return int(h.Sum64() % uint64(len(m.addrs)))
+	}
+
+	i, found := slices.BinarySearch(m.splits, key)
+	if found {
+		i++
+	}
+	return i
+}
+
+// shardsOf returns the shards holding keys from start up to, but excluding,
+// end, which is unbounded if empty.
+func (m *shardMap) shardsOf(start, end string) []int {
+	first, last := 0, len(m.addrs)-1
+	if m.splits != nil {
+		first = m.shardOf("", start)
+		if end != "" {
+			// The shard holding the key right before end.
+			last, _ = slices.BinarySearch(m.splits, end)
+		}
+	}
+
+	var shards []int
+	for i := first; i <= last; i++ {
+		shards = append(shards, i)
+	}
+	return shards
+}
+
+func (m *shardMap) String() string {
+	if m.splits == nil {
+		return fmt.Sprintf("hash over %s", strings.Join(m.addrs, ", "))
+	}
+
+	var b strings.Builder
+	for i, addr := range m.addrs {
+		from, to := "", ""
+		if i > 0 {
+			from = m.splits[i-1]
+		}
+		if i < len(m.splits) {
+			to = m.splits[i]
+		}
+		fmt.Fprintf(&b, "\n  [%q, %q) -> %s", from, to, addr)
+	}
+	return "ranges:" + b.String()
+}
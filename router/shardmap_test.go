@@ -0,0 +1,106 @@
+package router
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNewShardMapErrors(t *testing.T) {
+	tests := []struct {
+		name, shards, ranges string
+	}{
+		{"no shards", "", ""},
+		{"too few splits", "a:1,b:2,c:3", "h"},
+		{"too many splits", "a:1,b:2", "h,p"},
+		{"splits not ascending", "a:1,b:2,c:3", "p,h"},
+		{"duplicate splits", "a:1,b:2,c:3", "h,h"},
+	}
+	for _, tt := range tests {
+		if _, err := newShardMap(tt.shards, tt.ranges); err == nil {
+			t.Errorf("%s: newShardMap(%q, %q) returned no error", tt.name, tt.shards, tt.ranges)
+		}
+	}
+}
+
+func TestShardOfRanges(t *testing.T) {
+	m, err := newShardMap("a:1,b:2,c:3", "h,p")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key   string
+		shard int
+	}{
+		{"", 0},
+		{"a", 0},
+		{"gzzz", 0},
+		{"h", 1},
+		{"h0", 1},
+		{"ozzz", 1},
+		{"p", 2},
+		{"z", 2},
+		{"\xff", 2},
+	}
+	for _, tt := range tests {
+		if got := m.shardOf("t", tt.key); got != tt.shard {
+			t.Errorf("shardOf(%q) = %d, want %d", tt.key, got, tt.shard)
+		}
+	}
+}
+
+func TestShardOfHash(t *testing.T) {
+	m, err := newShardMap("a:1,b:2,c:3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[int]bool{}
+	for i := range 300 {
+		key := string(rune('a'+i%26)) + string(rune('a'+i/26))
+		shard := m.shardOf("t", key)
+		if shard < 0 || shard >= len(m.addrs) {
+			t.Fatalf("shardOf(%q) = %d, out of range", key, shard)
+		}
+		if again := m.shardOf("t", key); again != shard {
+			t.Fatalf("shardOf(%q) = %d, then %d", key, shard, again)
+		}
+		seen[shard] = true
+	}
+	if len(seen) != len(m.addrs) {
+		t.Errorf("300 keys went to %d of %d shards", len(seen), len(m.addrs))
+	}
+}
+
+func TestShardsOf(t *testing.T) {
+	ranged, err := newShardMap("a:1,b:2,c:3", "h,p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed, err := newShardMap("a:1,b:2,c:3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		m          *shardMap
+		start, end string
+		shards     []int
+	}{
+		{ranged, "", "", []int{0, 1, 2}},
+		{ranged, "a", "c", []int{0}},
+		{ranged, "a", "h", []int{0}},
+		{ranged, "a", "h0", []int{0, 1}},
+		{ranged, "h", "p", []int{1}},
+		{ranged, "i", "", []int{1, 2}},
+		{ranged, "p", "", []int{2}},
+		{ranged, "", "b", []int{0}},
+		{hashed, "a", "b", []int{0, 1, 2}},
+		{hashed, "", "", []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		if got := tt.m.shardsOf(tt.start, tt.end); !slices.Equal(got, tt.shards) {
+			t.Errorf("%v: shardsOf(%q, %q) = %v, want %v", tt.m, tt.start, tt.end, got, tt.shards)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"orchiddb/auth"
+	"orchiddb/execution"
+	"orchiddb/globals"
+	"orchiddb/parser"
+	"orchiddb/raft"
+	"orchiddb/response"
+	"orchiddb/wire"
+)
+
+// handleBinary serves a connection speaking the binary protocol, whose
+// handshake is the next thing to read from r.
+func handleBinary(conn net.Conn, r *bufio.Reader) {
+	var hs [2]byte
+	if _, err := io.ReadFull(r, hs[:]); err != nil {
+		return
+	}
+	setWriteTimeout(conn, globals.WriteTimeout)
+	if _, err := conn.Write([]byte{wire.Handshake, wire.Version}); err != nil {
+		fmt.Printf("Error writing handshake to client: %v\n", err)
+		return
+	}
+	if hs[1] != wire.Version {
+		return
+	}
+
+	var user string // The user the connection authenticated as, if any.
+	for {
+		if err := awaitRequest(conn, r); err != nil {
+			return
+		}
+		req, err := wire.ReadRequestLimit(r, globals.MaxRequestSize)
+		setWriteTimeout(conn, globals.WriteTimeout)
+		if errors.Is(err, wire.ErrFrameTooLarge) {
+			// The rest of the frame is not read, so the connection is closed.
+			wire.WriteResponse(conn, badRequest("%s", errTooLarge()))
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !isTimeout(err) {
+				fmt.Println("read error:", err)
+			}
+			return
+		}
+
+		var resp *wire.Response
+		switch {
+		case !allowRequest(conn.RemoteAddr().String()):
+			resp = errorResponse(errRateLimit())
+		case req.Op == wire.OpAuth:
+			resp = authBinary(req, &user)
+		case auth.Enabled() && user == "":
+			resp = errorResponse(response.Errorf(response.CodeNoAuth, "AUTH first"))
+		default:
+			resp = serveBinary(req, user)
+		}
+		if err := wire.WriteResponse(conn, resp); err != nil {
+			fmt.Printf("Error writing to client: %v\n", err)
+			return
+		}
+	}
+}
+
+// serveBinary executes a binary request of the user and returns its response.
+func serveBinary(req *wire.Request, user string) *wire.Response {
+	switch req.Op {
+	case wire.OpGet, wire.OpPut, wire.OpDel, wire.OpDrop:
+		want := map[wire.Op]int{wire.OpGet: 2, wire.OpPut: 3, wire.OpDel: 2, wire.OpDrop: 1}[req.Op]
+		if len(req.Args) != want {
+			return badRequest("%s takes %d arguments", req.Op, want)
+		}
+	case wire.OpScan:
+		if len(req.Args) != 2 && len(req.Args) != 3 {
+			return badRequest("SCAN takes 2 or 3 arguments")
+		}
+	case wire.OpMake:
+		if len(req.Args) < 1 || len(req.Args) > 3 {
+			return badRequest("MAKE takes 1 to 3 arguments")
+		}
+		if _, _, err := makeArgs(req.Args); err != nil {
+			return badRequest("%s", err)
+		}
+	case wire.OpTables:
+	default:
+		return badRequest("unknown opcode %d", req.Op)
+	}
+
+	if req.Op == wire.OpTables {
+		var fields [][]byte
+		for _, name := range execution.TableNames() {
+			if auth.Check(user, name, auth.RightRead) == nil {
+				fields = append(fields, []byte(name))
+			}
+		}
+		return &wire.Response{Status: wire.StatusOK, Fields: fields}
+	}
+
+	table := string(req.Args[0])
+	write := req.Op != wire.OpGet && req.Op != wire.OpScan
+
+	need := auth.RightWrite
+	switch req.Op {
+	case wire.OpGet, wire.OpScan:
+		need = auth.RightRead
+	case wire.OpMake, wire.OpDrop:
+		need = auth.RightAdmin
+	}
+	if err := auth.Check(user, table, need); err != nil {
+		return errorResponse(err)
+	}
+	if write && globals.ReplicaOf != "" {
+		return &wire.Response{Status: wire.StatusReadOnly, Fields: [][]byte{[]byte(errReadOnly.Error())}}
+	}
+	if write && raft.Enabled() {
+		return errorResponse(proposeBinary(req))
+	}
+
+	switch req.Op {
+	case wire.OpGet:
+		value, found, err := execution.Get(table, req.Args[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !found {
+			return &wire.Response{Status: wire.StatusNotFound}
+		}
+		return &wire.Response{Status: wire.StatusOK, Fields: [][]byte{value}}
+	case wire.OpPut:
+		return errorResponse(execution.Put(table, req.Args[1], req.Args[2]))
+	case wire.OpDel:
+		_, err := execution.Del(table, req.Args[1])
+		return errorResponse(err)
+	case wire.OpScan:
+		var end []byte
+		if len(req.Args) == 3 {
+			end = req.Args[2]
+		}
+		items, err := execution.Scan(table, req.Args[1], end)
+		if err != nil {
+			return errorResponse(err)
+		}
+		fields := make([][]byte, 0, 2*len(items))
+		for _, item := range items {
+			fields = append(fields, item.Key, item.Value)
+		}
+		return &wire.Response{Status: wire.StatusOK, Fields: fields}
+	case wire.OpMake:
+		kind, versions, _ := makeArgs(req.Args)
+		return errorResponse(execution.MakeTable(parser.NormalizeTableKey(table), kind, versions))
+	default: // wire.OpDrop
+		return errorResponse(execution.DropTable(parser.NormalizeTableKey(table)))
+	}
+}
+
+// authBinary authenticates the connection of an AUTH request as *user.
+func authBinary(req *wire.Request, user *string) *wire.Response {
+	if len(req.Args) != 2 {
+		return badRequest("AUTH takes 2 arguments")
+	}
+	if !auth.Enabled() {
+		return errorResponse(response.Errorf(response.CodeUnsupported, "authentication is not enabled"))
+	}
+
+	u, err := auth.Authenticate(string(req.Args[0]), string(req.Args[1]))
+	if err != nil {
+		return errorResponse(err)
+	}
+	*user = u.Name
+	return errorResponse(nil)
+}
+
+// makeArgs returns the kind and versions of a MAKE request.
+func makeArgs(args [][]byte) (kind string, versions int, err error) {
+	if len(args) > 1 {
+		kind = string(args[1])
+	}
+	if len(args) > 2 {
+		if versions, err = strconv.Atoi(string(args[2])); err != nil {
+			return "", 0, fmt.Errorf("invalid versions %s", args[2])
+		}
+	}
+	return kind, versions, nil
+}
+
+// proposeBinary commits a binary write through the Raft log as the equivalent
+// query and waits for it to be applied.
+func proposeBinary(req *wire.Request) error {
+	if req.Op == wire.OpPut || req.Op == wire.OpDel {
+		if err := requireTable(string(req.Args[0])); err != nil {
+			return err
+		}
+	}
+	return proposeQuery(req.Op.String(), req.Args, nil)
+}
+
+var errReadOnly = response.Errorf(response.CodeReadOnly, "replica is read-only")
+
+// requireTable returns an error wrapping execution.ErrNoTable if table is not
+// loaded. Writes are checked before they are proposed in cluster mode, since
+// the response of a query applied from the Raft log is discarded.
+func requireTable(table string) error {
+	table = parser.NormalizeTableKey(table)
+	if !slices.Contains(execution.TableNames(), table) {
+		return fmt.Errorf("%w: %s", execution.ErrNoTable, table)
+	}
+	return nil
+}
+
+// proposeQuery commits the query of the command name with the given arguments
+// through the Raft log and waits for it to be applied, which responds to conn
+// if it is not nil. Log entries are query lines, so the arguments cannot hold
+// a NUL byte.
+func proposeQuery(name string, args [][]byte, conn net.Conn) error {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if bytes.IndexByte(arg, 0) >= 0 {
+			return errors.New("arguments cannot hold a NUL byte in cluster mode")
+		}
+		quoted[i] = parser.Quote(string(arg))
+	}
+
+	query := fmt.Sprintf("%s(%s)", name, strings.Join(quoted, ", "))
+	return raft.Propose(query, conn)
+}
+
+// errorResponse returns the response to a request that failed with err, or
+// an OK response if err is nil.
+func errorResponse(err error) *wire.Response {
+	switch {
+	case err == nil:
+		return &wire.Response{Status: wire.StatusOK}
+	case errors.Is(err, execution.ErrNoTable):
+		return &wire.Response{Status: wire.StatusNoTable, Fields: [][]byte{[]byte(err.Error())}}
+	case response.CodeOf(err) == response.CodeNoAuth, response.CodeOf(err) == response.CodeDenied:
+		return &wire.Response{Status: wire.StatusDenied, Fields: [][]byte{[]byte(err.Error())}}
+	default:
+		return &wire.Response{Status: wire.StatusError, Fields: [][]byte{[]byte(err.Error())}}
+	}
+}
+
+func badRequest(format string, args ...any) *wire.Response {
+	return &wire.Response{Status: wire.StatusBadRequest, Fields: [][]byte{fmt.Appendf(nil, format, args...)}}
+}
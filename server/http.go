@@ -0,0 +1,343 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"orchiddb/auth"
+	"orchiddb/execution"
+	"orchiddb/globals"
+	"orchiddb/parser"
+	"orchiddb/paths"
+	"orchiddb/raft"
+	"orchiddb/response"
+	"orchiddb/storage"
+)
+
+// -----------------------------------------------------------------------------
+// The HTTP gateway serves the tables as REST resources with JSON responses:
+//
+//	GET    /tables                   lists the tables
+//	POST   /tables/{table}           makes a table, ?kind=&versions= optional
+//	DELETE /tables/{table}           drops a table
+//	GET    /tables/{table}           scans a table, ?start=&end= optional
+//	GET    /tables/{table}/keys/{key} gets a key
+//	PUT    /tables/{table}/keys/{key} puts the request body as the key's value
+//	DELETE /tables/{table}/keys/{key} deletes a key
+//
+// Failed requests respond with an {"error": message} object. With
+// authentication, every request carries HTTP Basic credentials, and its user
+// needs the right on the table the route needs.
+// -----------------------------------------------------------------------------
+
+// ListenHTTP serves the HTTP gateway on globals.HTTPPort. Returns once it
+// cannot accept connections anymore.
+func ListenHTTP() error {
+	addr := fmt.Sprintf("%s:%d", globals.Address, globals.HTTPPort)
+	l, err := listen(addr, rejectHTTP)
+	if err != nil {
+		return fmt.Errorf("cannot create HTTP listener for %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tables", listTables)
+	mux.HandleFunc("POST /tables/{table}", allow(auth.RightAdmin, makeTable))
+	mux.HandleFunc("DELETE /tables/{table}", allow(auth.RightAdmin, dropTable))
+	mux.HandleFunc("GET /tables/{table}", allow(auth.RightRead, scanTable))
+	mux.HandleFunc("GET /tables/{table}/keys/{key}", allow(auth.RightRead, getKey))
+	mux.HandleFunc("PUT /tables/{table}/keys/{key}", allow(auth.RightWrite, putKey))
+	mux.HandleFunc("DELETE /tables/{table}/keys/{key}", allow(auth.RightWrite, delKey))
+
+	srv := &http.Server{
+		Handler:           limitRate(requireAuth(mux)),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       max(globals.ReadTimeout, 0),
+		IdleTimeout:       max(globals.IdleTimeout, 0),
+	}
+	return srv.Serve(l)
+}
+
+// rejectHTTP responds to a connection over the limit before it is closed.
+func rejectHTTP(conn net.Conn) {
+	body := `{"error":"too many connections"}`
+	fmt.Fprintf(conn, "HTTP/1.1 503 Service Unavailable\r\nContent-Type: application/json\r\n"+
+		"Content-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+}
+
+// limitRate refuses the requests of a client address over globals.RateLimit.
+func limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowRequest(r.RemoteAddr) {
+			writeError(w, http.StatusTooManyRequests, errRateLimit())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuth lets only requests with the Basic credentials of a user through,
+// once authentication is enabled.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.Enabled() {
+			name, password, ok := r.BasicAuth()
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="orchid"`)
+				writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+				return
+			}
+			if _, err := auth.Authenticate(name, password); err != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="orchid"`)
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow lets only requests whose user has the right need on the route's table
+// through to next. Users were authenticated by requireAuth.
+func allow(need auth.Right, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		if err := auth.Check(user, r.PathValue("table"), need); err != nil {
+			writeExecError(w, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// jsonItem is an item in a response. If its key or value is not valid UTF-8,
+// which JSON strings cannot hold, both are base64 encoded and Encoding says so.
+type jsonItem struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func newJSONItem(key, value []byte) jsonItem {
+	if utf8.Valid(key) && utf8.Valid(value) {
+		return jsonItem{Key: string(key), Value: string(value)}
+	}
+	return jsonItem{
+		Key:      base64.StdEncoding.EncodeToString(key),
+		Value:    base64.StdEncoding.EncodeToString(value),
+		Encoding: "base64",
+	}
+}
+
+// -------Tables----------------------------------------------------------------
+
+// listTables responds with the tables the user can read.
+func listTables(w http.ResponseWriter, r *http.Request) {
+	user, _, _ := r.BasicAuth()
+	tables := []string{}
+	for _, name := range execution.TableNames() {
+		if auth.Check(user, name, auth.RightRead) == nil {
+			tables = append(tables, name)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"tables": tables})
+}
+
+func makeTable(w http.ResponseWriter, r *http.Request) {
+	table := parser.NormalizeTableKey(r.PathValue("table"))
+	kind := r.URL.Query().Get("kind")
+	versions := r.URL.Query().Get("versions")
+
+	// The mux unescapes the path, so "..%2F" reaches here as "../".
+	if err := paths.CheckTableName(table); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	n := 0
+	if versions != "" {
+		var err error
+		if n, err = strconv.Atoi(versions); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid versions %s", versions))
+			return
+		}
+	}
+
+	kinds := []string{"", storage.KindBTree, storage.KindMemory, storage.KindLSM, storage.KindHash}
+	if !slices.Contains(kinds, kind) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown table kind %s", kind))
+		return
+	}
+
+	var err error
+	switch {
+	case globals.ReplicaOf != "":
+		err = errReadOnly
+	case raft.Enabled():
+		args := [][]byte{[]byte(table), []byte(kind)}
+		if versions != "" {
+			args = append(args, []byte(versions))
+		}
+		err = proposeQuery("MAKE", args, nil)
+	default:
+		err = execution.MakeTable(table, kind, n)
+	}
+	if err != nil {
+		writeExecError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"table": table})
+}
+
+func dropTable(w http.ResponseWriter, r *http.Request) {
+	table := parser.NormalizeTableKey(r.PathValue("table"))
+
+	var err error
+	switch {
+	case globals.ReplicaOf != "":
+		err = errReadOnly
+	case raft.Enabled():
+		if err = requireTable(table); err == nil {
+			err = proposeQuery("DROP", [][]byte{[]byte(table)}, nil)
+		}
+	default:
+		err = execution.DropTable(table)
+	}
+	if err != nil {
+		writeExecError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"table": table})
+}
+
+// scanTable responds with the items from the start parameter up to, but
+// excluding, the end parameter, to the end of the table if it is empty.
+func scanTable(w http.ResponseWriter, r *http.Request) {
+	var end []byte
+	if e := r.URL.Query().Get("end"); e != "" {
+		end = []byte(e)
+	}
+
+	items, err := execution.Scan(r.PathValue("table"), []byte(r.URL.Query().Get("start")), end)
+	if err != nil {
+		writeExecError(w, err)
+		return
+	}
+
+	resp := make([]jsonItem, len(items))
+	for i, item := range items {
+		resp[i] = newJSONItem(item.Key, item.Value)
+	}
+	writeJSON(w, http.StatusOK, map[string][]jsonItem{"items": resp})
+}
+
+// -------Keys------------------------------------------------------------------
+
+func getKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	value, found, err := execution.Get(r.PathValue("table"), []byte(key))
+	if err != nil {
+		writeExecError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("key %s not found", key))
+		return
+	}
+	writeJSON(w, http.StatusOK, newJSONItem([]byte(key), value))
+}
+
+func putKey(w http.ResponseWriter, r *http.Request) {
+	table, key := r.PathValue("table"), r.PathValue("key")
+
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(globals.MaxRequestSize)))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, errTooLarge())
+		} else {
+			writeError(w, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	switch {
+	case globals.ReplicaOf != "":
+		err = errReadOnly
+	case raft.Enabled():
+		if err = requireTable(table); err == nil {
+			err = proposeQuery("PUT", [][]byte{[]byte(table), []byte(key), value}, nil)
+		}
+	default:
+		err = execution.Put(table, []byte(key), value)
+	}
+	if err != nil {
+		writeExecError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newJSONItem([]byte(key), value))
+}
+
+// delKey deletes a key and responds with whether it existed. In cluster mode
+// it is checked before the delete is committed.
+func delKey(w http.ResponseWriter, r *http.Request) {
+	table, key := r.PathValue("table"), r.PathValue("key")
+
+	var found bool
+	var err error
+	switch {
+	case globals.ReplicaOf != "":
+		err = errReadOnly
+	case raft.Enabled():
+		_, found, err = execution.Get(table, []byte(key))
+		if err == nil && found {
+			err = proposeQuery("DEL", [][]byte{[]byte(table), []byte(key)}, nil)
+		}
+	default:
+		found, err = execution.Del(table, []byte(key))
+	}
+	if err != nil {
+		writeExecError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"key": key, "deleted": found})
+}
+
+// -------Responses-------------------------------------------------------------
+
+// writeExecError responds with err and the status its cause maps to.
+func writeExecError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, execution.ErrNoTable):
+		status = http.StatusNotFound
+	case errors.Is(err, storage.ErrUnsupported), errors.Is(err, storage.ErrReservedKey),
+		response.CodeOf(err) == response.CodeInvalid:
+		status = http.StatusBadRequest
+	case errors.Is(err, errReadOnly), response.CodeOf(err) == response.CodeDenied:
+		status = http.StatusForbidden
+	case errors.Is(err, raft.ErrNotLeader):
+		status = http.StatusServiceUnavailable
+	}
+	writeError(w, status, err)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("Error writing to client: %v\n", err)
+	}
+}
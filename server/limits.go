@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"orchiddb/globals"
+	"orchiddb/response"
+)
+
+// -----------------------------------------------------------------------------
+// Limits keep misbehaving clients from exhausting the server:
+//
+//   - the listeners together keep at most globals.MaxConns connections open,
+//     and tell the clients over the limit why they are closed
+//   - a connection waiting longer than globals.IdleTimeout for its next request
+//     is closed, and so is one that takes longer than globals.ReadTimeout to
+//     send the rest of a request
+//   - a connection not taking a response within globals.WriteTimeout is closed
+//   - a request larger than globals.MaxRequestSize is refused
+//   - a client address makes at most globals.RateLimit requests per second,
+//     the ones above it are refused
+// -----------------------------------------------------------------------------
+
+// rejectTimeout is how long a connection over the limit has to take the
+// reason it is closed.
+const rejectTimeout = time.Second
+
+var (
+	errTooManyConns = response.Errorf(response.CodeLimit, "too many connections")
+	errReadTimeout  = response.Errorf(response.CodeLimit, "request not complete in time")
+)
+
+// errTooLarge returns the error of a request over globals.MaxRequestSize.
+func errTooLarge() error {
+	return response.Errorf(response.CodeLimit, "request larger than %d bytes", globals.MaxRequestSize)
+}
+
+// errRateLimit returns the error of a request over globals.RateLimit.
+func errRateLimit() error {
+	return response.Errorf(response.CodeLimit, "more than %d requests per second", globals.RateLimit)
+}
+
+// -------Connections-----------------------------------------------------------
+
+// openConns counts the connections of all listeners.
+var openConns atomic.Int64
+
+// limitListener closes the connections it accepts over globals.MaxConns.
+type limitListener struct {
+	net.Listener
+	reject func(conn net.Conn) // Writes why a connection is closed, if set.
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if n := openConns.Add(1); globals.MaxConns <= 0 || n <= int64(globals.MaxConns) {
+			return &limitedConn{Conn: conn}, nil
+		}
+		openConns.Add(-1)
+
+		go func() {
+			if l.reject != nil {
+				conn.SetDeadline(time.Now().Add(rejectTimeout))
+				l.reject(conn)
+			}
+			conn.Close()
+		}()
+	}
+}
+
+// limitedConn is a connection counted by openConns until it is closed.
+type limitedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(func() { openConns.Add(-1) })
+	return c.Conn.Close()
+}
+
+// -------Requests--------------------------------------------------------------
+
+// awaitRequest waits up to globals.IdleTimeout for the next request on conn to
+// start, and gives it globals.ReadTimeout from then on to arrive. Returns the
+// read error, e.g. a timeout, if no request started.
+func awaitRequest(conn net.Conn, r *bufio.Reader) error {
+	if err := setReadTimeout(conn, globals.IdleTimeout); err != nil {
+		return err
+	}
+	if _, err := r.Peek(1); err != nil {
+		return err
+	}
+	return setReadTimeout(conn, globals.ReadTimeout)
+}
+
+// setReadTimeout makes reads from conn fail after d, never if d is 0.
+func setReadTimeout(conn net.Conn, d time.Duration) error {
+	if d <= 0 {
+		return conn.SetReadDeadline(time.Time{})
+	}
+	return conn.SetReadDeadline(time.Now().Add(d))
+}
+
+// setWriteTimeout makes writes to conn fail after d, never if d is 0.
+func setWriteTimeout(conn net.Conn, d time.Duration) error {
+	if d <= 0 {
+		return conn.SetWriteDeadline(time.Time{})
+	}
+	return conn.SetWriteDeadline(time.Now().Add(d))
+}
+
+// readLine reads a query line without its line ending, after waiting for it
+// with awaitRequest. A line over globals.MaxRequestSize is read to its end and
+// dropped, and errTooLarge returned. A line that does not arrive in time
+// returns errReadTimeout.
+func readLine(conn net.Conn, r *bufio.Reader) (string, error) {
+	if err := awaitRequest(conn, r); err != nil {
+		return "", err
+	}
+
+	var line []byte
+	tooLarge := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			line = append(line, chunk...)
+			// The line ending does not count.
+			if len(line) > globals.MaxRequestSize+2 {
+				tooLarge, line = true, nil
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		// The last line may have no line ending.
+		if err == nil || (errors.Is(err, io.EOF) && len(line) > 0) {
+			break
+		}
+		if isTimeout(err) {
+			return "", errReadTimeout
+		}
+		return "", err
+	}
+
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	if tooLarge || len(line) > globals.MaxRequestSize {
+		return "", errTooLarge()
+	}
+	return string(line), nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// -------Rate Limits-----------------------------------------------------------
+
+// rates holds a token bucket per client address, of globals.RateLimit tokens
+// refilled at globals.RateLimit tokens per second.
+var rates struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time // When full buckets were last removed.
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time // When tokens was last refilled.
+}
+
+// refill adds the tokens since the last refill to b.
+func (b *bucket) refill(now time.Time, limit float64) {
+	b.tokens = min(limit, b.tokens+now.Sub(b.last).Seconds()*limit)
+	b.last = now
+}
+
+// allowRequest takes a token of the client at addr, a host:port, and returns
+// whether it had one.
+func allowRequest(addr string) bool {
+	if globals.RateLimit <= 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	limit := float64(globals.RateLimit)
+	now := time.Now()
+
+	rates.mu.Lock()
+	defer rates.mu.Unlock()
+
+	if rates.buckets == nil {
+		rates.buckets = map[string]*bucket{}
+	}
+	// A full bucket is the same as none, so the ones of clients gone quiet go.
+	if now.Sub(rates.pruned) > time.Minute {
+		for h, b := range rates.buckets {
+			if b.refill(now, limit); b.tokens >= limit {
+				delete(rates.buckets, h)
+			}
+		}
+		rates.pruned = now
+	}
+
+	b, found := rates.buckets[host]
+	if !found {
+		b = &bucket{tokens: limit, last: now}
+		rates.buckets[host] = b
+	}
+	b.refill(now, limit)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,696 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"orchiddb/auth"
+	"orchiddb/execution"
+	"orchiddb/globals"
+	"orchiddb/raft"
+	"orchiddb/response"
+)
+
+// -----------------------------------------------------------------------------
+// The RESP listener speaks the protocol of Redis, version 2, so Redis clients
+// and tools can use Orchid tables. Redis database n is the table named
+// globals.RespTables followed by n, e.g. db0, which is selected first. A
+// database's table is made on its first write, reading a database without a
+// table finds no keys.
+//
+// Commands are arrays of bulk strings, or inline lines of space separated
+// arguments as typed into telnet. Responses are written once no more pipelined
+// commands are buffered.
+// -----------------------------------------------------------------------------
+
+const (
+	maxRespLine = 64 << 10 // The longest inline command or RESP header line.
+	maxRespArgs = 1 << 20  // The most arguments of a command.
+
+	defaultScanCount = 10
+	keysPage         = 1024 // How many keys KEYS reads from a worker at once.
+)
+
+var (
+	errRespProtocol  = errors.New("Protocol error")
+	errRespReadOnly  = errors.New("READONLY You can't write against a read only replica.")
+	errRespNoAuth    = errors.New("NOAUTH Authentication required.")
+	errRespWrongPass = errors.New("WRONGPASS invalid username-password pair")
+	errRespNoPerm    = errors.New("NOPERM")
+)
+
+// respArity holds the least and most arguments of every command, -1 for any.
+var respArity = map[string][2]int{
+	"PING": {0, 1}, "ECHO": {1, 1}, "SELECT": {1, 1}, "QUIT": {0, 0},
+	"COMMAND": {0, -1}, "GET": {1, 1}, "SET": {2, 2}, "DEL": {1, -1},
+	"EXISTS": {1, -1}, "INCR": {1, 1}, "DECR": {1, 1}, "INCRBY": {2, 2},
+	"DECRBY": {2, 2}, "SCAN": {1, 5}, "KEYS": {1, 1}, "AUTH": {1, 2},
+}
+
+// respRights holds the right on the database's table every command needs.
+var respRights = map[string]auth.Right{
+	"GET": auth.RightRead, "EXISTS": auth.RightRead, "SCAN": auth.RightRead,
+	"KEYS": auth.RightRead, "SET": auth.RightWrite, "DEL": auth.RightWrite,
+	"INCR": auth.RightWrite, "DECR": auth.RightWrite, "INCRBY": auth.RightWrite,
+	"DECRBY": auth.RightWrite,
+}
+
+func NewRespServer() (*Server, error) {
+	addr := fmt.Sprintf("%s:%d", globals.Address, globals.RespPort)
+	l, err := listen(addr, func(conn net.Conn) {
+		io.WriteString(conn, "-ERR max number of clients reached\r\n")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create RESP listener for %s: %w", addr, err)
+	}
+
+	return &Server{
+		address:  globals.Address,
+		port:     globals.RespPort,
+		listener: l,
+		handle:   handleResp,
+	}, nil
+}
+
+// respSession is the state of a RESP connection.
+type respSession struct {
+	w    *bufio.Writer
+	db   int
+	user string // The user the connection authenticated as, if any.
+}
+
+// Parses the incoming RESP commands and executes them on the tables of the
+// selected database.
+func handleResp(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			fmt.Printf("Error closing connection: %v\n", err)
+		}
+	}()
+
+	r := bufio.NewReaderSize(conn, maxRespLine)
+	s := &respSession{w: bufio.NewWriter(conn)}
+
+	for {
+		if err := awaitRequest(conn, r); err != nil {
+			return
+		}
+		args, err := readRespCommand(r)
+		if errors.Is(err, errRespProtocol) {
+			s.error(err)
+			s.w.Flush()
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !isTimeout(err) {
+				fmt.Println("read error:", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		setWriteTimeout(conn, globals.WriteTimeout)
+		var quit bool
+		if allowRequest(conn.RemoteAddr().String()) {
+			quit = s.serve(args)
+		} else {
+			s.error(errRateLimit())
+		}
+		if r.Buffered() == 0 || quit {
+			if err := s.w.Flush(); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// serve executes the command args and writes its reply. Returns true if the
+// client quits.
+func (s *respSession) serve(args [][]byte) (quit bool) {
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+
+	bounds, known := respArity[name]
+	switch {
+	case auth.Enabled() && s.user == "" && name != "AUTH" && name != "QUIT":
+		s.error(errRespNoAuth)
+		return false
+	case !known:
+		s.error(fmt.Errorf("unknown command '%s'", name))
+		return false
+	case len(args) < bounds[0] || (bounds[1] >= 0 && len(args) > bounds[1]):
+		s.error(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return false
+	}
+
+	table := fmt.Sprintf("%s%d", globals.RespTables, s.db)
+	if need, ok := respRights[name]; ok {
+		if err := auth.Check(s.user, table, need); err != nil {
+			s.error(fmt.Errorf("%w %w", errRespNoPerm, err))
+			return false
+		}
+	}
+
+	switch name {
+	case "PING":
+		if len(args) == 1 {
+			s.bulk(args[0])
+		} else {
+			s.simple("PONG")
+		}
+	case "ECHO":
+		s.bulk(args[0])
+	case "SELECT":
+		db, err := strconv.Atoi(string(args[0]))
+		if err != nil || db < 0 {
+			s.error(errors.New("invalid DB index"))
+			break
+		}
+		s.db = db
+		s.simple("OK")
+	case "QUIT":
+		s.simple("OK")
+		return true
+	case "AUTH":
+		// AUTH with only a password is for the user called default.
+		user, password := "default", string(args[0])
+		if len(args) == 2 {
+			user, password = string(args[0]), string(args[1])
+		}
+		if !auth.Enabled() {
+			s.error(errors.New("AUTH called without any users configured, see -users"))
+			break
+		}
+		u, err := auth.Authenticate(user, password)
+		if err != nil {
+			s.error(errRespWrongPass)
+			break
+		}
+		s.user = u.Name
+		s.simple("OK")
+	case "COMMAND":
+		s.array(0) // Clients ask for the command table, there is none to give.
+	case "GET":
+		value, found, err := execution.Get(table, args[0])
+		switch {
+		case err != nil && !errors.Is(err, execution.ErrNoTable):
+			s.error(err)
+		case !found:
+			s.null()
+		default:
+			s.bulk(value)
+		}
+	case "SET":
+		if err := set(s.user, table, args[0], args[1]); err != nil {
+			s.error(err)
+			break
+		}
+		s.simple("OK")
+	case "DEL":
+		n, err := del(table, args)
+		if err != nil {
+			s.error(err)
+			break
+		}
+		s.integer(n)
+	case "EXISTS":
+		n, err := exists(table, args)
+		if err != nil {
+			s.error(err)
+			break
+		}
+		s.integer(n)
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		delta := int64(1)
+		if len(args) == 2 {
+			d, err := strconv.ParseInt(string(args[1]), 10, 64)
+			if err != nil {
+				s.error(errors.New("value is not an integer or out of range"))
+				break
+			}
+			delta = d
+		}
+		if strings.HasPrefix(name, "DECR") {
+			if delta == math.MinInt64 {
+				s.error(errors.New("decrement would overflow"))
+				break
+			}
+			delta = -delta
+		}
+		n, err := incr(s.user, table, args[0], delta)
+		if err != nil {
+			s.error(err)
+			break
+		}
+		s.integer(n)
+	case "SCAN":
+		s.scan(table, args)
+	case "KEYS":
+		keys, err := matchingKeys(table, args[0])
+		if err != nil {
+			s.error(err)
+			break
+		}
+		s.array(len(keys))
+		for _, key := range keys {
+			s.bulk(key)
+		}
+	}
+	return false
+}
+
+// -------Commands--------------------------------------------------------------
+
+// set puts key to value in table, making the table if it does not exist.
+func set(user, table string, key, value []byte) error {
+	if err := writable(user, table); err != nil {
+		return err
+	}
+	if raft.Enabled() {
+		return proposeQuery("PUT", [][]byte{[]byte(table), key, value}, nil)
+	}
+	return execution.Put(table, key, value)
+}
+
+// del removes the keys from table and returns how many existed.
+// In cluster mode the keys are counted before their removal is committed.
+func del(table string, keys [][]byte) (int64, error) {
+	if globals.ReplicaOf != "" {
+		return 0, errRespReadOnly
+	}
+
+	var n int64
+	for _, key := range keys {
+		var found bool
+		var err error
+		if raft.Enabled() {
+			_, found, err = execution.Get(table, key)
+			if err == nil && found {
+				err = proposeQuery("DEL", [][]byte{[]byte(table), key}, nil)
+			}
+		} else {
+			found, err = execution.Del(table, key)
+		}
+		if errors.Is(err, execution.ErrNoTable) {
+			return 0, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if found {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// exists returns how many of the keys are in table, counting repeated keys
+// every time.
+func exists(table string, keys [][]byte) (int64, error) {
+	var n int64
+	for _, key := range keys {
+		_, found, err := execution.Get(table, key)
+		if errors.Is(err, execution.ErrNoTable) {
+			return 0, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if found {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// incr adds delta to the integer value of key in table, making the table if
+// it does not exist, and returns the result.
+func incr(user, table string, key []byte, delta int64) (int64, error) {
+	if err := writable(user, table); err != nil {
+		return 0, err
+	}
+	if !raft.Enabled() {
+		return execution.Incr(table, key, delta)
+	}
+
+	// The INCR query responds to conn once its table's worker executed it.
+	conn := &captureConn{}
+	args := [][]byte{[]byte(table), key, strconv.AppendInt(nil, delta, 10)}
+	if err := proposeQuery("INCR", args, conn); err != nil {
+		return 0, err
+	}
+	if err := execution.Await(table); err != nil {
+		return 0, err
+	}
+	reply, err := response.Read(bufio.NewReader(&conn.buf))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(reply.Value), 10, 64)
+}
+
+// scan replies with the cursor to continue from and those of the next COUNT
+// keys of table after the cursor that match the MATCH pattern. The cursor
+// encodes the last key scanned, so keys added or removed during a scan do not
+// make it skip others.
+func (s *respSession) scan(table string, args [][]byte) {
+	after, err := decodeCursor(args[0])
+	if err != nil {
+		s.error(err)
+		return
+	}
+
+	var pattern []byte
+	count := defaultScanCount
+	for opts := args[1:]; len(opts) > 0; opts = opts[2:] {
+		if len(opts) < 2 {
+			s.error(errors.New("syntax error"))
+			return
+		}
+		switch strings.ToUpper(string(opts[0])) {
+		case "MATCH":
+			pattern = opts[1]
+		case "COUNT":
+			count, err = strconv.Atoi(string(opts[1]))
+			if err != nil || count < 1 {
+				s.error(errors.New("value is not an integer or out of range"))
+				return
+			}
+		default:
+			s.error(errors.New("syntax error"))
+			return
+		}
+	}
+
+	keys, err := tableKeys(table, after, count)
+	if err != nil {
+		s.error(err)
+		return
+	}
+
+	next := []byte("0")
+	if len(keys) == count {
+		next = encodeCursor(keys[len(keys)-1])
+	}
+	if pattern != nil {
+		keys = slices.DeleteFunc(keys, func(key []byte) bool {
+			return !globMatch(pattern, key)
+		})
+	}
+
+	s.array(2)
+	s.bulk(next)
+	s.array(len(keys))
+	for _, key := range keys {
+		s.bulk(key)
+	}
+}
+
+// encodeCursor returns the SCAN cursor that continues after key. Clients
+// expect a number, with 0 ending the scan, so it is the decimal number whose
+// bytes are 1 followed by key.
+func encodeCursor(key []byte) []byte {
+	return new(big.Int).SetBytes(append([]byte{1}, key...)).Append(nil, 10)
+}
+
+// decodeCursor returns the key a SCAN cursor continues after, nil for 0.
+func decodeCursor(cursor []byte) ([]byte, error) {
+	if string(cursor) == "0" {
+		return nil, nil
+	}
+	n, ok := new(big.Int).SetString(string(cursor), 10)
+	if !ok || n.Sign() <= 0 || n.Bytes()[0] != 1 {
+		return nil, errors.New("invalid cursor")
+	}
+	return n.Bytes()[1:], nil
+}
+
+// tableKeys returns up to n keys of table after the key after, in key order.
+// A table that does not exist has no keys.
+func tableKeys(table string, after []byte, n int) ([][]byte, error) {
+	keys, err := execution.Keys(table, after, n)
+	if errors.Is(err, execution.ErrNoTable) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+// matchingKeys returns the keys of table that match pattern, reading them
+// from the table's worker keysPage at a time so other commands run between.
+func matchingKeys(table string, pattern []byte) ([][]byte, error) {
+	var matched [][]byte
+	var after []byte
+	for {
+		keys, err := tableKeys(table, after, keysPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if globMatch(pattern, key) {
+				matched = append(matched, key)
+			}
+		}
+		if len(keys) < keysPage {
+			return matched, nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+// writable returns an error if the server cannot write to table, and makes
+// table if it does not exist and user has the admin right to make it.
+func writable(user, table string) error {
+	if globals.ReplicaOf != "" {
+		return errRespReadOnly
+	}
+	if slices.Contains(execution.TableNames(), table) {
+		return nil
+	}
+	if err := auth.Check(user, table, auth.RightAdmin); err != nil {
+		return fmt.Errorf("%w %w", errRespNoPerm, err)
+	}
+	if raft.Enabled() {
+		return proposeQuery("MAKE", [][]byte{[]byte(table)}, nil)
+	}
+	return execution.MakeTable(table, "", 0)
+}
+
+// captureConn is a net.Conn that keeps the response written to it.
+// Only Write is ever called by the execution of a query.
+type captureConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *captureConn) Write(b []byte) (int, error) { return c.buf.Write(b) }
+
+// globMatch returns whether s matches the Redis glob pattern, where * matches
+// any bytes, ? any byte, [abc], [^abc] and [a-z] a byte of a set and \ escapes
+// the next byte.
+//
+// On a mismatch only the last * takes one more byte and matching resumes after
+// it, as whatever earlier stars took the last one can take too. Matching thus
+// takes at most len(pattern)*len(s) steps, however many stars there are.
+func globMatch(pattern, s []byte) bool {
+	var starPattern, starS []byte
+	star := false
+	for {
+		if len(pattern) > 0 && pattern[0] == '*' {
+			pattern = pattern[1:]
+			starPattern, starS, star = pattern, s, true
+			continue
+		}
+		if len(pattern) == 0 && len(s) == 0 {
+			return true
+		}
+		if rest, ok := matchByte(pattern, s); ok {
+			pattern, s = rest, s[1:]
+			continue
+		}
+		if !star || len(starS) == 0 {
+			return false
+		}
+		starS = starS[1:]
+		pattern, s = starPattern, starS
+	}
+}
+
+// matchByte returns whether the first byte of s matches the start of pattern,
+// which is not a *, and the pattern after it.
+func matchByte(pattern, s []byte) ([]byte, bool) {
+	if len(pattern) == 0 || len(s) == 0 {
+		return nil, false
+	}
+	switch pattern[0] {
+	case '?':
+		return pattern[1:], true
+	case '[':
+		ok, rest := matchSet(pattern[1:], s[0])
+		return rest, ok
+	case '\\':
+		if len(pattern) > 1 {
+			pattern = pattern[1:]
+		}
+	}
+	return pattern[1:], s[0] == pattern[0]
+}
+
+// matchSet returns whether c is in the set at the start of pattern, after its
+// opening bracket, and the pattern after the set's closing bracket.
+func matchSet(pattern []byte, c byte) (bool, []byte) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		lo := pattern[0]
+		if lo == '\\' && len(pattern) > 1 {
+			pattern = pattern[1:]
+			lo = pattern[0]
+		}
+		hi := lo
+		if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+			hi = pattern[2]
+			pattern = pattern[2:]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		if lo <= c && c <= hi {
+			match = true
+		}
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:] // The closing bracket.
+	}
+	return match != negate, pattern
+}
+
+// -------Protocol--------------------------------------------------------------
+
+// readRespCommand reads a command as its arguments, the first being the
+// command's name. Returns errRespProtocol for malformed input and for
+// arguments over globals.MaxRequestSize in total.
+func readRespCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readRespLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		var args [][]byte
+		for _, field := range bytes.Fields(line) {
+			args = append(args, bytes.Clone(field))
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxRespArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRespProtocol)
+	}
+
+	args := make([][]byte, 0, max(n, 0))
+	total := 0
+	for range n {
+		line, err := readRespLine(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errRespProtocol, line[:min(len(line), 1)])
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRespProtocol)
+		}
+		if total += size; total > globals.MaxRequestSize {
+			return nil, fmt.Errorf("%w: %w", errRespProtocol, errTooLarge())
+		}
+
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if !bytes.HasSuffix(arg, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: bulk string not followed by CRLF", errRespProtocol)
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// readRespLine reads a line without its line ending, which is valid only
+// until the next read from r.
+func readRespLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("%w: too big inline request", errRespProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r")), nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (s *respSession) simple(str string) {
+	fmt.Fprintf(s.w, "+%s\r\n", str)
+}
+
+// error writes err as an error reply of kind ERR, or READONLY for writes to
+// a replica, on a single line.
+func (s *respSession) error(err error) {
+	msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+
+	// Errors with a code of their own are not prefixed with ERR.
+	switch {
+	case errors.Is(err, errRespReadOnly), errors.Is(err, errRespNoAuth), errors.Is(err, errRespWrongPass),
+		errors.Is(err, errRespNoPerm):
+	default:
+		msg = "ERR " + msg
+	}
+	fmt.Fprintf(s.w, "-%s\r\n", msg)
+}
+
+func (s *respSession) integer(n int64) {
+	fmt.Fprintf(s.w, ":%d\r\n", n)
+}
+
+func (s *respSession) bulk(b []byte) {
+	fmt.Fprintf(s.w, "$%d\r\n", len(b))
+	s.w.Write(b)
+	s.w.WriteString("\r\n")
+}
+
+// null writes the null bulk string of a missing value.
+func (s *respSession) null() {
+	s.w.WriteString("$-1\r\n")
+}
+
+func (s *respSession) array(n int) {
+	fmt.Fprintf(s.w, "*%d\r\n", n)
+}
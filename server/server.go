@@ -6,10 +6,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 
+	"orchiddb/auth"
 	"orchiddb/execution"
 	"orchiddb/globals"
 	"orchiddb/parser"
+	"orchiddb/raft"
+	"orchiddb/response"
+	"orchiddb/wire"
 )
 
 type Server struct {
@@ -17,11 +22,13 @@ type Server struct {
 
 	address string
 	port    int
+
+	handle func(conn net.Conn) // Serves a connection of the server's protocol.
 }
 
 func NewServer() (*Server, error) {
 	addr := fmt.Sprintf("%s:%d", globals.Address, globals.Port)
-	l, err := net.Listen("tcp", addr)
+	l, err := listen(addr, func(conn net.Conn) { response.WriteError(conn, errTooManyConns) })
 	if err != nil {
 		return nil, fmt.Errorf("cannot create listener for %s: %w", addr, err)
 	}
@@ -30,6 +37,7 @@ func NewServer() (*Server, error) {
 		address:  globals.Address,
 		port:     globals.Port,
 		listener: l,
+		handle:   handleConnection,
 	}, nil
 }
 
@@ -42,7 +50,7 @@ func (server *Server) Run() error {
 			continue
 		}
 
-		go handleConnection(conn)
+		go server.handle(conn)
 	}
 
 	return nil
@@ -56,16 +64,91 @@ func handleConnection(conn net.Conn) {
 		}
 	}()
 
-	scanner := bufio.NewScanner(conn)
+	// A binary client starts with a handshake byte that no query line starts
+	// with.
+	r := bufio.NewReader(conn)
+	if err := awaitRequest(conn, r); err != nil {
+		return
+	}
+	if first, err := r.Peek(1); err == nil && first[0] == wire.Handshake {
+		handleBinary(conn, r)
+		return
+	}
+
+	// Responses go through the connection's writer, which the deferred Close
+	// flushes and closes.
+	w := newConnWriter(conn)
+	conn = w
+
+	// Commands between BATCH and END are collected and executed together.
+	// Errors inside a batch abort it and are reported once END is reached.
+	var batch *openBatch
+
+	// The user the connection authenticated as, if any.
+	var user string
+
+	for {
+		line, err := readLine(conn, r)
+		switch {
+		case errors.Is(err, errReadTimeout):
+			// What follows the partial request cannot be told apart from it.
+			response.WriteError(conn, err)
+			return
+		case response.CodeOf(err) == response.CodeLimit && batch != nil:
+			batch.fail(err)
+			continue
+		case response.CodeOf(err) == response.CodeLimit:
+			if err := response.WriteError(conn, err); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+			continue
+		case err != nil:
+			// The writer closes the connection of a client not reading.
+			if !errors.Is(err, io.EOF) && !isTimeout(err) && !errors.Is(err, net.ErrClosed) {
+				fmt.Println("read error:", err)
+			}
+			return
+		}
+
+		id, rawQuery, err := response.CutID(line)
+		if err != nil {
+			if err := response.WriteError(conn, err); err != nil {
+				fmt.Printf("Error writing parse error to client: %v\n", err)
+				return
+			}
+			continue
+		}
+		// The response is tagged with the request ID, if there is one.
+		out := w.respondTo(id)
 
-	for scanner.Scan() {
-		rawQuery := scanner.Text()
+		// The lines of a batch count as a single request.
+		if batch == nil && !allowRequest(conn.RemoteAddr().String()) {
+			if err := response.WriteError(out, errRateLimit()); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+			continue
+		}
 
 		l := parser.NewLexer(rawQuery)
 		p := parser.NewParser(l)
 		cmd := p.ParseCommand()
+
+		if batch != nil {
+			if done := batch.add(cmd, rawQuery); !done {
+				continue
+			}
+			if err := batch.respond(out); err != nil {
+				fmt.Printf("Error writing batch result to client: %v\n", err)
+				return
+			}
+			batch = nil
+			continue
+		}
+
 		if cmd == nil || cmd.Command == nil {
-			if _, err := io.WriteString(conn, "ERR: parseError\n"); err != nil {
+			if err := response.WriteError(out, errParse); err != nil {
 				fmt.Printf("Error writing parse error to client: %v\n", err)
 				return
 			}
@@ -74,19 +157,300 @@ func handleConnection(conn net.Conn) {
 
 		fmt.Println("parsed command:", cmd.Command.String())
 
+		// With authentication, nothing but AUTH runs until it succeeded.
+		if _, isAuth := cmd.Command.(*parser.AuthCommand); auth.Enabled() && user == "" && !isAuth {
+			if err := response.WriteError(out, errNoAuth); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+			continue
+		}
+		if err := authorize(user, cmd.Command); err != nil {
+			if err := response.WriteError(out, err); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+			continue
+		}
+
+		if globals.ReplicaOf != "" && isWrite(cmd.Command) {
+			if err := response.WriteError(out, errReadOnly); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+			continue
+		}
+
+		parser.BindConn(cmd.Command, out)
+
 		// Handle non-storage engine commands here.
 		switch t := cmd.Command.(type) {
-		case *parser.GetCommand:
-			t.Conn = conn
 		case *parser.StopCommand:
 			globals.PerformShutdown = true
+			if err := response.WriteOK(out); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+			}
+			return
+		case *parser.WatchCommand:
+			// The connection only streams changes from now on, and is never
+			// idle.
+			setReadTimeout(conn, 0)
+			if err := execution.Watch(w.streamTo(id), t); err != nil {
+				fmt.Printf("Error streaming to watcher: %v\n", err)
+			}
+			return
+		case *parser.SyncCommand:
+			// The connection only streams to the replica from now on.
+			setReadTimeout(conn, 0)
+			if err := execution.Sync(w.streamTo(id)); err != nil {
+				fmt.Printf("Error streaming to replica: %v\n", err)
+			}
 			return
+		case *parser.JoinCommand, *parser.LeaveCommand, *parser.ClusterCommand:
+			if err := cluster(out, t); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+			continue
+		case *parser.AuthCommand, *parser.AddUserCommand, *parser.DelUserCommand, *parser.UsersCommand,
+			*parser.GrantCommand, *parser.RevokeCommand:
+			if err := users(out, &user, t); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+			continue
+		case *parser.TablesCommand:
+			if err := writeTables(out, user); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+			continue
+		case *parser.BatchCommand:
+			batch = &openBatch{user: user}
+			continue
+		case *parser.EndCommand:
+			err := response.Errorf(response.CodeInvalid, "END without BATCH")
+			if err := response.WriteError(out, err); err != nil {
+				fmt.Printf("Error writing to client: %v\n", err)
+				return
+			}
+			continue
+		}
+
+		// In cluster mode writes are applied once committed to the Raft log.
+		if raft.Enabled() && isWrite(cmd.Command) {
+			if err := raft.Propose(rawQuery, out); err != nil {
+				if err := response.WriteError(out, err); err != nil {
+					fmt.Printf("Error writing to client: %v\n", err)
+					return
+				}
+			}
+			continue
 		}
 
 		execution.ExecuteCommand(cmd)
 	}
+}
+
+var (
+	errParse  = response.Errorf(response.CodeParse, "invalid query")
+	errNoAuth = response.Errorf(response.CodeNoAuth, "AUTH(user, password) first")
+)
+
+// isWrite returns whether cmd changes a table or the set of tables, which a
+// replica only takes from its primary.
+func isWrite(cmd parser.Node) bool {
+	switch cmd.(type) {
+	case *parser.PutCommand, *parser.DelCommand, *parser.IncrCommand,
+		*parser.AppendCommand, *parser.GetSetCommand, *parser.CasCommand,
+		*parser.CondPutCommand, *parser.BatchCommand, *parser.MakeCommand,
+		*parser.DropCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// authorize returns a DENIED error if the user may not run cmd. The user
+// management commands check the user's rights themselves.
+func authorize(user string, cmd parser.Node) error {
+	switch cmd.(type) {
+	case *parser.StopCommand, *parser.SyncCommand, *parser.JoinCommand, *parser.LeaveCommand:
+		return auth.CheckAdmin(user, cmd.TokenLiteral())
+	case *parser.MakeCommand, *parser.DropCommand:
+		return auth.Check(user, cmd.GetTable(), auth.RightAdmin)
+	}
+
+	switch {
+	case cmd.GetTable() == "":
+		return nil
+	case isWrite(cmd):
+		return auth.Check(user, cmd.GetTable(), auth.RightWrite)
+	default:
+		return auth.Check(user, cmd.GetTable(), auth.RightRead)
+	}
+}
+
+// cluster executes the cluster membership and status commands.
+func cluster(conn net.Conn, cmd parser.Node) error {
+	if !raft.Enabled() {
+		return response.WriteError(conn, response.Errorf(response.CodeUnsupported, "not running in a cluster"))
+	}
+
+	var err error
+	switch t := cmd.(type) {
+	case *parser.JoinCommand:
+		err = raft.AddMember(t.ID, t.Addr)
+	case *parser.LeaveCommand:
+		err = raft.RemoveMember(t.ID)
+	case *parser.ClusterCommand:
+		return raft.WriteStatus(conn)
+	}
+
+	if err != nil {
+		return response.WriteError(conn, err)
+	}
+	return response.WriteOK(conn)
+}
+
+// users executes the authentication and user management commands of a
+// connection authenticated as *user, which a successful AUTH sets.
+func users(conn net.Conn, user *string, cmd parser.Node) error {
+	if !auth.Enabled() {
+		return response.WriteError(conn, response.Errorf(response.CodeUnsupported, "authentication is not enabled"))
+	}
 
-	if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) {
-		fmt.Println("read error:", err)
+	var err error
+	switch t := cmd.(type) {
+	case *parser.AuthCommand:
+		var u *auth.User
+		if u, err = auth.Authenticate(t.User, t.Password); err == nil {
+			*user = u.Name
+		}
+	case *parser.AddUserCommand:
+		switch {
+		case !auth.IsAdmin(*user):
+			err = auth.ErrNotAdmin
+		case t.Role != "" && t.Role != "admin":
+			err = response.Errorf(response.CodeInvalid, "unknown role %s, use admin or leave it out", t.Role)
+		default:
+			err = auth.AddUser(t.User, t.Password, t.Role == "admin")
+		}
+	case *parser.DelUserCommand:
+		if !auth.IsAdmin(*user) {
+			err = auth.ErrNotAdmin
+		} else {
+			err = auth.RemoveUser(t.User)
+		}
+	case *parser.GrantCommand:
+		var right auth.Right
+		if !auth.IsAdmin(*user) {
+			err = auth.ErrNotAdmin
+		} else if right, err = auth.ParseRight(t.Right); err == nil {
+			err = auth.AddGrant(t.User, t.Pattern, right)
+		}
+	case *parser.RevokeCommand:
+		if !auth.IsAdmin(*user) {
+			err = auth.ErrNotAdmin
+		} else {
+			err = auth.RemoveGrant(t.User, t.Pattern)
+		}
+	case *parser.UsersCommand:
+		if !auth.IsAdmin(*user) {
+			return response.WriteError(conn, auth.ErrNotAdmin)
+		}
+		var b strings.Builder
+		for _, u := range auth.Users() {
+			role := "user"
+			if u.Admin {
+				role = "admin"
+			}
+			fmt.Fprintf(&b, "%s %s", u.Name, role)
+			for _, g := range u.Grants {
+				fmt.Fprintf(&b, " %s", g)
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteString("END\n")
+		_, err := io.WriteString(conn, b.String())
+		return err
+	}
+
+	if err != nil {
+		return response.WriteError(conn, err)
+	}
+	return response.WriteOK(conn)
+}
+
+// writeTables writes the name of every table the user can read as a line,
+// followed by an END line.
+func writeTables(conn net.Conn, user string) error {
+	var b strings.Builder
+	for _, name := range execution.TableNames() {
+		if auth.Check(user, name, auth.RightRead) == nil {
+			fmt.Fprintf(&b, "%s\n", name)
+		}
+	}
+	b.WriteString("END\n")
+	_, err := io.WriteString(conn, b.String())
+	return err
+}
+
+// -------Batches---------------------------------------------------------------
+
+// openBatch collects the commands of a connection's batch until its END.
+type openBatch struct {
+	cmds  []*parser.Command
+	lines []string // The queries of cmds, proposed as is in cluster mode.
+	err   error    // The first error in the batch, which aborts it.
+	user  string   // The user of the connection, which needs write rights.
+}
+
+// add adds cmd, parsed from line, to the batch. Returns true once cmd ends the
+// batch.
+func (b *openBatch) add(cmd *parser.Command, line string) bool {
+	if cmd == nil || cmd.Command == nil {
+		b.fail(errParse)
+		return false
+	}
+
+	switch cmd.Command.(type) {
+	case *parser.EndCommand:
+		return true
+	case *parser.PutCommand, *parser.DelCommand:
+		if err := authorize(b.user, cmd.Command); err != nil {
+			b.fail(err)
+		}
+		b.cmds = append(b.cmds, cmd)
+		b.lines = append(b.lines, line)
+	default:
+		b.fail(response.Errorf(response.CodeInvalid, "%s cannot be batched", cmd.Command.TokenLiteral()))
+	}
+	return false
+}
+
+func (b *openBatch) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// respond executes the batch, unless it was aborted, and writes the result to
+// conn. In cluster mode a committed batch responds once applied.
+func (b *openBatch) respond(conn net.Conn) error {
+	var err error
+	if b.err != nil {
+		err = fmt.Errorf("batch aborted: %w", b.err)
+	} else if len(b.cmds) > 0 && raft.Enabled() {
+		if err = raft.ProposeBatch(b.lines, conn); err == nil {
+			return nil
+		}
+	} else if len(b.cmds) > 0 {
+		err = execution.ExecuteBatch(b.cmds)
+	}
+
+	if err != nil {
+		return response.WriteError(conn, err)
 	}
+	return response.WriteOK(conn)
 }
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"orchiddb/globals"
+)
+
+// -----------------------------------------------------------------------------
+// With -tls-cert and -tls-key, the query, RESP and HTTP listeners only accept
+// TLS connections. With -tls-client-ca, clients must also present a certificate
+// signed by one of its authorities. The files are read again on SIGHUP, so
+// certificates can be renewed without a restart: connections made since use
+// the new ones, and a reload that fails keeps the old ones.
+//
+// With -raft-tls-ca as well, the nodes of a cluster talk over mutual TLS: each
+// presents the listeners' certificate, and only accepts peers whose
+// certificate is signed by one of its authorities. Without it Raft traffic is
+// plain TCP, neither encrypted nor authenticated.
+// -----------------------------------------------------------------------------
+
+// tlsConfig is the configuration of the listeners, nil if they are plain TCP.
+var tlsConfig *tls.Config
+
+// tlsFiles holds what was last read from the TLS files.
+var tlsFiles struct {
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool // Nil if clients need no certificate.
+	raftCAs   *x509.CertPool // Nil if Raft traffic is plain TCP.
+}
+
+// ConfigureTLS reads the TLS files given by the runtime options and reloads
+// them on every SIGHUP. Listeners made afterwards accept TLS connections only.
+// Does nothing if no certificate is given.
+func ConfigureTLS() error {
+	if globals.TLSCert == "" && globals.TLSKey == "" {
+		if globals.TLSClientCA != "" {
+			return errors.New("-tls-client-ca needs -tls-cert and -tls-key")
+		}
+		if globals.RaftTLSCA != "" {
+			return errors.New("-raft-tls-ca needs -tls-cert and -tls-key")
+		}
+		return nil
+	}
+	if globals.TLSCert == "" || globals.TLSKey == "" {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if err := reloadTLS(); err != nil {
+		return err
+	}
+
+	tlsConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Every handshake takes the files' latest contents.
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			tlsFiles.mu.RLock()
+			defer tlsFiles.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*tlsFiles.cert},
+			}
+			if tlsFiles.clientCAs != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = tlsFiles.clientCAs
+			}
+			return cfg, nil
+		},
+	}
+
+	go func() {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		for range sighup {
+			if err := reloadTLS(); err != nil {
+				fmt.Printf("Error reloading TLS files, keeping the previous ones: %v\n", err)
+				continue
+			}
+			fmt.Println("reloaded TLS files")
+		}
+	}()
+	return nil
+}
+
+// reloadTLS reads the TLS files, replacing the ones read before only if all
+// of them can be read.
+func reloadTLS() error {
+	cert, err := tls.LoadX509KeyPair(globals.TLSCert, globals.TLSKey)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS certificate: %w", err)
+	}
+
+	clientCAs, err := loadCAs(globals.TLSClientCA)
+	if err != nil {
+		return fmt.Errorf("cannot read TLS client CA: %w", err)
+	}
+	raftCAs, err := loadCAs(globals.RaftTLSCA)
+	if err != nil {
+		return fmt.Errorf("cannot read Raft TLS CA: %w", err)
+	}
+
+	tlsFiles.mu.Lock()
+	tlsFiles.cert, tlsFiles.clientCAs, tlsFiles.raftCAs = &cert, clientCAs, raftCAs
+	tlsFiles.mu.Unlock()
+	return nil
+}
+
+// loadCAs reads the PEM file of certificate authorities at path, nil if path
+// is empty.
+func loadCAs(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// RaftTLS returns the TLS configuration of the Raft listener, and one of a
+// connection to a peer which takes the files' latest contents on every call.
+// Both are nil if Raft traffic is plain TCP.
+func RaftTLS() (listen *tls.Config, dial func() *tls.Config) {
+	if globals.RaftTLSCA == "" || tlsConfig == nil {
+		return nil, nil
+	}
+
+	listen = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			tlsFiles.mu.RLock()
+			defer tlsFiles.mu.RUnlock()
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*tlsFiles.cert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    tlsFiles.raftCAs,
+			}, nil
+		},
+	}
+	dial = func() *tls.Config {
+		tlsFiles.mu.RLock()
+		defer tlsFiles.mu.RUnlock()
+
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*tlsFiles.cert},
+			RootCAs:      tlsFiles.raftCAs,
+		}
+	}
+	return listen, dial
+}
+
+// listen listens on addr, accepting TLS connections only if TLS is configured.
+// Connections over the limit are closed after reject wrote why, which it only
+// does in plain TCP, since a TLS client cannot read it before the handshake.
+func listen(addr string, reject func(conn net.Conn)) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		return &limitListener{Listener: l, reject: reject}, nil
+	}
+	return tls.NewListener(&limitListener{Listener: l}, tlsConfig), nil
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"orchiddb/globals"
+	"orchiddb/response"
+)
+
+// -----------------------------------------------------------------------------
+// The responses to a connection's queries are written by whichever goroutine
+// executes them, e.g. the workers of different tables, so they complete in any
+// order. A connWriter hands every response, written whole by a single Write,
+// to a goroutine of the connection that writes them one after another, so
+// responses never interleave. A query with a request ID is bound to a
+// taggedConn, which tags its response with the ID.
+//
+// The goroutines executing queries never wait for a client: a client that
+// lets maxPending bytes of responses pile up, or does not take them within
+// globals.WriteTimeout, has its connection closed. Streams of changes are
+// written by the connection's own goroutine, which waits for the client
+// instead.
+// -----------------------------------------------------------------------------
+
+var errSlowClient = errors.New("client is not reading its responses")
+
+const (
+	// maxPending is how many bytes of responses wait for a slow client before
+	// its connection is closed.
+	maxPending = 4 << 20
+
+	// flushTimeout is how long the responses pending when a connection closes
+	// have to reach the client.
+	flushTimeout = 5 * time.Second
+)
+
+// connWriter is a connection whose writes are queued for its writer goroutine.
+type connWriter struct {
+	net.Conn
+
+	mu      sync.Mutex
+	room    *sync.Cond // Signalled when the pending responses are taken.
+	pending []byte     // The responses not yet taken by the writer goroutine.
+	closed  bool       // Set by Close.
+	err     error      // Why the writer goroutine returned.
+
+	wake chan struct{} // Wakes the writer goroutine for new responses or Close.
+	done chan struct{} // Closed once the writer goroutine returned.
+}
+
+func newConnWriter(conn net.Conn) *connWriter {
+	w := &connWriter{
+		Conn: conn,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	w.room = sync.NewCond(&w.mu)
+	go w.loop()
+	return w
+}
+
+// Write queues b as a whole response. Fails once the connection failed or was
+// closed, and closes the connection of a client that is not reading.
+func (w *connWriter) Write(b []byte) (int, error) {
+	return w.queue(b, false)
+}
+
+// queue queues b as a whole response. If maxPending bytes are pending, it
+// waits for the writer goroutine to take them if wait is set, otherwise it
+// closes the connection.
+func (w *connWriter) queue(b []byte, wait bool) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for {
+		if w.err != nil {
+			return 0, w.err
+		}
+		if w.closed {
+			return 0, net.ErrClosed
+		}
+		// A response larger than maxPending still goes out on its own.
+		if len(w.pending) == 0 || len(w.pending)+len(b) <= maxPending {
+			break
+		}
+		if !wait {
+			// Closing the connection fails the write the writer goroutine
+			// is stuck on, and the reads of the connection's goroutine.
+			w.err = errSlowClient
+			w.Conn.Close()
+			return 0, w.err
+		}
+		w.room.Wait()
+	}
+
+	w.pending = append(w.pending, b...)
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// loop writes the pending responses, all at once, until the connection fails
+// or is closed.
+func (w *connWriter) loop() {
+	defer close(w.done)
+
+	var buf []byte
+	for range w.wake {
+		w.mu.Lock()
+		buf, w.pending = w.pending, buf[:0]
+		closed := w.closed
+		if !closed {
+			// Once closed, the responses left only get Close's flushTimeout.
+			setWriteTimeout(w.Conn, globals.WriteTimeout)
+		}
+		w.room.Broadcast()
+		w.mu.Unlock()
+
+		if len(buf) > 0 {
+			if _, err := w.Conn.Write(buf); err != nil {
+				w.fail(err)
+				return
+			}
+		}
+		if closed {
+			w.fail(net.ErrClosed)
+			return
+		}
+	}
+}
+
+// fail makes the writes fail with err, unless they already fail.
+func (w *connWriter) fail(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.room.Broadcast()
+	w.mu.Unlock()
+}
+
+// Close writes the pending responses, giving the client flushTimeout to read
+// them, and closes the connection. Responses written after Close are dropped.
+func (w *connWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.Conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	<-w.done
+
+	// A connection closed for a slow client is already closed.
+	if err := w.Conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// taggedConn is the connection a query with a request ID responds to.
+type taggedConn struct {
+	*connWriter
+	id   string
+	wait bool // Whether writes wait for the client, see streamTo.
+}
+
+// Write queues b tagged with the request ID.
+func (c *taggedConn) Write(b []byte) (int, error) {
+	if _, err := c.queue(response.Tag(c.id, b), c.wait); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// respondTo returns the connection the response to a query with the request
+// ID id is written to.
+func (w *connWriter) respondTo(id string) net.Conn {
+	if id == "" {
+		return w
+	}
+	return &taggedConn{connWriter: w, id: id}
+}
+
+// streamTo returns the connection a WATCH or SYNC with the request ID id
+// streams to. Its writes wait while maxPending bytes are pending rather than
+// close the connection, as only the connection's own goroutine streams, and a
+// client that stops reading still fails the writes after globals.WriteTimeout.
+func (w *connWriter) streamTo(id string) net.Conn {
+	return &taggedConn{connWriter: w, id: id, wait: true}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"orchiddb/vfs"
+)
+
+// -----------------------------------------------------------------------------
+// Multi-table batches are committed in two phases. Every table of the batch
+// first stages its operations and writes them to a batch log, then a single
+// commit record is written for the batch. Only after the commit record is
+// durable do the tables commit. On startup a batch log is replayed if its
+// batch's commit record exists and discarded otherwise, so a batch is applied
+// to all of its tables or to none of them.
+//
+// A batch log holds the operations as LSM entries followed by a CRC32 of them.
+// -----------------------------------------------------------------------------
+
+// BatchOp is a single put, or delete if Del is set, in a batch.
+type BatchOp struct {
+	Key   []byte
+	Value []byte
+	Del   bool
+}
+
+// ApplyBatch stages ops on the engine in order. The caller commits or rolls
+// back the engine.
+// Every write of a user passes through here, so keys reserved for the engine's
+// own records are refused here for every table kind.
+func ApplyBatch(e Engine, ops []BatchOp) error {
+	for _, op := range ops {
+		if isReservedKey(op.Key) {
+			return ErrReservedKey
+		}
+
+		var err error
+		if op.Del {
+			err = e.Del(op.Key)
+		} else {
+			err = e.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBatchLog writes and syncs the batch log of ops at path in fsys.
+func WriteBatchLog(fsys vfs.FS, path string, ops []BatchOp) error {
+	var buf []byte
+	for _, op := range ops {
+		buf = appendEntry(buf, lsmEntry{key: op.Key, value: op.Value, deleted: op.Del})
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	return writeSynced(fsys, path, buf)
+}
+
+// ReadBatchLog reads the operations of the batch log at path in fsys.
+// Returns an error if the log is incomplete.
+func ReadBatchLog(fsys vfs.FS, path string) ([]BatchOp, error) {
+	f, err := fsys.Open(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, err := f.Size()
+	if err != nil {
+		return nil, err
+	}
+	if size < 4 {
+		return nil, errors.New("batch log is truncated")
+	}
+
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+
+	body := buf[:size-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(buf[size-4:]) {
+		return nil, errors.New("batch log checksum mismatch")
+	}
+
+	var ops []BatchOp
+	r := bufio.NewReader(bytes.NewReader(body))
+	for {
+		e, err := readEntry(r)
+		if errors.Is(err, io.EOF) {
+			return ops, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read batch log: %w", err)
+		}
+		ops = append(ops, BatchOp{Key: e.key, Value: e.value, Del: e.deleted})
+	}
+}
+
+// WriteBatchCommit writes and syncs the commit record of a batch at path in
+// fsys. Once it returns, the batch is committed.
+func WriteBatchCommit(fsys vfs.FS, path string) error {
+	return writeSynced(fsys, path, nil)
+}
+
+// writeSynced creates the file at path in fsys with contents and syncs it.
+func writeSynced(fsys vfs.FS, path string, contents []byte) error {
+	f, err := fsys.Open(path, true)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteAt(contents, 0); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,103 @@
+package storage
+
+// tableCursor walks a table's B-tree in order.
+//
+// The cursor keeps the path from the root to the current node as a stack of
+// frames. Each frame holds the index of the next item to return from its node,
+// all children left of that item have already been visited.
+type tableCursor struct {
+	tbl   *Table
+	stack []cursorFrame
+}
+
+type cursorFrame struct {
+	node  *Node
+	index int
+}
+
+// Cursor returns a cursor over the table's items in key order.
+func (tbl *Table) Cursor() (Cursor, error) {
+	return &tableCursor{tbl: tbl}, nil
+}
+
+func (c *tableCursor) First() (*Item, error) {
+	return c.Seek(nil)
+}
+
+func (c *tableCursor) Seek(key []byte) (*Item, error) {
+	c.tbl.rwMutex.RLock()
+	defer c.tbl.rwMutex.RUnlock()
+
+	c.stack = c.stack[:0]
+
+	node, err := c.tbl.GetNode(c.tbl.meta.RootPageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		found, index := node.findKeyInNode(key)
+		c.stack = append(c.stack, cursorFrame{node: node, index: index})
+		if found || node.isLeaf() {
+			break
+		}
+
+		node, err = c.tbl.GetNode(node.childNodes[index])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.next()
+}
+
+func (c *tableCursor) Next() (*Item, error) {
+	c.tbl.rwMutex.RLock()
+	defer c.tbl.rwMutex.RUnlock()
+
+	return c.next()
+}
+
+// next returns the item at the top of the stack and advances past it.
+// Must be called with the table's read lock held.
+func (c *tableCursor) next() (*Item, error) {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+
+		if top.index >= len(top.node.items) {
+			// Node exhausted, the parent already points at its next item.
+			c.stack = c.stack[:len(c.stack)-1]
+			continue
+		}
+
+		item := top.node.items[top.index]
+		top.index++
+
+		// The subtree right of the returned item comes before the next item.
+		if !top.node.isLeaf() {
+			if err := c.descendLeft(top.node.childNodes[top.index]); err != nil {
+				return nil, err
+			}
+		}
+
+		return item, nil
+	}
+
+	return nil, nil
+}
+
+// descendLeft pushes the path to the leftmost leaf under pn.
+func (c *tableCursor) descendLeft(pn pageNum) error {
+	for {
+		node, err := c.tbl.GetNode(pn)
+		if err != nil {
+			return err
+		}
+
+		c.stack = append(c.stack, cursorFrame{node: node, index: 0})
+		if node.isLeaf() {
+			return nil
+		}
+		pn = node.childNodes[0]
+	}
+}
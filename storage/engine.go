@@ -0,0 +1,81 @@
+package storage
+
+import "errors"
+
+// ErrUnsupported is returned by engines for operations their structure cannot
+// provide, e.g. ordered iteration over a hash index.
+var ErrUnsupported = errors.New("operation not supported by this table kind")
+
+// Engine is the storage structure behind a table.
+//
+// Put and Del are staged and only made durable by Commit. Reads always see
+// staged changes. Engines are not safe for concurrent mutation, the table's
+// worker is the only caller.
+type Engine interface {
+	Get(key []byte) (*Item, error)
+	Put(key []byte, value []byte) error
+	Del(key []byte) error
+
+	// Cursor returns a cursor over the items in key order.
+	Cursor() (Cursor, error)
+
+	Commit() error
+	// Rollback discards the changes staged since the last Commit.
+	Rollback() error
+	Close() error
+
+	// Drop closes the engine and removes all of its files.
+	Drop() error
+}
+
+// Cursor iterates the items of an engine in key order.
+// Every method returns a nil item once the end of the engine is reached.
+type Cursor interface {
+	// First moves to the first item.
+	First() (*Item, error)
+
+	// Seek moves to the first item with a key greater than or equal to key.
+	Seek(key []byte) (*Item, error)
+
+	// Next moves to the item after the current one.
+	Next() (*Item, error)
+}
+
+// ForEach calls fn for every item of e. Engines that cannot iterate in key
+// order, such as hash tables, visit their items in any order.
+func ForEach(e Engine, fn func(item *Item) error) error {
+	if it, ok := e.(interface{ Each(func(*Item) error) error }); ok {
+		return it.Each(fn)
+	}
+
+	cursor, err := e.Cursor()
+	if err != nil {
+		return err
+	}
+	item, err := cursor.First()
+	for ; item != nil && err == nil; item, err = cursor.Next() {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// KindOf returns the table kind of e, one of the Kind constants.
+func KindOf(e Engine) string {
+	switch t := e.(type) {
+	case *Versioned:
+		return KindOf(t.inner)
+	case *Table:
+		if t.IsMemory() {
+			return KindMemory
+		}
+		return KindBTree
+	case *HashTable:
+		return KindHash
+	case *LSM:
+		return KindLSM
+	default:
+		return ""
+	}
+}
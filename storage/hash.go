@@ -0,0 +1,487 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+
+	"orchiddb/globals"
+	"orchiddb/paths"
+	"orchiddb/vfs"
+)
+
+// HashTable is a table kind for point lookups, keys are placed in buckets by
+// an on-disk linear hash index instead of a B-tree.
+//
+// Get, Put and Del read a single bucket regardless of the table's size. The
+// table grows one bucket at a time: once the items fill more than
+// hashMaxLoad of the bucket pages, the bucket at the split pointer is split in
+// two and the pointer advances. Buckets that fill their page before being
+// split continue into overflow pages.
+//
+// Hash tables share the .db file layout of B-tree tables: page 0 is the meta
+// page, page 1 the freelist and meta.RootPageNum the hash header. All pages
+// are committed through the same transaction and WAL.
+//
+// Keys are not kept in order, so Cursor returns ErrUnsupported.
+type HashTable struct {
+	Name string
+
+	rwMutex sync.RWMutex
+
+	meta     *meta
+	freelist *freelist
+	header   *hashHeader
+
+	Txn *Transaction
+}
+
+const (
+	hashInitialBuckets = 4    // Buckets in a new table, level 0.
+	hashMaxLoad        = 0.75 // Fraction of bucket space filled before a split.
+)
+
+// -------Table File Management-------------------------------------------------
+
+// GetHashTable gets the hash table file from the path in fsys.
+// If it does not exist, a new one is created.
+// Returns error, if any.
+func GetHashTable(fsys vfs.FS, path string) (*HashTable, error) {
+	tableName, err := paths.GetStem(path)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := fsys.Exists(path)
+
+	pager, err := OpenPager(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ht *HashTable
+	if exists {
+		ht, err = openHashTable(tableName, pager)
+	} else {
+		ht, err = createHashTable(tableName, pager)
+	}
+	if err != nil {
+		if closeErr := pager.Close(); closeErr != nil {
+			fmt.Println("[ERROR]", closeErr)
+		}
+		return nil, err
+	}
+
+	return ht, nil
+}
+
+// createHashTable initializes a new hash table in the pager with: page 0 =
+// meta; page 1 = freelist, page 2 = hash header, followed by the directory and
+// the initial buckets.
+func createHashTable(name string, pager *Pager) (*HashTable, error) {
+	m := newMeta()
+	m.Kind = metaKindHash
+	fr := newFreelist()
+
+	// ---- write meta (page 0)
+	metaPg := m.serializeToPage()
+	if err := pager.WritePage(metaPg); err != nil {
+		return nil, fmt.Errorf("write meta: %w", err)
+	}
+
+	// ---- write freelist (page 1)
+	flPg := fr.serializeToPage()
+	if err := pager.WritePage(flPg); err != nil {
+		return nil, fmt.Errorf("write freelist: %w", err)
+	}
+
+	if err := pager.Sync(); err != nil {
+		return nil, err
+	}
+
+	ht := &HashTable{
+		Name:     name,
+		rwMutex:  sync.RWMutex{},
+		meta:     m,
+		freelist: fr,
+		header:   &hashHeader{pageNum: m.RootPageNum},
+		Txn:      NewTransaction(pager),
+	}
+	fr.MaxPage = m.RootPageNum
+
+	for range hashInitialBuckets {
+		pn := ht.freelist.GetNextPage()
+		ht.addBucket(pn)
+		ht.Txn.appendPage(pn, &hashPage{pageNum: pn})
+	}
+
+	ht.writeHeader()
+	ht.Txn.meta = m
+	ht.Txn.freelist = fr
+
+	if err := ht.Txn.Commit(); err != nil {
+		return nil, err
+	}
+
+	return ht, nil
+}
+
+// openHashTable opens an existing hash table from the pager, reading page 0
+// (meta), the freelist, the hash header and its directory pages.
+func openHashTable(name string, pager *Pager) (*HashTable, error) {
+	// ---- read meta (page 0)
+	metaPg, err := pager.readPage(MetaPageNum)
+	if err != nil {
+		return nil, fmt.Errorf("read meta: %w", err)
+	}
+	m := newMeta()
+	m.deserializeFromPage(metaPg)
+	if m.Kind != metaKindHash {
+		return nil, fmt.Errorf("%s is not a %s table", name, KindHash)
+	}
+
+	// ---- read freelist (meta.freelistPage)
+	flPg, err := pager.readPage(m.FreelistPageNum)
+	if err != nil {
+		return nil, fmt.Errorf("read freelist: %w", err)
+	}
+	fl := newFreelist()
+	fl.deserializeFromPage(flPg)
+
+	// ---- read header (meta.RootPageNum) and directory
+	hdr, err := readHashHeader(pager, m.RootPageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	txn := NewTransaction(pager)
+	txn.meta = m
+	txn.freelist = fl
+
+	return &HashTable{
+		Name:     name,
+		rwMutex:  sync.RWMutex{},
+		meta:     m,
+		freelist: fl,
+		header:   hdr,
+		Txn:      txn,
+	}, nil
+}
+
+// IsMemory returns whether the table only lives in memory.
+func (ht *HashTable) IsMemory() bool {
+	return ht.Txn.Pager.IsMemory()
+}
+
+func (ht *HashTable) Commit() error {
+	return ht.Txn.Commit()
+}
+
+// Rollback discards the staged pages and reloads the meta, freelist and hash
+// header pages as they were last committed.
+func (ht *HashTable) Rollback() error {
+	ht.rwMutex.Lock()
+	defer ht.rwMutex.Unlock()
+
+	if err := ht.Txn.rollback(); err != nil {
+		return err
+	}
+
+	hdr, err := readHashHeader(ht.Txn.Pager, ht.meta.RootPageNum)
+	if err != nil {
+		return err
+	}
+	ht.header = hdr
+	return nil
+}
+
+func (ht *HashTable) Close() error {
+	return ht.Txn.Pager.Close()
+}
+
+// Drop closes the table and removes its .db file.
+func (ht *HashTable) Drop() error {
+	if err := ht.Close(); err != nil {
+		return err
+	}
+	if ht.IsMemory() {
+		return nil
+	}
+
+	pager := ht.Txn.Pager
+	return pager.FS().Remove(pager.Name())
+}
+
+// -------Page Management-------------------------------------------------------
+
+// allocatePage returns a fresh page number, staging the freelist.
+func (ht *HashTable) allocatePage() pageNum {
+	pn := ht.freelist.GetNextPage()
+	ht.Txn.freelist = ht.freelist
+	return pn
+}
+
+// releasePage marks a page as free and drops any staged contents of it.
+func (ht *HashTable) releasePage(pn pageNum) {
+	ht.freelist.ReleasePage(pn)
+	ht.Txn.freelist = ht.freelist
+	delete(ht.Txn.dirtyPages, pn)
+}
+
+func (ht *HashTable) writeHeader() {
+	ht.Txn.appendPage(ht.header.pageNum, ht.header)
+}
+
+// addBucket appends the bucket starting at page pn to the directory, staging
+// the directory page it lands in.
+func (ht *HashTable) addBucket(pn pageNum) {
+	hdr := ht.header
+	idx := len(hdr.Buckets)
+	hdr.Buckets = append(hdr.Buckets, pn)
+
+	entries := hashDirEntries()
+	dirIdx := idx / entries
+	if dirIdx == len(hdr.DirPages) {
+		hdr.DirPages = append(hdr.DirPages, ht.allocatePage())
+	}
+
+	start := dirIdx * entries
+	end := min(start+entries, len(hdr.Buckets))
+	dir := &hashDirPage{entries: slices.Clone(hdr.Buckets[start:end])}
+	ht.Txn.appendPage(hdr.DirPages[dirIdx], dir)
+}
+
+// getHashPage returns the bucket page at pn, preferring a staged copy.
+func (ht *HashTable) getHashPage(pn pageNum) (*hashPage, error) {
+	if ps, exists := ht.Txn.dirtyPages[pn]; exists {
+		return ps.(*hashPage), nil
+	}
+
+	pg, err := ht.Txn.Pager.readPage(pn)
+	if err != nil {
+		return nil, err
+	}
+
+	hp := &hashPage{pageNum: pn}
+	hp.deserializeFromPage(pg)
+	return hp, nil
+}
+
+// getBucket returns the chain of pages of the bucket starting at page pn.
+func (ht *HashTable) getBucket(pn pageNum) ([]*hashPage, error) {
+	var chain []*hashPage
+	for pn != 0 {
+		hp, err := ht.getHashPage(pn)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, hp)
+		pn = hp.overflow
+	}
+	return chain, nil
+}
+
+// writeBucket packs items into the page chain of a bucket and stages it.
+// The chain grows into newly allocated overflow pages as needed and overflow
+// pages that are no longer needed are released.
+func (ht *HashTable) writeBucket(chain []*hashPage, items []*Item) {
+	cur := &hashPage{pageNum: chain[0].pageNum}
+	pages := []*hashPage{cur}
+	used := hashPageHeaderSize
+
+	for _, item := range items {
+		size := hashItemSize(item)
+		if used+size > globals.PageSize {
+			var pn pageNum
+			if len(pages) < len(chain) {
+				pn = chain[len(pages)].pageNum
+			} else {
+				pn = ht.allocatePage()
+			}
+			cur.overflow = pn
+			cur = &hashPage{pageNum: pn}
+			pages = append(pages, cur)
+			used = hashPageHeaderSize
+		}
+		cur.items = append(cur.items, item)
+		used += size
+	}
+
+	for i := len(pages); i < len(chain); i++ {
+		ht.releasePage(chain[i].pageNum)
+	}
+	for _, hp := range pages {
+		ht.Txn.appendPage(hp.pageNum, hp)
+	}
+}
+
+// split splits the bucket at the split pointer, moving the items that now hash
+// to the next level into a new bucket at the end of the directory.
+func (ht *HashTable) split() error {
+	hdr := ht.header
+	if len(hdr.Buckets) >= hashMaxBuckets() {
+		return nil // Buckets keep growing overflow pages instead.
+	}
+
+	old := hdr.Next
+	chain, err := ht.getBucket(hdr.Buckets[old])
+	if err != nil {
+		return err
+	}
+
+	hdr.Next++
+	if hdr.Next == hashInitialBuckets<<hdr.Level {
+		hdr.Level++
+		hdr.Next = 0
+	}
+
+	newPn := ht.allocatePage()
+	ht.addBucket(newPn)
+
+	var keep, moved []*Item
+	for _, hp := range chain {
+		for _, item := range hp.items {
+			if hdr.bucketIndex(item.Key) == old {
+				keep = append(keep, item)
+			} else {
+				moved = append(moved, item)
+			}
+		}
+	}
+
+	ht.writeBucket(chain, keep)
+	ht.writeBucket([]*hashPage{{pageNum: newPn}}, moved)
+	return nil
+}
+
+// findBucket returns the page chain of the bucket holding key, along with its
+// items and the index of key among them, -1 if it is not found.
+func (ht *HashTable) findBucket(key []byte) ([]*hashPage, []*Item, int, error) {
+	hdr := ht.header
+	chain, err := ht.getBucket(hdr.Buckets[hdr.bucketIndex(key)])
+	if err != nil {
+		return nil, nil, -1, err
+	}
+
+	var items []*Item
+	for _, hp := range chain {
+		items = append(items, hp.items...)
+	}
+
+	idx := slices.IndexFunc(items, func(i *Item) bool {
+		return bytes.Equal(i.Key, key)
+	})
+	return chain, items, idx, nil
+}
+
+// -------Value Operators-------------------------------------------------------
+
+// Get returns the item with the given key from its bucket.
+func (ht *HashTable) Get(key []byte) (*Item, error) {
+	ht.rwMutex.RLock()
+	defer ht.rwMutex.RUnlock()
+
+	_, items, idx, err := ht.findBucket(key)
+	if err != nil || idx == -1 {
+		return nil, err
+	}
+	return items[idx], nil
+}
+
+// Put adds or replaces the key in its bucket, then splits the next bucket if
+// the table is past its load factor.
+func (ht *HashTable) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return fmt.Errorf("key cannot be empty")
+	}
+	if len(key) > globals.MaxItemFieldSize || len(value) > globals.MaxItemFieldSize {
+		return fmt.Errorf(
+			"key and value cannot exceed %d bytes", globals.MaxItemFieldSize,
+		)
+	}
+
+	ht.rwMutex.Lock()
+	defer ht.rwMutex.Unlock()
+
+	chain, items, idx, err := ht.findBucket(key)
+	if err != nil {
+		return err
+	}
+
+	hdr := ht.header
+	item := NewItem(key, value)
+	if idx == -1 {
+		items = append(items, item)
+		hdr.Count++
+	} else {
+		hdr.Bytes -= uint64(hashItemSize(items[idx]))
+		items[idx] = item
+	}
+	hdr.Bytes += uint64(hashItemSize(item))
+
+	ht.writeBucket(chain, items)
+	if hdr.isOverloaded() {
+		if err := ht.split(); err != nil {
+			return err
+		}
+	}
+
+	ht.writeHeader()
+	return nil
+}
+
+// Del removes the key from its bucket.
+// Buckets are never merged back, the table keeps its size once grown.
+func (ht *HashTable) Del(key []byte) error {
+	ht.rwMutex.Lock()
+	defer ht.rwMutex.Unlock()
+
+	chain, items, idx, err := ht.findBucket(key)
+	if err != nil || idx == -1 {
+		return err
+	}
+
+	hdr := ht.header
+	hdr.Count--
+	hdr.Bytes -= uint64(hashItemSize(items[idx]))
+
+	ht.writeBucket(chain, slices.Delete(items, idx, idx+1))
+	ht.writeHeader()
+	return nil
+}
+
+// Each calls fn for every item of the table, in bucket order.
+func (ht *HashTable) Each(fn func(item *Item) error) error {
+	ht.rwMutex.RLock()
+	defer ht.rwMutex.RUnlock()
+
+	for _, pn := range ht.header.Buckets {
+		chain, err := ht.getBucket(pn)
+		if err != nil {
+			return err
+		}
+		for _, hp := range chain {
+			for _, item := range hp.items {
+				if err := fn(item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Cursor is not supported, hash tables do not keep their keys in order.
+func (ht *HashTable) Cursor() (Cursor, error) {
+	return nil, ErrUnsupported
+}
+
+// -------Hashing---------------------------------------------------------------
+
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
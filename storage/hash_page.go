@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"orchiddb/globals"
+)
+
+// -----------------------------------------------------------------------------
+// Page layouts of the hash table kind.
+//
+// Header:    marker | level u32 | next u64 | count u64 | bytes u64 |
+//            directory page count u32 | directory page numbers...
+// Directory: marker | entry count u16 | bucket page numbers...
+// Bucket:    marker | overflow page u64 | item count u16 |
+//            items ([key len u8][value len u8][key][value])...
+// -----------------------------------------------------------------------------
+
+const (
+	hashHeaderSize     = globals.PageMarkerSize + 4 + 8 + 8 + 8 + 4
+	hashDirHeaderSize  = globals.PageMarkerSize + 2
+	hashPageHeaderSize = globals.PageMarkerSize + globals.PageNumSize + 2
+)
+
+// hashDirEntries returns the bucket page numbers held by a directory page.
+func hashDirEntries() int {
+	return (globals.PageSize - hashDirHeaderSize) / globals.PageNumSize
+}
+
+// hashMaxBuckets returns the most buckets a table can address, as many as the
+// directory pages listed by the header can hold.
+func hashMaxBuckets() int {
+	maxDirPages := (globals.PageSize - hashHeaderSize) / globals.PageNumSize
+	return maxDirPages * hashDirEntries()
+}
+
+// -------Header----------------------------------------------------------------
+
+// hashHeader is the state of a hash table's linear hashing.
+//
+// The table has hashInitialBuckets<<Level buckets plus Next buckets that have
+// already been split into the next level. Buckets is the directory of the
+// first page of every bucket, it is kept in memory and persisted in DirPages.
+type hashHeader struct {
+	pageNum pageNum
+
+	Level uint32
+	Next  uint64 // The split pointer, the next bucket to be split.
+	Count uint64 // Items in the table.
+	Bytes uint64 // Encoded size of the items in the table.
+
+	DirPages []pageNum
+	Buckets  []pageNum
+}
+
+// bucketIndex returns the index in the directory of the bucket for key.
+func (h *hashHeader) bucketIndex(key []byte) uint64 {
+	hv := hashKey(key)
+	size := uint64(hashInitialBuckets) << h.Level
+
+	b := hv % size
+	if b < h.Next {
+		b = hv % (size * 2) // Already split into the next level.
+	}
+	return b
+}
+
+// isOverloaded returns whether the items fill more than hashMaxLoad of the
+// table's bucket pages.
+func (h *hashHeader) isOverloaded() bool {
+	capacity := len(h.Buckets) * (globals.PageSize - hashPageHeaderSize)
+	return float64(h.Bytes) > hashMaxLoad*float64(capacity)
+}
+
+// serializeToPage writes the header's contents into page p.
+func (h *hashHeader) serializeToPage(p *page) {
+	pos := 0
+
+	insertPageMarker(p.contents)
+	pos += globals.PageMarkerSize
+
+	binary.LittleEndian.PutUint32(p.contents[pos:], h.Level)
+	pos += 4
+	binary.LittleEndian.PutUint64(p.contents[pos:], h.Next)
+	pos += 8
+	binary.LittleEndian.PutUint64(p.contents[pos:], h.Count)
+	pos += 8
+	binary.LittleEndian.PutUint64(p.contents[pos:], h.Bytes)
+	pos += 8
+
+	binary.LittleEndian.PutUint32(p.contents[pos:], uint32(len(h.DirPages)))
+	pos += 4
+	for _, pn := range h.DirPages {
+		binary.LittleEndian.PutUint64(p.contents[pos:], uint64(pn))
+		pos += globals.PageNumSize
+	}
+}
+
+// deserializeFromPage reads the header's contents, without the directory,
+// from page p.
+func (h *hashHeader) deserializeFromPage(p *page) {
+	pos := 0
+
+	verifyPageMarker(p.contents)
+	pos += globals.PageMarkerSize
+
+	h.Level = binary.LittleEndian.Uint32(p.contents[pos:])
+	pos += 4
+	h.Next = binary.LittleEndian.Uint64(p.contents[pos:])
+	pos += 8
+	h.Count = binary.LittleEndian.Uint64(p.contents[pos:])
+	pos += 8
+	h.Bytes = binary.LittleEndian.Uint64(p.contents[pos:])
+	pos += 8
+
+	count := int(binary.LittleEndian.Uint32(p.contents[pos:]))
+	pos += 4
+	h.DirPages = make([]pageNum, 0, count)
+	for range count {
+		pn := pageNum(binary.LittleEndian.Uint64(p.contents[pos:]))
+		pos += globals.PageNumSize
+		h.DirPages = append(h.DirPages, pn)
+	}
+}
+
+// readHashHeader reads the header at page pn and the directory it lists.
+func readHashHeader(pager *Pager, pn pageNum) (*hashHeader, error) {
+	pg, err := pager.readPage(pn)
+	if err != nil {
+		return nil, fmt.Errorf("read hash header: %w", err)
+	}
+	h := &hashHeader{pageNum: pn}
+	h.deserializeFromPage(pg)
+
+	for _, dirPn := range h.DirPages {
+		pg, err := pager.readPage(dirPn)
+		if err != nil {
+			return nil, fmt.Errorf("read hash directory: %w", err)
+		}
+		dir := &hashDirPage{}
+		dir.deserializeFromPage(pg)
+		h.Buckets = append(h.Buckets, dir.entries...)
+	}
+
+	expected := (uint64(hashInitialBuckets) << h.Level) + h.Next
+	if uint64(len(h.Buckets)) != expected {
+		return nil, fmt.Errorf(
+			"hash directory holds %d buckets, expected %d",
+			len(h.Buckets), expected,
+		)
+	}
+
+	return h, nil
+}
+
+// -------Directory-------------------------------------------------------------
+
+// hashDirPage is one page of a hash table's bucket directory.
+type hashDirPage struct {
+	entries []pageNum
+}
+
+// serializeToPage writes the directory page's contents into page p.
+func (d *hashDirPage) serializeToPage(p *page) {
+	pos := 0
+
+	insertPageMarker(p.contents)
+	pos += globals.PageMarkerSize
+
+	binary.LittleEndian.PutUint16(p.contents[pos:], uint16(len(d.entries)))
+	pos += 2
+	for _, pn := range d.entries {
+		binary.LittleEndian.PutUint64(p.contents[pos:], uint64(pn))
+		pos += globals.PageNumSize
+	}
+}
+
+// deserializeFromPage reads the directory page's contents from page p.
+func (d *hashDirPage) deserializeFromPage(p *page) {
+	pos := 0
+
+	verifyPageMarker(p.contents)
+	pos += globals.PageMarkerSize
+
+	count := int(binary.LittleEndian.Uint16(p.contents[pos:]))
+	pos += 2
+	d.entries = make([]pageNum, 0, count)
+	for range count {
+		pn := pageNum(binary.LittleEndian.Uint64(p.contents[pos:]))
+		pos += globals.PageNumSize
+		d.entries = append(d.entries, pn)
+	}
+}
+
+// -------Bucket----------------------------------------------------------------
+
+// hashPage is one page of a bucket, overflow points to the next page of the
+// bucket or is 0 on its last page.
+type hashPage struct {
+	pageNum  pageNum
+	overflow pageNum
+	items    []*Item
+}
+
+// hashItemSize returns the encoded size of item in a bucket page.
+func hashItemSize(item *Item) int {
+	return 2 + len(item.Key) + len(item.Value)
+}
+
+// serializeToPage writes the bucket page's contents into page p.
+func (hp *hashPage) serializeToPage(p *page) {
+	pos := 0
+
+	insertPageMarker(p.contents)
+	pos += globals.PageMarkerSize
+
+	binary.LittleEndian.PutUint64(p.contents[pos:], uint64(hp.overflow))
+	pos += globals.PageNumSize
+
+	binary.LittleEndian.PutUint16(p.contents[pos:], uint16(len(hp.items)))
+	pos += 2
+
+	for _, item := range hp.items {
+		p.contents[pos] = byte(len(item.Key))
+		p.contents[pos+1] = byte(len(item.Value))
+		pos += 2
+
+		pos += copy(p.contents[pos:], item.Key)
+		pos += copy(p.contents[pos:], item.Value)
+	}
+}
+
+// deserializeFromPage reads the bucket page's contents from page p.
+func (hp *hashPage) deserializeFromPage(p *page) {
+	pos := 0
+
+	verifyPageMarker(p.contents)
+	pos += globals.PageMarkerSize
+
+	hp.overflow = pageNum(binary.LittleEndian.Uint64(p.contents[pos:]))
+	pos += globals.PageNumSize
+
+	count := int(binary.LittleEndian.Uint16(p.contents[pos:]))
+	pos += 2
+
+	hp.items = make([]*Item, 0, count)
+	for range count {
+		klen := int(p.contents[pos])
+		vlen := int(p.contents[pos+1])
+		pos += 2
+
+		key := make([]byte, klen)
+		pos += copy(key, p.contents[pos:pos+klen])
+		value := make([]byte, vlen)
+		pos += copy(value, p.contents[pos:pos+vlen])
+
+		hp.items = append(hp.items, NewItem(key, value))
+	}
+}
@@ -0,0 +1,438 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"orchiddb/globals"
+	"orchiddb/paths"
+	"orchiddb/vfs"
+)
+
+// LSM is a log-structured merge-tree table, the table kind for write heavy
+// workloads.
+//
+// Writes are appended to a log and collected in a memtable. Once the memtable
+// is large enough it is flushed, sorted, into an immutable segment file in
+// level 0. Segments are merged into deeper levels by leveled compaction,
+// where every level past 0 holds non-overlapping segments and is ten times
+// larger than the one above it. Writes never rewrite pages in place, which
+// keeps write amplification low compared to the B-tree.
+//
+// The files of an LSM table named t in the database path are:
+//
+//	t.lsm     the manifest listing the live segments and their levels
+//	t.lsmlog  the log of committed entries that are only in the memtable
+//	t-N.sst   segment N
+type LSM struct {
+	Name string
+
+	rwMutex sync.RWMutex
+	options Options
+
+	fs       vfs.FS
+	dir      string
+	manifest string
+
+	memtable *memtable
+	log      *memtableLog
+
+	// Entries staged by Put and Del, applied to the memtable on Commit.
+	staged      map[string]lsmEntry
+	stagedOrder []string
+
+	// levels[0] holds overlapping segments, oldest first. Deeper levels hold
+	// non-overlapping segments ordered by key.
+	levels [][]*segment
+	nextID uint64
+}
+
+const (
+	lsmLevel0Trigger   = 4        // Level 0 segments that trigger a compaction.
+	lsmBaseLevelSize   = 10 << 20 // Bytes allowed in level 1.
+	lsmLevelMultiplier = 10       // Growth factor of each level past 1.
+	lsmSegmentSize     = 2 << 20  // Target size of compacted segments.
+)
+
+// -------Table File Management-------------------------------------------------
+
+// GetLSM gets the LSM table whose manifest is at path in fsys.
+// If it does not exist, a new one is created.
+// Returns error, if any.
+func GetLSM(fsys vfs.FS, path string) (*LSM, error) {
+	name, err := paths.GetStem(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lsm := &LSM{
+		Name:     name,
+		options:  *NewOptions(),
+		fs:       fsys,
+		dir:      filepath.Dir(path),
+		manifest: path,
+		memtable: newMemtable(),
+		staged:   map[string]lsmEntry{},
+		levels:   [][]*segment{{}},
+		nextID:   1,
+	}
+
+	if fsys.Exists(path) {
+		err = lsm.open()
+	} else {
+		err = lsm.writeManifest()
+	}
+	if err != nil {
+		lsm.closeFiles()
+		return nil, err
+	}
+
+	lsm.log, err = openMemtableLog(fsys, lsm.logPath())
+	if err != nil {
+		lsm.closeFiles()
+		return nil, err
+	}
+	if err := lsm.log.replay(lsm.memtable); err != nil {
+		lsm.closeFiles()
+		return nil, err
+	}
+
+	return lsm, nil
+}
+
+// open loads the manifest and its segments, then removes any files left
+// behind by a flush or compaction that did not finish.
+func (lsm *LSM) open() error {
+	if err := lsm.readManifest(); err != nil {
+		return err
+	}
+
+	live := map[string]bool{}
+	for _, level := range lsm.levels {
+		for _, seg := range level {
+			live[seg.path] = true
+		}
+	}
+
+	files, err := lsm.fs.List(lsm.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		_, isSegment := lsm.segmentID(f)
+		stale := isSegment && !live[f]
+		if stale || f == lsm.manifest+globals.TMP_SUFFIX {
+			if err := lsm.fs.Remove(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (lsm *LSM) logPath() string {
+	return filepath.Join(lsm.dir, lsm.Name+globals.LSM_LOG_SUFFIX)
+}
+
+func (lsm *LSM) segmentPath(id uint64) string {
+	name := fmt.Sprintf("%s-%d%s", lsm.Name, id, globals.SEGMENT_SUFFIX)
+	return filepath.Join(lsm.dir, name)
+}
+
+// segmentID returns the id of the segment file at path and whether it belongs
+// to this table.
+func (lsm *LSM) segmentID(path string) (uint64, bool) {
+	base := filepath.Base(path)
+	if !strings.HasSuffix(base, globals.SEGMENT_SUFFIX) {
+		return 0, false
+	}
+
+	stem := strings.TrimSuffix(base, globals.SEGMENT_SUFFIX)
+	i := strings.LastIndex(stem, "-")
+	if i == -1 || stem[:i] != lsm.Name {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(stem[i+1:], 10, 64)
+	return id, err == nil
+}
+
+func (lsm *LSM) Commit() error {
+	lsm.rwMutex.Lock()
+	defer lsm.rwMutex.Unlock()
+
+	if len(lsm.stagedOrder) == 0 {
+		return nil
+	}
+
+	entries := make([]lsmEntry, 0, len(lsm.stagedOrder))
+	for _, key := range lsm.stagedOrder {
+		entries = append(entries, lsm.staged[key])
+	}
+
+	if err := lsm.log.append(entries); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		lsm.memtable.put(e)
+	}
+	lsm.resetStaged()
+
+	if lsm.memtable.size < lsm.options.MemtableSize {
+		return nil
+	}
+
+	if err := lsm.flush(); err != nil {
+		return err
+	}
+	return lsm.compact()
+}
+
+// Rollback discards all staged entries.
+func (lsm *LSM) Rollback() error {
+	lsm.rwMutex.Lock()
+	defer lsm.rwMutex.Unlock()
+
+	lsm.resetStaged()
+	return nil
+}
+
+func (lsm *LSM) resetStaged() {
+	lsm.staged = map[string]lsmEntry{}
+	lsm.stagedOrder = nil
+}
+
+func (lsm *LSM) Close() error {
+	lsm.rwMutex.Lock()
+	defer lsm.rwMutex.Unlock()
+
+	return lsm.closeFiles()
+}
+
+func (lsm *LSM) closeFiles() error {
+	var errs []error
+	if lsm.log != nil {
+		errs = append(errs, lsm.log.close())
+	}
+	for _, level := range lsm.levels {
+		for _, seg := range level {
+			errs = append(errs, seg.close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Drop closes the table and removes the manifest, log and every segment.
+func (lsm *LSM) Drop() error {
+	if err := lsm.Close(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, level := range lsm.levels {
+		for _, seg := range level {
+			errs = append(errs, lsm.fs.Remove(seg.path))
+		}
+	}
+	errs = append(errs, lsm.fs.Remove(lsm.logPath()))
+	errs = append(errs, lsm.fs.Remove(lsm.manifest))
+
+	return errors.Join(errs...)
+}
+
+// -------Manifest--------------------------------------------------------------
+
+// writeManifest atomically replaces the manifest with the current levels.
+//
+// Manifest structure is: marker | next segment id (8) | segment count (4) |
+// (segment id (8) | level (1))... | checksum (4).
+func (lsm *LSM) writeManifest() error {
+	out := append([]byte{}, globals.PageMarker...)
+	out = binary.LittleEndian.AppendUint64(out, lsm.nextID)
+
+	var count uint32
+	for _, level := range lsm.levels {
+		count += uint32(len(level))
+	}
+	out = binary.LittleEndian.AppendUint32(out, count)
+
+	for l, level := range lsm.levels {
+		for _, seg := range level {
+			out = binary.LittleEndian.AppendUint64(out, seg.id)
+			out = append(out, byte(l))
+		}
+	}
+	out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(out))
+
+	tmp := lsm.manifest + globals.TMP_SUFFIX
+	f, err := lsm.fs.Open(tmp, true)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(out, 0); err != nil {
+		f.Close()
+		return fmt.Errorf("write manifest %s: %w", tmp, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return lsm.fs.Rename(tmp, lsm.manifest)
+}
+
+// readManifest loads the manifest and opens every segment it lists.
+func (lsm *LSM) readManifest() error {
+	f, err := lsm.fs.Open(lsm.manifest, false)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size, err := f.Size()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	const headerSize = globals.PageMarkerSize + 8 + 4
+	if len(buf) < headerSize+4 || !bytes.Equal(buf[:globals.PageMarkerSize], globals.PageMarker) {
+		return fmt.Errorf("manifest %s is corrupt", lsm.manifest)
+	}
+	body, checksum := buf[:len(buf)-4], binary.LittleEndian.Uint32(buf[len(buf)-4:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return fmt.Errorf("manifest %s checksum mismatch", lsm.manifest)
+	}
+
+	pos := globals.PageMarkerSize
+	lsm.nextID = binary.LittleEndian.Uint64(body[pos:])
+	pos += 8
+	count := int(binary.LittleEndian.Uint32(body[pos:]))
+	pos += 4
+	if len(body) != headerSize+count*9 {
+		return fmt.Errorf("manifest %s is corrupt", lsm.manifest)
+	}
+
+	for range count {
+		id := binary.LittleEndian.Uint64(body[pos:])
+		level := int(body[pos+8])
+		pos += 9
+
+		seg, err := openSegment(lsm.fs, lsm.segmentPath(id), id, level)
+		if err != nil {
+			return err
+		}
+		for len(lsm.levels) <= level {
+			lsm.levels = append(lsm.levels, nil)
+		}
+		lsm.levels[level] = append(lsm.levels[level], seg)
+	}
+
+	return nil
+}
+
+// -------Value Operators-------------------------------------------------------
+
+// Get returns the newest committed or staged item for key, nil if it does not
+// exist or was deleted.
+func (lsm *LSM) Get(key []byte) (*Item, error) {
+	lsm.rwMutex.RLock()
+	defer lsm.rwMutex.RUnlock()
+
+	e, found, err := lsm.lookup(key)
+	if err != nil || !found || e.deleted {
+		return nil, err
+	}
+	return NewItem(e.key, e.value), nil
+}
+
+// lookup searches from the newest to the oldest data: staged entries, the
+// memtable, level 0 newest first, then each deeper level.
+func (lsm *LSM) lookup(key []byte) (lsmEntry, bool, error) {
+	if e, found := lsm.staged[string(key)]; found {
+		return e, true, nil
+	}
+	if e, found := lsm.memtable.get(key); found {
+		return e, true, nil
+	}
+
+	level0 := lsm.levels[0]
+	for i := len(level0) - 1; i >= 0; i-- {
+		e, found, err := level0[i].get(key)
+		if err != nil || found {
+			return e, found, err
+		}
+	}
+
+	for _, level := range lsm.levels[1:] {
+		i, found := slices.BinarySearchFunc(level, key, func(s *segment, k []byte) int {
+			if bytes.Compare(s.maxKey, k) < 0 {
+				return -1
+			}
+			if bytes.Compare(s.minKey, k) > 0 {
+				return 1
+			}
+			return 0
+		})
+		if !found {
+			continue
+		}
+
+		e, found, err := level[i].get(key)
+		if err != nil || found {
+			return e, found, err
+		}
+	}
+
+	return lsmEntry{}, false, nil
+}
+
+// Put stages key to be set to value on the next commit.
+func (lsm *LSM) Put(key []byte, value []byte) error {
+	if err := checkEntrySize(key, value); err != nil {
+		return err
+	}
+
+	lsm.rwMutex.Lock()
+	defer lsm.rwMutex.Unlock()
+
+	lsm.stage(lsmEntry{key: bytes.Clone(key), value: bytes.Clone(value)})
+	return nil
+}
+
+// Del stages a tombstone for key on the next commit.
+func (lsm *LSM) Del(key []byte) error {
+	if err := checkEntrySize(key, nil); err != nil {
+		return err
+	}
+
+	lsm.rwMutex.Lock()
+	defer lsm.rwMutex.Unlock()
+
+	lsm.stage(lsmEntry{key: bytes.Clone(key), deleted: true})
+	return nil
+}
+
+func (lsm *LSM) stage(e lsmEntry) {
+	if _, exists := lsm.staged[string(e.key)]; !exists {
+		lsm.stagedOrder = append(lsm.stagedOrder, string(e.key))
+	}
+	lsm.staged[string(e.key)] = e
+}
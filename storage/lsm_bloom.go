@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+const (
+	bloomBitsPerKey = 10
+	bloomHashCount  = 7 // Close to optimal for 10 bits per key, ~1% false positives.
+)
+
+// bloomFilter answers whether a segment may contain a key without reading it.
+// A false answer is always correct, a true answer may be a false positive.
+type bloomFilter struct {
+	bits   []byte
+	hashes uint8
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	nbits := max(keys*bloomBitsPerKey, 64)
+	return &bloomFilter{
+		bits:   make([]byte, (nbits+7)/8),
+		hashes: bloomHashCount,
+	}
+}
+
+// bloomHashes derives the two hashes used for double hashing from key.
+func bloomHashes(key []byte) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum >> 32)
+}
+
+func (bf *bloomFilter) add(key []byte) {
+	h1, h2 := bloomHashes(key)
+	nbits := uint32(len(bf.bits) * 8)
+
+	for i := range uint32(bf.hashes) {
+		bit := (h1 + i*h2) % nbits
+		bf.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (bf *bloomFilter) mayContain(key []byte) bool {
+	h1, h2 := bloomHashes(key)
+	nbits := uint32(len(bf.bits) * 8)
+
+	for i := range uint32(bf.hashes) {
+		bit := (h1 + i*h2) % nbits
+		if bf.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// serialize returns the filter as: hash count | bits.
+func (bf *bloomFilter) serialize() []byte {
+	out := make([]byte, 0, 1+len(bf.bits))
+	out = append(out, bf.hashes)
+	return append(out, bf.bits...)
+}
+
+func deserializeBloomFilter(buf []byte) (*bloomFilter, error) {
+	if len(buf) < 2 {
+		return nil, errors.New("bloom filter too short")
+	}
+	return &bloomFilter{hashes: buf[0], bits: buf[1:]}, nil
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+)
+
+// -------Flushing--------------------------------------------------------------
+
+// flush writes the memtable to a new level 0 segment and starts a new, empty
+// memtable log.
+func (lsm *LSM) flush() error {
+	if lsm.memtable.len() == 0 {
+		return nil
+	}
+
+	b := &segmentBuilder{}
+	for _, e := range lsm.memtable.sorted() {
+		b.add(e)
+	}
+
+	id := lsm.nextID
+	lsm.nextID++
+	seg, err := writeSegment(lsm.fs, lsm.segmentPath(id), id, 0, b)
+	if err != nil {
+		return err
+	}
+
+	lsm.levels[0] = append(lsm.levels[0], seg)
+	if err := lsm.writeManifest(); err != nil {
+		lsm.levels[0] = lsm.levels[0][:len(lsm.levels[0])-1]
+		return errors.Join(err, lsm.discardSegments(seg))
+	}
+
+	// The memtable is durable in the segment now. If the old log outlives a
+	// crash, replaying it only rewrites the same entries.
+	if err := lsm.log.close(); err != nil {
+		return err
+	}
+	if err := lsm.fs.Remove(lsm.log.path); err != nil {
+		return err
+	}
+	log, err := openMemtableLog(lsm.fs, lsm.logPath())
+	if err != nil {
+		return err
+	}
+
+	lsm.log = log
+	lsm.memtable = newMemtable()
+	return nil
+}
+
+// -------Leveled Compaction----------------------------------------------------
+
+// compact runs compactions until no level is over its limit.
+func (lsm *LSM) compact() error {
+	for {
+		level, needed := lsm.pickCompaction()
+		if !needed {
+			return nil
+		}
+		if err := lsm.compactLevel(level); err != nil {
+			return err
+		}
+	}
+}
+
+// pickCompaction returns the shallowest level that needs compacting.
+// Level 0 is compacted by segment count, as its segments overlap and every
+// one has to be read on a lookup. Deeper levels are compacted by size.
+func (lsm *LSM) pickCompaction() (int, bool) {
+	if len(lsm.levels[0]) >= lsmLevel0Trigger {
+		return 0, true
+	}
+
+	for l := 1; l < len(lsm.levels); l++ {
+		if lsm.levelSize(l) > maxLevelSize(l) {
+			return l, true
+		}
+	}
+
+	return 0, false
+}
+
+func (lsm *LSM) levelSize(l int) int64 {
+	var size int64
+	for _, seg := range lsm.levels[l] {
+		size += seg.size
+	}
+	return size
+}
+
+// maxLevelSize returns the number of bytes level l may hold, l > 0.
+func maxLevelSize(l int) int64 {
+	size := int64(lsmBaseLevelSize)
+	for range l - 1 {
+		size *= lsmLevelMultiplier
+	}
+	return size
+}
+
+// compactLevel merges segments of level l with the overlapping segments of
+// level l+1 into new segments in level l+1.
+//
+// All of level 0 is compacted at once since its segments overlap. For deeper
+// levels, the first segment is compacted.
+func (lsm *LSM) compactLevel(l int) error {
+	var inputs []*segment
+	if l == 0 {
+		inputs = slices.Clone(lsm.levels[0])
+		slices.Reverse(inputs) // newest first, so newer entries win the merge
+	} else {
+		inputs = []*segment{lsm.levels[l][0]}
+	}
+
+	if len(lsm.levels) == l+1 {
+		lsm.levels = append(lsm.levels, nil)
+	}
+
+	minKey, maxKey := keyRange(inputs)
+	var overlapping []*segment
+	for _, seg := range lsm.levels[l+1] {
+		if seg.overlaps(minKey, maxKey) {
+			overlapping = append(overlapping, seg)
+		}
+	}
+
+	// Tombstones only have to be kept while older data for their key might
+	// still exist in a deeper level.
+	dropTombstones := true
+	for _, level := range lsm.levels[l+2:] {
+		if len(level) > 0 {
+			dropTombstones = false
+		}
+	}
+
+	outputs, err := lsm.mergeSegments(inputs, overlapping, l+1, dropTombstones)
+	if err != nil {
+		return err
+	}
+
+	previous := slices.Clone(lsm.levels)
+	lsm.levels[l] = removeSegments(lsm.levels[l], inputs)
+	next := append(removeSegments(lsm.levels[l+1], overlapping), outputs...)
+	slices.SortFunc(next, func(a, b *segment) int { return bytes.Compare(a.minKey, b.minKey) })
+	lsm.levels[l+1] = next
+
+	if err := lsm.writeManifest(); err != nil {
+		lsm.levels = previous
+		return errors.Join(err, lsm.discardSegments(outputs...))
+	}
+
+	return lsm.discardSegments(append(inputs, overlapping...)...)
+}
+
+// mergeSegments merges inputs, newest first, over the sorted, non-overlapping
+// segments of the next level into new segments at level out.
+func (lsm *LSM) mergeSegments(
+	inputs []*segment, next []*segment, out int, dropTombstones bool,
+) ([]*segment, error) {
+	sources := make([]entrySource, 0, len(inputs)+1)
+	for _, seg := range inputs {
+		sources = append(sources, seg.iterator())
+	}
+	sources = append(sources, newLevelIterator(next))
+
+	it := &mergeIterator{sources: sources}
+	if err := it.seek(nil); err != nil {
+		return nil, err
+	}
+
+	var outputs []*segment
+	b := &segmentBuilder{}
+
+	writeOutput := func() error {
+		id := lsm.nextID
+		lsm.nextID++
+		seg, err := writeSegment(lsm.fs, lsm.segmentPath(id), id, out, b)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, seg)
+		b = &segmentBuilder{}
+		return nil
+	}
+
+	for it.ok {
+		if !it.cur.deleted || !dropTombstones {
+			b.add(it.cur)
+			if b.size() >= lsmSegmentSize {
+				if err := writeOutput(); err != nil {
+					return nil, errors.Join(err, lsm.discardSegments(outputs...))
+				}
+			}
+		}
+
+		if err := it.next(); err != nil {
+			return nil, errors.Join(err, lsm.discardSegments(outputs...))
+		}
+	}
+
+	if !b.empty() {
+		if err := writeOutput(); err != nil {
+			return nil, errors.Join(err, lsm.discardSegments(outputs...))
+		}
+	}
+
+	return outputs, nil
+}
+
+// discardSegments closes and removes segments that are no longer live.
+func (lsm *LSM) discardSegments(segs ...*segment) error {
+	var errs []error
+	for _, seg := range segs {
+		errs = append(errs, seg.close(), lsm.fs.Remove(seg.path))
+	}
+	return errors.Join(errs...)
+}
+
+// keyRange returns the smallest and largest key across segs.
+func keyRange(segs []*segment) ([]byte, []byte) {
+	var minKey, maxKey []byte
+	for _, seg := range segs {
+		if seg.count == 0 {
+			continue
+		}
+		if minKey == nil || bytes.Compare(seg.minKey, minKey) < 0 {
+			minKey = seg.minKey
+		}
+		if maxKey == nil || bytes.Compare(seg.maxKey, maxKey) > 0 {
+			maxKey = seg.maxKey
+		}
+	}
+	return minKey, maxKey
+}
+
+// removeSegments returns level without the segments in remove.
+func removeSegments(level []*segment, remove []*segment) []*segment {
+	return slices.DeleteFunc(slices.Clone(level), func(s *segment) bool {
+		return slices.Contains(remove, s)
+	})
+}
@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+)
+
+// entrySource is an ordered stream of entries the merge iterator reads from.
+type entrySource interface {
+	seek(key []byte) error
+	current() (lsmEntry, bool)
+	next() error
+}
+
+// -------Merging---------------------------------------------------------------
+
+// mergeIterator merges several sources into a single stream ordered by key.
+// Sources are ordered newest first, when several hold the same key only the
+// entry of the first one is returned.
+type mergeIterator struct {
+	sources []entrySource
+	cur     lsmEntry
+	ok      bool
+}
+
+func (m *mergeIterator) seek(key []byte) error {
+	for _, src := range m.sources {
+		if err := src.seek(key); err != nil {
+			return err
+		}
+	}
+	return m.pick()
+}
+
+func (m *mergeIterator) next() error {
+	if !m.ok {
+		return nil
+	}
+
+	// Move every source past the current key, older versions included.
+	for _, src := range m.sources {
+		e, ok := src.current()
+		if ok && bytes.Equal(e.key, m.cur.key) {
+			if err := src.next(); err != nil {
+				return err
+			}
+		}
+	}
+	return m.pick()
+}
+
+// pick sets the current entry to the smallest key across the sources.
+func (m *mergeIterator) pick() error {
+	m.ok = false
+	for _, src := range m.sources {
+		e, ok := src.current()
+		if !ok {
+			continue
+		}
+		if !m.ok || bytes.Compare(e.key, m.cur.key) < 0 {
+			m.cur, m.ok = e, true
+		}
+	}
+	return nil
+}
+
+// -------Sources---------------------------------------------------------------
+
+// sliceIterator iterates entries that are already sorted in memory.
+type sliceIterator struct {
+	entries []lsmEntry
+	pos     int
+}
+
+func (it *sliceIterator) seek(key []byte) error {
+	it.pos = sort.Search(len(it.entries), func(i int) bool {
+		return bytes.Compare(it.entries[i].key, key) >= 0
+	})
+	return nil
+}
+
+func (it *sliceIterator) current() (lsmEntry, bool) {
+	if it.pos >= len(it.entries) {
+		return lsmEntry{}, false
+	}
+	return it.entries[it.pos], true
+}
+
+func (it *sliceIterator) next() error {
+	it.pos++
+	return nil
+}
+
+// levelIterator iterates a level of sorted, non-overlapping segments as one
+// stream.
+type levelIterator struct {
+	segs []*segment
+	pos  int
+	it   *segmentIterator
+}
+
+func newLevelIterator(segs []*segment) *levelIterator {
+	return &levelIterator{segs: segs}
+}
+
+func (l *levelIterator) seek(key []byte) error {
+	l.pos = sort.Search(len(l.segs), func(i int) bool {
+		return bytes.Compare(l.segs[i].maxKey, key) >= 0
+	})
+	if l.pos >= len(l.segs) {
+		l.it = nil
+		return nil
+	}
+
+	l.it = l.segs[l.pos].iterator()
+	if err := l.it.seek(key); err != nil {
+		return err
+	}
+	return l.skipExhausted()
+}
+
+func (l *levelIterator) current() (lsmEntry, bool) {
+	if l.it == nil {
+		return lsmEntry{}, false
+	}
+	return l.it.current()
+}
+
+func (l *levelIterator) next() error {
+	if l.it == nil {
+		return nil
+	}
+	if err := l.it.next(); err != nil {
+		return err
+	}
+	return l.skipExhausted()
+}
+
+// skipExhausted moves on to the following segments once the current one has
+// no entries left.
+func (l *levelIterator) skipExhausted() error {
+	for {
+		if _, ok := l.it.current(); ok {
+			return nil
+		}
+
+		l.pos++
+		if l.pos >= len(l.segs) {
+			l.it = nil
+			return nil
+		}
+
+		l.it = l.segs[l.pos].iterator()
+		if err := l.it.reset(0); err != nil {
+			return err
+		}
+	}
+}
+
+// -------Cursor----------------------------------------------------------------
+
+// lsmCursor iterates an LSM table's live items in key order.
+//
+// The staged entries and memtable are copied when the cursor is created, the
+// segments are read as the cursor moves.
+type lsmCursor struct {
+	lsm *LSM
+	it  *mergeIterator
+}
+
+// Cursor returns a cursor over the table's items in key order.
+func (lsm *LSM) Cursor() (Cursor, error) {
+	lsm.rwMutex.RLock()
+	defer lsm.rwMutex.RUnlock()
+
+	// Staged entries take precedence over the memtable.
+	recent := newMemtable()
+	for _, e := range lsm.memtable.entries {
+		recent.put(e)
+	}
+	for _, e := range lsm.staged {
+		recent.put(e)
+	}
+
+	sources := []entrySource{&sliceIterator{entries: recent.sorted()}}
+
+	level0 := lsm.levels[0]
+	for i := len(level0) - 1; i >= 0; i-- {
+		sources = append(sources, level0[i].iterator())
+	}
+	for _, level := range lsm.levels[1:] {
+		sources = append(sources, newLevelIterator(level))
+	}
+
+	return &lsmCursor{lsm: lsm, it: &mergeIterator{sources: sources}}, nil
+}
+
+func (c *lsmCursor) First() (*Item, error) {
+	return c.Seek(nil)
+}
+
+func (c *lsmCursor) Seek(key []byte) (*Item, error) {
+	c.lsm.rwMutex.RLock()
+	defer c.lsm.rwMutex.RUnlock()
+
+	if err := c.it.seek(key); err != nil {
+		return nil, err
+	}
+	return c.live()
+}
+
+func (c *lsmCursor) Next() (*Item, error) {
+	c.lsm.rwMutex.RLock()
+	defer c.lsm.rwMutex.RUnlock()
+
+	if err := c.it.next(); err != nil {
+		return nil, err
+	}
+	return c.live()
+}
+
+// live skips tombstones and returns the current item, nil at the end.
+func (c *lsmCursor) live() (*Item, error) {
+	for c.it.ok && c.it.cur.deleted {
+		if err := c.it.next(); err != nil {
+			return nil, err
+		}
+	}
+	if !c.it.ok {
+		return nil, nil
+	}
+	return NewItem(c.it.cur.key, c.it.cur.value), nil
+}
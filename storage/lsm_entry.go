@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// lsmEntry is a single key version in an LSM table. Deletes are recorded as
+// tombstones so they shadow older versions of the key in deeper segments.
+type lsmEntry struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+// entryHeaderSize is the size of an encoded entry before its key and value:
+// flags (1) | key length (2) | value length (4).
+const entryHeaderSize = 1 + 2 + 4
+
+const entryFlagDeleted = 1
+
+// size returns the number of bytes the entry takes up once encoded.
+func (e lsmEntry) size() int {
+	return entryHeaderSize + len(e.key) + len(e.value)
+}
+
+// checkEntrySize returns an error if key or value cannot be encoded.
+func checkEntrySize(key, value []byte) error {
+	if len(key) == 0 {
+		return errors.New("key cannot be empty")
+	}
+	if len(key) > math.MaxUint16 {
+		return fmt.Errorf("key of %d bytes exceeds %d bytes", len(key), math.MaxUint16)
+	}
+	if len(value) > math.MaxUint32 {
+		return fmt.Errorf("value of %d bytes is too large", len(value))
+	}
+	return nil
+}
+
+// appendEntry appends the encoded entry to buf.
+func appendEntry(buf []byte, e lsmEntry) []byte {
+	var flags byte
+	if e.deleted {
+		flags |= entryFlagDeleted
+	}
+
+	buf = append(buf, flags)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(e.key)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(e.value)))
+	buf = append(buf, e.key...)
+	return append(buf, e.value...)
+}
+
+// readEntry decodes the next entry from r.
+// Returns io.EOF if r is exhausted exactly at an entry boundary.
+func readEntry(r *bufio.Reader) (lsmEntry, error) {
+	header := make([]byte, entryHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return lsmEntry{}, fmt.Errorf("truncated entry header: %w", err)
+		}
+		return lsmEntry{}, err
+	}
+
+	klen := int(binary.LittleEndian.Uint16(header[1:]))
+	vlen := int(binary.LittleEndian.Uint32(header[3:]))
+
+	body := make([]byte, klen+vlen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return lsmEntry{}, fmt.Errorf("truncated entry body: %w", err)
+	}
+
+	return lsmEntry{
+		key:     body[:klen],
+		value:   body[klen:],
+		deleted: header[0]&entryFlagDeleted != 0,
+	}, nil
+}
+
+// decodeEntry decodes a single entry that fills buf.
+func decodeEntry(buf []byte) (lsmEntry, error) {
+	if len(buf) < entryHeaderSize {
+		return lsmEntry{}, errors.New("entry too short")
+	}
+
+	klen := int(binary.LittleEndian.Uint16(buf[1:]))
+	vlen := int(binary.LittleEndian.Uint32(buf[3:]))
+	if len(buf) != entryHeaderSize+klen+vlen {
+		return lsmEntry{}, errors.New("entry length mismatch")
+	}
+
+	body := buf[entryHeaderSize:]
+	return lsmEntry{
+		key:     body[:klen],
+		value:   body[klen:],
+		deleted: buf[0]&entryFlagDeleted != 0,
+	}, nil
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+
+	"orchiddb/vfs"
+)
+
+// memtable holds the most recent entries of an LSM table in memory until it
+// is large enough to be flushed to a segment.
+type memtable struct {
+	entries map[string]lsmEntry
+	size    int // Approximate encoded size of all entries.
+}
+
+func newMemtable() *memtable {
+	return &memtable{entries: map[string]lsmEntry{}}
+}
+
+func (m *memtable) put(e lsmEntry) {
+	if old, exists := m.entries[string(e.key)]; exists {
+		m.size -= old.size()
+	}
+	m.entries[string(e.key)] = e
+	m.size += e.size()
+}
+
+func (m *memtable) get(key []byte) (lsmEntry, bool) {
+	e, exists := m.entries[string(key)]
+	return e, exists
+}
+
+func (m *memtable) len() int { return len(m.entries) }
+
+// sorted returns the entries ordered by key.
+func (m *memtable) sorted() []lsmEntry {
+	out := make([]lsmEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		out = append(out, e)
+	}
+	slices.SortFunc(out, func(a, b lsmEntry) int { return bytes.Compare(a.key, b.key) })
+	return out
+}
+
+// -------Memtable Log----------------------------------------------------------
+
+// memtableLog is the log of committed entries that are only in the memtable.
+// It is replayed into the memtable when the table is opened and replaced once
+// the memtable is flushed.
+//
+// Every commit appends one record: checksum (4) | length (4) | count (4) |
+// entries. A record is replayed entirely or not at all, a torn record at the
+// end of the log is a commit that never finished.
+type memtableLog struct {
+	fs   vfs.FS
+	path string
+	f    vfs.File
+	size int64
+}
+
+const logRecordHeaderSize = 4 + 4
+
+func openMemtableLog(fsys vfs.FS, path string) (*memtableLog, error) {
+	f, err := fsys.Open(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return &memtableLog{fs: fsys, path: path, f: f}, nil
+}
+
+// append writes the entries as a single record and syncs the log.
+func (l *memtableLog) append(entries []lsmEntry) error {
+	payload := binary.LittleEndian.AppendUint32(nil, uint32(len(entries)))
+	for _, e := range entries {
+		payload = appendEntry(payload, e)
+	}
+
+	record := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(payload))
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(payload)))
+	record = append(record, payload...)
+
+	if _, err := l.f.WriteAt(record, l.size); err != nil {
+		return fmt.Errorf("write %s: %w", l.path, err)
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+
+	l.size += int64(len(record))
+	return nil
+}
+
+// replay applies every complete record in the log to m.
+// Replay stops at the first torn or corrupt record, which is cut off the log
+// so later commits are appended after the last good one.
+func (l *memtableLog) replay(m *memtable) error {
+	size, err := l.f.Size()
+	if err != nil {
+		return err
+	}
+
+	contents := make([]byte, size)
+	if _, err := l.f.ReadAt(contents, 0); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	pos := 0
+	for pos+logRecordHeaderSize <= len(contents) {
+		checksum := binary.LittleEndian.Uint32(contents[pos:])
+		length := int(binary.LittleEndian.Uint32(contents[pos+4:]))
+
+		start := pos + logRecordHeaderSize
+		if length < 4 || start+length > len(contents) {
+			break
+		}
+		payload := contents[start : start+length]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+
+		entries, err := decodeLogPayload(payload)
+		if err != nil {
+			break
+		}
+		for _, e := range entries {
+			m.put(e)
+		}
+
+		pos = start + length
+	}
+
+	l.size = int64(pos)
+	return nil
+}
+
+func decodeLogPayload(payload []byte) ([]lsmEntry, error) {
+	count := int(binary.LittleEndian.Uint32(payload))
+	pos := 4
+
+	entries := make([]lsmEntry, 0, count)
+	for range count {
+		if pos+entryHeaderSize > len(payload) {
+			return nil, errors.New("log record truncated")
+		}
+		klen := int(binary.LittleEndian.Uint16(payload[pos+1:]))
+		vlen := int(binary.LittleEndian.Uint32(payload[pos+3:]))
+		end := pos + entryHeaderSize + klen + vlen
+		if end > len(payload) {
+			return nil, errors.New("log record truncated")
+		}
+
+		e, err := decodeEntry(bytes.Clone(payload[pos:end]))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+		pos = end
+	}
+
+	return entries, nil
+}
+
+func (l *memtableLog) close() error {
+	return l.f.Close()
+}
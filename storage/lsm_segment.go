@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"orchiddb/globals"
+	"orchiddb/vfs"
+)
+
+// A segment is an immutable file of entries sorted by key, written when an LSM
+// table's memtable is flushed or when segments are compacted.
+//
+// Segment structure is:
+// -----------------------------------------------------------------------------
+// | entries ... | sparse index | bloom filter | footer                        |
+// -----------------------------------------------------------------------------
+//
+// The sparse index holds the key and offset of every segmentIndexInterval'th
+// entry, a lookup reads at most one run of entries between two index keys.
+// The footer locates the index and bloom filter and holds a checksum of
+// everything before it.
+type segment struct {
+	id    uint64
+	level int
+	path  string
+	f     vfs.File
+
+	count   uint64
+	dataEnd int64 // Offset where the entries end and the index begins.
+	size    int64 // Total file size.
+
+	minKey []byte
+	maxKey []byte
+
+	index []segmentIndexEntry
+	bloom *bloomFilter
+}
+
+type segmentIndexEntry struct {
+	key    []byte
+	offset int64
+}
+
+const segmentIndexInterval = 16
+
+// segmentFooterSize: index offset (8) | bloom offset (8) | count (8) |
+// checksum (4) | page marker (4).
+const segmentFooterSize = 8 + 8 + 8 + 4 + globals.PageMarkerSize
+
+// -------Writing---------------------------------------------------------------
+
+// segmentBuilder accumulates sorted entries into the bytes of a segment file.
+type segmentBuilder struct {
+	data  []byte
+	index []segmentIndexEntry
+	keys  [][]byte
+}
+
+func (b *segmentBuilder) add(e lsmEntry) {
+	if len(b.keys)%segmentIndexInterval == 0 {
+		b.index = append(b.index, segmentIndexEntry{key: e.key, offset: int64(len(b.data))})
+	}
+	b.keys = append(b.keys, e.key)
+	b.data = appendEntry(b.data, e)
+}
+
+func (b *segmentBuilder) empty() bool { return len(b.keys) == 0 }
+func (b *segmentBuilder) size() int   { return len(b.data) }
+
+// finish returns the complete segment file contents.
+func (b *segmentBuilder) finish() []byte {
+	out := b.data
+	indexOffset := len(out)
+
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(b.index)))
+	for _, ie := range b.index {
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(ie.key)))
+		out = append(out, ie.key...)
+		out = binary.LittleEndian.AppendUint64(out, uint64(ie.offset))
+	}
+
+	bloomOffset := len(out)
+	bloom := newBloomFilter(len(b.keys))
+	for _, k := range b.keys {
+		bloom.add(k)
+	}
+	out = append(out, bloom.serialize()...)
+
+	checksum := crc32.ChecksumIEEE(out)
+	out = binary.LittleEndian.AppendUint64(out, uint64(indexOffset))
+	out = binary.LittleEndian.AppendUint64(out, uint64(bloomOffset))
+	out = binary.LittleEndian.AppendUint64(out, uint64(len(b.keys)))
+	out = binary.LittleEndian.AppendUint32(out, checksum)
+	return append(out, globals.PageMarker...)
+}
+
+// writeSegment writes the builder's entries to a new, synced segment file and
+// opens it.
+func writeSegment(fsys vfs.FS, path string, id uint64, level int, b *segmentBuilder) (*segment, error) {
+	f, err := fsys.Open(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.WriteAt(b.finish(), 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write segment %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	return openSegment(fsys, path, id, level)
+}
+
+// -------Reading---------------------------------------------------------------
+
+// openSegment opens the segment file at path and loads its index and bloom
+// filter. The whole file is verified against its checksum.
+func openSegment(fsys vfs.FS, path string, id uint64, level int) (*segment, error) {
+	f, err := fsys.Open(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	seg, err := loadSegment(f, path, id, level)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open segment %s: %w", path, err)
+	}
+	return seg, nil
+}
+
+func loadSegment(f vfs.File, path string, id uint64, level int) (*segment, error) {
+	size, err := f.Size()
+	if err != nil {
+		return nil, err
+	}
+	if size < segmentFooterSize {
+		return nil, errors.New("segment too short")
+	}
+
+	contents := make([]byte, size)
+	if _, err := f.ReadAt(contents, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	footer := contents[size-segmentFooterSize:]
+	body := contents[:size-segmentFooterSize]
+	if !bytes.Equal(footer[28:], globals.PageMarker) {
+		return nil, errors.New("segment marker not found")
+	}
+
+	indexOffset := int64(binary.LittleEndian.Uint64(footer[0:]))
+	bloomOffset := int64(binary.LittleEndian.Uint64(footer[8:]))
+	count := binary.LittleEndian.Uint64(footer[16:])
+	checksum := binary.LittleEndian.Uint32(footer[24:])
+
+	if crc32.ChecksumIEEE(body) != checksum {
+		return nil, errors.New("segment checksum mismatch")
+	}
+	if indexOffset > bloomOffset || bloomOffset > int64(len(body)) {
+		return nil, errors.New("segment footer offsets out of range")
+	}
+
+	index, err := decodeSegmentIndex(body[indexOffset:bloomOffset])
+	if err != nil {
+		return nil, err
+	}
+
+	bloom, err := deserializeBloomFilter(bytes.Clone(body[bloomOffset:]))
+	if err != nil {
+		return nil, err
+	}
+
+	seg := &segment{
+		id:      id,
+		level:   level,
+		path:    path,
+		f:       f,
+		count:   count,
+		dataEnd: indexOffset,
+		size:    size,
+		index:   index,
+		bloom:   bloom,
+	}
+
+	if count > 0 {
+		seg.minKey = index[0].key
+		last, err := seg.lastEntry(body[index[len(index)-1].offset:indexOffset])
+		if err != nil {
+			return nil, err
+		}
+		seg.maxKey = bytes.Clone(last.key)
+	}
+
+	return seg, nil
+}
+
+func decodeSegmentIndex(buf []byte) ([]segmentIndexEntry, error) {
+	if len(buf) < 4 {
+		return nil, errors.New("segment index too short")
+	}
+	n := int(binary.LittleEndian.Uint32(buf))
+	pos := 4
+
+	index := make([]segmentIndexEntry, 0, n)
+	for range n {
+		if pos+2 > len(buf) {
+			return nil, errors.New("segment index truncated")
+		}
+		klen := int(binary.LittleEndian.Uint16(buf[pos:]))
+		pos += 2
+		if pos+klen+8 > len(buf) {
+			return nil, errors.New("segment index truncated")
+		}
+
+		// Copied so the index does not pin the whole file read on open.
+		key := bytes.Clone(buf[pos : pos+klen])
+		pos += klen
+		offset := int64(binary.LittleEndian.Uint64(buf[pos:]))
+		pos += 8
+
+		index = append(index, segmentIndexEntry{key: key, offset: offset})
+	}
+
+	return index, nil
+}
+
+// lastEntry returns the last entry of the run of entries in buf.
+func (s *segment) lastEntry(buf []byte) (lsmEntry, error) {
+	r := bufio.NewReader(bytes.NewReader(buf))
+
+	var last lsmEntry
+	for {
+		e, err := readEntry(r)
+		if errors.Is(err, io.EOF) {
+			return last, nil
+		}
+		if err != nil {
+			return lsmEntry{}, err
+		}
+		last = e
+	}
+}
+
+// overlaps returns whether the segment's key range intersects [min, max].
+func (s *segment) overlaps(min, max []byte) bool {
+	if s.count == 0 {
+		return false
+	}
+	return bytes.Compare(s.minKey, max) <= 0 && bytes.Compare(min, s.maxKey) <= 0
+}
+
+// blockStart returns the offset of the indexed run of entries that key would
+// be in.
+func (s *segment) blockStart(key []byte) int64 {
+	i := sort.Search(len(s.index), func(i int) bool {
+		return bytes.Compare(s.index[i].key, key) > 0
+	})
+	if i == 0 {
+		return 0
+	}
+	return s.index[i-1].offset
+}
+
+// get returns the entry for key and whether the segment holds one.
+func (s *segment) get(key []byte) (lsmEntry, bool, error) {
+	if !s.overlaps(key, key) || !s.bloom.mayContain(key) {
+		return lsmEntry{}, false, nil
+	}
+
+	it := s.iterator()
+	if err := it.seek(key); err != nil {
+		return lsmEntry{}, false, err
+	}
+
+	e, ok := it.current()
+	if !ok || !bytes.Equal(e.key, key) {
+		return lsmEntry{}, false, nil
+	}
+	return e, true, nil
+}
+
+func (s *segment) close() error {
+	return s.f.Close()
+}
+
+// -------Iteration-------------------------------------------------------------
+
+// segmentIterator reads a segment's entries in order.
+type segmentIterator struct {
+	seg *segment
+	r   *bufio.Reader
+	cur lsmEntry
+	ok  bool
+}
+
+func (s *segment) iterator() *segmentIterator {
+	return &segmentIterator{seg: s}
+}
+
+// reset positions the iterator at offset and reads the entry there.
+func (it *segmentIterator) reset(offset int64) error {
+	section := io.NewSectionReader(it.seg.f, offset, it.seg.dataEnd-offset)
+	it.r = bufio.NewReader(section)
+	return it.next()
+}
+
+func (it *segmentIterator) seek(key []byte) error {
+	if err := it.reset(it.seg.blockStart(key)); err != nil {
+		return err
+	}
+	for it.ok && bytes.Compare(it.cur.key, key) < 0 {
+		if err := it.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *segmentIterator) current() (lsmEntry, bool) {
+	return it.cur, it.ok
+}
+
+func (it *segmentIterator) next() error {
+	e, err := readEntry(it.r)
+	if errors.Is(err, io.EOF) {
+		it.cur, it.ok = lsmEntry{}, false
+		return nil
+	}
+	if err != nil {
+		it.ok = false
+		return fmt.Errorf("read segment %s: %w", it.seg.path, err)
+	}
+
+	it.cur, it.ok = e, true
+	return nil
+}
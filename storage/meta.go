@@ -12,13 +12,24 @@ const (
 	RootNodePageNum pageNum = 2
 )
 
+// The structure of the pages in a .db file, stored in its meta page.
+// Files written before the kind was recorded hold 0, a B-tree.
+const (
+	metaKindBTree byte = iota
+	metaKindHash
+)
+
 // The database file table of contents.
 // Contains page numbers for various non-user-created pages, such as the
 // freelist and root node pages.
 // Should always default to page 0 in a new db file.
+//
+// RootPageNum is the root node of a B-tree table and the header page of a hash
+// table.
 type meta struct {
 	FreelistPageNum pageNum
 	RootPageNum     pageNum
+	Kind            byte
 }
 
 func newMeta() *meta {
@@ -42,6 +53,9 @@ func (m *meta) serializeToPage() *page {
 	binary.LittleEndian.PutUint64(p.contents[pos:], uint64(m.RootPageNum))
 	pos += globals.PageNumSize
 
+	p.contents[pos] = m.Kind
+	pos += 1
+
 	return p
 }
 
@@ -57,4 +71,7 @@ func (m *meta) deserializeFromPage(p *page) {
 
 	m.RootPageNum = pageNum(binary.LittleEndian.Uint64(p.contents[pos:]))
 	pos += globals.PageNumSize
+
+	m.Kind = p.contents[pos]
+	pos += 1
 }
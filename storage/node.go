@@ -212,6 +212,7 @@ func (n *Node) deserializeFromPage(p *page) {
 // It's assumed i <= len(node.items).
 func (n *Node) elementSize(i int) int {
 	size := len(n.items[i].Key) + len(n.items[i].Value)
+	size += globals.ItemOverheadSize
 	if !n.isLeaf() {
 		size += globals.PageNumSize
 	}
@@ -222,6 +223,7 @@ func (n *Node) elementSize(i int) int {
 // nodeSize returns the node's size in bytes.
 func (n *Node) nodeSize() int {
 	size := 0
+	size += globals.PageMarkerSize
 	size += globals.NodeHeaderSize
 
 	for i := range n.items {
@@ -437,6 +439,12 @@ func (n *Node) merge(bNode *Node, bNodeIndex int) error {
 	n.tbl.WriteNodes(aNode, n)
 	n.tbl.DeleteNode(bNode.pageNum)
 
+	// Neither sibling could spare an item, but with large items the merged
+	// node can still outgrow a page, so split it back up.
+	if aNode.isOverPopulated() {
+		n.split(aNode, bNodeIndex-1)
+	}
+
 	return nil
 }
 
@@ -12,6 +12,8 @@ type Options struct {
 
 	MaxFillPercent float32 // Percentage to be filled before the node split.
 	MaxThreshold   float32 // Bytes to be filled before the node split.
+
+	MemtableSize int // Bytes an LSM memtable holds before it is flushed.
 }
 
 // NewOptions builds a table options struct from the global values assembled by
@@ -25,7 +27,17 @@ func NewOptions() *Options {
 
 		MaxFillPercent: globals.MaxFillPercent,
 		MaxThreshold:   globals.MaxFillPercent * float32(globals.PageSize),
+
+		MemtableSize: globals.MemtableSize,
 	}
 
 	return o
 }
+
+// Table kinds that can be requested with MAKE(table, kind).
+const (
+	KindBTree  = "btree"  // Default kind, pages are persisted to a .db file.
+	KindMemory = "memory" // Pages are only kept in memory, nothing is persisted.
+	KindLSM    = "lsm"    // Log-structured, sorted segment files are persisted.
+	KindHash   = "hash"   // Linear hash index in a .db file, unordered.
+)
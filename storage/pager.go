@@ -3,22 +3,53 @@ package storage
 import (
 	"errors"
 	"io"
-	"os"
 
 	"orchiddb/globals"
+	"orchiddb/vfs"
 )
 
 // Pager is a helper struct for opening db files to read/write/sync pages.
+// All file access goes through the pager's filesystem.
 type Pager struct {
-	f *os.File
+	fs vfs.FS
+	f  vfs.File
+
+	inMemory bool // Pages are never persisted, no WAL is required.
+}
+
+// OpenPager opens, or creates, the table file at path in fsys.
+func OpenPager(fsys vfs.FS, path string) (*Pager, error) {
+	f, err := fsys.Open(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Pager{fs: fsys, f: f}, nil
 }
 
-func OpenPager(path string) (*Pager, error) {
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+// OpenMemoryPager returns a pager whose pages only live in process memory.
+// Nothing is written to disk and the pages are dropped once it is closed.
+func OpenMemoryPager(name string) (*Pager, error) {
+	p, err := OpenPager(vfs.NewMemFS(), name)
 	if err != nil {
 		return nil, err
 	}
-	return &Pager{f: f}, nil
+	p.inMemory = true
+	return p, nil
+}
+
+// FS returns the filesystem the pager's file lives in.
+func (p *Pager) FS() vfs.FS {
+	return p.fs
+}
+
+// Name returns the name of the file backing the pager.
+func (p *Pager) Name() string {
+	return p.f.Name()
+}
+
+// IsMemory returns whether the pager keeps its pages in memory only.
+func (p *Pager) IsMemory() bool {
+	return p.inMemory
 }
 
 func (p *Pager) Close() error {
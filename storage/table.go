@@ -3,11 +3,11 @@ package storage
 import (
 	"bytes"
 	"fmt"
-	"os"
 	"sync"
 
 	"orchiddb/globals"
 	"orchiddb/paths"
+	"orchiddb/vfs"
 )
 
 // Table is the database struct that uses a pager to read/write/create pages and
@@ -31,29 +31,95 @@ type Table struct {
 // If it does not exist, a new one is created.
 // Returns error, if any.
 func GetTable(path string) (*Table, error) {
+	return GetTableFS(vfs.Default, path)
+}
+
+// GetTableFS gets the table file from the path in fsys.
+// If it does not exist, a new one is created.
+// Returns error, if any.
+func GetTableFS(fsys vfs.FS, path string) (*Table, error) {
 	options := NewOptions()
 
-	_, err := os.Stat(path)
-	if err == nil {
-		return openTable(path, options)
+	tableName, err := paths.GetStem(path)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := fsys.Exists(path)
+
+	pager, err := OpenPager(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tbl *Table
+	if exists {
+		tbl, err = openTable(tableName, pager, options)
+	} else {
+		tbl, err = createTable(tableName, pager, options)
+	}
+	if err != nil {
+		if closeErr := pager.Close(); closeErr != nil {
+			fmt.Println("[ERROR]", closeErr)
+		}
+		return nil, err
 	}
 
-	return createTable(path, options)
+	return tbl, nil
 }
 
-// createTable creates a new table file with: page 0 = meta; page 1 = freelist,
-// page 2 = initial root node.
-func createTable(path string, options *Options) (tbl *Table, err error) {
-	pager, err := OpenPager(path)
+// OpenTableFile opens the existing .db file at path in fsys as the kind of
+// table recorded in its meta page, a B-tree or a hash table.
+func OpenTableFile(fsys vfs.FS, path string) (Engine, error) {
+	tableName, err := paths.GetStem(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pager, err := OpenPager(fsys, path)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if closeErr := pager.Close(); closeErr != nil && err == nil {
-			err = closeErr
+
+	var tbl Engine
+	metaPg, err := pager.readPage(MetaPageNum)
+	if err == nil {
+		m := newMeta()
+		m.deserializeFromPage(metaPg)
+
+		switch m.Kind {
+		case metaKindBTree:
+			tbl, err = openTable(tableName, pager, NewOptions())
+		case metaKindHash:
+			tbl, err = openHashTable(tableName, pager)
+		default:
+			err = fmt.Errorf("unknown table kind %d in %s", m.Kind, path)
 		}
-	}()
+	}
+	if err != nil {
+		if closeErr := pager.Close(); closeErr != nil {
+			fmt.Println("[ERROR]", closeErr)
+		}
+		return nil, err
+	}
+
+	return tbl, nil
+}
 
+// GetMemoryTable creates a new table whose pages are only kept in memory.
+// The table behaves like any other table but no .db or WAL files are ever
+// written, everything is discarded once the table is closed.
+func GetMemoryTable(name string) (*Table, error) {
+	pager, err := OpenMemoryPager(name)
+	if err != nil {
+		return nil, err
+	}
+	return createTable(name, pager, NewOptions())
+}
+
+// createTable initializes a new table in the pager with: page 0 = meta;
+// page 1 = freelist, page 2 = initial root node.
+func createTable(name string, pager *Pager, options *Options) (*Table, error) {
 	// ---- write meta-page table of contents
 	m := newMeta()
 	fr := newFreelist()
@@ -74,13 +140,8 @@ func createTable(path string, options *Options) (tbl *Table, err error) {
 		return nil, err
 	}
 
-	tableName, err := paths.GetStem(path)
-	if err != nil {
-		return nil, err
-	}
-
-	tbl = &Table{
-		Name:     tableName,
+	tbl := &Table{
+		Name:     name,
 		rwMutex:  sync.RWMutex{},
 		options:  *options,
 		meta:     m,
@@ -103,24 +164,9 @@ func createTable(path string, options *Options) (tbl *Table, err error) {
 	return tbl, nil
 }
 
-// openTable opens an existing table file, reading page 0 (meta) then the
-// following infrastructure pages.
-func openTable(path string, options *Options) (tbl *Table, err error) {
-	pager, err := OpenPager(path)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if closeErr := pager.Close(); closeErr != nil && err == nil {
-			err = closeErr
-		}
-	}()
-
-	tableName, err := paths.GetStem(path)
-	if err != nil {
-		return nil, err
-	}
-
+// openTable opens an existing table from the pager, reading page 0 (meta)
+// then the following infrastructure pages.
+func openTable(name string, pager *Pager, options *Options) (*Table, error) {
 	// ---- read meta (page 0)
 	metaPg, err := pager.readPage(MetaPageNum)
 	if err != nil {
@@ -128,6 +174,9 @@ func openTable(path string, options *Options) (tbl *Table, err error) {
 	}
 	m := newMeta()
 	m.deserializeFromPage(metaPg)
+	if m.Kind != metaKindBTree {
+		return nil, fmt.Errorf("%s is not a %s table", name, KindBTree)
+	}
 
 	// ---- read freelist (meta.freelistPage)
 	flPg, err := pager.readPage(m.FreelistPageNum)
@@ -142,7 +191,7 @@ func openTable(path string, options *Options) (tbl *Table, err error) {
 	txn.freelist = fl
 
 	return &Table{
-		Name:     tableName,
+		Name:     name,
 		rwMutex:  sync.RWMutex{},
 		options:  *options,
 		meta:     m,
@@ -151,14 +200,42 @@ func openTable(path string, options *Options) (tbl *Table, err error) {
 	}, nil
 }
 
+// IsMemory returns whether the table only lives in memory.
+func (tbl *Table) IsMemory() bool {
+	return tbl.Txn.Pager.IsMemory()
+}
+
 func (tbl *Table) Commit() error {
 	return tbl.Txn.Commit()
 }
 
+// Rollback discards the staged pages and reloads the meta and freelist pages
+// as they were last committed.
+func (tbl *Table) Rollback() error {
+	tbl.rwMutex.Lock()
+	defer tbl.rwMutex.Unlock()
+
+	return tbl.Txn.rollback()
+}
+
 func (tbl *Table) Close() error {
 	return tbl.Txn.Pager.Close()
 }
 
+// Drop closes the table and removes its .db file.
+// In-memory tables have no file, closing them discards their contents.
+func (tbl *Table) Drop() error {
+	if err := tbl.Close(); err != nil {
+		return err
+	}
+	if tbl.IsMemory() {
+		return nil
+	}
+
+	pager := tbl.Txn.Pager
+	return pager.FS().Remove(pager.Name())
+}
+
 // -------Page Management-------------------------------------------------------
 
 // allocatePage returns a fresh page number, writing back freelist state.
@@ -203,7 +280,7 @@ func (tbl *Table) GetNode(pageNum pageNum) (*Node, error) {
 	n, exists := tbl.Txn.dirtyPages[pageNum]
 	if exists {
 		// No point reading if node has been updated but yet to be written.
-		return n, nil
+		return n.(*Node), nil
 	}
 
 	pg, err := tbl.Txn.Pager.readPage(pageNum)
@@ -260,7 +337,7 @@ func (tbl *Table) WriteNode(n *Node) {
 		pg = newEmptyPage(n.pageNum)
 	}
 
-	tbl.Txn.appendPage(n)
+	tbl.Txn.appendPage(n.pageNum, n)
 }
 
 // WriteNodes serializes the given nodes into pages and adds them to the current
@@ -285,6 +362,7 @@ func (tbl *Table) DeleteNode(pageNum pageNum) {
 // Otherwise -1 is returned.
 func (tbl *Table) getSplitIndex(node *Node) int {
 	size := 0
+	size += globals.PageMarkerSize
 	size += globals.NodeHeaderSize
 
 	for i := range node.items {
@@ -330,6 +408,15 @@ func (tbl *Table) Get(key []byte) (*Item, error) {
 // then a new root of a new layer is created and the created nodes from the
 // split are added as children.
 func (tbl *Table) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return fmt.Errorf("key cannot be empty")
+	}
+	if len(key) > globals.MaxItemFieldSize || len(value) > globals.MaxItemFieldSize {
+		return fmt.Errorf(
+			"key and value cannot exceed %d bytes", globals.MaxItemFieldSize,
+		)
+	}
+
 	tbl.rwMutex.Lock()
 	defer tbl.rwMutex.Unlock()
 
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"fmt"
-	"os"
+	"maps"
+	"path/filepath"
+	"slices"
 
 	"orchiddb/filestamp"
 	"orchiddb/globals"
@@ -16,19 +18,25 @@ type Transaction struct {
 
 	meta       *meta
 	freelist   *freelist
-	dirtyPages map[pageNum]*Node
+	dirtyPages map[pageNum]pageSerializer
+}
+
+// pageSerializer is an in-memory page structure, such as a node or a hash
+// bucket, that can be written into its page on commit.
+type pageSerializer interface {
+	serializeToPage(p *page)
 }
 
 func NewTransaction(pgr *Pager) *Transaction {
 	return &Transaction{
 		Pager:      pgr,
 		wal:        NewWal(),
-		dirtyPages: map[pageNum]*Node{},
+		dirtyPages: map[pageNum]pageSerializer{},
 	}
 }
 
-func (t *Transaction) appendPage(n *Node) {
-	t.dirtyPages[n.pageNum] = n
+func (t *Transaction) appendPage(pn pageNum, ps pageSerializer) {
+	t.dirtyPages[pn] = ps
 }
 
 // Commit makes a WAL file before actually committing the changes to the DB.
@@ -38,14 +46,26 @@ func (t *Transaction) appendPage(n *Node) {
 // on db reboot.
 // Finally, the WAL file is deleted as to not confuse a system on reboot and
 // conserve disk space.
+//
+// In-memory tables have nothing to recover, so their pages are written
+// straight to the pager without a WAL.
 func (t *Transaction) Commit() error {
-	tableName, err := paths.GetStem(t.Pager.f)
+	t.stagePages()
+
+	if t.Pager.IsMemory() {
+		return t.writeToTable()
+	}
+
+	tableName, err := paths.GetStem(t.Pager.Name())
 	if err != nil || tableName == "" {
-		return fmt.Errorf("could not get table name from %s", t.Pager.f.Name())
+		return fmt.Errorf("could not get table name from %s", t.Pager.Name())
 	}
-	logFile := filestamp.FileNameMonotonic(tableName, globals.WAL_SUFFIX)
+	logFile := filepath.Join(
+		filepath.Dir(t.Pager.Name()),
+		filestamp.FileNameMonotonic(tableName, globals.WAL_SUFFIX),
+	)
 
-	if err := t.writeLog(logFile); err != nil {
+	if err := t.wal.WriteLog(t.Pager.FS(), logFile); err != nil {
 		return err
 	}
 
@@ -53,16 +73,16 @@ func (t *Transaction) Commit() error {
 		return err
 	}
 
-	if err := os.Remove(logFile); err != nil {
-		fmt.Println("[ERROR]", err)
+	if err := t.Pager.FS().Remove(logFile); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// writeLog writes the write-ahead-log for the updated pages in the transaction.
-func (t *Transaction) writeLog(path string) error {
+// stagePages appends the updated pages in the transaction to the
+// write-ahead-log.
+func (t *Transaction) stagePages() {
 	if t.meta != nil {
 		mPg := t.meta.serializeToPage()
 		t.wal.appendPage(mPg)
@@ -72,17 +92,40 @@ func (t *Transaction) writeLog(path string) error {
 		t.wal.appendPage(flPg)
 	}
 	if len(t.dirtyPages) > 0 {
-		for _, n := range t.dirtyPages {
-			nPg := newEmptyPage(n.pageNum)
-			n.serializeToPage(nPg)
+		// Stage in page order so commits write the same way every time.
+		pageNums := slices.Sorted(maps.Keys(t.dirtyPages))
+		for _, pn := range pageNums {
+			nPg := newEmptyPage(pn)
+			t.dirtyPages[pn].serializeToPage(nPg)
 			t.wal.appendPage(nPg)
 		}
 	}
+}
+
+// rollback discards the staged pages and re-reads the meta and freelist pages
+// from the pager into the transaction's meta and freelist.
+// The table shares them with the transaction, so it sees the reloaded state.
+func (t *Transaction) rollback() error {
+	t.wal.reset()
+	t.dirtyPages = map[pageNum]pageSerializer{}
+
+	metaPg, err := t.Pager.readPage(MetaPageNum)
+	if err != nil {
+		return fmt.Errorf("read meta: %w", err)
+	}
+	t.meta.deserializeFromPage(metaPg)
+
+	flPg, err := t.Pager.readPage(t.meta.FreelistPageNum)
+	if err != nil {
+		return fmt.Errorf("read freelist: %w", err)
+	}
+	t.freelist.deserializeFromPage(flPg)
 
-	return t.wal.WriteLog(path)
+	return nil
 }
 
 // writeToTable commits the actual updated pages to the .db file.
+// The pages are synced before returning so the WAL can safely be removed.
 func (t *Transaction) writeToTable() error {
 	for _, p := range t.wal.pages {
 		err := t.Pager.WritePage(p)
@@ -91,8 +134,12 @@ func (t *Transaction) writeToTable() error {
 		}
 	}
 
+	if err := t.Pager.Sync(); err != nil {
+		return err
+	}
+
 	// reset after dirty pages written
 	t.wal.reset()
-	t.dirtyPages = map[pageNum]*Node{}
+	t.dirtyPages = map[pageNum]pageSerializer{}
 	return nil
 }
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"orchiddb/globals"
+)
+
+// Versioned wraps an engine to keep the last versions of every key, each with
+// the sequence number of the commit that wrote it and a timestamp.
+//
+// The history is kept in the wrapped engine itself under reserved keys
+// starting with a 0 byte, so it is committed in the same transaction as the
+// value and works with every table kind:
+//
+//	\x00n              the number of versions kept per key
+//	\x00s              the last commit sequence number
+//	\x00v<key>         the version index of key, newest first
+//	\x00h<key><seq>    the value of key written by commit seq
+//
+// The current value stays under the key itself, so Get costs the same as
+// without versions. Reserved keys are hidden from Get and Cursor and cannot be
+// written by users.
+type Versioned struct {
+	inner Engine
+
+	versions int
+	seq      uint64 // The last committed sequence number.
+	dirty    bool   // Whether changes are staged for the next commit.
+}
+
+// Version is a single version of a key.
+type Version struct {
+	Seq     uint64
+	Time    time.Time
+	Value   []byte
+	Deleted bool
+}
+
+const (
+	// MaxVersions is the most versions of a key whose index fits in a value.
+	MaxVersions = globals.MaxItemFieldSize / versionEntrySize
+
+	versionPrefix    = 0x00
+	versionEntrySize = 16 // seq (8) with the deleted flag as its top bit | time (8)
+	versionDeleted   = 1 << 63
+
+	versionIndexTag = 'v'
+	versionValueTag = 'h'
+
+	// The key length taken up by a version value key's prefix, tag and seq.
+	versionKeyOverhead = 2 + 8
+)
+
+// ErrReservedKey is returned for a write to a key starting with a 0 byte, which
+// are kept for the records of Versioned.
+var ErrReservedKey = errors.New("keys starting with a 0 byte are reserved")
+
+var (
+	versionCountKey = []byte{versionPrefix, 'n'}
+	versionSeqKey   = []byte{versionPrefix, 's'}
+)
+
+// NewVersioned starts keeping the last versions of every key of inner, which
+// is recorded in inner so LoadVersioned finds it again.
+func NewVersioned(inner Engine, versions int) (*Versioned, error) {
+	if versions < 1 || versions > MaxVersions {
+		return nil, fmt.Errorf("versions must be between 1 and %d", MaxVersions)
+	}
+
+	v, err := openVersioned(inner, versions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := inner.Put(versionCountKey, []byte{byte(versions)}); err != nil {
+		return nil, err
+	}
+	if err := inner.Commit(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// LoadVersioned returns inner wrapped in a Versioned if it keeps versions,
+// otherwise inner itself.
+func LoadVersioned(inner Engine) (Engine, error) {
+	item, err := inner.Get(versionCountKey)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil || len(item.Value) != 1 {
+		return inner, nil
+	}
+
+	return openVersioned(inner, int(item.Value[0]))
+}
+
+func openVersioned(inner Engine, versions int) (*Versioned, error) {
+	v := &Versioned{inner: inner, versions: versions}
+
+	item, err := inner.Get(versionSeqKey)
+	if err != nil {
+		return nil, err
+	}
+	if item != nil {
+		if len(item.Value) != 8 {
+			return nil, fmt.Errorf("invalid version sequence number of %d bytes", len(item.Value))
+		}
+		v.seq = binary.BigEndian.Uint64(item.Value)
+	}
+	return v, nil
+}
+
+// Versions returns the number of versions kept per key.
+func (v *Versioned) Versions() int {
+	return v.versions
+}
+
+// IsMemory returns whether the wrapped table only lives in memory.
+func (v *Versioned) IsMemory() bool {
+	m, ok := v.inner.(interface{ IsMemory() bool })
+	return ok && m.IsMemory()
+}
+
+// -------Engine----------------------------------------------------------------
+
+func (v *Versioned) Get(key []byte) (*Item, error) {
+	if isReservedKey(key) {
+		return nil, nil
+	}
+	return v.inner.Get(key)
+}
+
+func (v *Versioned) Put(key []byte, value []byte) error {
+	if err := checkVersionedKey(key); err != nil {
+		return err
+	}
+
+	if err := v.inner.Put(key, value); err != nil {
+		return err
+	}
+	return v.record(key, value, false)
+}
+
+// Del removes the key and records the delete as a version of it.
+func (v *Versioned) Del(key []byte) error {
+	if isReservedKey(key) {
+		return nil
+	}
+
+	item, err := v.inner.Get(key)
+	if err != nil || item == nil {
+		return err
+	}
+
+	if err := v.inner.Del(key); err != nil {
+		return err
+	}
+	return v.record(key, nil, true)
+}
+
+// Cursor returns a cursor over the wrapped engine that skips reserved keys.
+func (v *Versioned) Cursor() (Cursor, error) {
+	c, err := v.inner.Cursor()
+	if err != nil {
+		return nil, err
+	}
+	return &versionedCursor{inner: c}, nil
+}
+
+// Each calls fn for every item of the wrapped engine but the reserved keys.
+func (v *Versioned) Each(fn func(item *Item) error) error {
+	return ForEach(v.inner, func(item *Item) error {
+		if isReservedKey(item.Key) {
+			return nil
+		}
+		return fn(item)
+	})
+}
+
+// Commit commits the staged changes with the next sequence number.
+func (v *Versioned) Commit() error {
+	if !v.dirty {
+		return v.inner.Commit()
+	}
+
+	seq := v.seq + 1
+	if err := v.inner.Put(versionSeqKey, binary.BigEndian.AppendUint64(nil, seq)); err != nil {
+		return err
+	}
+	if err := v.inner.Commit(); err != nil {
+		return err
+	}
+
+	v.seq = seq
+	v.dirty = false
+	return nil
+}
+
+func (v *Versioned) Rollback() error {
+	v.dirty = false
+	return v.inner.Rollback()
+}
+
+func (v *Versioned) Close() error { return v.inner.Close() }
+func (v *Versioned) Drop() error  { return v.inner.Drop() }
+
+// -------History---------------------------------------------------------------
+
+// History returns the kept versions of key, newest first.
+func (v *Versioned) History(key []byte) ([]*Version, error) {
+	if isReservedKey(key) {
+		return nil, nil
+	}
+
+	versions, err := v.readIndex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ver := range versions {
+		if ver.Deleted {
+			continue
+		}
+		item, err := v.inner.Get(versionValueKey(key, ver.Seq))
+		if err != nil {
+			return nil, err
+		}
+		if item == nil {
+			return nil, fmt.Errorf("value of %s version %d is missing", key, ver.Seq)
+		}
+		ver.Value = item.Value
+	}
+	return versions, nil
+}
+
+// GetVersion returns the version of key written by commit seq, or nil if it is
+// not kept.
+func (v *Versioned) GetVersion(key []byte, seq uint64) (*Version, error) {
+	versions, err := v.History(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ver := range versions {
+		if ver.Seq == seq {
+			return ver, nil
+		}
+	}
+	return nil, nil
+}
+
+// record adds a version of key to its index as part of the next commit,
+// pruning the oldest versions past the number kept.
+// Writing the same key again in one commit replaces its version.
+func (v *Versioned) record(key, value []byte, deleted bool) error {
+	versions, err := v.readIndex(key)
+	if err != nil {
+		return err
+	}
+
+	ver := &Version{Seq: v.seq + 1, Time: time.Now().UTC(), Deleted: deleted}
+	if len(versions) > 0 && versions[0].Seq == ver.Seq {
+		versions[0] = ver
+	} else {
+		versions = append([]*Version{ver}, versions...)
+	}
+
+	for _, pruned := range versions[min(len(versions), v.versions):] {
+		if err := v.inner.Del(versionValueKey(key, pruned.Seq)); err != nil {
+			return err
+		}
+	}
+	versions = versions[:min(len(versions), v.versions)]
+
+	valueKey := versionValueKey(key, ver.Seq)
+	if deleted {
+		err = v.inner.Del(valueKey)
+	} else {
+		err = v.inner.Put(valueKey, value)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := v.inner.Put(versionIndexKey(key), encodeVersionIndex(versions)); err != nil {
+		return err
+	}
+
+	v.dirty = true
+	return nil
+}
+
+// readIndex returns the versions of key, without their values, newest first.
+func (v *Versioned) readIndex(key []byte) ([]*Version, error) {
+	item, err := v.inner.Get(versionIndexKey(key))
+	if err != nil || item == nil {
+		return nil, err
+	}
+
+	var versions []*Version
+	for buf := item.Value; len(buf) >= versionEntrySize; buf = buf[versionEntrySize:] {
+		seq := binary.BigEndian.Uint64(buf)
+		nanos := int64(binary.BigEndian.Uint64(buf[8:]))
+		versions = append(versions, &Version{
+			Seq:     seq &^ versionDeleted,
+			Time:    time.Unix(0, nanos).UTC(),
+			Deleted: seq&versionDeleted != 0,
+		})
+	}
+	return versions, nil
+}
+
+func encodeVersionIndex(versions []*Version) []byte {
+	buf := make([]byte, 0, len(versions)*versionEntrySize)
+	for _, ver := range versions {
+		seq := ver.Seq
+		if ver.Deleted {
+			seq |= versionDeleted
+		}
+		buf = binary.BigEndian.AppendUint64(buf, seq)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(ver.Time.UnixNano()))
+	}
+	return buf
+}
+
+// -------Reserved Keys---------------------------------------------------------
+
+func isReservedKey(key []byte) bool {
+	return len(key) > 0 && key[0] == versionPrefix
+}
+
+// checkVersionedKey returns an error if key cannot be written by users of a
+// Versioned, either because it is reserved or leaves no room for the version
+// keys built from it.
+func checkVersionedKey(key []byte) error {
+	if isReservedKey(key) {
+		return ErrReservedKey
+	}
+	if len(key)+versionKeyOverhead > globals.MaxItemFieldSize {
+		return fmt.Errorf(
+			"keys of versioned tables cannot exceed %d bytes",
+			globals.MaxItemFieldSize-versionKeyOverhead,
+		)
+	}
+	return nil
+}
+
+func versionIndexKey(key []byte) []byte {
+	return append([]byte{versionPrefix, versionIndexTag}, key...)
+}
+
+func versionValueKey(key []byte, seq uint64) []byte {
+	k := append([]byte{versionPrefix, versionValueTag}, key...)
+	return binary.BigEndian.AppendUint64(k, seq)
+}
+
+// -------Cursor----------------------------------------------------------------
+
+// versionedCursor is a cursor that skips the reserved keys of a Versioned.
+type versionedCursor struct {
+	inner Cursor
+}
+
+func (c *versionedCursor) First() (*Item, error) {
+	return c.skipReserved(c.inner.First())
+}
+
+func (c *versionedCursor) Seek(key []byte) (*Item, error) {
+	return c.skipReserved(c.inner.Seek(key))
+}
+
+func (c *versionedCursor) Next() (*Item, error) {
+	return c.skipReserved(c.inner.Next())
+}
+
+func (c *versionedCursor) skipReserved(item *Item, err error) (*Item, error) {
+	for err == nil && item != nil && bytes.HasPrefix(item.Key, []byte{versionPrefix}) {
+		item, err = c.inner.Next()
+	}
+	return item, err
+}
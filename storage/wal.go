@@ -4,9 +4,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"os"
+	"hash/crc32"
 
 	"orchiddb/globals"
+	"orchiddb/vfs"
 )
 
 // WAL (write-ahead-log) is a log detailing the actions that will be committed
@@ -35,6 +36,10 @@ type WAL struct {
 	pages []*page
 }
 
+// walMetaHeaderSize is the size of the WAL meta page before the page numbers:
+// the page marker, page count and checksum.
+const walMetaHeaderSize = globals.PageMarkerSize + 4 + 4
+
 func NewWal() *WAL {
 	return &WAL{
 		pages: []*page{},
@@ -50,22 +55,24 @@ func (w *WAL) appendPage(p *page) {
 }
 
 // WriteLog loops through WAL.pages, serializing them, and then writing them out
-// to the path.
+// to the path in fsys.
 //
 // When serializing, a success marker is placed at the end of the byte array to
 // be written out. If the bytes could not be successfully written out, there
 // should be no success marker present in the final WAL file.
-func (w *WAL) WriteLog(path string) (err error) {
+//
+// The file is synced before returning, the log must be durable before any of
+// its pages are written to the table.
+func (w *WAL) WriteLog(fsys vfs.FS, path string) (err error) {
 	if len(w.pages) == 0 {
 		return fmt.Errorf("WAL has no pages to write")
 	}
 
-	_, err = os.Stat(path)
-	if err == nil {
+	if fsys.Exists(path) {
 		return fmt.Errorf("WAL file for %s already exists", path)
 	}
 
-	walFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	walFile, err := fsys.Open(path, true)
 	if err != nil {
 		return err
 	}
@@ -75,8 +82,13 @@ func (w *WAL) WriteLog(path string) (err error) {
 		}
 	}()
 
+	metaPage, err := w.serializeWalMetaPage()
+	if err != nil {
+		return err
+	}
+
 	var out []byte
-	out = append(out, w.serializeWalMetaPage()...)
+	out = append(out, metaPage...)
 	for _, pg := range w.pages {
 		out = append(out, pg.contents...)
 	}
@@ -87,21 +99,42 @@ func (w *WAL) WriteLog(path string) (err error) {
 		return fmt.Errorf("unable to write wal to %s: %w", path, err)
 	}
 
-	return nil
+	return walFile.Sync()
 }
 
 // serializeWalMetaPage returns a byte array page content of the uint64 page
 // numbers that are in the WAL file.
 // Like all pages, this page beings with a page marker for validity.
-func (w *WAL) serializeWalMetaPage() []byte {
+//
+// Layout: marker | page count (uint32) | checksum (uint32) | page numbers...
+//
+// The checksum is the CRC-32 of all logged page contents, a torn log that
+// still happens to end in the success marker is not replayed.
+func (w *WAL) serializeWalMetaPage() ([]byte, error) {
+	if walMetaHeaderSize+len(w.pages)*globals.PageNumSize > globals.PageSize {
+		return nil, fmt.Errorf("WAL cannot hold %d pages", len(w.pages))
+	}
+
 	out := make([]byte, globals.PageSize)
 	insertPageMarker(out)
+	pos := globals.PageMarkerSize
+
+	binary.LittleEndian.PutUint32(out[pos:], uint32(len(w.pages)))
+	pos += 4
 
+	crc := crc32.NewIEEE()
 	for _, p := range w.pages {
-		binary.LittleEndian.PutUint64(out, uint64(p.pageNum))
+		crc.Write(p.contents)
 	}
+	binary.LittleEndian.PutUint32(out[pos:], crc.Sum32())
+	pos += 4
 
-	return out
+	for _, p := range w.pages {
+		binary.LittleEndian.PutUint64(out[pos:], uint64(p.pageNum))
+		pos += globals.PageNumSize
+	}
+
+	return out, nil
 }
 
 // RecoverFromLog inspects a write-ahead-log file.
@@ -117,22 +150,23 @@ func (w *WAL) serializeWalMetaPage() []byte {
 // intended action of the user cannot be fully determined, the actions are
 // simply discarded, and we do not necessarily care why.
 func RecoverFromLog(path string, pager *Pager) (err error) {
-	logFile, err := os.Open(path)
+	fsys := pager.FS()
+
+	logFile, err := fsys.Open(path, false)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		if closeErr := closeAndRemove(logFile); closeErr != nil && err == nil {
+		if closeErr := closeAndRemove(fsys, logFile); closeErr != nil && err == nil {
 			err = closeErr
 		}
 	}()
 
-	info, err := logFile.Stat()
+	size, err := logFile.Size()
 	if err != nil {
 		return err
 	}
 
-	size := info.Size()
 	if !verifyWalFileSize(size) {
 		return fmt.Errorf("invalid WAL file size")
 	}
@@ -157,15 +191,35 @@ func RecoverFromLog(path string, pager *Pager) (err error) {
 		return err
 	}
 
-	return nil
+	return pager.Sync()
+}
+
+// RecoverTable replays the WAL file at walPath onto the table file at path in
+// fsys.
+//
+// The table file is only opened as raw pages, its meta and freelist pages may
+// be torn until the log has been replayed. The WAL file is removed afterwards
+// whether or not it could be replayed.
+func RecoverTable(fsys vfs.FS, path, walPath string) (err error) {
+	pager, err := OpenPager(fsys, path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := pager.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	return RecoverFromLog(walPath, pager)
 }
 
-// Closes the opened *os.File and returns any errors from os.Remove().
-func closeAndRemove(f *os.File) error {
+// Closes the opened file and returns any errors from removing it from fsys.
+func closeAndRemove(fsys vfs.FS, f vfs.File) error {
 	if err := f.Close(); err != nil {
 		return err
 	}
-	return os.Remove(f.Name())
+	return fsys.Remove(f.Name())
 }
 
 // verifyWalFileSize returns whether the file contents can be cleanly divided
@@ -174,6 +228,11 @@ func closeAndRemove(f *os.File) error {
 func verifyWalFileSize(size int64) bool {
 	sizeAllPages := size - 4 // exclude success marker
 
+	if sizeAllPages < int64(globals.PageSize) {
+		// Not even the WAL meta page was written.
+		return false
+	}
+
 	if sizeAllPages%int64(globals.PageSize) == 0 {
 		// Contents can be sliced into pages.
 		return true
@@ -188,23 +247,38 @@ func verifyWalFileSize(size int64) bool {
 // file, and then getting all later page contents in globals.PageSize blocks
 // and recreating the pages.
 //
+// The pages are verified against the logged page count and checksum before
+// anything is written, so a torn log is discarded as a whole.
+//
 // Recreated pages are written out by pager, and if any error is encountered in
 // the pager writing process, the loop is cut short and the error is returned.
 func replayLog(log []byte, pager *Pager) error {
-	pageNums := make([]byte, globals.PageSize)
-	bytesRead := copy(pageNums, log)
-	log = log[bytesRead:] // remove pageNum array page
+	metaPage := log[:globals.PageSize]
+	log = log[globals.PageSize:] // remove pageNum array page
 
-	numItems := len(log) / globals.PageSize
+	if !bytes.Equal(metaPage[:globals.PageMarkerSize], globals.PageMarker) {
+		return fmt.Errorf("WAL meta page marker not found")
+	}
+	pos := globals.PageMarkerSize
+
+	numItems := int(binary.LittleEndian.Uint32(metaPage[pos:]))
+	pos += 4
+	if numItems != len(log)/globals.PageSize {
+		return fmt.Errorf("WAL page count mismatch")
+	}
+
+	checksum := binary.LittleEndian.Uint32(metaPage[pos:])
+	pos += 4
+	if checksum != crc32.ChecksumIEEE(log) {
+		return fmt.Errorf("WAL checksum mismatch")
+	}
 
 	for range numItems {
-		pgNumBytes := make([]byte, globals.PageNumSize)
-		bytesRead := copy(pgNumBytes, pageNums)
-		pageNums = pageNums[bytesRead:] // remove read page number
-		pn := pageNum(binary.LittleEndian.Uint64(pgNumBytes))
+		pn := pageNum(binary.LittleEndian.Uint64(metaPage[pos:]))
+		pos += globals.PageNumSize
 
 		pageContents := make([]byte, globals.PageSize)
-		bytesRead = copy(pageContents, log)
+		bytesRead := copy(pageContents, log)
 		log = log[bytesRead:] // remove read page contents
 
 		pg := newEmptyPage(pn)
@@ -24,6 +24,42 @@ func parseCLIArgs(argv []string) error {
 	portHelp := "Which port the server uses for listening. Defaults to 6000"
 	fs.IntVar(&globals.Port, "port", globals.Port, portHelp)
 
+	respPortHelp := "Port of a listener speaking the Redis protocol (RESP). Disabled if 0."
+	fs.IntVar(&globals.RespPort, "resp-port", globals.RespPort, respPortHelp)
+
+	respTablesHelp := "Prefix of the tables Redis databases map to, db0 for database 0 by default."
+	fs.StringVar(&globals.RespTables, "resp-tables", globals.RespTables, respTablesHelp)
+
+	httpPortHelp := "Port of the HTTP/JSON gateway. Disabled if 0."
+	fs.IntVar(&globals.HTTPPort, "http-port", globals.HTTPPort, httpPortHelp)
+
+	maxConnsHelp := "Client connections open at once, over all listeners. Unlimited if 0."
+	fs.IntVar(&globals.MaxConns, "max-conns", globals.MaxConns, maxConnsHelp)
+
+	idleHelp := "Time a connection may wait between requests before it is closed. Unlimited if 0."
+	fs.DurationVar(&globals.IdleTimeout, "idle-timeout", globals.IdleTimeout, idleHelp)
+
+	readHelp := "Time a client has to send the rest of a request it started. Unlimited if 0."
+	fs.DurationVar(&globals.ReadTimeout, "read-timeout", globals.ReadTimeout, readHelp)
+
+	writeHelp := "Time a client has to take a response before it is closed. Unlimited if 0."
+	fs.DurationVar(&globals.WriteTimeout, "write-timeout", globals.WriteTimeout, writeHelp)
+
+	maxRequestHelp := "Largest request in bytes, e.g. a query line or a value."
+	fs.IntVar(&globals.MaxRequestSize, "max-request-size", globals.MaxRequestSize, maxRequestHelp)
+
+	rateHelp := "Requests per second a client address may make. Unlimited if 0."
+	fs.IntVar(&globals.RateLimit, "rate-limit", globals.RateLimit, rateHelp)
+
+	tlsCertHelp := "PEM certificate file of the listeners. Enables TLS, reloaded on SIGHUP."
+	fs.StringVar(&globals.TLSCert, "tls-cert", globals.TLSCert, tlsCertHelp)
+
+	tlsKeyHelp := "PEM private key file of -tls-cert."
+	fs.StringVar(&globals.TLSKey, "tls-key", globals.TLSKey, tlsKeyHelp)
+
+	tlsClientCAHelp := "PEM file of the CAs client certificates must be signed by. Enables mTLS."
+	fs.StringVar(&globals.TLSClientCA, "tls-client-ca", globals.TLSClientCA, tlsClientCAHelp)
+
 	pageHelp := "Size in bytes for a single database page. Defaults to OS page size."
 	fs.IntVar(&globals.PageSize, "page-size", globals.PageSize, pageHelp)
 
@@ -35,14 +71,82 @@ func parseCLIArgs(argv []string) error {
 	temp = fs.Float64("node-max", float64(globals.MaxFillPercent), maxHelp)
 	globals.MaxFillPercent = float32(*temp)
 
+	memtableHelp := "Size in bytes an LSM table's memtable grows to before it is flushed."
+	fs.IntVar(&globals.MemtableSize, "memtable-size", globals.MemtableSize, memtableHelp)
+
+	watchHelp := "Latest changes per table kept for resuming watchers."
+	fs.IntVar(&globals.WatchBuffer, "watch-buffer", globals.WatchBuffer, watchHelp)
+
+	replicaHelp := "host:port of a primary to replicate. The server becomes read-only."
+	fs.StringVar(&globals.ReplicaOf, "replica-of", globals.ReplicaOf, replicaHelp)
+
+	replicaAuthHelp := "user:password a replica authenticates to its primary with."
+	fs.StringVar(&globals.ReplicaAuth, "replica-auth", globals.ReplicaAuth, replicaAuthHelp)
+
+	replicaTLSHelp := "Connect to the primary over TLS."
+	fs.BoolVar(&globals.ReplicaTLS, "replica-tls", globals.ReplicaTLS, replicaTLSHelp)
+
+	replicaTLSCAHelp := "PEM file of the CAs to verify the primary with. Implies -replica-tls."
+	fs.StringVar(&globals.ReplicaTLSCA, "replica-tls-ca", globals.ReplicaTLSCA, replicaTLSCAHelp)
+
+	replicaTLSCertHelp := "PEM client certificate file presented to the primary. Implies -replica-tls."
+	fs.StringVar(&globals.ReplicaTLSCert, "replica-tls-cert", globals.ReplicaTLSCert, replicaTLSCertHelp)
+
+	replicaTLSKeyHelp := "PEM private key file of -replica-tls-cert."
+	fs.StringVar(&globals.ReplicaTLSKey, "replica-tls-key", globals.ReplicaTLSKey, replicaTLSKeyHelp)
+
+	usersHelp := "Credentials file. Connections must AUTH if set, created with an admin if missing."
+	fs.StringVar(&globals.UsersFile, "users", globals.UsersFile, usersHelp)
+
+	nodeHelp := "Id of the node in a Raft cluster. Enables cluster mode."
+	fs.StringVar(&globals.NodeID, "node-id", globals.NodeID, nodeHelp)
+
+	raftAddrHelp := "host:port used for Raft traffic. Defaults to the node's address in -cluster."
+	fs.StringVar(&globals.RaftAddr, "raft-addr", globals.RaftAddr, raftAddrHelp)
+
+	raftTLSCAHelp := "PEM file of the CAs Raft peers must be signed by. Enables mTLS between nodes with -tls-cert."
+	fs.StringVar(&globals.RaftTLSCA, "raft-tls-ca", globals.RaftTLSCA, raftTLSCAHelp)
+
+	clusterHelp := "Initial cluster members as id=host:port,... Omit to join an existing cluster."
+	fs.StringVar(&globals.Cluster, "cluster", globals.Cluster, clusterHelp)
+
+	snapshotHelp := "Applied Raft log entries between snapshots of the tables."
+	fs.IntVar(&globals.SnapshotEvery, "snapshot-every", globals.SnapshotEvery, snapshotHelp)
+
 	const usageString = `Orchid runtime options:
 	
   -path      string   Path to place database files. Ideally is empty directory.
   -addr      string   Which address the server uses for listening. Defaults to 127.0.0.1.
   -port      int      Which port the server usese for listening. Defaults to 6000.
+  -resp-port  int     Port of a listener speaking the Redis protocol (RESP). Disabled if 0.
+  -resp-tables string Prefix of the tables Redis databases map to, db0 for database 0 by default.
+  -http-port  int     Port of the HTTP/JSON gateway. Disabled if 0.
+  -max-conns  int     Client connections open at once, over all listeners. Defaults to 1024, unlimited if 0.
+  -idle-timeout duration Time a connection may wait between requests. Defaults to 5m, unlimited if 0.
+  -read-timeout duration Time a client has to send the rest of a request. Defaults to 30s, unlimited if 0.
+  -write-timeout duration Time a client has to take a response. Defaults to 30s, unlimited if 0.
+  -max-request-size int Largest request in bytes, e.g. a query line or a value. Defaults to 16 MiB.
+  -rate-limit int     Requests per second a client address may make. Unlimited if 0, the default.
+  -tls-cert   string  PEM certificate file of the listeners. Enables TLS, reloaded on SIGHUP.
+  -tls-key    string  PEM private key file of -tls-cert.
+  -tls-client-ca string PEM file of the CAs client certificates must be signed by. Enables mTLS.
   -page-size int      Size in bytes for a single database page. Defaults to OS page size.
   -node-min  float32  Minimum percentage a node must be filled to before consolidation.
   -node-max  float32  Maximum percentage a node must be to before splitting.
+  -memtable-size int  Size in bytes an LSM table's memtable grows to before it is flushed.
+  -watch-buffer  int  Latest changes per table kept for resuming watchers.
+  -replica-of string  host:port of a primary to replicate. The server becomes read-only.
+  -replica-auth string user:password a replica authenticates to its primary with.
+  -replica-tls        Connect to the primary over TLS.
+  -replica-tls-ca string PEM file of the CAs to verify the primary with. Implies -replica-tls.
+  -replica-tls-cert string PEM client certificate file presented to the primary. Implies -replica-tls.
+  -replica-tls-key string PEM private key file of -replica-tls-cert.
+  -users     string   Credentials file. Connections must AUTH if set, created with an admin if missing.
+  -node-id    string  Id of the node in a Raft cluster. Enables cluster mode.
+  -raft-addr  string  host:port used for Raft traffic. Defaults to the node's address in -cluster.
+  -raft-tls-ca string PEM file of the CAs Raft peers must be signed by. Enables mTLS between nodes with -tls-cert.
+  -cluster    string  Initial cluster members as id=host:port,... Omit to join an existing cluster.
+  -snapshot-every int Applied Raft log entries between snapshots of the tables.
 `
 	fs.Usage = func() {
 		fmt.Fprintf(fs.Output(), usageString)
@@ -51,6 +155,9 @@ func parseCLIArgs(argv []string) error {
 	if err := fs.Parse(argv); err != nil {
 		return err
 	}
+	if globals.MaxRequestSize <= 0 {
+		return fmt.Errorf("-max-request-size must be positive, got %d", globals.MaxRequestSize)
+	}
 
 	return nil
 }
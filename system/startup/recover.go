@@ -1,16 +1,31 @@
 package startup
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"path/filepath"
 
+	"orchiddb/globals"
 	"orchiddb/paths"
 	"orchiddb/storage"
+	"orchiddb/vfs"
 )
 
-// performRecoveryCheck checks for any table WAL files and runs a recovery
-// attempt from them.
-func performRecoveryCheck() {
+// Recover checks the database path for any table WAL files and runs a recovery
+// attempt from them, then finishes or discards any interrupted batches.
+// What was recovered is reported to logf if it is not nil. Returns the joined
+// errors of the batches that could not be finished.
+func Recover(logf func(format string, args ...any)) error {
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	recoverTableWALs(logf)
+	return recoverBatches(logf)
+}
+
+// recoverTableWALs replays the WAL file of every table that has one.
+// An invalid WAL is of a transaction that never committed, and is removed.
+func recoverTableWALs(logf func(format string, args ...any)) {
 	tableFiles := paths.GetTablePaths()
 	if tableFiles == nil {
 		return
@@ -26,31 +41,83 @@ func performRecoveryCheck() {
 			continue
 		}
 
-		db, err := storage.GetTable(t)
+		err = storage.RecoverTable(vfs.Default, t, walFile)
 		if err != nil {
-			if removeErr := os.Remove(t); removeErr != nil {
-				fmt.Printf("Error removing corrupted table %s: %v\n", t, removeErr)
-			}
+			logf("WAL invalid, removed WAL file %s: %v\n", walFile, err)
 			continue
 		}
+		logf("Recovered table %s from WAL file %s\n", tableName, walFile)
+	}
+}
+
+// recoverBatches replays every batch log whose batch has a commit record and
+// discards the rest, then removes the commit records.
+// Tables commit their part of a batch atomically and replaying a log that was
+// already applied leaves the table unchanged, so logs are replayed whole.
+func recoverBatches(logf func(format string, args ...any)) error {
+	kept := map[string]bool{} // Commit records of logs that failed to replay.
+	var errs []error
 
-		// Use anonymous func so defer runs per iteration,
-		// not at the function end
-		func() {
-			defer func() {
-				if closeErr := db.Close(); closeErr != nil {
-					fmt.Printf("Error closing table %s: %v\n", tableName, closeErr)
-				}
-			}()
-
-			err = storage.RecoverFromLog(walFile, db.Txn.Pager)
-			if err != nil {
-				if removeErr := os.Remove(walFile); removeErr != nil {
-					fmt.Printf("Error removing WAL file %s: %v\n", walFile, removeErr)
-				}
-				fmt.Printf("WAL invalid, removed WAL file %s\n", walFile)
-				return
+	for _, logPath := range paths.GetBatchLogPaths() {
+		tableName, id, ok := paths.ParseBatchLogPath(logPath)
+		if !ok {
+			continue
+		}
+
+		if vfs.Default.Exists(paths.BatchCommitPath(id)) {
+			if err := replayBatchLog(tableName, logPath, logf); err != nil {
+				errs = append(errs, fmt.Errorf("could not replay batch log %s: %w", logPath, err))
+				kept[paths.BatchCommitPath(id)] = true
+				continue
 			}
-		}()
+			logf("Recovered table %s from batch log %s\n", tableName, logPath)
+		} else {
+			logf("Discarded uncommitted batch log %s\n", logPath)
+		}
+
+		if err := vfs.Default.Remove(logPath); err != nil {
+			errs = append(errs, fmt.Errorf("could not remove batch log %s: %w", logPath, err))
+		}
+	}
+
+	for _, commitPath := range paths.GetBatchCommitPaths() {
+		if kept[commitPath] {
+			continue
+		}
+		if err := vfs.Default.Remove(commitPath); err != nil {
+			errs = append(errs, fmt.Errorf("could not remove batch commit %s: %w", commitPath, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// replayBatchLog applies and commits the operations of a batch log to the
+// table it belongs to.
+func replayBatchLog(tableName, logPath string, logf func(format string, args ...any)) error {
+	ops, err := storage.ReadBatchLog(vfs.Default, logPath)
+	if err != nil {
+		return err
+	}
+
+	var tbl storage.Engine
+	dbPath := filepath.Join(paths.DatabasePath, tableName+globals.TBL_SUFFIX)
+	lsmPath := filepath.Join(paths.DatabasePath, tableName+globals.LSM_SUFFIX)
+	switch {
+	case vfs.Default.Exists(dbPath):
+		tbl, err = OpenEngine(storage.OpenTableFile(vfs.Default, dbPath))
+	case vfs.Default.Exists(lsmPath):
+		tbl, err = OpenEngine(storage.GetLSM(vfs.Default, lsmPath))
+	default:
+		logf("table %s no longer exists, nothing to replay\n", tableName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = storage.ApplyBatch(tbl, ops)
+	if err == nil {
+		err = tbl.Commit()
 	}
+	return errors.Join(err, tbl.Close())
 }
@@ -0,0 +1,192 @@
+package startup
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"orchiddb/execution"
+	"orchiddb/parser"
+	"orchiddb/paths"
+	"orchiddb/storage"
+	"orchiddb/vfs"
+)
+
+// testDir is the database path of the tests, on a MemFS.
+const testDir = "/db"
+
+// batchTables are the tables the test batch writes to.
+var batchTables = []string{"a", "b"}
+
+// useFS makes fsys the filesystem of the server, with the database path
+// testDir, for the rest of the test.
+func useFS(t *testing.T, fsys vfs.FS) {
+	oldFS, oldPath := vfs.Default, paths.DatabasePath
+	vfs.Default, paths.DatabasePath = fsys, testDir
+	t.Cleanup(func() { vfs.Default, paths.DatabasePath = oldFS, oldPath })
+}
+
+// tablePath returns the path of a B-tree table in testDir.
+func tablePath(table string) string {
+	return filepath.Join(testDir, table+".db")
+}
+
+// newBatchFS returns a filesystem holding the batch tables, each with k set
+// to old.
+func newBatchFS(t *testing.T) *vfs.MemFS {
+	fsys := vfs.NewMemFS()
+	for _, table := range batchTables {
+		tbl, err := storage.GetTableFS(fsys, tablePath(table))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.Put([]byte("k"), []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fsys
+}
+
+// runBatch loads the batch tables from fsys and executes a batch setting k to
+// new in all of them, crashing at the given I/O point of the batch. The
+// workers are stopped and the machine restarted before it returns.
+// Returns whether the crash point was reached and the result of the batch.
+func runBatch(t *testing.T, fsys *vfs.MemFS, point int) (crashed bool, batchErr error) {
+	t.Helper()
+
+	var cmds []*parser.Command
+	for _, table := range batchTables {
+		if err := execution.MakeTable(table, "", 0); err != nil {
+			t.Fatal(err)
+		}
+		query := fmt.Sprintf("PUT(%s, k, new)", table)
+		cmds = append(cmds, parser.NewParser(parser.NewLexer(query)).ParseCommand())
+	}
+	defer func() { execution.LoadedWorkers = map[string]*execution.TableWorker{} }()
+
+	fsys.CrashAfter(point)
+	batchErr = execution.ExecuteBatch(cmds)
+	crashed = fsys.Crashed()
+	if !crashed {
+		fsys.CrashAfter(0)
+	}
+
+	execution.CloseAllTables()
+	fsys.Crash()
+	return crashed, batchErr
+}
+
+// batchValues returns the value of k in every batch table of fsys.
+func batchValues(t *testing.T, fsys vfs.FS) []string {
+	t.Helper()
+
+	var values []string
+	for _, table := range batchTables {
+		tbl, err := storage.GetTableFS(fsys, tablePath(table))
+		if err != nil {
+			t.Fatalf("open %s: %v", table, err)
+		}
+		item, err := tbl.Get([]byte("k"))
+		if err != nil {
+			t.Fatalf("get k of %s: %v", table, err)
+		}
+		if err := tbl.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		value := ""
+		if item != nil {
+			value = string(item.Value)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func TestRecoverBatchAtEveryCrashPoint(t *testing.T) {
+	for _, tear := range []bool{false, true} {
+		base := newBatchFS(t)
+
+		for point := 1; ; point++ {
+			fsys := base.Clone()
+			fsys.SetTearWrites(tear)
+			useFS(t, fsys)
+
+			crashed, batchErr := runBatch(t, fsys, point)
+			if err := Recover(nil); err != nil {
+				t.Fatalf("tear %v, crash at %d: recover: %v", tear, point, err)
+			}
+
+			values := batchValues(t, fsys)
+			want := "new"
+			if values[0] != "new" && batchErr != nil {
+				want = "old"
+			}
+			for i, value := range values {
+				if value != want {
+					t.Errorf("tear %v, crash at %d: k of %s is %q, want %q as in the other tables (batch error: %v)",
+						tear, point, batchTables[i], value, want, batchErr)
+				}
+			}
+
+			leftovers, err := fsys.List(testDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range leftovers {
+				if strings.HasSuffix(name, ".batch") || strings.HasSuffix(name, ".commit") {
+					t.Errorf("tear %v, crash at %d: %s left after recovery", tear, point, name)
+				}
+			}
+
+			if !crashed {
+				if point < 3 {
+					t.Fatalf("the batch finished within %d I/O operations", point)
+				}
+				break
+			}
+		}
+	}
+}
+
+// TestRecoverBatchCommittedButNotApplied crashes once the commit record is
+// written but before the tables commit, so only their batch logs hold the
+// batch.
+func TestRecoverBatchCommittedButNotApplied(t *testing.T) {
+	for point := 1; ; point++ {
+		fsys := newBatchFS(t)
+		useFS(t, fsys)
+
+		crashed, batchErr := runBatch(t, fsys, point)
+		if !crashed {
+			t.Fatal("no crash point between the commit record and the commits")
+		}
+		if batchErr == nil || !strings.Contains(batchErr.Error(), "committed but not yet applied") {
+			continue
+		}
+
+		if n := len(paths.GetBatchLogPaths()); n != len(batchTables) {
+			t.Fatalf("%d batch logs after the crash, want %d", n, len(batchTables))
+		}
+		if n := len(paths.GetBatchCommitPaths()); n != 1 {
+			t.Fatalf("%d commit records after the crash, want 1", n)
+		}
+
+		if err := Recover(nil); err != nil {
+			t.Fatal(err)
+		}
+		for i, value := range batchValues(t, fsys) {
+			if value != "new" {
+				t.Errorf("k of %s is %q after replaying its batch log, want %q", batchTables[i], value, "new")
+			}
+		}
+		return
+	}
+}
@@ -1,20 +1,27 @@
 package startup
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"orchiddb/execution"
 	"orchiddb/paths"
 	"orchiddb/storage"
+	"orchiddb/vfs"
 )
 
 func Startup(argv []string) {
 	parseCLI(argv)
-	performRecoveryCheck()
+	if err := Recover(printf); err != nil {
+		fmt.Printf("Error recovering: %v\n", err)
+	}
 	loadWorkers()
 }
 
+// printf prints what the startup did.
+func printf(format string, args ...any) { fmt.Printf(format, args...) }
+
 // Parses and stores the runtime flags in public vars.
 func parseCLI(argv []string) {
 	if err := parseCLIArgs(argv); err != nil {
@@ -25,18 +32,51 @@ func parseCLI(argv []string) {
 
 // Starts a worker for each table in the database path.
 func loadWorkers() {
-	tablePaths := paths.GetTablePaths()
-	if len(tablePaths) == 0 {
-		return
+	for _, p := range paths.GetTablePaths() {
+		name, err := paths.GetStem(p)
+		// Files not named after a table belong to the staging table of a
+		// replica's interrupted load, which the next load removes.
+		if err != nil || paths.CheckTableName(name) != nil {
+			continue
+		}
+		tbl, err := OpenEngine(storage.OpenTableFile(vfs.Default, p))
+		if err != nil {
+			fmt.Printf("could not load table %s: %v\n", p, err)
+			continue
+		}
+
+		w := execution.NewWorker(name, tbl) // Adds self to active table map
+		w.Start()
 	}
 
-	for _, p := range tablePaths {
-		tbl, err := storage.GetTable(p)
+	for _, p := range paths.GetLSMPaths() {
+		name, err := paths.GetStem(p)
+		// Files not named after a table belong to the staging table of a
+		// replica's interrupted load, which the next load removes.
+		if err != nil || paths.CheckTableName(name) != nil {
+			continue
+		}
+		tbl, err := OpenEngine(storage.GetLSM(vfs.Default, p))
 		if err != nil {
+			fmt.Printf("could not load LSM table %s: %v\n", p, err)
 			continue
 		}
 
-		w := execution.NewWorker(tbl) // Adds self to active table map
+		w := execution.NewWorker(name, tbl)
 		w.Start()
 	}
 }
+
+// OpenEngine finishes opening a table engine, wrapping it if it keeps
+// versions. The engine is closed on error.
+func OpenEngine[E storage.Engine](e E, err error) (storage.Engine, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	tbl, err := storage.LoadVersioned(e)
+	if err != nil {
+		return nil, errors.Join(err, e.Close())
+	}
+	return tbl, nil
+}
@@ -0,0 +1,345 @@
+package torture
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"orchiddb/paths"
+	"orchiddb/storage"
+	"orchiddb/vfs"
+)
+
+// -----------------------------------------------------------------------------
+// Torture mode runs a seeded random workload of PUTs and DELs against a table
+// on a fault injecting in-memory filesystem.
+//
+// Before every operation is committed, the operation is replayed once for
+// every I/O point inside Transaction.Commit with a simulated power loss at
+// that point. The crashed filesystem is then recovered like a real restart
+// would and the table is compared against an in-memory model.
+//
+// The same seed always produces the same workload, crash points and torn
+// writes, so a failure can be reproduced exactly.
+// -----------------------------------------------------------------------------
+
+const tableDir = "/torture"
+
+var tablePath = filepath.Join(tableDir, "torture.db")
+
+// config holds the torture run options.
+type config struct {
+	seed      int64
+	ops       int
+	keys      int
+	valueSize int
+	tear      bool
+	verbose   bool
+}
+
+// op is a single workload operation, a PUT when del is not set.
+type op struct {
+	del   bool
+	key   string
+	value string
+}
+
+func (o op) String() string {
+	if o.del {
+		return fmt.Sprintf("DEL(%s)", o.key)
+	}
+	return fmt.Sprintf("PUT(%s, %s)", o.key, o.value)
+}
+
+// model is the expected contents of the table.
+type model map[string]string
+
+func (m model) apply(o op) model {
+	next := make(model, len(m)+1)
+	for k, v := range m {
+		next[k] = v
+	}
+
+	if o.del {
+		delete(next, o.key)
+	} else {
+		next[o.key] = o.value
+	}
+	return next
+}
+
+// Run parses the torture options from argv and runs the workload.
+// Returns the process exit code.
+func Run(argv []string) int {
+	cfg, err := parseArgs(argv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	fmt.Printf(
+		"torture: seed=%d ops=%d keys=%d value-size=%d tear=%t\n",
+		cfg.seed, cfg.ops, cfg.keys, cfg.valueSize, cfg.tear,
+	)
+
+	crashes, err := run(cfg)
+	if err != nil {
+		fmt.Println("torture: FAILED:", err)
+		fmt.Printf("torture: reproduce with -seed %d\n", cfg.seed)
+		return 1
+	}
+
+	fmt.Printf("torture: OK, %d operations, %d simulated crashes\n", cfg.ops, crashes)
+	return 0
+}
+
+func parseArgs(argv []string) (*config, error) {
+	cfg := &config{}
+
+	fs := flag.NewFlagSet("torture", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+
+	fs.Int64Var(&cfg.seed, "seed", 1, "Seed for the workload, crash points and torn writes.")
+	fs.IntVar(&cfg.ops, "ops", 200, "Number of PUT/DEL operations to run.")
+	fs.IntVar(&cfg.keys, "keys", 128, "Number of distinct keys the workload uses.")
+	fs.IntVar(&cfg.valueSize, "value-size", 64, "Maximum size of a value in bytes.")
+	fs.BoolVar(&cfg.tear, "tear", false, "Tear unsynced writes on crash instead of dropping them.")
+	fs.BoolVar(&cfg.verbose, "v", false, "Print every operation.")
+
+	if err := fs.Parse(argv); err != nil {
+		return nil, err
+	}
+
+	if cfg.ops < 0 || cfg.keys <= 0 || cfg.valueSize <= 0 || cfg.valueSize > 255 {
+		return nil, fmt.Errorf("invalid torture options")
+	}
+
+	return cfg, nil
+}
+
+// run executes the workload and returns the number of simulated crashes.
+func run(cfg *config) (int, error) {
+	rng := rand.New(rand.NewSource(cfg.seed))
+
+	fsys := vfs.NewMemFS()
+	fsys.SetSeed(cfg.seed)
+	fsys.SetTearWrites(cfg.tear)
+
+	tbl, err := storage.GetTableFS(fsys, tablePath)
+	if err != nil {
+		return 0, fmt.Errorf("create table: %w", err)
+	}
+
+	expected := model{}
+	crashes := 0
+
+	for i := range cfg.ops {
+		o := randomOp(rng, cfg)
+		if cfg.verbose {
+			fmt.Printf("op %d: %s\n", i, o)
+		}
+
+		next := expected.apply(o)
+
+		n, err := crashEveryPoint(fsys, o, expected, next)
+		crashes += n
+		if err != nil {
+			return crashes, fmt.Errorf("op %d %s: %w", i, o, err)
+		}
+
+		// Advance the real table without any faults.
+		if err := applyOp(tbl, o); err != nil {
+			return crashes, fmt.Errorf("op %d %s: %w", i, o, err)
+		}
+		if err := verify(tbl, next, cfg.keys); err != nil {
+			return crashes, fmt.Errorf("op %d %s without crash: %w", i, o, err)
+		}
+		expected = next
+	}
+
+	return crashes, tbl.Close()
+}
+
+// crashEveryPoint replays o on a copy of fsys once per I/O point inside the
+// commit, crashing at that point, and verifies the recovered table holds
+// either the before or after state.
+// Returns the number of crashes simulated.
+func crashEveryPoint(fsys *vfs.MemFS, o op, before, after model) (int, error) {
+	crashes := 0
+
+	for point := 1; ; point++ {
+		crashed, err := crashAt(fsys.Clone(), o, point, before, after)
+		if err != nil {
+			return crashes, fmt.Errorf("crash at I/O point %d: %w", point, err)
+		}
+		if !crashed {
+			// The commit finished before reaching the crash point, every point
+			// has been covered.
+			return crashes, nil
+		}
+		crashes++
+	}
+}
+
+// crashAt opens the table in fsys, applies o and loses power at the given I/O
+// point of the commit. The table is then recovered and checked.
+// Returns whether the crash point was reached.
+func crashAt(fsys *vfs.MemFS, o op, point int, before, after model) (crashed bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	tbl, err := storage.GetTableFS(fsys, tablePath)
+	if err != nil {
+		return false, fmt.Errorf("open table: %w", err)
+	}
+
+	if o.del {
+		err = tbl.Del([]byte(o.key))
+	} else {
+		err = tbl.Put([]byte(o.key), []byte(o.value))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	fsys.CrashAfter(point)
+	commitErr := tbl.Commit()
+	crashed = fsys.Crashed()
+	fsys.Crash()
+
+	if commitErr != nil && !crashed {
+		return false, fmt.Errorf("commit: %w", commitErr)
+	}
+
+	if err := recoverTable(fsys); err != nil {
+		return crashed, err
+	}
+
+	recovered, err := storage.GetTableFS(fsys, tablePath)
+	if err != nil {
+		return crashed, fmt.Errorf("reopen table: %w", err)
+	}
+	defer recovered.Close()
+
+	if !crashed {
+		// A commit that returned must be durable.
+		return false, verifyModel(recovered, after, before)
+	}
+
+	errAfter := verifyModel(recovered, after, before)
+	if errAfter == nil {
+		return true, nil
+	}
+	errBefore := verifyModel(recovered, before, after)
+	if errBefore == nil {
+		return true, nil
+	}
+
+	return true, fmt.Errorf(
+		"table matches neither state: after: %v; before: %v", errAfter, errBefore,
+	)
+}
+
+// recoverTable runs the same WAL recovery a restarting server would.
+func recoverTable(fsys vfs.FS) error {
+	walFile, found := paths.FindTableWAL(fsys, tableDir, "torture")
+	if !found {
+		return nil
+	}
+
+	// An invalid log is discarded, just like on startup.
+	_ = storage.RecoverTable(fsys, tablePath, walFile)
+
+	if fsys.Exists(walFile) {
+		return fmt.Errorf("WAL file %s was not removed by recovery", walFile)
+	}
+	return nil
+}
+
+// -------Workload--------------------------------------------------------------
+
+func randomOp(rng *rand.Rand, cfg *config) op {
+	key := fmt.Sprintf("k%d", rng.Intn(cfg.keys))
+
+	// Favor puts so the tree grows enough to split and merge nodes.
+	if rng.Intn(3) == 0 {
+		return op{del: true, key: key}
+	}
+
+	return op{key: key, value: randomValue(rng, 1+rng.Intn(cfg.valueSize))}
+}
+
+const valueChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randomValue(rng *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = valueChars[rng.Intn(len(valueChars))]
+	}
+	return string(b)
+}
+
+func applyOp(tbl *storage.Table, o op) error {
+	var err error
+	if o.del {
+		err = tbl.Del([]byte(o.key))
+	} else {
+		err = tbl.Put([]byte(o.key), []byte(o.value))
+	}
+	if err != nil {
+		return err
+	}
+	return tbl.Commit()
+}
+
+// -------Verification----------------------------------------------------------
+
+// verify checks that the table holds exactly the keys of m out of the
+// workload's key space.
+func verify(tbl *storage.Table, m model, keys int) error {
+	for i := range keys {
+		key := fmt.Sprintf("k%d", i)
+		if err := verifyKey(tbl, m, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyModel checks every key that appears in either model.
+func verifyModel(tbl *storage.Table, m, other model) error {
+	for key := range m {
+		if err := verifyKey(tbl, m, key); err != nil {
+			return err
+		}
+	}
+	for key := range other {
+		if err := verifyKey(tbl, m, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyKey(tbl *storage.Table, m model, key string) error {
+	item, err := tbl.Get([]byte(key))
+	if err != nil {
+		return fmt.Errorf("get %s: %w", key, err)
+	}
+
+	want, exists := m[key]
+	switch {
+	case !exists && item != nil:
+		return fmt.Errorf("key %s should be absent, got %q", key, item.Value)
+	case exists && item == nil:
+		return fmt.Errorf("key %s is missing, want %q", key, want)
+	case exists && string(item.Value) != want:
+		return fmt.Errorf("key %s is %q, want %q", key, item.Value, want)
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// ErrCrashed is returned by every operation on a MemFS once its simulated
+// power loss point has been reached.
+var ErrCrashed = errors.New("vfs: simulated crash")
+
+// ErrNoSpace is returned by writes that would grow a MemFS past its capacity.
+var ErrNoSpace = syscall.ENOSPC
+
+// tearSize is the granularity torn writes are applied at, a disk sector.
+const tearSize = 512
+
+// MemFS is an FS that keeps every file in memory.
+//
+// Each file tracks the writes made since its last Sync. Writes that have not
+// been synced are lost when Crash is called, or, if torn writes are enabled,
+// only some sectors of them survive. File creation and removal are treated as
+// immediately durable.
+//
+// Faults can be injected to test crash recovery:
+//   - CrashAfter makes every operation fail with ErrCrashed after n more I/O
+//     operations, simulating power loss at that exact point.
+//   - SetCapacity makes writes fail with ENOSPC once the total size of all
+//     files would exceed the capacity.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+
+	rng        *rand.Rand
+	tearWrites bool  // Unsynced writes may partially survive a crash.
+	capacity   int64 // Maximum total bytes of all files, 0 = unlimited.
+
+	ops     int // Number of I/O operations performed.
+	crashAt int // Operation count at which the crash happens, 0 = never.
+	crashed bool
+}
+
+// memData is the state of a single file in a MemFS.
+type memData struct {
+	data    []byte     // contents visible to readers
+	pending []memWrite // writes since the last sync, in order
+}
+
+// memWrite is an unsynced write along with what it overwrote, so it can be
+// undone on Crash.
+type memWrite struct {
+	off  int64
+	data []byte // bytes written
+	prev []byte // bytes overwritten
+	size int64  // file size before the write
+}
+
+// NewMemFS returns an empty in-memory filesystem without any faults enabled.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: map[string]*memData{},
+		rng:   rand.New(rand.NewSource(1)),
+	}
+}
+
+// Clone returns a deep copy of the filesystem, including unsynced writes and
+// the fault settings. The crash point is not copied.
+func (m *MemFS) Clone() *MemFS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &MemFS{
+		files:      make(map[string]*memData, len(m.files)),
+		rng:        rand.New(rand.NewSource(m.rng.Int63())),
+		tearWrites: m.tearWrites,
+		capacity:   m.capacity,
+	}
+
+	for name, f := range m.files {
+		c.files[name] = &memData{
+			data:    append([]byte{}, f.data...),
+			pending: append([]memWrite{}, f.pending...),
+		}
+	}
+
+	return c
+}
+
+// -------Fault Injection-------------------------------------------------------
+
+// SetSeed seeds the random source used to decide which sectors of a torn
+// write survive a crash.
+func (m *MemFS) SetSeed(seed int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rng = rand.New(rand.NewSource(seed))
+}
+
+// SetTearWrites enables or disables torn writes on Crash.
+func (m *MemFS) SetTearWrites(tear bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tearWrites = tear
+}
+
+// SetCapacity limits the total size of all files to n bytes, 0 removes the
+// limit.
+func (m *MemFS) SetCapacity(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.capacity = n
+}
+
+// CrashAfter makes the filesystem lose power after n more I/O operations.
+// Every operation from then on fails with ErrCrashed until Crash is called.
+// n <= 0 disables the crash point.
+func (m *MemFS) CrashAfter(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n <= 0 {
+		m.crashAt = 0
+		return
+	}
+	m.crashAt = m.ops + n
+}
+
+// Ops returns the number of I/O operations performed so far.
+func (m *MemFS) Ops() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ops
+}
+
+// Crashed returns whether the crash point has been reached.
+func (m *MemFS) Crashed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.crashed
+}
+
+// Crash simulates the machine restarting after a power loss: unsynced writes
+// are dropped, or torn if enabled, and the crash point is cleared so the
+// filesystem can be used again.
+func (m *MemFS) Crash() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names) // map order is random, keep tearing reproducible
+
+	for _, name := range names {
+		f := m.files[name]
+
+		// Undo the unsynced writes, newest first.
+		for i := len(f.pending) - 1; i >= 0; i-- {
+			w := f.pending[i]
+			copy(f.data[w.off:], w.prev)
+			f.data = f.data[:w.size]
+		}
+
+		if m.tearWrites {
+			for _, w := range f.pending {
+				f.data = m.tear(f.data, w)
+			}
+		}
+
+		f.pending = nil
+	}
+
+	m.crashAt = 0
+	m.crashed = false
+}
+
+// tear applies a random subset of the sectors of w onto buf.
+func (m *MemFS) tear(buf []byte, w memWrite) []byte {
+	for start := 0; start < len(w.data); start += tearSize {
+		if m.rng.Intn(2) == 0 {
+			continue
+		}
+
+		end := min(start+tearSize, len(w.data))
+		buf = writeInto(buf, w.off+int64(start), w.data[start:end])
+	}
+	return buf
+}
+
+// step counts an I/O operation and reports whether it may proceed.
+// Must be called with m.mu held.
+func (m *MemFS) step() error {
+	if m.crashed {
+		return ErrCrashed
+	}
+
+	m.ops++
+	if m.crashAt > 0 && m.ops >= m.crashAt {
+		m.crashed = true
+		return ErrCrashed
+	}
+	return nil
+}
+
+// -------FS Interface----------------------------------------------------------
+
+func (m *MemFS) Open(name string, create bool) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.step(); err != nil {
+		return nil, err
+	}
+
+	name = filepath.Clean(name)
+	if _, exists := m.files[name]; !exists {
+		if !create {
+			return nil, fmt.Errorf("open %s: %w", name, ErrNotExist)
+		}
+		m.files[name] = &memData{}
+	}
+
+	return &memFile{fs: m, name: name}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.step(); err != nil {
+		return err
+	}
+
+	name = filepath.Clean(name)
+	if _, exists := m.files[name]; !exists {
+		return fmt.Errorf("remove %s: %w", name, ErrNotExist)
+	}
+
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.step(); err != nil {
+		return err
+	}
+
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	f, exists := m.files[oldName]
+	if !exists {
+		return fmt.Errorf("rename %s: %w", oldName, ErrNotExist)
+	}
+
+	delete(m.files, oldName)
+	m.files[newName] = f
+	return nil
+}
+
+func (m *MemFS) List(dir string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir = filepath.Clean(dir)
+
+	var contents []string
+	for name := range m.files {
+		if filepath.Dir(name) == dir {
+			contents = append(contents, name)
+		}
+	}
+	sort.Strings(contents)
+
+	return contents, nil
+}
+
+func (m *MemFS) Exists(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, exists := m.files[filepath.Clean(name)]
+	return exists
+}
+
+// usage returns the total size of all files.
+// Must be called with m.mu held.
+func (m *MemFS) usage() int64 {
+	var total int64
+	for _, f := range m.files {
+		total += int64(len(f.data))
+	}
+	return total
+}
+
+// -------Files-----------------------------------------------------------------
+
+// memFile is an open handle to a file in a MemFS.
+type memFile struct {
+	fs     *MemFS
+	name   string
+	closed bool
+}
+
+// data returns the file's state, or an error if the handle is closed or the
+// file has been removed.
+// Must be called with f.fs.mu held.
+func (f *memFile) data() (*memData, error) {
+	if f.closed {
+		return nil, fmt.Errorf("%s: file already closed", f.name)
+	}
+
+	d, exists := f.fs.files[f.name]
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", f.name, ErrNotExist)
+	}
+	return d, nil
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.step(); err != nil {
+		return 0, err
+	}
+	d, err := f.data()
+	if err != nil {
+		return 0, err
+	}
+
+	if off >= int64(len(d.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.step(); err != nil {
+		return 0, err
+	}
+	d, err := f.data()
+	if err != nil {
+		return 0, err
+	}
+
+	if f.fs.capacity > 0 {
+		growth := off + int64(len(p)) - int64(len(d.data))
+		if growth > 0 && f.fs.usage()+growth > f.fs.capacity {
+			return 0, fmt.Errorf("write %s: %w", f.name, ErrNoSpace)
+		}
+	}
+
+	w := memWrite{off: off, data: append([]byte{}, p...), size: int64(len(d.data))}
+	if off < w.size {
+		end := min(off+int64(len(p)), w.size)
+		w.prev = append([]byte{}, d.data[off:end]...)
+	}
+
+	d.data = writeInto(d.data, off, p)
+	d.pending = append(d.pending, w)
+
+	return len(p), nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.step(); err != nil {
+		return err
+	}
+	d, err := f.data()
+	if err != nil {
+		return err
+	}
+
+	d.pending = nil
+	return nil
+}
+
+func (f *memFile) Size() (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	d, err := f.data()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(d.data)), nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return fmt.Errorf("%s: file already closed", f.name)
+	}
+	f.closed = true
+	return nil
+}
+
+// writeInto copies p into buf at off, growing buf as required.
+func writeInto(buf []byte, off int64, p []byte) []byte {
+	end := off + int64(len(p))
+	if end > int64(len(buf)) {
+		grown := make([]byte, end)
+		copy(grown, buf)
+		buf = grown
+	}
+	copy(buf[off:], p)
+	return buf
+}
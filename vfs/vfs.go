@@ -0,0 +1,112 @@
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// -----------------------------------------------------------------------------
+// A small virtual filesystem that all storage I/O goes through.
+// The OS implementation is used at runtime, the in-memory implementation lets
+// tables live without any files and lets faults be injected for testing crash
+// recovery.
+// -----------------------------------------------------------------------------
+
+// File is an open file in a FS.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	Sync() error
+	Close() error
+	Name() string
+	Size() (int64, error)
+}
+
+// FS is the set of filesystem operations used by orchid's storage.
+type FS interface {
+	// Open opens the named file for reading and writing.
+	// If create is set, the file is created when it does not exist, otherwise
+	// an fs.ErrNotExist error is returned.
+	Open(name string, create bool) (File, error)
+
+	// Remove removes the named file.
+	Remove(name string) error
+
+	// Rename atomically replaces newName with oldName.
+	Rename(oldName, newName string) error
+
+	// List returns the paths of all files directly inside dir.
+	List(dir string) ([]string, error)
+
+	// Exists returns whether the named file exists.
+	Exists(name string) bool
+}
+
+// Default is the FS used by the server for all table and WAL files.
+var Default FS = OS{}
+
+// ErrNotExist is returned when opening a file that does not exist.
+var ErrNotExist = fs.ErrNotExist
+
+// -------OS FS-----------------------------------------------------------------
+
+// OS is the FS backed by the operating system's filesystem.
+type OS struct{}
+
+type osFile struct {
+	*os.File
+}
+
+func (f osFile) Size() (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (OS) Open(name string, create bool) (File, error) {
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+
+	f, err := os.OpenFile(name, flag, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{f}, nil
+}
+
+func (OS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OS) List(dir string) ([]string, error) {
+	var contents []string
+
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return contents, err
+	}
+
+	for _, item := range items {
+		if item.IsDir() {
+			continue
+		}
+		contents = append(contents, filepath.Join(dir, item.Name()))
+	}
+
+	return contents, nil
+}
+
+func (OS) Exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
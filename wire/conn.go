@@ -0,0 +1,73 @@
+package wire
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Conn is a client connection speaking the binary protocol.
+// It is not safe for concurrent use.
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+// Dial connects to the server at addr and performs the handshake. Every
+// request must be answered within timeout, 0 for no limit.
+func Dial(addr string, timeout time.Duration) (*Conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, max(timeout, 5*time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{conn: nc, r: bufio.NewReader(nc), timeout: timeout}
+	if err := c.handshake(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) handshake() error {
+	c.setDeadline()
+	if _, err := c.conn.Write([]byte{Handshake, Version}); err != nil {
+		return err
+	}
+
+	var resp [2]byte
+	if _, err := io.ReadFull(c.r, resp[:]); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+	if resp[0] != Handshake {
+		return fmt.Errorf("handshake: server does not speak the binary protocol")
+	}
+	if resp[1] != Version {
+		return fmt.Errorf("handshake: server speaks version %d, not %d", resp[1], Version)
+	}
+	return nil
+}
+
+// Do sends a request and returns its response.
+func (c *Conn) Do(op Op, args ...[]byte) (*Response, error) {
+	c.setDeadline()
+	if err := WriteRequest(c.conn, &Request{Op: op, Args: args}); err != nil {
+		return nil, err
+	}
+	return ReadResponse(c.r)
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) setDeadline() {
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	c.conn.SetDeadline(deadline)
+}
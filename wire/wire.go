@@ -0,0 +1,234 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// -----------------------------------------------------------------------------
+// The binary protocol frames every request and response with its length, so
+// keys and values can hold any bytes, newlines and NUL bytes included.
+//
+// A client starts the connection with the Handshake byte followed by the
+// protocol Version, and the server answers with the same two bytes, or with
+// its own version before closing the connection if it does not speak the
+// client's. After that, every request is a frame of:
+//
+//	uint32  length of the rest of the frame
+//	uint8   opcode
+//	fields  the arguments, each a uint32 length followed by that many bytes
+//
+// and the server answers every request, in order, with a frame of:
+//
+//	uint32  length of the rest of the frame
+//	uint8   status
+//	fields  the results, each a uint32 length followed by that many bytes
+//
+// Integers are big endian. A query line of the text protocol never starts
+// with a NUL byte, which is how the server tells the protocols apart.
+// -----------------------------------------------------------------------------
+
+const (
+	Handshake byte = 0x00
+	Version   byte = 1
+
+	// MaxFrameSize is the largest frame accepted, in bytes.
+	MaxFrameSize = 64 << 20
+)
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// Op is the opcode of a request.
+type Op uint8
+
+const (
+	OpGet    Op = iota + 1 // table, key -> value
+	OpPut                  // table, key, value
+	OpDel                  // table, key
+	OpScan                 // table, start[, end] -> key, value, key, value...
+	OpMake                 // table[, kind[, versions]]
+	OpDrop                 // table
+	OpTables               // -> table, table...
+	OpAuth                 // user, password
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpGet:
+		return "GET"
+	case OpPut:
+		return "PUT"
+	case OpDel:
+		return "DEL"
+	case OpScan:
+		return "SCAN"
+	case OpMake:
+		return "MAKE"
+	case OpDrop:
+		return "DROP"
+	case OpTables:
+		return "TABLES"
+	case OpAuth:
+		return "AUTH"
+	default:
+		return fmt.Sprintf("op(%d)", uint8(op))
+	}
+}
+
+// Status is the outcome of a request. The fields of a response that is not
+// StatusOK hold a message.
+type Status uint8
+
+const (
+	StatusOK         Status = iota
+	StatusNotFound          // GET of a missing key, no fields.
+	StatusNoTable           // The table is not loaded.
+	StatusBadRequest        // Unknown opcode or wrong arguments.
+	StatusReadOnly          // A write to a replica.
+	StatusError             // Any other failure.
+	StatusDenied            // Not authenticated, or not allowed to.
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "OK"
+	case StatusNotFound:
+		return "NOT_FOUND"
+	case StatusNoTable:
+		return "NO_TABLE"
+	case StatusBadRequest:
+		return "BAD_REQUEST"
+	case StatusReadOnly:
+		return "READ_ONLY"
+	case StatusError:
+		return "ERROR"
+	case StatusDenied:
+		return "DENIED"
+	default:
+		return fmt.Sprintf("status(%d)", uint8(s))
+	}
+}
+
+type Request struct {
+	Op   Op
+	Args [][]byte
+}
+
+type Response struct {
+	Status Status
+	Fields [][]byte
+}
+
+// Err returns the response as an error, nil for StatusOK and StatusNotFound.
+func (r *Response) Err() error {
+	if r.Status == StatusOK || r.Status == StatusNotFound {
+		return nil
+	}
+	msg := r.Status.String()
+	if len(r.Fields) > 0 {
+		msg += ": " + string(r.Fields[0])
+	}
+	return errors.New(msg)
+}
+
+// -------Frames----------------------------------------------------------------
+
+func WriteRequest(w io.Writer, req *Request) error {
+	return writeFrame(w, byte(req.Op), req.Args)
+}
+
+func ReadRequest(r io.Reader) (*Request, error) {
+	return ReadRequestLimit(r, MaxFrameSize)
+}
+
+// ReadRequestLimit reads a request of at most limit bytes. Returns
+// ErrFrameTooLarge, before reading the rest, if the request is larger.
+func ReadRequestLimit(r io.Reader, limit int) (*Request, error) {
+	code, fields, err := readFrame(r, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{Op: Op(code), Args: fields}, nil
+}
+
+func WriteResponse(w io.Writer, resp *Response) error {
+	return writeFrame(w, byte(resp.Status), resp.Fields)
+}
+
+func ReadResponse(r io.Reader) (*Response, error) {
+	code, fields, err := readFrame(r, MaxFrameSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Status: Status(code), Fields: fields}, nil
+}
+
+// writeFrame writes a frame of code and fields in a single write.
+func writeFrame(w io.Writer, code byte, fields [][]byte) error {
+	size := 1
+	for _, f := range fields {
+		size += 4 + len(f)
+	}
+	if size > MaxFrameSize {
+		return fmt.Errorf("%w, over %d bytes", ErrFrameTooLarge, MaxFrameSize)
+	}
+
+	buf := make([]byte, 0, 4+size)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(size))
+	buf = append(buf, code)
+	for _, f := range fields {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(f)))
+		buf = append(buf, f...)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFrame reads a frame of at most limit bytes. Returns io.EOF if r ends
+// before the frame starts and ErrFrameTooLarge, before reading the rest, if the
+// frame is too large.
+func readFrame(r io.Reader, limit int) (byte, [][]byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(head[:])
+	if uint64(size) > uint64(limit) {
+		return 0, nil, fmt.Errorf("%w, over %d bytes", ErrFrameTooLarge, limit)
+	}
+	if size == 0 {
+		return 0, nil, errors.New("empty frame")
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+
+	code, rest := body[0], body[1:]
+	var fields [][]byte
+	for len(rest) > 0 {
+		if len(rest) < 4 {
+			return 0, nil, errors.New("truncated field length")
+		}
+		n := binary.BigEndian.Uint32(rest)
+		rest = rest[4:]
+		if uint64(n) > uint64(len(rest)) {
+			return 0, nil, errors.New("field exceeds its frame")
+		}
+		fields = append(fields, rest[:n:n])
+		rest = rest[n:]
+	}
+	return code, fields, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}